@@ -3,8 +3,11 @@ package main
 import (
 	"filemanager-api/internal/config"
 	"filemanager-api/internal/handlers"
+	"filemanager-api/internal/metrics"
 	"filemanager-api/internal/middleware"
 	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+	"filemanager-api/internal/utils"
 	"log"
 	"os"
 	"os/signal"
@@ -12,6 +15,7 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
@@ -20,9 +24,64 @@ import (
 func main() {
 	// Load configuration
 	cfg := config.Load()
+	utils.SetLogLevel(cfg.LogLevel)
+	utils.SetPathLimits(cfg.MaxPathDepth, cfg.MaxNameLength)
+	if err := utils.SetBufferSize(cfg.IOBufferSize); err != nil {
+		log.Fatalf("Error configuring IO_BUFFER_SIZE: %v", err)
+	}
+	if err := utils.SetDefaultModes(cfg.DefaultDirMode, cfg.DefaultFileMode); err != nil {
+		log.Fatalf("Error configuring DEFAULT_DIR_MODE/DEFAULT_FILE_MODE: %v", err)
+	}
+	if err := cfg.LoadUserBasePaths(); err != nil {
+		log.Fatalf("Error loading user base paths: %v", err)
+	}
+	services.ConfigureOperations(cfg.MaxConcurrentOperations, cfg.OperationQueueSize)
+	services.ConfigureMaxExpandedSources(cfg.MaxBatchItems)
+	if err := services.ConfigureTempDir(cfg.TempDir); err != nil {
+		log.Fatalf("Error configuring temp directory: %v", err)
+	}
+	if err := services.ConfigureEncryption(cfg.EncryptionMasterKey); err != nil {
+		log.Fatalf("Error configuring ENCRYPTION_MASTER_KEY: %v", err)
+	}
+	services.ConfigureSSHRetry(cfg.SSHRetryAttempts, time.Millisecond*time.Duration(cfg.SSHRetryDelayMs), time.Second*time.Duration(cfg.SSHConnectTimeout))
+	services.ConfigureExtractLimits(services.ExtractLimits{
+		MaxTotalSize: cfg.ExtractMaxTotalSize,
+		MaxEntries:   cfg.ExtractMaxEntries,
+		MaxEntrySize: cfg.ExtractMaxEntrySize,
+		MaxRatio:     cfg.ExtractMaxRatio,
+	})
+	services.ConfigureThumbnails(services.ThumbnailLimits{
+		MaxPixels:    cfg.ThumbnailMaxPixels,
+		CacheMaxSize: cfg.ThumbnailCacheMaxSize,
+		CacheMaxAge:  time.Second * time.Duration(cfg.ThumbnailCacheMaxAge),
+	})
+	handlers.ConfigureProgressStreams(time.Second*time.Duration(cfg.ProgressStreamIdleTimeout), time.Second*time.Duration(cfg.ProgressStreamMaxDuration))
+	handlers.ConfigureProgressStreamCaps(cfg.ProgressStreamsMax, cfg.ProgressStreamsPerUser)
 
-	// Create progress store
+	// Create progress store, persisted to disk when configured
 	progressStore := models.NewProgressStore()
+	if cfg.ProgressStorePath != "" {
+		store, err := models.NewPersistentProgressStore(cfg.ProgressStorePath, time.Second*time.Duration(cfg.ProgressFlushInterval))
+		if err != nil {
+			log.Fatalf("Error loading progress store: %v", err)
+		}
+		progressStore = store
+	}
+	progressStore.StartSweeper(time.Minute, time.Second*time.Duration(cfg.ProgressRetention), cfg.ProgressMaxFinished)
+
+	// Create chunk store shared by all chunked uploads
+	chunkStore := services.NewChunkStore()
+	chunkStore.StartStatsCollector(time.Second * time.Duration(cfg.ChunkStatsInterval))
+	chunkStore.StartSweeper(time.Second*time.Duration(cfg.ChunkSweepInterval), time.Second*time.Duration(cfg.ChunkMaxAge), progressStore)
+
+	// Prometheus gauges read from the shared stores on every scrape
+	metrics.RegisterProgressStore(progressStore)
+	metrics.RegisterGaugeFunc("chunked_upload_sessions", "Chunked upload sessions in progress.", func() float64 {
+		return float64(chunkStore.Stats().ActiveSessions)
+	})
+	metrics.RegisterGaugeFunc("chunked_upload_temp_bytes", "Bytes held in chunked upload temp files.", func() float64 {
+		return float64(chunkStore.Stats().TempBytes)
+	})
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -40,7 +99,9 @@ func main() {
 	app.Use(logger.New(logger.Config{
 		Format: "[${time}] ${status} - ${method} ${path} (${latency})\n",
 	}))
+	app.Use(middleware.Metrics())
 	app.Use(middleware.CORS())
+	app.Use(middleware.Compress())
 
 	// API routes
 	api := app.Group("/api/v1")
@@ -48,58 +109,126 @@ func main() {
 	// Apply auth middleware to all API routes
 	api.Use(middleware.Auth())
 	api.Use(middleware.RateLimit())
+	api.Use(middleware.BodyLimit())
+	api.Use(middleware.Timeout())
+	api.Use(middleware.Idempotency())
 
 	// Initialize handlers
-	fmHandler := handlers.NewFileManagerHandler(progressStore)
-	uploadHandler := handlers.NewUploadHandler(progressStore)
+	fmHandler := handlers.NewFileManagerHandler(progressStore, cfg.ChownAllowedOwners)
+	uploadHandler := handlers.NewUploadHandler(progressStore, chunkStore, services.UploadRules{
+		MaxFileSize:       cfg.UploadMaxFileSize,
+		AllowedExtensions: cfg.UploadAllowedExtensions,
+		DeniedExtensions:  cfg.UploadDeniedExtensions,
+		AllowedMimeTypes:  cfg.UploadAllowedMimeTypes,
+	})
 	compressHandler := handlers.NewCompressHandler(progressStore)
 	extractHandler := handlers.NewExtractHandler(progressStore)
+	watchHandler := handlers.NewWatchHandler(cfg.MaxWatchersPerUser)
+	fetchHandler := handlers.NewFetchHandler(progressStore, services.FetchOptions{
+		MaxSize:      cfg.FetchMaxSize,
+		BlockPrivate: cfg.FetchBlockPrivate,
+		Timeout:      time.Second * time.Duration(cfg.FetchTimeout),
+	})
 
 	// File System routes (combined files + folders)
 	fs := api.Group("/fs")
 	fs.Get("/", fmHandler.List)                // List directory
 	fs.Get("/disk-usage", fmHandler.GetDiskUsage) // Get disk usage
+	fs.Get("/stats", fmHandler.TreeStats)      // Count files/folders in a tree
+	fs.Get("/tree", fmHandler.Tree)            // Nested folder tree up to a depth
+	fs.Get("/recent", fmHandler.Recent)        // Recently modified files, newest first
+	fs.Get("/mount", fmHandler.Mount)          // Mount point and filesystem type of a path
 	fs.Get("/info/*", fmHandler.GetInfo)       // Get file/folder info
+	fs.Post("/info-batch", fmHandler.InfoBatch) // Get info for several paths
+	fs.Head("/download/*", fmHandler.DownloadHead) // Download headers only; before Get, which also matches HEAD
 	fs.Get("/download/*", fmHandler.Download)  // Download file
+	fs.Get("/thumbnail/*", fmHandler.Thumbnail) // Image thumbnail
+	fs.Get("/preview/*", fmHandler.Preview)     // Preview start of text file
+	fs.Get("/tail/*", fmHandler.Tail)           // Follow appended lines (SSE)
+	fs.Get("/hexdump/*", fmHandler.HexDump)     // Hex dump of a byte range
+	fs.Get("/watch/ws", websocket.New(watchHandler.WebSocketWatch)) // Directory change events
 	fs.Post("/file", fmHandler.CreateFile)     // Create file
 	fs.Put("/file/*", fmHandler.UpdateFile)    // Update file content
+	fs.Patch("/file/*", fmHandler.WriteAt)     // Write byte range into file
+	fs.Get("/lines/*", fmHandler.ReadLines)    // Read line range
+	fs.Put("/lines/*", fmHandler.WriteLines)   // Replace line range
 	fs.Post("/folder", fmHandler.CreateFolder) // Create folder
+	fs.Post("/folders", fmHandler.CreateFolders) // Create several folders
 	fs.Put("/rename/*", fmHandler.Rename)      // Rename file/folder
+	fs.Post("/chown", fmHandler.Chown)         // Change owner/group
+	if cfg.XattrEnabled {
+		fs.Get("/xattr/*", fmHandler.GetXattrs) // Read extended attributes
+		fs.Put("/xattr/*", fmHandler.SetXattrs) // Set/remove extended attributes
+	}
 	fs.Delete("/*", fmHandler.Delete)          // Delete file/folder
+	fs.Post("/delete-batch", fmHandler.DeleteBatch) // Delete several files/folders
 	fs.Post("/copy", fmHandler.Copy)           // Copy files/folders
+	fs.Post("/duplicate", fmHandler.Duplicate) // Copy file/folder next to itself
 	fs.Post("/move", fmHandler.Move)           // Move files/folders
-
-	// Upload routes
+	fs.Get("/move/progress/:id", fmHandler.MoveProgress) // Cross-device move progress (SSE)
+	fs.Post("/fetch", fetchHandler.Fetch)                 // Download URL into user space
+	fs.Get("/fetch/progress/:id", fetchHandler.Progress)  // Fetch progress (SSE)
+	fs.Post("/diff", fmHandler.Diff)           // Compare files/folders
+	fs.Post("/replace", fmHandler.Replace)     // Find and replace in files
+	fs.Post("/transfer", fmHandler.Transfer)   // Copy/move between local and SSH host
+	fs.Get("/transfer/progress/:id", fmHandler.MoveProgress) // Transfer progress (SSE)
+
+	// Upload routes; status polling is registered first so it does not count against the upload rate limit
+	api.Get("/upload/status/:id", uploadHandler.Status)
 	upload := api.Group("/upload")
 	upload.Use(middleware.UploadRateLimit())
 	upload.Post("/", uploadHandler.Upload)
 	upload.Post("/chunked", uploadHandler.ChunkedUpload)
+	upload.Patch("/chunked/:id", uploadHandler.SetChunkedDestination)
 	upload.Get("/progress/:id", uploadHandler.Progress)
 
 	// WebSocket for upload progress
-	app.Get("/api/v1/upload/ws/:id", websocket.New(uploadHandler.WebSocketProgress))
+	app.Get("/api/v1/upload/ws/:id", handlers.ProgressStreamAvailable, websocket.New(uploadHandler.WebSocketProgress))
 
 	// Compression routes
 	compress := api.Group("/compress")
 	compress.Post("/", compressHandler.Compress)
+	compress.Post("/stream", compressHandler.CompressStream)
+	compress.Post("/add", compressHandler.AddToArchive)
 	compress.Get("/progress/:id", compressHandler.Progress)
+	compress.Get("/status/:id", compressHandler.Status)
 
 	// Extraction routes
 	extract := api.Group("/extract")
 	extract.Post("/", extractHandler.Extract)
+	extract.Post("/stream", extractHandler.ExtractStream)
+	extract.Get("/size", extractHandler.Size)
 	extract.Get("/progress/:id", extractHandler.Progress)
+	extract.Get("/status/:id", extractHandler.Status)
 
 	// Raw command routes
 	rawHandler := handlers.NewRawCommandHandler()
-	api.Post("/raw", rawHandler.Execute)
-
-	// Health check (no auth)
-	app.Get("/health", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{
-			"status":  "healthy",
-			"version": "1.0.0",
-		})
-	})
+	api.Post("/raw", middleware.RawCommandRateLimit(), rawHandler.Execute)
+
+	// Metrics routes
+	metricsHandler := handlers.NewMetricsHandler(chunkStore)
+	api.Get("/metrics", metricsHandler.Get)
+
+	// Features and limits of this instance
+	capabilitiesHandler := handlers.NewCapabilitiesHandler(cfg, "1.0.0")
+	api.Get("/capabilities", capabilitiesHandler.Get)
+
+	// SSH setup check for remote mode
+	remoteHandler := handlers.NewRemoteHandler()
+	api.Post("/remote/test", remoteHandler.Test)
+
+	// Operation limiter stats
+	operationsHandler := handlers.NewOperationsHandler(progressStore)
+	api.Get("/operations", operationsHandler.Stats)
+	api.Delete("/operations/:id", operationsHandler.Cancel)
+
+	// Health checks (no auth)
+	healthHandler := handlers.NewHealthHandler(cfg.BasePath, "1.0.0")
+	app.Get("/health", healthHandler.Health) // Liveness
+	app.Get("/ready", healthHandler.Ready)   // Readiness (base path writable)
+
+	// Prometheus metrics (no auth)
+	app.Get("/metrics", adaptor.HTTPHandler(metrics.Handler()))
 
 	// Graceful shutdown
 	c := make(chan os.Signal, 1)
@@ -109,6 +238,9 @@ func main() {
 		<-c
 		log.Println("Gracefully shutting down...")
 		_ = app.Shutdown()
+		if err := progressStore.Flush(); err != nil {
+			log.Printf("Error saving progress store: %v", err)
+		}
 	}()
 
 	// Start server
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -17,6 +21,79 @@ type Config struct {
 	ReadTimeout     int
 	WriteTimeout    int
 	IdleTimeout     int
+
+	ChunkStatsInterval int
+	MaxBatchItems      int
+	MaxWatchersPerUser int
+	CompressLevel      int
+
+	UploadMaxFileSize       int64
+	UploadAllowedExtensions []string
+	UploadDeniedExtensions  []string
+	UploadAllowedMimeTypes  []string
+
+	RawRateLimitReqs   int
+	RawRateLimitWindow int
+
+	ProgressStorePath     string
+	ProgressFlushInterval int
+	ProgressRetention     int // seconds finished operations are kept; 0 = until ProgressMaxFinished is reached
+	ProgressMaxFinished   int // finished operations kept at most; 0 = no limit
+
+	FetchMaxSize      int64
+	FetchBlockPrivate bool
+	FetchTimeout      int
+
+	MaxPathDepth  int
+	MaxNameLength int
+
+	ChownAllowedOwners []string
+
+	UserBasePathsSource string            // inline JSON object or path to a JSON file
+	UserBasePaths       map[string]string // usersite -> base path, filled by LoadUserBasePaths
+
+	MaxConcurrentOperations int
+	OperationQueueSize      int
+
+	TempDir string // chunked uploads, staged archives, thumbnails and dedup indexes; empty = os.TempDir()
+
+	ChunkSweepInterval int // seconds between sweeps of abandoned chunked uploads
+	ChunkMaxAge        int // seconds without a new chunk before an upload counts as abandoned
+
+	SSHRetryAttempts  int // attempts per SSH connect or SFTP call on network errors
+	SSHRetryDelayMs   int // delay before the first retry, doubled on each further one
+	SSHConnectTimeout int // seconds per SSH connect attempt
+
+	IOBufferSize int // bytes per read/write when copying file data
+
+	DefaultDirMode  string // octal permissions of new folders
+	DefaultFileMode string // octal permissions of new files
+
+	XattrEnabled bool // expose the extended attribute endpoints
+
+	PreserveOwner bool // leave new and changed files with the ownership the filesystem gives them instead of chowning them to the usersite
+
+	EncryptionMasterKey string // 32-byte key as hex or base64; empty = uploads stored unencrypted
+
+	ProgressStreamIdleTimeout int // seconds an SSE progress stream may go without a change; 0 = no limit
+	ProgressStreamMaxDuration int // seconds an SSE progress stream may stay open; 0 = no limit
+	ProgressStreamsMax        int // progress streams (SSE and WebSocket) open at once; 0 = no limit
+	ProgressStreamsPerUser    int // progress streams one usersite may have open at once; 0 = no limit
+
+	IdempotencyTTL int // seconds the response to a request with an Idempotency-Key is replayed; 0 = disabled
+
+	ExtractMaxTotalSize int64 // uncompressed bytes one archive may extract; 0 = no limit
+	ExtractMaxEntries   int   // files and folders one archive may extract; 0 = no limit
+	ExtractMaxEntrySize int64 // uncompressed bytes of a single entry; 0 = no limit
+	ExtractMaxRatio     int64 // uncompressed to compressed size; 0 = no limit
+
+	ThumbnailMaxPixels    int64 // width x height of an image thumbnails are rendered from; 0 = no limit
+	ThumbnailCacheMaxSize int64 // bytes of cached thumbnails; 0 = no limit
+	ThumbnailCacheMaxAge  int   // seconds a cached thumbnail is kept after it was last served; 0 = no limit
+
+	MaxBodySize int64 // request body bytes on routes other than uploads; 0 = only MAX_UPLOAD_SIZE applies
+
+	RequestTimeout int // seconds a request may take outside streaming, upload, copy and move routes; 0 = no limit
 }
 
 var AppConfig *Config
@@ -34,10 +111,130 @@ func Load() *Config {
 		ReadTimeout:     getEnvInt("READ_TIMEOUT", 7200),  // 2 hours default
 		WriteTimeout:    getEnvInt("WRITE_TIMEOUT", 7200), // 2 hours default
 		IdleTimeout:     getEnvInt("IDLE_TIMEOUT", 10800), // 3 hours default
+
+		ChunkStatsInterval: getEnvInt("CHUNK_STATS_INTERVAL", 30), // seconds
+		MaxBatchItems:      getEnvInt("MAX_BATCH_ITEMS", 1000),
+		MaxWatchersPerUser: getEnvInt("MAX_WATCHERS_PER_USER", 5),
+		CompressLevel:      getEnvInt("COMPRESS_LEVEL", 0), // -1 disabled, 0 default, 1 best speed, 2 best compression
+
+		UploadMaxFileSize:       getEnvInt64("UPLOAD_MAX_FILE_SIZE", 0), // 0 = only MAX_UPLOAD_SIZE applies
+		UploadAllowedExtensions: getEnvList("UPLOAD_ALLOWED_EXTENSIONS"),
+		UploadDeniedExtensions:  getEnvList("UPLOAD_DENIED_EXTENSIONS"),
+		UploadAllowedMimeTypes:  getEnvList("UPLOAD_ALLOWED_MIME_TYPES"),
+
+		RawRateLimitReqs:   getEnvInt("RAW_RATE_LIMIT_REQUESTS", 5),
+		RawRateLimitWindow: getEnvInt("RAW_RATE_LIMIT_WINDOW", 60),
+
+		ProgressStorePath:     getEnv("PROGRESS_STORE_PATH", ""),       // empty = in-memory only
+		ProgressFlushInterval: getEnvInt("PROGRESS_FLUSH_INTERVAL", 2), // seconds
+		ProgressRetention:     getEnvInt("PROGRESS_RETENTION", 86400),
+		ProgressMaxFinished:   getEnvInt("PROGRESS_MAX_FINISHED", 10000),
+
+		FetchMaxSize:      getEnvInt64("FETCH_MAX_SIZE", 10737418240), // 10GB default
+		FetchBlockPrivate: getEnv("FETCH_BLOCK_PRIVATE", "true") == "true",
+		FetchTimeout:      getEnvInt("FETCH_TIMEOUT", 7200), // seconds
+
+		MaxPathDepth:  getEnvInt("MAX_PATH_DEPTH", 64),   // 0 = unlimited
+		MaxNameLength: getEnvInt("MAX_NAME_LENGTH", 255), // bytes, 0 = unlimited
+
+		ChownAllowedOwners: getEnvList("CHOWN_ALLOWED_OWNERS"), // the usersite itself is always allowed
+
+		UserBasePathsSource: getEnv("USER_BASE_PATHS", ""),
+
+		MaxConcurrentOperations: getEnvInt("MAX_CONCURRENT_OPERATIONS", 4), // compress, extract and upload
+		OperationQueueSize:      getEnvInt("OPERATION_QUEUE_SIZE", 64),     // queued compress/extract jobs
+
+		TempDir: getEnv("TEMP_DIR", ""),
+
+		ChunkSweepInterval: getEnvInt("CHUNK_SWEEP_INTERVAL", 600),
+		ChunkMaxAge:        getEnvInt("CHUNK_MAX_AGE", 86400),
+
+		SSHRetryAttempts:  getEnvInt("SSH_RETRY_ATTEMPTS", 3),
+		SSHRetryDelayMs:   getEnvInt("SSH_RETRY_DELAY_MS", 200),
+		SSHConnectTimeout: getEnvInt("SSH_CONNECT_TIMEOUT", 15),
+
+		IOBufferSize: getEnvInt("IO_BUFFER_SIZE", 65536), // 64KB default
+
+		DefaultDirMode:  getEnv("DEFAULT_DIR_MODE", "0755"),
+		DefaultFileMode: getEnv("DEFAULT_FILE_MODE", "0644"),
+
+		XattrEnabled: getEnv("XATTR_ENABLED", "false") == "true",
+
+		PreserveOwner: getEnv("PRESERVE_OWNER", "false") == "true",
+
+		EncryptionMasterKey: getEnv("ENCRYPTION_MASTER_KEY", ""),
+
+		ProgressStreamIdleTimeout: getEnvInt("PROGRESS_STREAM_IDLE_TIMEOUT", 600),
+		ProgressStreamMaxDuration: getEnvInt("PROGRESS_STREAM_MAX_DURATION", 3600),
+		ProgressStreamsMax:        getEnvInt("PROGRESS_STREAMS_MAX", 1000),
+		ProgressStreamsPerUser:    getEnvInt("PROGRESS_STREAMS_PER_USER", 20),
+
+		IdempotencyTTL: getEnvInt("IDEMPOTENCY_TTL", 3600),
+
+		ExtractMaxTotalSize: getEnvInt64("EXTRACT_MAX_TOTAL_SIZE", 10737418240), // 10GB default
+		ExtractMaxEntries:   getEnvInt("EXTRACT_MAX_ENTRIES", 100000),
+		ExtractMaxEntrySize: getEnvInt64("EXTRACT_MAX_ENTRY_SIZE", 0),
+		ExtractMaxRatio:     getEnvInt64("EXTRACT_MAX_RATIO", 1000),
+
+		ThumbnailMaxPixels:    getEnvInt64("THUMBNAIL_MAX_PIXELS", 50000000),      // 50 megapixels
+		ThumbnailCacheMaxSize: getEnvInt64("THUMBNAIL_CACHE_MAX_SIZE", 536870912), // 512MB default
+		ThumbnailCacheMaxAge:  getEnvInt("THUMBNAIL_CACHE_MAX_AGE", 604800),       // 7 days
+
+		MaxBodySize: getEnvInt64("MAX_BODY_SIZE", 10485760), // 10MB default
+
+		RequestTimeout: getEnvInt("REQUEST_TIMEOUT", 60),
 	}
 	return AppConfig
 }
 
+// LoadUserBasePaths parses UserBasePathsSource, either an inline JSON object
+// ({"site": "/mnt/disk2/site"}) or the path of a file containing one, and
+// checks that every configured path is an existing directory
+func (c *Config) LoadUserBasePaths() error {
+	c.UserBasePaths = map[string]string{}
+	source := strings.TrimSpace(c.UserBasePathsSource)
+	if source == "" {
+		return nil
+	}
+
+	data := []byte(source)
+	if !strings.HasPrefix(source, "{") {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return fmt.Errorf("reading USER_BASE_PATHS file: %v", err)
+		}
+	}
+
+	var paths map[string]string
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return fmt.Errorf("parsing USER_BASE_PATHS: %v", err)
+	}
+
+	for userSite, path := range paths {
+		if userSite == "" || !filepath.IsAbs(path) {
+			return fmt.Errorf("USER_BASE_PATHS entry %q: path %q must be absolute", userSite, path)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("USER_BASE_PATHS entry %q: %v", userSite, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("USER_BASE_PATHS entry %q: %s is not a directory", userSite, path)
+		}
+		c.UserBasePaths[userSite] = filepath.Clean(path)
+	}
+	return nil
+}
+
+// UserBasePath returns the base path of a local usersite, preferring
+// USER_BASE_PATHS over the default BASE_PATH/usersite layout
+func (c *Config) UserBasePath(userSite string) string {
+	if path, ok := c.UserBasePaths[userSite]; ok {
+		return path
+	}
+	return c.BasePath + "/" + userSite
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -62,3 +259,14 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 	}
 	return defaultValue
 }
+
+// getEnvList parses a comma-separated environment variable, skipping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"filemanager-api/internal/config"
+	"filemanager-api/internal/models"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// exceedsBatchLimit reports whether a request carries more items than MAX_BATCH_ITEMS allows
+func exceedsBatchLimit(count int) bool {
+	limit := config.AppConfig.MaxBatchItems
+	return limit > 0 && count > limit
+}
+
+// tooManyItems responds with 400 TOO_MANY_ITEMS
+func tooManyItems(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(
+		models.NewErrorResponse("Bad Request", "TOO_MANY_ITEMS",
+			fmt.Sprintf("At most %d items are allowed per request", config.AppConfig.MaxBatchItems)),
+	)
+}
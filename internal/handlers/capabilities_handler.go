@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"filemanager-api/internal/config"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CapabilitiesHandler reports the features and limits of this instance
+type CapabilitiesHandler struct {
+	capabilities models.Capabilities
+}
+
+// NewCapabilitiesHandler creates a capabilities handler. The configuration does not change
+// while the server runs, so the response is built once.
+func NewCapabilitiesHandler(cfg *config.Config, version string) *CapabilitiesHandler {
+	return &CapabilitiesHandler{capabilities: models.Capabilities{
+		Version: version,
+		Features: models.FeatureFlags{
+			Remote:             true,
+			Xattr:              cfg.XattrEnabled,
+			Encryption:         services.EncryptionEnabled(),
+			PersistentProgress: cfg.ProgressStorePath != "",
+			BlockPrivateFetch:  cfg.FetchBlockPrivate,
+		},
+		Limits: models.CapabilityLimits{
+			MaxUploadSize:       cfg.MaxUploadSize,
+			MaxUploadFileSize:   cfg.UploadMaxFileSize,
+			MaxBodySize:         cfg.MaxBodySize,
+			ChunkSize:           cfg.ChunkSize,
+			MaxBatchItems:       cfg.MaxBatchItems,
+			MaxPathDepth:        cfg.MaxPathDepth,
+			MaxNameLength:       cfg.MaxNameLength,
+			MaxTreeDepth:        services.MaxTreeDepth,
+			MaxThumbnailSize:    services.MaxThumbnailSize,
+			MaxWatchersPerUser:  cfg.MaxWatchersPerUser,
+			FetchMaxSize:        cfg.FetchMaxSize,
+			ExtractMaxTotalSize: cfg.ExtractMaxTotalSize,
+			ExtractMaxEntries:   cfg.ExtractMaxEntries,
+			ExtractMaxEntrySize: cfg.ExtractMaxEntrySize,
+			ExtractMaxRatio:     cfg.ExtractMaxRatio,
+			MaxConcurrentOps:    cfg.MaxConcurrentOperations,
+			OperationQueueSize:  cfg.OperationQueueSize,
+		},
+		RateLimit: models.CapabilityRateLimit{
+			Requests:    cfg.RateLimitReqs,
+			Window:      cfg.RateLimitWindow,
+			RawRequests: cfg.RawRateLimitReqs,
+			RawWindow:   cfg.RawRateLimitWindow,
+		},
+		Upload: models.UploadCapabilities{
+			AllowedExtensions: emptyIfNil(cfg.UploadAllowedExtensions),
+			DeniedExtensions:  emptyIfNil(cfg.UploadDeniedExtensions),
+			AllowedMimeTypes:  emptyIfNil(cfg.UploadAllowedMimeTypes),
+		},
+		Archives: models.ArchiveCapabilities{
+			Compress:     services.CompressFormats(),
+			Extract:      services.ExtractFormats(),
+			MinLevel:     services.MinCompressionLevel,
+			MaxLevel:     services.MaxCompressionLevel,
+			DefaultLevel: services.DefaultCompressionLevel,
+		},
+	}}
+}
+
+// Get handles GET /api/v1/capabilities
+func (h *CapabilitiesHandler) Get(c *fiber.Ctx) error {
+	return c.JSON(models.NewSuccessResponse("Capabilities retrieved", h.capabilities))
+}
+
+// emptyIfNil returns list, or an empty list instead of nil so it is encoded as []
+func emptyIfNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
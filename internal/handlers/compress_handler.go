@@ -2,13 +2,14 @@ package handlers
 
 import (
 	"bufio"
-	"encoding/json"
+	"errors"
 	"filemanager-api/internal/middleware"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/services"
+	"filemanager-api/internal/utils"
 	"fmt"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -23,23 +24,32 @@ func NewCompressHandler(progressStore *models.ProgressStore) *CompressHandler {
 	return &CompressHandler{progressStore: progressStore}
 }
 
-// getCompressService returns a compress service for the current user
-func (h *CompressHandler) getCompressService(c *fiber.Ctx) *services.CompressService {
+// getCompressService returns a compress service for the current user, creating archives on
+// the SSH host for remote users
+func (h *CompressHandler) getCompressService(c *fiber.Ctx) (*services.CompressService, error) {
 	userCtx := middleware.GetUserContext(c)
 	if userCtx == nil {
-		return nil
+		return nil, services.ErrPermissionDenied
 	}
-	return services.NewCompressService(userCtx.BasePath, userCtx.UserSite, h.progressStore)
+	if userCtx.IsRemote {
+		remote, err := userService(userCtx)
+		if err != nil {
+			return nil, err
+		}
+		return services.NewRemoteCompressService(remote, h.progressStore), nil
+	}
+	svc := services.NewCompressService(userCtx.BasePath, userCtx.UserSite, h.progressStore)
+	svc.SetPreserveOwner(userCtx.PreserveOwner)
+	return svc, nil
 }
 
 // Compress handles POST /api/v1/compress
 func (h *CompressHandler) Compress(c *fiber.Ctx) error {
-	svc := h.getCompressService(c)
-	if svc == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(
-			models.NewErrorResponse("Unauthorized", "AUTH_ERROR", "User context not found"),
-		)
+	svc, err := h.getCompressService(c)
+	if err != nil {
+		return serviceError(c, err)
 	}
+	defer svc.Close()
 
 	var req models.CompressRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -54,13 +64,42 @@ func (h *CompressHandler) Compress(c *fiber.Ctx) error {
 		)
 	}
 
-	if req.CompressionLevel < 0 {
-		req.CompressionLevel = 6 // Default compression level
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
+	}
+
+	if req.Format == "" {
+		req.Format = "zip"
+	}
+	if _, ok := services.ArchiveFormats[req.Format]; !ok {
+		return invalidFormat(c)
+	}
+
+	if req.CompressionLevel < 0 || req.CompressionLevel > services.MaxCompressionLevel {
+		return invalidLevel(c)
+	}
+
+	symlinks, err := utils.ParseSymlinkPolicy(req.Symlinks)
+	if err != nil {
+		return invalidSymlinks(c, err)
+	}
+
+	if !validManifest(req.Manifest) {
+		return invalidManifest(c)
+	}
+	if req.Manifest != "" && svc.IsRemote() {
+		return localOnly(c, "Archive manifests are")
 	}
 
-	result, err := svc.Compress(req.Paths, req.Output, req.CompressionLevel)
+	result, err := svc.Compress(req.Paths, req.Output, req.Format, req.CompressionLevel, symlinks, req.Manifest)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrQueueFull) {
+			status = fiber.StatusServiceUnavailable
+		} else if errors.Is(err, services.ErrToolUnavailable) {
+			return toolUnavailable(c, err)
+		}
+		return c.Status(status).JSON(
 			models.NewErrorResponse("Failed to compress", "COMPRESS_ERROR", err.Error()),
 		)
 	}
@@ -82,6 +121,206 @@ func (h *CompressHandler) Compress(c *fiber.Ctx) error {
 	}))
 }
 
+// AddToArchive handles POST /api/v1/compress/add
+func (h *CompressHandler) AddToArchive(c *fiber.Ctx) error {
+	svc, err := h.getCompressService(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	defer svc.Close()
+
+	if svc.IsRemote() {
+		return localOnly(c, "Adding to archives is")
+	}
+
+	var req models.CompressAddRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Paths) == 0 || req.Archive == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Archive and paths are required"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
+	}
+
+	if req.CompressionLevel < 0 || req.CompressionLevel > services.MaxCompressionLevel {
+		return invalidLevel(c)
+	}
+
+	symlinks, err := utils.ParseSymlinkPolicy(req.Symlinks)
+	if err != nil {
+		return invalidSymlinks(c, err)
+	}
+
+	result, err := svc.AddToArchive(req.Archive, req.Paths, req.Overwrite, req.CompressionLevel, symlinks)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrAlreadyExists) {
+			status = fiber.StatusConflict
+		} else if errors.Is(err, services.ErrUnsupportedType) {
+			status = fiber.StatusUnsupportedMediaType
+		} else if errors.Is(err, services.ErrQueueFull) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to add to archive", "COMPRESS_ERROR", err.Error()),
+		)
+	}
+
+	parts := strings.SplitN(result, ":", 2)
+	compressID := parts[0]
+	archivePath := ""
+	if len(parts) > 1 {
+		archivePath = parts[1]
+	}
+
+	progress, _ := svc.GetProgress(compressID)
+
+	return c.Status(fiber.StatusAccepted).JSON(models.NewSuccessResponse("Adding to archive started", fiber.Map{
+		"compress_id": compressID,
+		"archive":     archivePath,
+		"progress":    progress,
+	}))
+}
+
+// CompressStream handles POST /api/v1/compress/stream, sending the archive as the response body.
+// Nothing is written to disk and no progress is tracked.
+func (h *CompressHandler) CompressStream(c *fiber.Ctx) error {
+	svc, err := h.getCompressService(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	defer svc.Close()
+
+	if svc.IsRemote() {
+		return localOnly(c, "Streaming archives is")
+	}
+
+	var req models.CompressStreamRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Paths) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Paths are required"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
+	}
+
+	if req.Format == "" {
+		req.Format = "zip"
+	}
+	format, ok := services.ArchiveFormats[req.Format]
+	if !ok {
+		return invalidFormat(c)
+	}
+
+	if req.CompressionLevel < 0 || req.CompressionLevel > services.MaxCompressionLevel {
+		return invalidLevel(c)
+	}
+
+	symlinks, err := utils.ParseSymlinkPolicy(req.Symlinks)
+	if err != nil {
+		return invalidSymlinks(c, err)
+	}
+
+	if !validManifest(req.Manifest) {
+		return invalidManifest(c)
+	}
+
+	filename := filepath.Base(req.Filename)
+	if req.Filename == "" || filename == "." || filename == "/" {
+		filename = "archive" + format[0]
+	}
+
+	// Resolve everything up front: once streaming starts errors can no longer be reported as JSON
+	fullPaths, _, err := svc.ResolvePaths(req.Paths, symlinks)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(
+			models.NewErrorResponse("Failed to compress", "COMPRESS_ERROR", err.Error()),
+		)
+	}
+
+	c.Set("Content-Type", format[1])
+	c.Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+
+	ctx := c.Context()
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		// A failure here leaves a truncated archive, which clients detect when reading it
+		if err := svc.CompressStream(ctx, w, fullPaths, req.Format, req.CompressionLevel, symlinks, req.Manifest); err != nil {
+			utils.Errorf("Streaming %s archive failed: %v", req.Format, err)
+		}
+		w.Flush()
+	})
+
+	return nil
+}
+
+// localOnly rejects a feature remote users cannot use; what names it with its verb
+func localOnly(c *fiber.Ctx, what string) error {
+	return c.Status(fiber.StatusNotImplemented).JSON(
+		models.NewErrorResponse("Not Implemented", "NOT_SUPPORTED", what+" only supported for local storage"),
+	)
+}
+
+// toolUnavailable reports a program missing on the SSH host an archive operation runs on
+func toolUnavailable(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusNotImplemented).JSON(
+		models.NewErrorResponse("Not Implemented", "TOOL_UNAVAILABLE", err.Error()),
+	)
+}
+
+func invalidFormat(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(
+		models.NewErrorResponse("Bad Request", "INVALID_FORMAT", "Format must be "+services.ArchiveFormatNames),
+	)
+}
+
+func invalidLevel(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(
+		models.NewErrorResponse("Bad Request", "INVALID_LEVEL",
+			fmt.Sprintf("Compression level must be between %d and %d", services.MinCompressionLevel, services.MaxCompressionLevel)),
+	)
+}
+
+func invalidSymlinks(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(
+		models.NewErrorResponse("Bad Request", "INVALID_SYMLINK_POLICY", err.Error()),
+	)
+}
+
+// validManifest reports whether manifest is empty or a hash algorithm a manifest can use
+func validManifest(manifest string) bool {
+	if manifest == "" {
+		return true
+	}
+	_, err := utils.NewHasher(manifest)
+	return err == nil
+}
+
+func invalidManifest(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(
+		models.NewErrorResponse("Bad Request", "INVALID_MANIFEST", "Manifest must be md5, sha1 or sha256"),
+	)
+}
+
 // Progress handles GET /api/v1/compress/progress/:id (SSE)
 func (h *CompressHandler) Progress(c *fiber.Ctx) error {
 	compressID := c.Params("id")
@@ -91,35 +330,10 @@ func (h *CompressHandler) Progress(c *fiber.Ctx) error {
 		)
 	}
 
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("Transfer-Encoding", "chunked")
-
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				progress, ok := h.progressStore.Get(compressID)
-				if !ok {
-					fmt.Fprintf(w, "data: {\"error\": \"compression not found\"}\n\n")
-					w.Flush()
-					return
-				}
-
-				data, _ := json.Marshal(progress)
-				fmt.Fprintf(w, "data: %s\n\n", data)
-				w.Flush()
-
-				if progress.Status == models.StatusCompleted || progress.Status == models.StatusFailed {
-					return
-				}
-			}
-		}
-	})
+	return streamProgress(c, h.progressStore, compressID, "compression")
+}
 
-	return nil
+// Status handles GET /api/v1/compress/status/:id
+func (h *CompressHandler) Status(c *fiber.Ctx) error {
+	return progressStatus(c, h.progressStore, c.Params("id"), "compression")
 }
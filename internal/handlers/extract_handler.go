@@ -1,14 +1,14 @@
 package handlers
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
+	"errors"
 	"filemanager-api/internal/middleware"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/services"
-	"fmt"
+	"io"
+	"mime/multipart"
 	"strings"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -23,23 +23,32 @@ func NewExtractHandler(progressStore *models.ProgressStore) *ExtractHandler {
 	return &ExtractHandler{progressStore: progressStore}
 }
 
-// getExtractService returns an extract service for the current user
-func (h *ExtractHandler) getExtractService(c *fiber.Ctx) *services.ExtractService {
+// getExtractService returns an extract service for the current user, extracting on the SSH
+// host for remote users
+func (h *ExtractHandler) getExtractService(c *fiber.Ctx) (*services.ExtractService, error) {
 	userCtx := middleware.GetUserContext(c)
 	if userCtx == nil {
-		return nil
+		return nil, services.ErrPermissionDenied
 	}
-	return services.NewExtractService(userCtx.BasePath, userCtx.UserSite, h.progressStore)
+	if userCtx.IsRemote {
+		remote, err := userService(userCtx)
+		if err != nil {
+			return nil, err
+		}
+		return services.NewRemoteExtractService(remote, h.progressStore), nil
+	}
+	svc := services.NewExtractService(userCtx.BasePath, userCtx.UserSite, h.progressStore)
+	svc.SetPreserveOwner(userCtx.PreserveOwner)
+	return svc, nil
 }
 
 // Extract handles POST /api/v1/extract
 func (h *ExtractHandler) Extract(c *fiber.Ctx) error {
-	svc := h.getExtractService(c)
-	if svc == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(
-			models.NewErrorResponse("Unauthorized", "AUTH_ERROR", "User context not found"),
-		)
+	svc, err := h.getExtractService(c)
+	if err != nil {
+		return serviceError(c, err)
 	}
+	defer svc.Close()
 
 	var req models.ExtractRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -54,10 +63,14 @@ func (h *ExtractHandler) Extract(c *fiber.Ctx) error {
 		)
 	}
 
-	result, err := svc.Extract(req.Source, req.Destination)
+	result, err := svc.Extract(req.Source, req.Destination, services.ExtractOptions{
+		IntoSubfolder: req.IntoSubfolder == nil || *req.IntoSubfolder,
+		PreserveMode:  req.PreserveMode == nil || *req.PreserveMode,
+	})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(
-			models.NewErrorResponse("Failed to extract", "EXTRACT_ERROR", err.Error()),
+		status, code := extractErrorStatus(err)
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to extract", code, err.Error()),
 		)
 	}
 
@@ -78,6 +91,127 @@ func (h *ExtractHandler) Extract(c *fiber.Ctx) error {
 	}))
 }
 
+// Size handles GET /api/v1/extract/size?source=&destination=, reporting the total size of an
+// archive's entries and whether it fits in the free space of the destination
+func (h *ExtractHandler) Size(c *fiber.Ctx) error {
+	svc, err := h.getExtractService(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	defer svc.Close()
+
+	source := c.Query("source")
+	if source == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Source is required"),
+		)
+	}
+
+	size, err := svc.ExtractedSize(source, c.Query("destination"))
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrUnsupportedType) {
+			status = fiber.StatusUnsupportedMediaType
+		} else if errors.Is(err, services.ErrToolUnavailable) {
+			return toolUnavailable(c, err)
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to read archive", "ARCHIVE_SIZE_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Archive size calculated", size))
+}
+
+// ExtractStream handles POST /api/v1/extract/stream?destination=
+// The archive is read from the multipart "file" field and never stored under the base path.
+func (h *ExtractHandler) ExtractStream(c *fiber.Ctx) error {
+	svc, err := h.getExtractService(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	defer svc.Close()
+
+	if svc.IsRemote() {
+		return localOnly(c, "Extracting uploaded archives is")
+	}
+
+	destination := c.Query("destination")
+	if destination == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Destination is required"),
+		)
+	}
+
+	boundary, err := parseBoundary(c.Get("Content-Type"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_CONTENT_TYPE", err.Error()),
+		)
+	}
+
+	var reader *multipart.Reader
+	bodyStream := c.Context().RequestBodyStream()
+	if bodyStream != nil {
+		reader = multipart.NewReader(bodyStream, boundary)
+	} else {
+		reader = multipart.NewReader(bytes.NewReader(c.Body()), boundary)
+	}
+
+	var filePart *multipart.Part
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "FORM_PARSE_ERROR", err.Error()),
+			)
+		}
+		if part.FormName() == "file" {
+			filePart = part
+			break
+		}
+	}
+
+	if filePart == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "FILE_REQUIRED", "File is required"),
+		)
+	}
+
+	result, err := svc.ExtractStream(filePart, filePart.FileName(), destination, services.ExtractOptions{
+		IntoSubfolder: c.Query("into_subfolder") != "false",
+		PreserveMode:  c.Query("preserve_mode") != "false",
+	})
+	if err != nil {
+		status, code := extractErrorStatus(err)
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to extract", code, err.Error()),
+		)
+	}
+
+	parts := strings.SplitN(result, ":", 2)
+	extractID := parts[0]
+	destPath := ""
+	if len(parts) > 1 {
+		destPath = parts[1]
+	}
+
+	progress, _ := svc.GetProgress(extractID)
+
+	return c.Status(fiber.StatusAccepted).JSON(models.NewSuccessResponse("Extraction started", fiber.Map{
+		"extract_id":  extractID,
+		"destination": destPath,
+		"progress":    progress,
+	}))
+}
+
 // Progress handles GET /api/v1/extract/progress/:id (SSE)
 func (h *ExtractHandler) Progress(c *fiber.Ctx) error {
 	extractID := c.Params("id")
@@ -87,35 +221,30 @@ func (h *ExtractHandler) Progress(c *fiber.Ctx) error {
 		)
 	}
 
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("Transfer-Encoding", "chunked")
-
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				progress, ok := h.progressStore.Get(extractID)
-				if !ok {
-					fmt.Fprintf(w, "data: {\"error\": \"extraction not found\"}\n\n")
-					w.Flush()
-					return
-				}
-
-				data, _ := json.Marshal(progress)
-				fmt.Fprintf(w, "data: %s\n\n", data)
-				w.Flush()
-
-				if progress.Status == models.StatusCompleted || progress.Status == models.StatusFailed {
-					return
-				}
-			}
-		}
-	})
+	return streamProgress(c, h.progressStore, extractID, "extraction")
+}
+
+// Status handles GET /api/v1/extract/status/:id
+func (h *ExtractHandler) Status(c *fiber.Ctx) error {
+	return progressStatus(c, h.progressStore, c.Params("id"), "extraction")
+}
 
-	return nil
+// extractErrorStatus maps an error starting an extraction to a status and error code
+func extractErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrArchiveTooLarge):
+		return fiber.StatusRequestEntityTooLarge, "ARCHIVE_TOO_LARGE"
+	case errors.Is(err, services.ErrZipBomb):
+		return fiber.StatusUnprocessableEntity, "ZIP_BOMB"
+	case errors.Is(err, services.ErrUnsupportedType):
+		return fiber.StatusUnsupportedMediaType, "EXTRACT_ERROR"
+	case errors.Is(err, services.ErrQueueFull):
+		return fiber.StatusServiceUnavailable, "EXTRACT_ERROR"
+	case errors.Is(err, services.ErrToolUnavailable):
+		return fiber.StatusNotImplemented, "TOOL_UNAVAILABLE"
+	case isInvalidPath(err):
+		// Also unsafe entry names, which remote archives are checked for up front
+		return fiber.StatusBadRequest, "INVALID_PATH"
+	}
+	return fiber.StatusInternalServerError, "EXTRACT_ERROR"
 }
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FetchHandler handles downloading remote URLs into the user's space
+type FetchHandler struct {
+	progressStore *models.ProgressStore
+	opts          services.FetchOptions
+}
+
+// NewFetchHandler creates a new fetch handler
+func NewFetchHandler(progressStore *models.ProgressStore, opts services.FetchOptions) *FetchHandler {
+	return &FetchHandler{progressStore: progressStore, opts: opts}
+}
+
+// Fetch handles POST /api/v1/fs/fetch
+func (h *FetchHandler) Fetch(c *fiber.Ctx) error {
+	userCtx := middleware.GetUserContext(c)
+	if userCtx == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(
+			models.NewErrorResponse("Unauthorized", "AUTH_ERROR", "User context not found"),
+		)
+	}
+	if userCtx.IsRemote {
+		return c.Status(fiber.StatusNotImplemented).JSON(
+			models.NewErrorResponse("Not Implemented", "NOT_SUPPORTED", "Fetching URLs is only supported for local storage"),
+		)
+	}
+
+	var req models.FetchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.URL == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "URL is required"),
+		)
+	}
+
+	svc := services.NewFetchService(userCtx.BasePath, userCtx.UserSite, h.progressStore, h.opts)
+	svc.SetPreserveOwner(userCtx.PreserveOwner)
+	result, err := svc.Fetch(req.URL, req.Destination, req.Filename)
+	if err != nil {
+		status, code := fiber.StatusInternalServerError, "FETCH_ERROR"
+		switch {
+		case errors.Is(err, services.ErrInvalidURL):
+			status, code = fiber.StatusBadRequest, "INVALID_URL"
+		case errors.Is(err, services.ErrInvalidFilename):
+			status, code = fiber.StatusBadRequest, "INVALID_NAME"
+		case isInvalidPath(err):
+			status, code = fiber.StatusBadRequest, "INVALID_PATH"
+		case errors.Is(err, services.ErrBlockedAddress):
+			status, code = fiber.StatusForbidden, "BLOCKED_ADDRESS"
+		case errors.Is(err, services.ErrNotFound):
+			status = fiber.StatusNotFound
+		case errors.Is(err, services.ErrFileTooLarge):
+			status, code = fiber.StatusRequestEntityTooLarge, "FILE_TOO_LARGE"
+		case errors.Is(err, services.ErrFetchFailed), errors.Is(err, services.ErrMaxRedirects):
+			status = fiber.StatusBadGateway
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to fetch URL", code, err.Error()),
+		)
+	}
+
+	parts := strings.SplitN(result, ":", 2)
+	fetchID := parts[0]
+	path := ""
+	if len(parts) > 1 {
+		path = parts[1]
+	}
+
+	progress, _ := svc.GetProgress(fetchID)
+
+	return c.Status(fiber.StatusAccepted).JSON(models.NewSuccessResponse("Fetch started", fiber.Map{
+		"fetch_id": fetchID,
+		"path":     path,
+		"progress": progress,
+	}))
+}
+
+// Progress handles GET /api/v1/fs/fetch/progress/:id (SSE)
+func (h *FetchHandler) Progress(c *fiber.Ctx) error {
+	fetchID := c.Params("id")
+	if fetchID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_ID", "Fetch ID is required"),
+		)
+	}
+
+	return streamProgress(c, h.progressStore, fetchID, "fetch")
+}
@@ -1,31 +1,49 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"filemanager-api/internal/metrics"
 	"filemanager-api/internal/middleware"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/services"
 	"filemanager-api/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 // FileManagerHandler handles all file and folder HTTP requests
 type FileManagerHandler struct {
 	progressStore *models.ProgressStore
+	chownOwners   []string // owners allowed for chown besides the usersite itself
 }
 
 // NewFileManagerHandler creates a new file manager handler
-func NewFileManagerHandler(progressStore *models.ProgressStore) *FileManagerHandler {
-	return &FileManagerHandler{progressStore: progressStore}
+func NewFileManagerHandler(progressStore *models.ProgressStore, chownOwners []string) *FileManagerHandler {
+	return &FileManagerHandler{progressStore: progressStore, chownOwners: chownOwners}
 }
 
 // getService returns a file manager service for the current user (local or remote)
 func (h *FileManagerHandler) getService(c *fiber.Ctx) (*services.FileManagerService, error) {
-	userCtx := middleware.GetUserContext(c)
+	return userService(middleware.GetUserContext(c))
+}
+
+// userService returns a file manager service for userCtx, connected to its SSH host if it has one
+func userService(userCtx *middleware.UserContext) (*services.FileManagerService, error) {
 	if userCtx == nil {
 		return nil, services.ErrPermissionDenied
 	}
@@ -38,15 +56,60 @@ func (h *FileManagerHandler) getService(c *fiber.Ctx) (*services.FileManagerServ
 			Username:   userCtx.SSHConfig.Username,
 			PrivateKey: userCtx.SSHConfig.PrivateKey,
 		}
-		return services.NewRemoteFileManagerService(userCtx.BasePath, sshConfig, userCtx.UserSite)
+		svc, err := services.NewRemoteFileManagerService(userCtx.BasePath, sshConfig, userCtx.UserSite)
+		if err != nil {
+			return nil, err
+		}
+		svc.SetPreserveOwner(userCtx.PreserveOwner)
+		return svc, nil
 	}
 
 	// Local service
-	return services.NewFileManagerService(userCtx.BasePath, userCtx.UserSite), nil
+	svc := services.NewFileManagerService(userCtx.BasePath, userCtx.UserSite)
+	svc.SetPreserveOwner(userCtx.PreserveOwner)
+	return svc, nil
+}
+
+// isInvalidPath reports whether err comes from rejecting a client supplied path or name
+func isInvalidPath(err error) bool {
+	return errors.Is(err, utils.ErrInvalidPath) || errors.Is(err, utils.ErrPathTraversal)
+}
+
+// pathParam returns the wildcard path parameter, percent-decoded exactly once and
+// with backslashes normalized to forward slashes. Traversal is rejected later by
+// utils.ValidatePath, which sees the same normalized form.
+func pathParam(c *fiber.Ctx) (string, error) {
+	path, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", utils.ErrInvalidPath, err)
+	}
+	return utils.NormalizeSeparators(path), nil
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidPathParam responds to a path parameter pathParam could not decode
+func invalidPathParam(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(
+		models.NewErrorResponse("Bad Request", "INVALID_PATH", err.Error()),
+	)
 }
 
 // handleServiceError handles errors from getService with proper error messages
 func (h *FileManagerHandler) handleServiceError(c *fiber.Ctx, err error) error {
+	return serviceError(c, err)
+}
+
+// serviceError answers a request whose service could not be created
+func serviceError(c *fiber.Ctx, err error) error {
 	if errors.Is(err, services.ErrSSHConnection) {
 		return c.Status(fiber.StatusBadGateway).JSON(
 			models.NewErrorResponse("SSH Connection Failed", "SSH_ERROR", err.Error()),
@@ -69,7 +132,14 @@ func (h *FileManagerHandler) List(c *fiber.Ctx) error {
 
 	path := c.Query("path", "")
 
-	items, err := svc.List(path)
+	filter, err := services.ParseListFilter(c.Query("type"), c.Query("ext"), c.Query("mime"), c.Query("hidden"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_FILTER", err.Error()),
+		)
+	}
+
+	items, err := svc.List(path, filter)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(
 			models.NewErrorResponse("Failed to list directory", "LIST_ERROR", err.Error()),
@@ -91,22 +161,39 @@ func (h *FileManagerHandler) GetDiskUsage(c *fiber.Ctx) error {
 
 	path := c.Query("path", "")
 
-	size, err := svc.GetDiskUsage(path)
+	symlinks, err := utils.ParseSymlinkPolicy(c.Query("symlinks"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_SYMLINK_POLICY", err.Error()),
+		)
+	}
+
+	size, err := svc.GetDiskUsage(c.UserContext(), path, symlinks)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(
 			models.NewErrorResponse("Failed to calculate disk usage", "DISK_USAGE_ERROR", err.Error()),
 		)
 	}
 
+	fsStats, err := svc.GetFilesystemStats(path)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(
+			models.NewErrorResponse("Failed to read filesystem stats", "DISK_USAGE_ERROR", err.Error()),
+		)
+	}
+
 	return c.JSON(models.NewSuccessResponse("Disk usage calculated", fiber.Map{
-		"path":       path,
-		"size_bytes": size,
-		"size_human": utils.FormatFileSize(size),
+		"path":         path,
+		"size_bytes":   size,
+		"size_human":   utils.FormatFileSize(size),
+		"total_bytes":  fsStats.TotalBytes,
+		"free_bytes":   fsStats.FreeBytes,
+		"used_percent": fsStats.UsedPercent,
 	}))
 }
 
-// GetInfo handles GET /api/v1/fs/info/*
-func (h *FileManagerHandler) GetInfo(c *fiber.Ctx) error {
+// TreeStats handles GET /api/v1/fs/stats
+func (h *FileManagerHandler) TreeStats(c *fiber.Ctx) error {
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
@@ -115,108 +202,53 @@ func (h *FileManagerHandler) GetInfo(c *fiber.Ctx) error {
 		defer svc.Close()
 	}
 
-	path, _ := url.PathUnescape(c.Params("*"))
-	if path == "" {
-		path = "."
-	}
-
-	info, err := svc.GetInfo(path)
+	stats, err := svc.TreeStats(c.UserContext(), c.Query("path", ""), c.QueryBool("refresh"))
 	if err != nil {
 		status := fiber.StatusInternalServerError
 		if errors.Is(err, services.ErrNotFound) {
 			status = fiber.StatusNotFound
+		} else if errors.Is(err, services.ErrNotAFolder) || isInvalidPath(err) {
+			status = fiber.StatusBadRequest
 		}
 		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to get info", "GET_INFO_ERROR", err.Error()),
+			models.NewErrorResponse("Failed to calculate tree stats", "STATS_ERROR", err.Error()),
 		)
 	}
 
-	return c.JSON(models.NewSuccessResponse("Info retrieved", info))
+	return c.JSON(models.NewSuccessResponse("Tree stats calculated", stats))
 }
 
-// Download handles GET /api/v1/fs/download/*
-func (h *FileManagerHandler) Download(c *fiber.Ctx) error {
+// Mount handles GET /api/v1/fs/mount?path=
+func (h *FileManagerHandler) Mount(c *fiber.Ctx) error {
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
 	}
-
-	path, _ := url.PathUnescape(c.Params("*"))
-	if path == "" {
-		if svc.IsRemote() {
-			svc.Close()
-		}
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
-		)
-	}
-
-	// For remote files, use the streaming approach
 	if svc.IsRemote() {
-		reader, info, err := svc.GetContent(path)
-		if err != nil {
-			svc.Close()
-			status := fiber.StatusInternalServerError
-			if errors.Is(err, services.ErrNotFound) {
-				status = fiber.StatusNotFound
-			} else if errors.Is(err, services.ErrNotAFile) {
-				status = fiber.StatusBadRequest
-			}
-			return c.Status(status).JSON(
-				models.NewErrorResponse("Failed to download", "DOWNLOAD_ERROR", err.Error()),
-			)
-		}
-
-		// Read all content before closing SSH connection
-		data, readErr := io.ReadAll(reader)
-		reader.Close()
-		svc.Close()
-		if readErr != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(
-				models.NewErrorResponse("Failed to download", "DOWNLOAD_ERROR", readErr.Error()),
-			)
-		}
-
-		c.Set("Content-Type", info.MimeType)
-		c.Set("Content-Disposition", "attachment; filename=\""+info.Name+"\"")
-		return c.Send(data)
-	}
-
-	// For local files, use SendFile which is more reliable
-	fullPath, err := svc.GetFullPath(path)
-	if err != nil {
-		status := fiber.StatusInternalServerError
-		if errors.Is(err, services.ErrNotFound) {
-			status = fiber.StatusNotFound
-		}
-		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to download", "DOWNLOAD_ERROR", err.Error()),
-		)
+		defer svc.Close()
 	}
 
-	info, err := svc.GetInfo(path)
+	mount, err := svc.Mount(c.Query("path", ""))
 	if err != nil {
 		status := fiber.StatusInternalServerError
 		if errors.Is(err, services.ErrNotFound) {
 			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
 		}
 		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to download", "DOWNLOAD_ERROR", err.Error()),
-		)
-	}
-
-	if info.IsDir {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Failed to download", "DOWNLOAD_ERROR", "Cannot download a directory"),
+			models.NewErrorResponse("Failed to read mount", "MOUNT_ERROR", err.Error()),
 		)
 	}
 
-	c.Set("Content-Disposition", "attachment; filename=\""+info.Name+"\"")
-	return c.SendFile(fullPath, false)
+	return c.JSON(models.NewSuccessResponse("Mount retrieved", mount))
 }
 
-// CreateFile handles POST /api/v1/fs/file
-func (h *FileManagerHandler) CreateFile(c *fiber.Ctx) error {
+// defaultTreeDepth is how many levels a tree expands without a depth parameter
+const defaultTreeDepth = 3
+
+// Tree handles GET /api/v1/fs/tree?path=&depth=3
+func (h *FileManagerHandler) Tree(c *fiber.Ctx) error {
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
@@ -225,35 +257,51 @@ func (h *FileManagerHandler) CreateFile(c *fiber.Ctx) error {
 		defer svc.Close()
 	}
 
-	var req models.CreateFileRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
-		)
-	}
-
-	if req.Path == "" {
+	depth := c.QueryInt("depth", defaultTreeDepth)
+	if depth < 1 {
 		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+			models.NewErrorResponse("Bad Request", "INVALID_DEPTH", "depth must be at least 1"),
 		)
 	}
 
-	info, err := svc.CreateFile(req.Path, req.Content)
+	tree, err := svc.Tree(c.UserContext(), c.Query("path", ""), depth)
 	if err != nil {
 		status := fiber.StatusInternalServerError
-		if errors.Is(err, services.ErrAlreadyExists) {
-			status = fiber.StatusConflict
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if errors.Is(err, services.ErrNotAFolder) || isInvalidPath(err) {
+			status = fiber.StatusBadRequest
 		}
 		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to create file", "CREATE_ERROR", err.Error()),
+			models.NewErrorResponse("Failed to read tree", "TREE_ERROR", err.Error()),
 		)
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(models.NewSuccessResponse("File created", info))
+	return c.JSON(models.NewSuccessResponse("Tree read successfully", tree))
 }
 
-// UpdateFile handles PUT /api/v1/fs/file/*
-func (h *FileManagerHandler) UpdateFile(c *fiber.Ctx) error {
+// defaultRecentFiles is how many files a recent listing returns without a limit parameter
+const defaultRecentFiles = 50
+
+// Recent handles GET /api/v1/fs/recent?path=&limit=50&since=<RFC3339>&skip_hidden=true
+func (h *FileManagerHandler) Recent(c *fiber.Ctx) error {
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_SINCE", "since must be an RFC3339 timestamp, e.g. 2026-01-18T12:00:00Z"),
+			)
+		}
+		since = parsed
+	}
+	limit := c.QueryInt("limit", defaultRecentFiles)
+	if limit < 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_LIMIT", "limit must be at least 1"),
+		)
+	}
+
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
@@ -262,38 +310,24 @@ func (h *FileManagerHandler) UpdateFile(c *fiber.Ctx) error {
 		defer svc.Close()
 	}
 
-	path, _ := url.PathUnescape(c.Params("*"))
-	if path == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
-		)
-	}
-
-	var req models.UpdateFileRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
-		)
-	}
-
-	info, err := svc.UpdateFile(path, req.Content)
+	files, err := svc.Recent(c.UserContext(), c.Query("path", ""), since, limit, c.QueryBool("skip_hidden"))
 	if err != nil {
 		status := fiber.StatusInternalServerError
 		if errors.Is(err, services.ErrNotFound) {
 			status = fiber.StatusNotFound
-		} else if errors.Is(err, services.ErrNotAFile) {
+		} else if errors.Is(err, services.ErrNotAFolder) || isInvalidPath(err) {
 			status = fiber.StatusBadRequest
 		}
 		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to update file", "UPDATE_ERROR", err.Error()),
+			models.NewErrorResponse("Failed to list recent files", "RECENT_ERROR", err.Error()),
 		)
 	}
 
-	return c.JSON(models.NewSuccessResponse("File updated", info))
+	return c.JSON(models.NewSuccessResponse("Recent files listed", files))
 }
 
-// CreateFolder handles POST /api/v1/fs/folder
-func (h *FileManagerHandler) CreateFolder(c *fiber.Ctx) error {
+// GetInfo handles GET /api/v1/fs/info/*
+func (h *FileManagerHandler) GetInfo(c *fiber.Ctx) error {
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
@@ -302,81 +336,66 @@ func (h *FileManagerHandler) CreateFolder(c *fiber.Ctx) error {
 		defer svc.Close()
 	}
 
-	var req models.CreateFolderRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
-		)
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
 	}
-
-	if req.Path == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
-		)
+	if path == "" {
+		path = "."
 	}
 
-	info, err := svc.CreateFolder(req.Path)
+	info, err := svc.GetInfo(path)
 	if err != nil {
 		status := fiber.StatusInternalServerError
-		if errors.Is(err, services.ErrAlreadyExists) {
-			status = fiber.StatusConflict
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
 		}
 		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to create folder", "CREATE_ERROR", err.Error()),
+			models.NewErrorResponse("Failed to get info", "GET_INFO_ERROR", err.Error()),
 		)
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(models.NewSuccessResponse("Folder created", info))
-}
-
-// Rename handles PUT /api/v1/fs/rename/*
-func (h *FileManagerHandler) Rename(c *fiber.Ctx) error {
-	svc, err := h.getService(c)
-	if err != nil {
-		return h.handleServiceError(c, err)
-	}
-	if svc.IsRemote() {
-		defer svc.Close()
+	// Hashing reads the whole file, so it is only done on request
+	if algo := c.Query("hash"); algo != "" && !info.IsDir {
+		digest, err := svc.Hash(path, algo)
+		if err != nil {
+			status := fiber.StatusInternalServerError
+			if errors.Is(err, utils.ErrUnsupportedHash) {
+				status = fiber.StatusBadRequest
+			}
+			return c.Status(status).JSON(
+				models.NewErrorResponse("Failed to hash file", "HASH_ERROR", err.Error()),
+			)
+		}
+		info.Hash = digest
+		info.HashAlgo = strings.ToLower(algo)
 	}
 
-	path, _ := url.PathUnescape(c.Params("*"))
-	if path == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
-		)
-	}
+	return c.JSON(models.NewSuccessResponse("Info retrieved", info))
+}
 
-	var req models.RenameRequest
+// InfoBatch handles POST /api/v1/fs/info-batch, returning the info of every requested path
+// in order. Paths that cannot be read carry an error instead of failing the request.
+func (h *FileManagerHandler) InfoBatch(c *fiber.Ctx) error {
+	var req models.InfoBatchRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(
 			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
 		)
 	}
 
-	if req.NewName == "" {
+	if len(req.Paths) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_NAME", "New name is required"),
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Paths are required"),
 		)
 	}
 
-	info, err := svc.Rename(path, req.NewName)
-	if err != nil {
-		status := fiber.StatusInternalServerError
-		if errors.Is(err, services.ErrNotFound) {
-			status = fiber.StatusNotFound
-		} else if errors.Is(err, services.ErrAlreadyExists) {
-			status = fiber.StatusConflict
-		}
-		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to rename", "RENAME_ERROR", err.Error()),
-		)
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
 	}
 
-	return c.JSON(models.NewSuccessResponse("Renamed successfully", info))
-}
-
-// Delete handles DELETE /api/v1/fs/*
-func (h *FileManagerHandler) Delete(c *fiber.Ctx) error {
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
@@ -385,61 +404,1375 @@ func (h *FileManagerHandler) Delete(c *fiber.Ctx) error {
 		defer svc.Close()
 	}
 
-	path, _ := url.PathUnescape(c.Params("*"))
-	if path == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
-		)
-	}
-
-	recursive := c.Query("recursive", "false") == "true"
-
-	if err := svc.Delete(path, recursive); err != nil {
-		status := fiber.StatusInternalServerError
-		if errors.Is(err, services.ErrNotFound) {
-			status = fiber.StatusNotFound
-		} else if errors.Is(err, services.ErrFolderNotEmpty) {
-			status = fiber.StatusConflict
-		}
-		return c.Status(status).JSON(
-			models.NewErrorResponse("Failed to delete", "DELETE_ERROR", err.Error()),
-		)
-	}
-
-	return c.JSON(models.NewSuccessResponse("Deleted successfully", nil))
+	return c.JSON(models.NewSuccessResponse("Info retrieved", svc.GetInfoBatch(req.Paths)))
 }
 
-// Copy handles POST /api/v1/fs/copy
-func (h *FileManagerHandler) Copy(c *fiber.Ctx) error {
+// Download handles GET /api/v1/fs/download/*
+func (h *FileManagerHandler) Download(c *fiber.Ctx) error {
 	svc, err := h.getService(c)
 	if err != nil {
 		return h.handleServiceError(c, err)
 	}
-	if svc.IsRemote() {
-		defer svc.Close()
-	}
 
-	var req models.CopyRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
-		)
+	path, err := pathParam(c)
+	if err != nil {
+		if svc.IsRemote() {
+			svc.Close()
+		}
+		return invalidPathParam(c, err)
 	}
-
-	if len(req.Sources) == 0 || req.Destination == "" {
+	if path == "" {
+		if svc.IsRemote() {
+			svc.Close()
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(
-			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Sources and destination are required"),
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
 		)
 	}
 
-	copied, err := svc.Copy(req.Sources, req.Destination, req.Overwrite)
+	// Both modes stat first, so a missing path, a folder and a broken SSH connection are
+	// reported alike, and the client's cached copy is validated before anything is transferred
+	stat, err := svc.StatFile(path)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(
+		if svc.IsRemote() {
+			svc.Close()
+		}
+		return downloadError(c, err)
+	}
+	if notModified(c, fileETag(stat, ""), stat.ModTime()) {
+		if svc.IsRemote() {
+			svc.Close()
+		}
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	// For remote files, use the streaming approach
+	if svc.IsRemote() {
+		reader, info, err := svc.GetContent(path)
+		if err != nil {
+			svc.Close()
+			return downloadError(c, err)
+		}
+
+		// Read all content before closing SSH connection
+		data, readErr := io.ReadAll(reader)
+		reader.Close()
+		svc.Close()
+		if readErr != nil {
+			return downloadError(c, readErr)
+		}
+
+		setDownloadHeaders(c, info)
+		metrics.BytesTransferred.WithLabelValues("download").Add(float64(len(data)))
+		return c.Send(data)
+	}
+
+	// For local files, use SendFile which is more reliable
+	fullPath, err := svc.GetFullPath(path)
+	if err != nil {
+		return downloadError(c, err)
+	}
+	info := &models.FileInfo{Name: stat.Name(), Size: stat.Size()}
+
+	// Encrypted uploads are decrypted on the fly, so ranges cannot be served
+	if encrypted, _, _ := svc.Encrypted(path); encrypted {
+		reader, info, err := svc.GetContent(path)
+		if err != nil {
+			return downloadError(c, err)
+		}
+		setDownloadHeaders(c, info)
+		c.Set("Accept-Ranges", "none")
+		metrics.BytesTransferred.WithLabelValues("download").Add(float64(info.Size))
+		return c.SendStream(reader, int(info.Size))
+	}
+
+	if err := c.SendFile(fullPath, false); err != nil {
+		return err
+	}
+	// The file handler sets its own Content-Type, so ours is applied afterwards
+	setDownloadHeaders(c, info)
+	metrics.BytesTransferred.WithLabelValues("download").Add(float64(info.Size))
+	return nil
+}
+
+// DownloadHead handles HEAD /api/v1/fs/download/*, answering with the headers of a
+// download from a single stat. Remote files are never opened or transferred.
+func (h *FileManagerHandler) DownloadHead(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil || path == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+
+	stat, err := svc.StatFile(path)
+	if err != nil {
+		return c.SendStatus(downloadStatus(err))
+	}
+
+	if notModified(c, fileETag(stat, ""), stat.ModTime()) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	setDownloadHeaders(c, &models.FileInfo{Name: stat.Name()})
+	size := stat.Size()
+	if encrypted, contentSize, _ := svc.Encrypted(path); encrypted {
+		size = contentSize
+		c.Set("Accept-Ranges", "none")
+	} else {
+		c.Set("Accept-Ranges", "bytes")
+	}
+	c.Response().Header.SetContentLength(int(size))
+	c.Response().SkipBody = true
+	return nil
+}
+
+// downloadStatus is the status of a failed download, the same locally and on SSH hosts:
+// 404 for a missing file, 400 for a folder or an invalid path and 502 when the SSH host
+// could not be reached
+func downloadStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrNotAFile), isInvalidPath(err):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrSSHConnection):
+		return fiber.StatusBadGateway
+	}
+	return fiber.StatusInternalServerError
+}
+
+// downloadError answers a failed download with the status of downloadStatus
+func downloadError(c *fiber.Ctx, err error) error {
+	status := downloadStatus(err)
+	code := "DOWNLOAD_ERROR"
+	if status == fiber.StatusBadGateway {
+		code = "SSH_ERROR"
+	}
+	return c.Status(status).JSON(
+		models.NewErrorResponse("Failed to download", code, err.Error()),
+	)
+}
+
+// setDownloadHeaders sets Content-Type and Content-Disposition for a download.
+// ?inline=true lets browsers preview the file instead of saving it.
+func setDownloadHeaders(c *fiber.Ctx, info *models.FileInfo) {
+	contentType := utils.GetMimeType(info.Name)
+	if strings.HasPrefix(contentType, "text/") && !strings.Contains(contentType, "charset") {
+		contentType += "; charset=utf-8"
+	}
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+
+	c.Set("Content-Type", contentType)
+	c.Set("Content-Disposition", disposition+"; filename=\""+info.Name+"\"")
+}
+
+// fileETag derives an entity tag from size and modification time, so it can be
+// computed from a stat alone (also over SFTP) without reading the file.
+// variant distinguishes representations of the same file, e.g. thumbnail sizes.
+func fileETag(info os.FileInfo, variant string) string {
+	tag := fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+	if variant != "" {
+		tag += "-" + variant
+	}
+	return `"` + tag + `"`
+}
+
+// notModified sets the ETag and Last-Modified validators and reports whether
+// the client's cached copy, per If-None-Match or If-Modified-Since, is still current
+func notModified(c *fiber.Ctx, etag string, modTime time.Time) bool {
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, modTime.UTC().Format(http.TimeFormat))
+	return c.Fresh()
+}
+
+const (
+	defaultPreviewBytes = 64 * 1024
+	maxPreviewBytes     = 1024 * 1024
+)
+
+// Preview handles GET /api/v1/fs/preview/*?bytes=65536&lines=200
+func (h *FileManagerHandler) Preview(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	maxBytes := int64(c.QueryInt("bytes", defaultPreviewBytes))
+	if maxBytes <= 0 || maxBytes > maxPreviewBytes {
+		maxBytes = maxPreviewBytes
+	}
+	maxLines := c.QueryInt("lines", 0)
+	if maxLines < 0 {
+		maxLines = 0
+	}
+
+	encoding := c.Query("encoding", "utf8")
+	if encoding != "utf8" && encoding != "base64" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_ENCODING", "Encoding must be utf8 or base64"),
+		)
+	}
+
+	preview, err := svc.Preview(path, maxBytes, maxLines, encoding == "base64")
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrNotAFile) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrBinaryFile) {
+			status = fiber.StatusUnsupportedMediaType
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to preview file", "PREVIEW_ERROR", err.Error()),
+		)
+	}
+
+	if encoding == "base64" {
+		preview.Content = base64.StdEncoding.EncodeToString([]byte(preview.Content))
+		preview.Encoding = "base64"
+	}
+
+	return c.JSON(models.NewSuccessResponse("Preview retrieved", preview))
+}
+
+const (
+	defaultTailLines = 10
+	maxTailLines     = 1000
+)
+
+// Tail handles GET /api/v1/fs/tail/*?lines=10 (SSE)
+func (h *FileManagerHandler) Tail(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		if svc.IsRemote() {
+			svc.Close()
+		}
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		if svc.IsRemote() {
+			svc.Close()
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	info, err := svc.GetInfo(path)
+	if err == nil && info.IsDir {
+		err = services.ErrNotAFile
+	}
+	if err != nil {
+		if svc.IsRemote() {
+			svc.Close()
+		}
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrNotAFile) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to tail file", "TAIL_ERROR", err.Error()),
+		)
+	}
+
+	backlog := c.QueryInt("lines", defaultTailLines)
+	if backlog < 0 {
+		backlog = 0
+	} else if backlog > maxTailLines {
+		backlog = maxTailLines
+	}
+
+	setSSEHeaders(c)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The service (and its SSH connection) lives as long as the stream
+		if svc.IsRemote() {
+			defer svc.Close()
+		}
+
+		err := svc.Tail(path, backlog, func(lines []string) error {
+			if len(lines) == 0 {
+				// Keepalive comment; a failed flush means the client is gone
+				fmt.Fprint(w, ": keepalive\n\n")
+				return w.Flush()
+			}
+			for _, line := range lines {
+				data, _ := json.Marshal(fiber.Map{"line": line})
+				fmt.Fprintf(w, "data: %s\n\n", data)
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			data, _ := json.Marshal(fiber.Map{"error": err.Error()})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.Flush()
+		}
+	})
+
+	return nil
+}
+
+// Thumbnail handles GET /api/v1/fs/thumbnail/*?w=200&h=200
+func (h *FileManagerHandler) Thumbnail(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	width := thumbnailDimension(c.Query("w"))
+	height := thumbnailDimension(c.Query("h"))
+
+	// Thumbnails change exactly when their source does, so the source stat
+	// (plus the requested size) validates the client's copy without rendering
+	if stat, err := svc.Stat(path); err == nil && !stat.IsDir() {
+		if notModified(c, fileETag(stat, fmt.Sprintf("%dx%d", width, height)), stat.ModTime()) {
+			return c.SendStatus(fiber.StatusNotModified)
+		}
+	}
+
+	thumbPath, contentType, err := services.NewThumbnailService(svc).Thumbnail(path, width, height)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrNotAFile) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrUnsupportedType) {
+			status = fiber.StatusUnsupportedMediaType
+		} else if errors.Is(err, services.ErrImageTooLarge) {
+			status = fiber.StatusUnprocessableEntity
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to generate thumbnail", "THUMBNAIL_ERROR", err.Error()),
+		)
+	}
+
+	c.Set("Content-Type", contentType)
+	return c.SendFile(thumbPath, false)
+}
+
+// thumbnailDimension parses a requested thumbnail bound, clamping it to the supported range
+func thumbnailDimension(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return services.DefaultThumbnailSize
+	}
+	if n > services.MaxThumbnailSize {
+		return services.MaxThumbnailSize
+	}
+	return n
+}
+
+// CreateFile handles POST /api/v1/fs/file
+func (h *FileManagerHandler) CreateFile(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	var req models.CreateFileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	content, err := decodeContent(req.Content, req.Encoding)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_ENCODING", err.Error()),
+		)
+	}
+
+	info, err := svc.CreateFile(req.Path, content)
+	if isInvalidPath(err) {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", err.Error()),
+		)
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrAlreadyExists) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to create file", "CREATE_ERROR", err.Error()),
+		)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.NewSuccessResponse("File created", info))
+}
+
+// decodeContent turns request content into raw file bytes according to its encoding
+func decodeContent(content, encoding string) (string, error) {
+	switch encoding {
+	case "", "utf8":
+		return content, nil
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return "", fmt.Errorf("content is not valid base64: %v", err)
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("unsupported encoding: %s", encoding)
+}
+
+// UpdateFile handles PUT /api/v1/fs/file/*
+func (h *FileManagerHandler) UpdateFile(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	var req models.UpdateFileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	content, err := decodeContent(req.Content, req.Encoding)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_ENCODING", err.Error()),
+		)
+	}
+
+	info, err := svc.UpdateFile(path, content)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrNotAFile) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to update file", "UPDATE_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("File updated", info))
+}
+
+// WriteAt handles PATCH /api/v1/fs/file/*
+func (h *FileManagerHandler) WriteAt(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	var req models.WriteAtRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.ContentBase64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", "content_base64 is not valid base64"),
+		)
+	}
+
+	info, err := svc.WriteAt(path, req.Offset, data)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrNotAFile) || errors.Is(err, services.ErrInvalidOffset) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to write file", "WRITE_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("File updated", info))
+}
+
+const (
+	defaultHexDumpBytes = 256
+	maxHexDumpBytes     = 64 * 1024
+)
+
+// HexDump handles GET /api/v1/fs/hexdump/*?offset=0&length=256
+func (h *FileManagerHandler) HexDump(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	offset, err := strconv.ParseInt(c.Query("offset", "0"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_OFFSET", "Offset must be a number"),
+		)
+	}
+	length := c.QueryInt("length", defaultHexDumpBytes)
+	if length <= 0 || length > maxHexDumpBytes {
+		length = maxHexDumpBytes
+	}
+
+	dump, err := svc.HexDump(path, offset, length)
+	if err != nil {
+		status, code := fiber.StatusInternalServerError, "HEXDUMP_ERROR"
+		switch {
+		case isInvalidPath(err):
+			status, code = fiber.StatusBadRequest, "INVALID_PATH"
+		case errors.Is(err, services.ErrInvalidOffset):
+			status, code = fiber.StatusBadRequest, "INVALID_OFFSET"
+		case errors.Is(err, services.ErrNotFound):
+			status = fiber.StatusNotFound
+		case errors.Is(err, services.ErrNotAFile):
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.NewErrorResponse("Failed to dump file", code, err.Error()))
+	}
+
+	return c.JSON(models.NewSuccessResponse("Hex dump retrieved", dump))
+}
+
+// ReadLines handles GET /api/v1/fs/lines/*?start=100&end=200
+func (h *FileManagerHandler) ReadLines(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	lines, err := svc.ReadLines(path, c.QueryInt("start", 1), c.QueryInt("end", 0))
+	if err != nil {
+		return linesFailure(c, "Failed to read lines", err)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Lines retrieved", lines))
+}
+
+// WriteLines handles PUT /api/v1/fs/lines/*
+func (h *FileManagerHandler) WriteLines(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	var req models.WriteLinesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	lines, err := svc.WriteLines(path, req.Start, req.End, req.Content)
+	if err != nil {
+		return linesFailure(c, "Failed to write lines", err)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Lines replaced", lines))
+}
+
+// linesFailure maps a line range error to its HTTP response
+func linesFailure(c *fiber.Ctx, message string, err error) error {
+	status, code := fiber.StatusInternalServerError, "LINES_ERROR"
+	switch {
+	case isInvalidPath(err):
+		status, code = fiber.StatusBadRequest, "INVALID_PATH"
+	case errors.Is(err, services.ErrInvalidRange):
+		status, code = fiber.StatusBadRequest, "INVALID_RANGE"
+	case errors.Is(err, services.ErrNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, services.ErrNotAFile), errors.Is(err, services.ErrBinaryFile):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, services.ErrFileTooLarge):
+		status, code = fiber.StatusRequestEntityTooLarge, "RANGE_TOO_LARGE"
+	}
+	return c.Status(status).JSON(models.NewErrorResponse(message, code, err.Error()))
+}
+
+// CreateFolder handles POST /api/v1/fs/folder
+func (h *FileManagerHandler) CreateFolder(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	var req models.CreateFolderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	info, err := svc.CreateFolder(req.Path)
+	if isInvalidPath(err) {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", err.Error()),
+		)
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrAlreadyExists) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to create folder", "CREATE_ERROR", err.Error()),
+		)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.NewSuccessResponse("Folder created", info))
+}
+
+// CreateFolders handles POST /api/v1/fs/folders
+func (h *FileManagerHandler) CreateFolders(c *fiber.Ctx) error {
+	var req models.CreateFoldersRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Paths) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Paths are required"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
+	}
+
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	return c.JSON(models.NewSuccessResponse("Folders created", svc.CreateFolders(req.Paths)))
+}
+
+// Rename handles PUT /api/v1/fs/rename/*
+func (h *FileManagerHandler) Rename(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	var req models.RenameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.NewName == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_NAME", "New name is required"),
+		)
+	}
+
+	info, err := svc.Rename(path, req.NewName)
+	if errors.Is(err, services.ErrInvalidFilename) {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_NAME", err.Error()),
+		)
+	}
+	if isInvalidPath(err) {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", err.Error()),
+		)
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if errors.Is(err, services.ErrAlreadyExists) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to rename", "RENAME_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Renamed successfully", info))
+}
+
+// Chown handles POST /api/v1/fs/chown
+func (h *FileManagerHandler) Chown(c *fiber.Ctx) error {
+	var req models.ChownRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+	if req.Owner == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_OWNER", "Owner is required"),
+		)
+	}
+	if req.Group == "" {
+		req.Group = req.Owner
+	}
+
+	// Only the usersite itself and explicitly configured owners may be assigned,
+	// so a client cannot hand files to root or another site
+	userCtx := middleware.GetUserContext(c)
+	if userCtx == nil {
+		return h.handleServiceError(c, services.ErrPermissionDenied)
+	}
+	for _, name := range []string{req.Owner, req.Group} {
+		if name != userCtx.UserSite && !containsString(h.chownOwners, name) {
+			return c.Status(fiber.StatusForbidden).JSON(
+				models.NewErrorResponse("Forbidden", "OWNER_NOT_ALLOWED", fmt.Sprintf("%q is not an allowed owner", name)),
+			)
+		}
+	}
+
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	info, err := svc.Chown(req.Path, req.Owner, req.Group, req.Recursive)
+	if isInvalidPath(err) {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", err.Error()),
+		)
+	}
+	if errors.Is(err, services.ErrUnknownOwner) {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_OWNER", err.Error()),
+		)
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to change owner", "CHOWN_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Owner changed successfully", info))
+}
+
+// GetXattrs handles GET /api/v1/fs/xattr/*?encoding=
+func (h *FileManagerHandler) GetXattrs(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		path = "."
+	}
+
+	encoding := c.Query("encoding", "utf8")
+	if encoding != "utf8" && encoding != "base64" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_ENCODING", "Encoding must be utf8 or base64"),
+		)
+	}
+
+	attrs, err := svc.GetXattrs(path)
+	if err != nil {
+		return xattrFailure(c, err)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Extended attributes retrieved", xattrInfo(path, attrs, encoding)))
+}
+
+// xattrInfo encodes attribute values for a response. Binary values such as
+// capabilities cannot be sent as text, so any of them switches all values to base64.
+func xattrInfo(path string, attrs map[string][]byte, encoding string) models.XattrInfo {
+	for _, value := range attrs {
+		if !utf8.Valid(value) {
+			encoding = "base64"
+			break
+		}
+	}
+	info := models.XattrInfo{Path: path, Attributes: make(map[string]string, len(attrs)), Encoding: encoding}
+	for name, value := range attrs {
+		if encoding == "base64" {
+			info.Attributes[name] = base64.StdEncoding.EncodeToString(value)
+		} else {
+			info.Attributes[name] = string(value)
+		}
+	}
+	return info
+}
+
+// SetXattrs handles PUT /api/v1/fs/xattr/*
+func (h *FileManagerHandler) SetXattrs(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		path = "."
+	}
+
+	var req models.XattrRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+	if len(req.Attributes) == 0 && len(req.Remove) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Attributes or remove is required"),
+		)
+	}
+
+	attrs := make(map[string][]byte, len(req.Attributes))
+	for name, value := range req.Attributes {
+		decoded, err := decodeContent(value, req.Encoding)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_ENCODING", err.Error()),
+			)
+		}
+		attrs[name] = []byte(decoded)
+	}
+
+	if err := svc.SetXattrs(path, attrs, req.Remove); err != nil {
+		return xattrFailure(c, err)
+	}
+
+	updated, err := svc.GetXattrs(path)
+	if err != nil {
+		return xattrFailure(c, err)
+	}
+
+	encoding := req.Encoding
+	if encoding == "" {
+		encoding = "utf8"
+	}
+	return c.JSON(models.NewSuccessResponse("Extended attributes updated", xattrInfo(path, updated, encoding)))
+}
+
+// xattrFailure maps an extended attribute error to its HTTP response
+func xattrFailure(c *fiber.Ctx, err error) error {
+	status, code := fiber.StatusInternalServerError, "XATTR_ERROR"
+	switch {
+	case isInvalidPath(err):
+		status, code = fiber.StatusBadRequest, "INVALID_PATH"
+	case errors.Is(err, services.ErrNotFound), errors.Is(err, services.ErrXattrNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, services.ErrXattrNotSupported):
+		status, code = fiber.StatusNotImplemented, "NOT_SUPPORTED"
+	case errors.Is(err, services.ErrPermissionDenied):
+		status, code = fiber.StatusForbidden, "PERMISSION_DENIED"
+	}
+	return c.Status(status).JSON(
+		models.NewErrorResponse("Failed to access extended attributes", code, err.Error()),
+	)
+}
+
+// Delete handles DELETE /api/v1/fs/*
+func (h *FileManagerHandler) Delete(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	path, err := pathParam(c)
+	if err != nil {
+		return invalidPathParam(c, err)
+	}
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATH", "Path is required"),
+		)
+	}
+
+	recursive := c.Query("recursive", "false") == "true"
+
+	if err := svc.Delete(path, recursive); err != nil {
+		if errors.Is(err, services.ErrTooManyMatches) {
+			return tooManyItems(c)
+		}
+		if errors.Is(err, services.ErrFolderNotEmpty) {
+			return c.Status(fiber.StatusConflict).JSON(
+				models.NewErrorResponse("Failed to delete", "FOLDER_NOT_EMPTY", err.Error()+"; pass recursive=true to delete it with its contents"),
+			)
+		}
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrNoMatches) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to delete", "DELETE_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Deleted successfully", nil))
+}
+
+// DeleteBatch handles POST /api/v1/fs/delete-batch, deleting every requested path over one
+// service. Paths that cannot be deleted carry an error instead of failing the request.
+func (h *FileManagerHandler) DeleteBatch(c *fiber.Ctx) error {
+	var req models.DeleteBatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Paths) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Paths are required"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
+	}
+
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	return c.JSON(models.NewSuccessResponse("Delete finished", svc.DeleteBatch(req.Paths, req.Recursive)))
+}
+
+// Replace handles POST /api/v1/fs/replace
+func (h *FileManagerHandler) Replace(c *fiber.Ctx) error {
+	var req models.ReplaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Paths) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Paths are required"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Paths)) {
+		return tooManyItems(c)
+	}
+
+	replacer, err := services.NewReplacer(req.Find, req.Replace, req.Regex)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_PATTERN", err.Error()),
+		)
+	}
+
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	results := svc.Replace(req.Paths, replacer, req.DryRun)
+	total := 0
+	for _, r := range results {
+		total += r.Matches
+	}
+
+	message := "Replace finished"
+	if req.DryRun {
+		message = "Replace previewed"
+	}
+	return c.JSON(models.NewSuccessResponse(message, fiber.Map{
+		"results":       results,
+		"total_matches": total,
+		"dry_run":       req.DryRun,
+	}))
+}
+
+// Copy handles POST /api/v1/fs/copy
+func (h *FileManagerHandler) Copy(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	var req models.CopyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Sources) == 0 || req.Destination == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Sources and destination are required"),
+		)
+	}
+
+	if req.PreserveStructure && req.PreserveTree {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "preserve_structure and preserve_tree cannot be combined"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Sources)) {
+		return tooManyItems(c)
+	}
+
+	policy, err := services.ParseConflictPolicy(req.OverwritePolicy, req.Overwrite)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_OVERWRITE_POLICY", err.Error()),
+		)
+	}
+
+	copied, err := svc.Copy(c.UserContext(), req.Sources, req.Destination, services.CopyOptions{
+		Policy:            policy,
+		PreserveStructure: req.PreserveStructure,
+		Base:              req.Base,
+		Preserve:          req.Preserve,
+		Hardlink:          req.Hardlink,
+		PreserveTree:      req.PreserveTree,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyMatches) {
+			return tooManyItems(c)
+		}
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNoMatches) {
+			status = fiber.StatusNotFound
+		} else if errors.Is(err, context.Canceled) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(
 			models.NewErrorResponse("Failed to copy", "COPY_ERROR", err.Error()),
 		)
 	}
 
-	return c.JSON(models.NewSuccessResponse("Copied successfully", copied))
+	return copyResponse(c, copied, "Copied", "copy", "COPY_ERROR")
+}
+
+// copyResponse answers a copy or move with the result of every source. Sources that failed
+// while others succeeded are reported in their results with 200. When every source failed
+// the request fails with the status of the first failure, still listing all results.
+func copyResponse(c *fiber.Ctx, results []models.CopyResult, done, verb, code string) error {
+	failed := failedResults(results)
+	switch {
+	case len(failed) == 0:
+		return c.JSON(models.NewSuccessResponse(done+" successfully", results))
+	case len(failed) < len(results):
+		return c.JSON(models.NewSuccessResponse(
+			fmt.Sprintf("Partially %s: %d of %d sources failed", strings.ToLower(done), len(failed), len(results)), results))
+	}
+
+	status := fiber.StatusInternalServerError
+	switch failed[0].Code {
+	case "NOT_FOUND":
+		status = fiber.StatusNotFound
+	case "INVALID_PATH", "OUTSIDE_COPY_BASE":
+		status = fiber.StatusBadRequest
+	case "PERMISSION_DENIED":
+		status = fiber.StatusForbidden
+	}
+	response := models.NewErrorResponse("Failed to "+verb, code, failed[0].Error)
+	response.Data = results
+	return c.Status(status).JSON(response)
+}
+
+// failedResults returns the results of the sources that could not be copied or moved
+func failedResults(results []models.CopyResult) []models.CopyResult {
+	var failed []models.CopyResult
+	for _, result := range results {
+		if result.Failed() {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Duplicate handles POST /api/v1/fs/duplicate
+func (h *FileManagerHandler) Duplicate(c *fiber.Ctx) error {
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	var req models.DuplicateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Path is required"),
+		)
+	}
+
+	info, err := svc.Duplicate(c.UserContext(), req.Path)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, context.Canceled) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to duplicate", "DUPLICATE_ERROR", err.Error()),
+		)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.NewSuccessResponse("Duplicated successfully", info))
+}
+
+// Diff handles POST /api/v1/fs/diff
+func (h *FileManagerHandler) Diff(c *fiber.Ctx) error {
+	var req models.DiffRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if req.A == "" || req.B == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Paths a and b are required"),
+		)
+	}
+
+	svc, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if svc.IsRemote() {
+		defer svc.Close()
+	}
+
+	// A side explicitly marked local is served from the local base path even in remote mode
+	var local *services.FileManagerService
+	sideService := func(location string) *services.FileManagerService {
+		if location != "local" || !svc.IsRemote() {
+			return svc
+		}
+		if local == nil {
+			userCtx := middleware.GetUserContext(c)
+			local = services.NewFileManagerService(userCtx.LocalBasePath, userCtx.UserSite)
+			local.SetPreserveOwner(userCtx.PreserveOwner)
+		}
+		return local
+	}
+
+	result, err := services.Diff(sideService(req.ALocation), req.A, sideService(req.BLocation), req.B, req.Hash)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, services.ErrTooManyEntries) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to compare", "DIFF_ERROR", err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Compared successfully", result))
 }
 
 // Move handles POST /api/v1/fs/move
@@ -465,12 +1798,159 @@ func (h *FileManagerHandler) Move(c *fiber.Ctx) error {
 		)
 	}
 
-	moved, err := svc.Move(req.Sources, req.Destination, req.Overwrite)
+	if exceedsBatchLimit(len(req.Sources)) {
+		return tooManyItems(c)
+	}
+
+	policy, err := services.ParseConflictPolicy(req.OverwritePolicy, req.Overwrite)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_OVERWRITE_POLICY", err.Error()),
+		)
+	}
+
+	// Moves that have to copy (across filesystems) report progress under this ID
+	progressID := req.ProgressID
+	if progressID == "" {
+		progressID = uuid.New().String()
+	}
+	h.progressStore.Set(progressID, &models.Progress{
+		ID:        progressID,
+		Operation: models.OperationMove,
+		Status:    models.StatusProcessing,
+	})
+	c.Set("X-Progress-ID", progressID)
+
+	ctx, release := services.TrackOperation(c.UserContext(), progressID, middleware.GetUserContext(c).UserSite)
+	defer release()
+
+	moved, err := svc.Move(ctx, req.Sources, req.Destination, policy, req.PreserveTree, func(copied, total int64) {
+		h.progressStore.Modify(progressID, func(p *models.Progress) { p.TotalBytes = total })
+		h.progressStore.Update(progressID, copied)
+	})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(
+		h.progressStore.Fail(progressID, err)
+		if errors.Is(err, services.ErrTooManyMatches) {
+			return tooManyItems(c)
+		}
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNoMatches) {
+			status = fiber.StatusNotFound
+		} else if errors.Is(err, context.Canceled) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(
 			models.NewErrorResponse("Failed to move", "MOVE_ERROR", err.Error()),
 		)
 	}
 
-	return c.JSON(models.NewSuccessResponse("Moved successfully", moved))
+	if failed := failedResults(moved); len(failed) > 0 && len(failed) == len(moved) {
+		h.progressStore.Fail(progressID, errors.New(failed[0].Error))
+	} else {
+		h.progressStore.Complete(progressID)
+	}
+
+	return copyResponse(c, moved, "Moved", "move", "MOVE_ERROR")
+}
+
+// Transfer handles POST /api/v1/fs/transfer, copying or moving between the local base path
+// and the host given by the SSH headers. Both sides validate paths against their own base path.
+func (h *FileManagerHandler) Transfer(c *fiber.Ctx) error {
+	var req models.TransferRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+
+	if len(req.Sources) == 0 || req.Destination == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Sources and destination are required"),
+		)
+	}
+	if req.SourceLocation == "" {
+		req.SourceLocation = "local"
+	}
+	if req.SourceLocation != "local" && req.SourceLocation != "remote" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "source_location must be local or remote"),
+		)
+	}
+
+	if exceedsBatchLimit(len(req.Sources)) {
+		return tooManyItems(c)
+	}
+
+	remote, err := h.getService(c)
+	if err != nil {
+		return h.handleServiceError(c, err)
+	}
+	if !remote.IsRemote() {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "REMOTE_REQUIRED", "Transfers need the SSH headers of the remote side"),
+		)
+	}
+	defer remote.Close()
+
+	userCtx := middleware.GetUserContext(c)
+	local := services.NewFileManagerService(userCtx.LocalBasePath, userCtx.UserSite)
+	local.SetPreserveOwner(userCtx.PreserveOwner)
+
+	src, dst := local, remote
+	if req.SourceLocation == "remote" {
+		src, dst = remote, local
+	}
+
+	progressID := req.ProgressID
+	if progressID == "" {
+		progressID = uuid.New().String()
+	}
+	h.progressStore.Set(progressID, &models.Progress{
+		ID:        progressID,
+		Operation: models.OperationTransfer,
+		Status:    models.StatusProcessing,
+	})
+	c.Set("X-Progress-ID", progressID)
+
+	ctx, release := services.TrackOperation(c.UserContext(), progressID, middleware.GetUserContext(c).UserSite)
+	defer release()
+
+	opts := services.TransferOptions{Overwrite: req.Overwrite, Move: req.Move}
+	transferred, err := services.Transfer(ctx, src, req.Sources, dst, req.Destination, opts, func(sent, total int64) {
+		h.progressStore.Modify(progressID, func(p *models.Progress) { p.TotalBytes = total })
+		h.progressStore.Update(progressID, sent)
+	})
+	if err != nil {
+		h.progressStore.Fail(progressID, err)
+		if errors.Is(err, services.ErrTooManyMatches) {
+			return tooManyItems(c)
+		}
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, services.ErrNotFound) || errors.Is(err, services.ErrNoMatches) {
+			status = fiber.StatusNotFound
+		} else if isInvalidPath(err) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, context.Canceled) {
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to transfer", "TRANSFER_ERROR", err.Error()),
+		)
+	}
+
+	h.progressStore.Complete(progressID)
+
+	return c.JSON(models.NewSuccessResponse("Transferred successfully", transferred))
+}
+
+// MoveProgress handles GET /api/v1/fs/move/progress/:id and /api/v1/fs/transfer/progress/:id (SSE)
+func (h *FileManagerHandler) MoveProgress(c *fiber.Ctx) error {
+	progressID := c.Params("id")
+	if progressID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_ID", "Progress ID is required"),
+		)
+	}
+
+	return streamProgress(c, h.progressStore, progressID, "operation")
 }
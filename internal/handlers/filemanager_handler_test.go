@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newFileManagerApp routes /fs requests of a local usersite below base to a file manager
+// handler, mounted as in cmd/main.go
+func newFileManagerApp(base string, routes func(fs fiber.Router, h *FileManagerHandler)) *fiber.App {
+	app := fiber.New()
+	fs := app.Group("/api/v1/fs", withLocalUser(base))
+	routes(fs, NewFileManagerHandler(models.NewProgressStore(), nil))
+	return app
+}
+
+// doJSON sends a request with an optional JSON body and decodes the standard response
+func doJSON(t *testing.T, app *fiber.App, method, url string, body interface{}) (*http.Response, models.StandardResponse) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, url, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var decoded models.StandardResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatalf("%s %s: response is not JSON: %v", method, url, err)
+	}
+	return resp, decoded
+}
+
+func TestBase64ContentRoundTrip(t *testing.T) {
+	base := t.TempDir()
+	app := newFileManagerApp(base, func(fs fiber.Router, h *FileManagerHandler) {
+		fs.Get("/preview/*", h.Preview)
+		fs.Post("/file", h.CreateFile)
+		fs.Put("/file/*", h.UpdateFile)
+	})
+
+	// Null bytes, bytes that are never valid UTF-8, a truncated sequence and a line break
+	created := []byte("\x00\x01\xff\xfe\x80head\x00\ntail\xc3\x28\xe2\x82")
+	updated := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x00")
+
+	resp, body := doJSON(t, app, "POST", "/api/v1/fs/file", fiber.Map{
+		"path": "bin.dat", "content": base64.StdEncoding.EncodeToString(created), "encoding": "base64",
+	})
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("create: status = %d, error = %+v", resp.StatusCode, body.Error)
+	}
+	assertPreview := func(want []byte) {
+		t.Helper()
+		if data, err := os.ReadFile(filepath.Join(base, "bin.dat")); err != nil || !bytes.Equal(data, want) {
+			t.Fatalf("stored bytes = %q, %v, want %q", data, err, want)
+		}
+		resp, body := doJSON(t, app, "GET", "/api/v1/fs/preview/bin.dat?encoding=base64", nil)
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("preview: status = %d, error = %+v", resp.StatusCode, body.Error)
+		}
+		preview := body.Data.(map[string]interface{})
+		if preview["encoding"] != "base64" {
+			t.Fatalf("preview encoding = %v, want base64", preview["encoding"])
+		}
+		got, err := base64.StdEncoding.DecodeString(preview["content"].(string))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("preview decodes to %q, %v, want %q", got, err, want)
+		}
+	}
+	assertPreview(created)
+
+	resp, body = doJSON(t, app, "PUT", "/api/v1/fs/file/bin.dat", fiber.Map{
+		"content": base64.StdEncoding.EncodeToString(updated), "encoding": "base64",
+	})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("update: status = %d, error = %+v", resp.StatusCode, body.Error)
+	}
+	assertPreview(updated)
+
+	// Binary content is only previewed as base64
+	if resp, _ := doJSON(t, app, "GET", "/api/v1/fs/preview/bin.dat", nil); resp.StatusCode == fiber.StatusOK {
+		t.Fatal("binary file previewed as utf8")
+	}
+
+	resp, body = doJSON(t, app, "POST", "/api/v1/fs/file", fiber.Map{
+		"path": "bad.dat", "content": "not base64!", "encoding": "base64",
+	})
+	if resp.StatusCode != fiber.StatusBadRequest || body.Error == nil || body.Error.Code != "INVALID_ENCODING" {
+		t.Fatalf("invalid base64: status = %d, error = %+v, want 400 INVALID_ENCODING", resp.StatusCode, body.Error)
+	}
+	if _, err := os.Stat(filepath.Join(base, "bad.dat")); !os.IsNotExist(err) {
+		t.Fatal("a file was created from invalid base64")
+	}
+}
+
+func TestPathParam(t *testing.T) {
+	app := fiber.New()
+	app.Get("/p/*", func(c *fiber.Ctx) error {
+		path, err := pathParam(c)
+		if err != nil {
+			return invalidPathParam(c, err)
+		}
+		return c.SendString(path)
+	})
+
+	tests := []struct {
+		name, raw, want string
+		valid           bool
+	}{
+		{"plain", "a/b.txt", "a/b.txt", true},
+		{"encoded space", "a%20b.txt", "a b.txt", true},
+		{"encoded slashes", "..%2f..%2fetc%2fpasswd", "../../etc/passwd", true},
+		{"encoded backslashes", "..%5c..%5cx", "../../x", true},
+		{"raw backslashes", `..\..\x`, "../../x", true},
+		{"mixed separators", `a/..\..%2fx`, "a/../../x", true},
+		{"decoded exactly once", "..%252f..%252fx", "..%2f..%2fx", true},
+		{"broken escape", "a%zzb", "", false},
+		{"truncated escape", "a%2", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// RequestURI is sent as is, so escapes and backslashes reach the server unchanged
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RequestURI = "/p/" + tt.raw
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if !tt.valid {
+				if resp.StatusCode != fiber.StatusBadRequest {
+					t.Fatalf("pathParam(%q): status = %d, want 400", tt.raw, resp.StatusCode)
+				}
+				return
+			}
+			if resp.StatusCode != fiber.StatusOK || string(body) != tt.want {
+				t.Fatalf("pathParam(%q) = %d %q, want %q", tt.raw, resp.StatusCode, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathParamTraversalRejected(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "u1")
+	writeFile := func(rel string) {
+		full := filepath.Join(base, rel)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("docs/a.txt")
+	// A sibling of the usersite a traversal would reach
+	if err := os.WriteFile(filepath.Join(filepath.Dir(base), "secret"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newFileManagerApp(base, func(fs fiber.Router, h *FileManagerHandler) {
+		fs.Get("/info/*", h.GetInfo)
+	})
+	for raw, want := range map[string]int{
+		"docs/a.txt":              fiber.StatusOK,
+		`docs\a.txt`:              fiber.StatusOK,
+		"docs%2fa.txt":            fiber.StatusOK,
+		"..%2fsecret":             fiber.StatusBadRequest,
+		"docs%2f..%2f..%2fsecret": fiber.StatusBadRequest,
+		`..\secret`:               fiber.StatusBadRequest,
+		`docs/..\..\secret`:       fiber.StatusBadRequest,
+		"..%5csecret":             fiber.StatusBadRequest,
+		"..%252fsecret":           fiber.StatusNotFound, // a name containing %2f, not a traversal
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RequestURI = "/api/v1/fs/info/" + raw
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET info/%s: status = %d, want %d", raw, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRenameRejectsPathsInNewName(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "u1")
+	if err := os.MkdirAll(filepath.Join(base, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	app := newFileManagerApp(base, func(fs fiber.Router, h *FileManagerHandler) {
+		fs.Put("/rename/*", h.Rename)
+	})
+
+	for _, name := range []string{"../evil", "..", ".", "sub/evil", `..\evil`, "/tmp/evil"} {
+		if err := os.WriteFile(filepath.Join(base, "docs/a.txt"), []byte("a"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		resp, decoded := doJSON(t, app, "PUT", "/api/v1/fs/rename/docs/a.txt", models.RenameRequest{NewName: name})
+		if resp.StatusCode != fiber.StatusBadRequest || decoded.Error == nil || decoded.Error.Code != "INVALID_NAME" {
+			t.Errorf("rename to %q: status = %d, error = %+v, want 400 INVALID_NAME", name, resp.StatusCode, decoded.Error)
+		}
+		if _, err := os.Stat(filepath.Join(base, "docs/a.txt")); err != nil {
+			t.Fatalf("rename to %q moved the file", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(base), "evil")); !os.IsNotExist(err) {
+		t.Fatal("a file was renamed out of the usersite")
+	}
+
+	resp, decoded := doJSON(t, app, "PUT", "/api/v1/fs/rename/docs/a.txt", models.RenameRequest{NewName: "b.txt"})
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("rename to b.txt: status = %d, error = %+v", resp.StatusCode, decoded.Error)
+	}
+	if _, err := os.Stat(filepath.Join(base, "docs/b.txt")); err != nil {
+		t.Fatal("b.txt missing after rename")
+	}
+}
+
+// startSSHServer serves SFTP on the local filesystem and runs commands with sh over SSH on a
+// free local port. It returns the connection details of a remote usersite on it and the
+// folder commands run in.
+func startSSHServer(t *testing.T) (*middleware.SSHConfig, string) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	home := t.TempDir()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config, home)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return &middleware.SSHConfig{
+		Host:       host,
+		Port:       port,
+		Username:   "test",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+	}, home
+}
+
+// serveSSH answers the sftp subsystem and exec requests, run with sh in home, of every
+// session on conn; other requests are refused
+func serveSSH(conn net.Conn, config *ssh.ServerConfig, home string) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// The payload of subsystem and exec requests is the length-prefixed name or command
+				if req.Type == "exec" && len(req.Payload) > 4 {
+					req.Reply(true, nil)
+					go runCommand(channel, string(req.Payload[4:]), home)
+					continue
+				}
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer channel.Close()
+					if server, err := sftp.NewServer(channel); err == nil {
+						server.Serve()
+						server.Close()
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// runCommand runs command with sh in dir on channel and reports its exit status
+func runCommand(channel ssh.Channel, command, dir string) {
+	defer channel.Close()
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = dir
+	cmd.Stdin = channel
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+	status := 0
+	if err := cmd.Run(); err != nil {
+		status = 1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			status = exitErr.ExitCode()
+		}
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+// withRemoteUser stands in for the auth middleware with a usersite below base on the SSH host of cfg
+func withRemoteUser(base string, cfg *middleware.SSHConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user", &middleware.UserContext{UserSite: "u1", BasePath: base, LocalBasePath: base,
+			IsRemote: true, SSHConfig: cfg, PreserveOwner: true})
+		return c.Next()
+	}
+}
+
+func TestDownloadStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{services.ErrNotFound, fiber.StatusNotFound},
+		{fmt.Errorf("open docs/a.txt: %w", services.ErrNotFound), fiber.StatusNotFound},
+		{services.ErrNotAFile, fiber.StatusBadRequest},
+		{utils.ErrPathTraversal, fiber.StatusBadRequest},
+		{utils.ErrInvalidPath, fiber.StatusBadRequest},
+		{fmt.Errorf("%w: connection reset", services.ErrSSHConnection), fiber.StatusBadGateway},
+		{errors.New("disk error"), fiber.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := downloadStatus(tt.err); got != tt.want {
+			t.Errorf("downloadStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	cfg, _ := startSSHServer(t)
+	users := map[string]func(base string) fiber.Handler{
+		"local":  withLocalUser,
+		"remote": func(base string) fiber.Handler { return withRemoteUser(base, cfg) },
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+		// The SFTP server of pkg/sftp reports a file in the middle of the path as a generic
+		// failure, where OpenSSH's reports no such file
+		localOnly bool
+	}{
+		{"docs/a.txt", fiber.StatusOK, "", false},
+		{"docs/missing.txt", fiber.StatusNotFound, "DOWNLOAD_ERROR", false},
+		{"docs/a.txt/below", fiber.StatusNotFound, "DOWNLOAD_ERROR", true},
+		{"docs", fiber.StatusBadRequest, "DOWNLOAD_ERROR", false},
+		{"..%2fsecret", fiber.StatusBadRequest, "DOWNLOAD_ERROR", false},
+	}
+	for mode, user := range users {
+		t.Run(mode, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "u1")
+			if err := os.MkdirAll(filepath.Join(base, "docs"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(base, "docs/a.txt"), []byte("hello"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			os.WriteFile(filepath.Join(filepath.Dir(base), "secret"), []byte("x"), 0644)
+
+			app := fiber.New()
+			fs := app.Group("/api/v1/fs", user(base))
+			h := NewFileManagerHandler(models.NewProgressStore(), nil)
+			fs.Get("/download/*", h.Download)
+			fs.Head("/download/*", h.DownloadHead)
+
+			for _, tt := range tests {
+				if tt.localOnly && mode == "remote" {
+					continue
+				}
+				for _, method := range []string{"GET", "HEAD"} {
+					req := httptest.NewRequest(method, "/", nil)
+					req.RequestURI = "/api/v1/fs/download/" + tt.path
+					resp, err := app.Test(req, -1)
+					if err != nil {
+						t.Fatal(err)
+					}
+					body, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode != tt.status {
+						t.Errorf("%s %s: status = %d, want %d: %s", method, tt.path, resp.StatusCode, tt.status, body)
+						continue
+					}
+					if method == "HEAD" {
+						continue
+					}
+					if tt.status == fiber.StatusOK {
+						if string(body) != "hello" {
+							t.Errorf("GET %s: body = %q, want hello", tt.path, body)
+						}
+						continue
+					}
+					var decoded models.StandardResponse
+					if err := json.Unmarshal(body, &decoded); err != nil || decoded.Error == nil || decoded.Error.Code != tt.code {
+						t.Errorf("GET %s: body = %s, want error code %s", tt.path, body, tt.code)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadUnreachableHost(t *testing.T) {
+	cfg, _ := startSSHServer(t)
+	// Nothing listens on the port of a closed listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cfg.Port, _ = net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	app := fiber.New()
+	fs := app.Group("/api/v1/fs", withRemoteUser(t.TempDir(), cfg))
+	h := NewFileManagerHandler(models.NewProgressStore(), nil)
+	fs.Get("/download/*", h.Download)
+	fs.Head("/download/*", h.DownloadHead)
+
+	resp, decoded := doJSON(t, app, "GET", "/api/v1/fs/download/a.txt", nil)
+	if resp.StatusCode != fiber.StatusBadGateway || decoded.Error == nil || decoded.Error.Code != "SSH_ERROR" {
+		t.Fatalf("GET: status = %d, error = %+v, want 502 SSH_ERROR", resp.StatusCode, decoded.Error)
+	}
+	resp, err = app.Test(httptest.NewRequest("HEAD", "/api/v1/fs/download/a.txt", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusBadGateway {
+		t.Fatalf("HEAD: status = %d, want 502", resp.StatusCode)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"filemanager-api/internal/utils"
+	"fmt"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	basePath string
+	version  string
+}
+
+// NewHealthHandler creates a new health handler
+func NewHealthHandler(basePath, version string) *HealthHandler {
+	return &HealthHandler{basePath: basePath, version: version}
+}
+
+// Health handles GET /health, a cheap liveness probe
+func (h *HealthHandler) Health(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"status":  "healthy",
+		"version": h.version,
+	})
+}
+
+// Ready handles GET /ready, checking that the base path is usable
+func (h *HealthHandler) Ready(c *fiber.Ctx) error {
+	checks := fiber.Map{}
+	ready := true
+
+	fail := func(name string, err error) {
+		checks[name] = err.Error()
+		ready = false
+	}
+
+	info, err := os.Stat(h.basePath)
+	switch {
+	case err != nil:
+		fail("base_path", err)
+	case !info.IsDir():
+		fail("base_path", fmt.Errorf("%s is not a directory", h.basePath))
+	default:
+		checks["base_path"] = "ok"
+
+		// Writability is only proven by actually writing
+		if probe, err := os.CreateTemp(h.basePath, ".ready-*"); err != nil {
+			fail("writable", err)
+		} else {
+			probe.Close()
+			os.Remove(probe.Name())
+			checks["writable"] = "ok"
+		}
+	}
+
+	disk := fiber.Map{}
+	if total, free, used, err := utils.GetFilesystemStats(h.basePath); err != nil {
+		fail("disk", err)
+	} else {
+		checks["disk"] = "ok"
+		disk = fiber.Map{
+			"total":        total,
+			"free":         free,
+			"used_percent": utils.UsedPercent(used, free),
+		}
+	}
+
+	status := "ready"
+	code := fiber.StatusOK
+	if !ready {
+		status = "not_ready"
+		code = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status":  status,
+		"version": h.version,
+		"checks":  checks,
+		"disk":    disk,
+	})
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MetricsHandler exposes operational metrics of the running instance
+type MetricsHandler struct {
+	chunkStore *services.ChunkStore
+}
+
+// NewMetricsHandler creates a new metrics handler
+func NewMetricsHandler(chunkStore *services.ChunkStore) *MetricsHandler {
+	return &MetricsHandler{chunkStore: chunkStore}
+}
+
+// Get handles GET /api/v1/metrics
+func (h *MetricsHandler) Get(c *fiber.Ctx) error {
+	return c.JSON(models.NewSuccessResponse("Metrics retrieved", fiber.Map{
+		"chunked_uploads": h.chunkStore.Stats(),
+	}))
+}
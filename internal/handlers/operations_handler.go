@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// OperationsHandler reports the load on heavy background operations
+type OperationsHandler struct {
+	progressStore *models.ProgressStore
+}
+
+// NewOperationsHandler creates a new operations handler
+func NewOperationsHandler(progressStore *models.ProgressStore) *OperationsHandler {
+	return &OperationsHandler{progressStore: progressStore}
+}
+
+// Stats handles GET /api/v1/operations
+func (h *OperationsHandler) Stats(c *fiber.Ctx) error {
+	return c.JSON(models.NewSuccessResponse("Operation stats retrieved", fiber.Map{
+		"limits": services.GetOperationStats(),
+		"active": h.progressStore.CountActive(),
+	}))
+}
+
+// Cancel handles DELETE /api/v1/operations/:id, stopping a running compress, extract, upload,
+// move or transfer of the calling usersite by its progress ID
+func (h *OperationsHandler) Cancel(c *fiber.Ctx) error {
+	userCtx := middleware.GetUserContext(c)
+	if userCtx == nil {
+		return serviceError(c, services.ErrPermissionDenied)
+	}
+	id := c.Params("id")
+	if !services.CancelOperation(id, userCtx.UserSite) {
+		return c.Status(fiber.StatusNotFound).JSON(
+			models.NewErrorResponse("Not Found", "OPERATION_NOT_FOUND", "No running operation with this ID"),
+		)
+	}
+	return c.JSON(models.NewSuccessResponse("Cancellation requested", fiber.Map{"id": id}))
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCancelOperationOfAnotherUsersite(t *testing.T) {
+	ctx, release := services.TrackOperation(context.Background(), "op-u2", "u2")
+	defer release()
+
+	h := NewOperationsHandler(models.NewProgressStore())
+	cancel := func(base string) int {
+		app := fiber.New()
+		app.Delete("/operations/:id", withLocalUser(base), h.Cancel)
+		resp, err := app.Test(httptest.NewRequest("DELETE", "/operations/op-u2", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// withLocalUser acts as usersite u1
+	if status := cancel(t.TempDir()); status != fiber.StatusNotFound {
+		t.Fatalf("u1 cancelling an operation of u2: status = %d, want 404", status)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("the operation of u2 was cancelled by u1")
+	}
+
+	if !services.CancelOperation("op-u2", "u2") {
+		t.Fatal("u2 could not cancel its own operation")
+	}
+	if ctx.Err() == nil {
+		t.Fatal("the operation was not cancelled by its owner")
+	}
+}
+
+func TestCancelOwnOperation(t *testing.T) {
+	ctx, release := services.TrackOperation(context.Background(), "op-u1", "u1")
+	defer release()
+
+	app := fiber.New()
+	app.Delete("/operations/:id", withLocalUser(t.TempDir()), NewOperationsHandler(models.NewProgressStore()).Cancel)
+	resp, err := app.Test(httptest.NewRequest("DELETE", "/operations/op-u1", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("the operation was not cancelled")
+	}
+}
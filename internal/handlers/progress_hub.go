@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"filemanager-api/internal/models"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// progressStreamCaps bound the progress streams (SSE and WebSocket) open at once, in total
+// and per usersite. Zero disables a cap.
+var progressStreamCaps struct {
+	total   int
+	perUser int
+}
+
+// ConfigureProgressStreamCaps sets how many progress streams may be open at once in total
+// and per usersite. Zero disables a cap.
+func ConfigureProgressStreamCaps(total, perUser int) {
+	progressStreamCaps.total = total
+	progressStreamCaps.perUser = perUser
+}
+
+// progressHub counts the open progress streams and shares one watcher per operation among
+// the streams following it, so more streams add no work of their own
+type progressHub struct {
+	mu      sync.Mutex
+	open    int
+	perUser map[string]int
+	feeds   map[string]*progressFeed
+}
+
+// progressStreams is the hub of every progress stream of the server
+var progressStreams = &progressHub{
+	perUser: make(map[string]int),
+	feeds:   make(map[string]*progressFeed),
+}
+
+// progressFeed holds the latest progress of one operation for the streams following it
+type progressFeed struct {
+	id          string
+	subscribers int           // guarded by the hub lock
+	stop        chan struct{} // closed once the last stream left
+
+	mu      sync.Mutex
+	data    []byte // progress as JSON, nil while unknown
+	ready   bool
+	final   bool          // the operation is done or unknown; nothing follows
+	changed chan struct{} // closed at the next change
+}
+
+// progressSubscription is one open progress stream
+type progressSubscription struct {
+	*progressFeed
+	userSite string
+}
+
+// subscribe reserves a stream for userSite following id, starting the watcher of id when
+// no other stream follows it. It returns false when a cap is reached.
+func (h *progressHub) subscribe(store *models.ProgressStore, id, userSite string) (*progressSubscription, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.fullLocked(userSite) {
+		return nil, false
+	}
+	// Both outlive the request, whose buffers Fiber reuses
+	id, userSite = utils.CopyString(id), utils.CopyString(userSite)
+	h.open++
+	h.perUser[userSite]++
+
+	feed := h.feeds[id]
+	if feed == nil {
+		feed = &progressFeed{id: id, stop: make(chan struct{}), changed: make(chan struct{})}
+		h.feeds[id] = feed
+		go h.watch(store, feed)
+	}
+	feed.subscribers++
+	return &progressSubscription{progressFeed: feed, userSite: userSite}, true
+}
+
+// full reports whether a new stream of userSite would exceed a cap
+func (h *progressHub) full(userSite string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.fullLocked(userSite)
+}
+
+func (h *progressHub) fullLocked(userSite string) bool {
+	caps := progressStreamCaps
+	return (caps.total > 0 && h.open >= caps.total) || (caps.perUser > 0 && h.perUser[userSite] >= caps.perUser)
+}
+
+// unsubscribe frees the stream of sub, stopping the watcher of its operation with the last one
+func (h *progressHub) unsubscribe(sub *progressSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	feed, userSite := sub.progressFeed, sub.userSite
+	h.open--
+	h.perUser[userSite]--
+	if h.perUser[userSite] <= 0 {
+		delete(h.perUser, userSite)
+	}
+
+	feed.subscribers--
+	if feed.subscribers == 0 {
+		if h.feeds[feed.id] == feed {
+			delete(h.feeds, feed.id)
+		}
+		close(feed.stop)
+	}
+}
+
+// watch reads the progress of feed whenever the store reports a change and wakes its
+// streams, until the operation is done or unknown or the last stream left. Changes closer
+// together than progressMinInterval are sent as one; the progress is also reread every
+// progressRecheckInterval in case it changed without going through the store.
+func (h *progressHub) watch(store *models.ProgressStore, feed *progressFeed) {
+	changes, cancel := store.Subscribe(feed.id)
+	defer cancel()
+	recheck := time.NewTicker(progressRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		var data []byte
+		progress, ok := store.Get(feed.id)
+		if ok {
+			data, _ = json.Marshal(progress)
+		}
+		final := !ok || progress.Status.Done()
+		feed.publish(data, final)
+
+		if final {
+			// Streams opened from now on start a new feed, which reads the final state again
+			h.mu.Lock()
+			if h.feeds[feed.id] == feed {
+				delete(h.feeds, feed.id)
+			}
+			h.mu.Unlock()
+			return
+		}
+
+		read := time.Now()
+		select {
+		case <-feed.stop:
+			return
+		case <-changes:
+		case <-recheck.C:
+		}
+		if wait := progressMinInterval - time.Since(read); wait > 0 {
+			select {
+			case <-feed.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// publish records the progress read and wakes the streams if it changed
+func (f *progressFeed) publish(data []byte, final bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ready && final == f.final && bytes.Equal(data, f.data) {
+		return
+	}
+	f.data, f.ready, f.final = data, true, final
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// latest returns the progress last read as JSON, nil when the operation is unknown, whether
+// it was read yet, whether it is final, and a channel closed at the next change
+func (f *progressFeed) latest() (data []byte, ready, final bool, next <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.data, f.ready, f.final, f.changed
+}
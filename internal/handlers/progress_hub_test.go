@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"filemanager-api/internal/models"
+	"testing"
+	"time"
+)
+
+// TestProgressFeedFollowsRunningJob follows an operation whose progress a job keeps changing,
+// as compress and extract jobs do, until it completes. Run with -race to check that
+// streams read the progress only through copies.
+func TestProgressFeedFollowsRunningJob(t *testing.T) {
+	store := models.NewProgressStore()
+	store.Set("job", &models.Progress{ID: "job", Operation: models.OperationCompress, TotalBytes: 1000, Status: models.StatusProcessing})
+
+	sub, ok := progressStreams.subscribe(store, "job", "u1")
+	if !ok {
+		t.Fatal("subscribe refused a stream")
+	}
+	defer progressStreams.unsubscribe(sub)
+
+	go func() {
+		for done := int64(0); done <= 1000; done += 10 {
+			store.Modify("job", func(p *models.Progress) {
+				p.CurrentFile = "file"
+				p.CurrentBytes = done
+			})
+			store.Update("job", done)
+			time.Sleep(time.Millisecond)
+		}
+		store.Complete("job")
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		data, _, final, next := sub.latest()
+		if final {
+			var progress models.Progress
+			if err := json.Unmarshal(data, &progress); err != nil {
+				t.Fatal(err)
+			}
+			if progress.Status != models.StatusCompleted || progress.Progress != 100 {
+				t.Fatalf("final progress = %s at %d%%, want completed at 100%%", progress.Status, progress.Progress)
+			}
+			return
+		}
+		select {
+		case <-next:
+		case <-deadline:
+			t.Fatal("stream did not see the job complete")
+		}
+	}
+}
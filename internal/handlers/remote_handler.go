@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RemoteHandler checks the SSH setup of remote usersites
+type RemoteHandler struct{}
+
+// NewRemoteHandler creates a new remote handler
+func NewRemoteHandler() *RemoteHandler {
+	return &RemoteHandler{}
+}
+
+// Test handles POST /api/v1/remote/test, connecting with the SSH headers of the request once
+// and reporting the host, the session user and whether the base path is usable
+func (h *RemoteHandler) Test(c *fiber.Ctx) error {
+	userCtx := middleware.GetUserContext(c)
+	if userCtx == nil || !userCtx.IsRemote || userCtx.SSHConfig == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "SSH_HEADERS_REQUIRED", "X-Ssh-Host and X-Ssh-Key headers are required"),
+		)
+	}
+
+	cfg := userCtx.SSHConfig
+	check, err := services.CheckRemote(userCtx.BasePath, &services.SSHConfig{
+		Host:       cfg.Host,
+		Port:       cfg.Port,
+		Username:   cfg.Username,
+		PrivateKey: cfg.PrivateKey,
+	})
+	if err != nil {
+		status, code := fiber.StatusBadGateway, "SSH_ERROR"
+		switch {
+		case errors.Is(err, services.ErrSSHAuth):
+			status, code = fiber.StatusForbidden, "SSH_AUTH_FAILED"
+		case errors.Is(err, services.ErrSSHUnreachable):
+			code = "SSH_UNREACHABLE"
+		case errors.Is(err, services.ErrBasePathMissing):
+			status, code = fiber.StatusNotFound, "BASE_PATH_NOT_FOUND"
+		}
+		return c.Status(status).JSON(models.NewErrorResponse("Remote test failed", code, err.Error()))
+	}
+
+	return c.JSON(models.NewSuccessResponse("Remote connection OK", check))
+}
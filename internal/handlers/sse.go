@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/models"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// progressMinInterval is the least time between two events of a progress stream; faster
+	// changes are sent together
+	progressMinInterval = 50 * time.Millisecond
+	// progressRecheckInterval is how often a followed operation is reread without a change
+	// reported by the store
+	progressRecheckInterval = 5 * time.Second
+	// heartbeatInterval is how often a progress stream without changes sends a comment,
+	// so proxies and load balancers do not close it as idle
+	heartbeatInterval = 15 * time.Second
+)
+
+// progressStreamLimits bound how long a progress stream stays open, so streams of
+// clients that went away without closing the connection cannot pile up
+var progressStreamLimits = struct {
+	idle time.Duration // without a change in progress
+	max  time.Duration // in total
+}{idle: 10 * time.Minute, max: time.Hour}
+
+// ConfigureProgressStreams sets how long a progress stream may go without a change in
+// progress and how long it may stay open at all. Zero disables a limit.
+func ConfigureProgressStreams(idle, max time.Duration) {
+	progressStreamLimits.idle = idle
+	progressStreamLimits.max = max
+}
+
+// setSSEHeaders prepares the response for a server-sent event stream. X-Accel-Buffering
+// stops nginx from buffering the stream, which would hold back every event until it ends.
+func setSSEHeaders(c *fiber.Ctx) {
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("Transfer-Encoding", "chunked")
+	c.Set("X-Accel-Buffering", "no")
+}
+
+// streamProgress sends the progress of id as server-sent events whenever it changes, until
+// the operation is done, the client disconnects or a stream limit is reached. notFound
+// names the operation in the error sent when id is unknown. It answers 429 when the
+// progress stream caps are reached.
+func streamProgress(c *fiber.Ctx, store *models.ProgressStore, id, notFound string) error {
+	sub, ok := progressStreams.subscribe(store, id, progressStreamUser(c))
+	if !ok {
+		return tooManyStreams(c)
+	}
+
+	setSSEHeaders(c)
+
+	// Done is closed when the server shuts down
+	done := c.Context().Done()
+	idle, max := progressStreamLimits.idle, progressStreamLimits.max
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer progressStreams.unsubscribe(sub)
+
+		// The limits are checked on every change and heartbeat
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+
+		// An immediate comment makes proxies pass the response on before the first change
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		started, changed, sent := time.Now(), time.Now(), time.Now()
+		var last []byte
+		for {
+			data, ready, final, next := sub.latest()
+			if ready {
+				if data == nil {
+					fmt.Fprintf(w, "data: {\"error\": \"%s not found\"}\n\n", notFound)
+					w.Flush()
+					return
+				}
+				if !bytes.Equal(data, last) {
+					last, changed, sent = data, time.Now(), time.Now()
+					fmt.Fprintf(w, "data: %s\n\n", data)
+					// A failed flush means the client is gone
+					if err := w.Flush(); err != nil || final {
+						return
+					}
+				}
+			}
+			if idle > 0 && time.Since(changed) >= idle {
+				sendStreamEnd(w, fmt.Sprintf("no progress for %s", idle))
+				return
+			}
+			if max > 0 && time.Since(started) >= max {
+				sendStreamEnd(w, fmt.Sprintf("stream open for %s", max))
+				return
+			}
+
+			select {
+			case <-done:
+				sendStreamEnd(w, "server is shutting down")
+				return
+			case <-next:
+			case <-ticker.C:
+				if time.Since(sent) >= heartbeatInterval/2 {
+					sent = time.Now()
+					fmt.Fprint(w, ": heartbeat\n\n")
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// progressStreamUser returns the usersite a progress stream counts against
+func progressStreamUser(c *fiber.Ctx) string {
+	if userCtx := middleware.GetUserContext(c); userCtx != nil {
+		return userCtx.UserSite
+	}
+	return ""
+}
+
+// tooManyStreams answers a progress stream refused by the progress stream caps
+func tooManyStreams(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusTooManyRequests).JSON(
+		models.NewErrorResponse("Too Many Requests", "TOO_MANY_STREAMS", "Too many concurrent progress streams, close one or poll the status instead"),
+	)
+}
+
+// ProgressStreamAvailable answers 429 before a progress WebSocket is upgraded when the
+// progress stream caps are reached; the socket handler still checks when it subscribes
+func ProgressStreamAvailable(c *fiber.Ctx) error {
+	if progressStreams.full(progressStreamUser(c)) {
+		return tooManyStreams(c)
+	}
+	return c.Next()
+}
+
+// sendStreamEnd sends the terminal event of a progress stream closed before the operation
+// finished; the operation itself continues and can be followed again by reconnecting
+func sendStreamEnd(w *bufio.Writer, reason string) {
+	data, _ := json.Marshal(fiber.Map{
+		"error": "progress stream closed: " + reason + ", reconnect to keep following",
+		"code":  "STREAM_CLOSED",
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	w.Flush()
+}
+
+// progressStatus answers with the current progress of id as a single JSON object, for
+// clients that poll instead of following the stream. notFound names the operation.
+func progressStatus(c *fiber.Ctx, store *models.ProgressStore, id, notFound string) error {
+	progress, ok := store.Get(id)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(
+			models.NewErrorResponse("Not Found", "NOT_FOUND", fmt.Sprintf("No %s with ID %s", notFound, id)),
+		)
+	}
+	return c.JSON(models.NewSuccessResponse("Progress retrieved", progress))
+}
@@ -1,18 +1,18 @@
 package handlers
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"filemanager-api/internal/middleware"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/services"
 	"bytes"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -21,30 +21,52 @@ import (
 // UploadHandler handles upload-related HTTP requests
 type UploadHandler struct {
 	progressStore *models.ProgressStore
+	chunkStore    *services.ChunkStore
+	rules         services.UploadRules
 }
 
 // NewUploadHandler creates a new upload handler
-func NewUploadHandler(progressStore *models.ProgressStore) *UploadHandler {
-	return &UploadHandler{progressStore: progressStore}
+func NewUploadHandler(progressStore *models.ProgressStore, chunkStore *services.ChunkStore, rules services.UploadRules) *UploadHandler {
+	return &UploadHandler{progressStore: progressStore, chunkStore: chunkStore, rules: rules}
 }
 
-// getUploadService returns an upload service for the current user
-func (h *UploadHandler) getUploadService(c *fiber.Ctx) *services.UploadService {
+// ruleViolation maps an upload rule error to its HTTP status and error code
+func ruleViolation(err error) (int, string, bool) {
+	switch {
+	case errors.Is(err, services.ErrFileTooLarge):
+		return fiber.StatusRequestEntityTooLarge, "FILE_TOO_LARGE", true
+	case errors.Is(err, services.ErrFileTypeNotAllowed):
+		return fiber.StatusUnsupportedMediaType, "FILE_TYPE_NOT_ALLOWED", true
+	}
+	return 0, "", false
+}
+
+// getUploadService returns an upload service for the current user, writing to the SSH
+// host when the request has SSH headers. It must be closed once the request is done.
+func (h *UploadHandler) getUploadService(c *fiber.Ctx) (*services.UploadService, error) {
 	userCtx := middleware.GetUserContext(c)
 	if userCtx == nil {
-		return nil
+		return nil, services.ErrPermissionDenied
 	}
-	return services.NewUploadService(userCtx.BasePath, userCtx.UserSite, h.progressStore)
+	if userCtx.IsRemote {
+		remote, err := userService(userCtx)
+		if err != nil {
+			return nil, err
+		}
+		return services.NewRemoteUploadService(remote, h.progressStore, h.chunkStore, h.rules), nil
+	}
+	svc := services.NewUploadService(userCtx.BasePath, userCtx.UserSite, h.progressStore, h.chunkStore, h.rules)
+	svc.SetPreserveOwner(userCtx.PreserveOwner)
+	return svc, nil
 }
 
 // Upload handles POST /api/v1/upload with streaming for large files
 func (h *UploadHandler) Upload(c *fiber.Ctx) error {
-	svc := h.getUploadService(c)
-	if svc == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(
-			models.NewErrorResponse("Unauthorized", "AUTH_ERROR", "User context not found"),
-		)
+	svc, err := h.getUploadService(c)
+	if err != nil {
+		return serviceError(c, err)
 	}
+	defer svc.Close()
 
 	contentType := c.Get("Content-Type")
 	if contentType == "" {
@@ -72,57 +94,204 @@ func (h *UploadHandler) Upload(c *fiber.Ctx) error {
 		reader = multipart.NewReader(bytes.NewReader(c.Body()), boundary)
 	}
 
-	// Get destination from form data
+	// Get destination and options from form data (must precede the file parts)
 	destination := ""
+	autoExtract := false
+	dedup := false
+	policy := services.OverwriteRename
+	var relativePaths []string
 
-	var filePart *multipart.Part
+	// Every file/file[] part is streamed to disk as it arrives
+	var uploads []fiber.Map
 	for {
 		part, err := reader.NextPart()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(
-				models.NewErrorResponse("Bad Request", "FORM_PARSE_ERROR", err.Error()),
-			)
+			return uploadFailed(c, fiber.StatusBadRequest, "Bad Request", "FORM_PARSE_ERROR", err, uploads)
 		}
 
-		if part.FormName() == "file" {
-			filePart = part
-			break
-		}
-
-		if part.FormName() == "destination" {
+		switch part.FormName() {
+		case "destination":
 			destBytes, _ := io.ReadAll(part)
 			destination = string(destBytes)
+			continue
+		case "auto_extract":
+			value, _ := io.ReadAll(part)
+			autoExtract = string(value) == "true"
+			// Refused as soon as it is requested, before any file part is stored
+			if autoExtract && svc.IsRemote() {
+				return c.Status(fiber.StatusNotImplemented).JSON(
+					models.NewErrorResponse("Not Implemented", "NOT_SUPPORTED", "auto_extract is only supported for local storage"),
+				)
+			}
+			// The extractor reads archives as stored, which would be ciphertext
+			if autoExtract && services.EncryptionEnabled() {
+				return c.Status(fiber.StatusBadRequest).JSON(
+					models.NewErrorResponse("Bad Request", "AUTO_EXTRACT_UNAVAILABLE", "auto_extract is not available while uploads are encrypted at rest"),
+				)
+			}
+			continue
+		case "dedup":
+			value, _ := io.ReadAll(part)
+			dedup = string(value) == "true"
+			continue
+		case "overwrite":
+			value, _ := io.ReadAll(part)
+			if policy, err = services.ParseOverwritePolicy(string(value)); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(
+					models.NewErrorResponse("Bad Request", "INVALID_OVERWRITE", err.Error()),
+				)
+			}
+			continue
+		case "relative_paths", "relative_paths[]":
+			value, _ := io.ReadAll(part)
+			relativePaths = append(relativePaths, string(value))
+			continue
+		case "file", "file[]":
+		default:
+			continue
+		}
+
+		// Folder uploads carry a relative path either in a parallel field or in the part filename
+		relPath := partRelativePath(part)
+		if len(uploads) < len(relativePaths) && relativePaths[len(uploads)] != "" {
+			relPath = relativePaths[len(uploads)]
+		}
+		relPath = strings.TrimPrefix(strings.ReplaceAll(relPath, "\\", "/"), "/")
+
+		filename := filepath.Base(relPath)
+		if relPath == "" || filename == "." || filename == ".." || filename == "/" {
+			filename = "uploaded_file"
+		}
+		fileDest := filepath.Join(destination, filepath.Dir(relPath))
+
+		// The extension alone would let any file through, so the content is checked as well
+		var content io.Reader = part
+		if autoExtract {
+			content, err = services.CheckArchiveContent(filename, part)
+			if errors.Is(err, services.ErrNotAnArchive) {
+				return uploadFailed(c, fiber.StatusUnsupportedMediaType, "Unsupported Media Type", "NOT_AN_ARCHIVE",
+					fmt.Errorf("auto_extract requires a supported archive: %w", err), uploads)
+			}
+			if err != nil {
+				return uploadFailed(c, fiber.StatusInternalServerError, "Failed to upload file", "UPLOAD_ERROR", err, uploads)
+			}
+		}
+
+		// Upload using streaming - the reader will stream data as it's received. The request
+		// length covers every part, so only a length the part declares itself is passed on.
+		uploadID, err := svc.Upload(c.Context(), filename, fileDest, content, partSize(part), policy, dedup)
+		if isInvalidPath(err) {
+			return uploadFailed(c, fiber.StatusBadRequest, "Bad Request", "INVALID_PATH", err, uploads)
+		}
+		if errors.Is(err, services.ErrAlreadyExists) {
+			return uploadFailed(c, fiber.StatusConflict, "Failed to upload file", "ALREADY_EXISTS", err, uploads)
+		}
+		if status, code, ok := ruleViolation(err); ok {
+			return uploadFailed(c, status, "Upload rejected", code, err, uploads)
+		}
+		if err != nil {
+			return uploadFailed(c, fiber.StatusInternalServerError, "Failed to upload file", "UPLOAD_ERROR", err, uploads)
+		}
+
+		progress, _ := svc.GetProgress(uploadID)
+		upload := fiber.Map{
+			"filename":  progress.Filename,
+			"upload_id": uploadID,
+			"progress":  progress,
+		}
+
+		if autoExtract {
+			extracted, err := h.extractUploaded(c, progress, fileDest)
+			if err != nil {
+				status, code := extractErrorStatus(err)
+				return uploadFailed(c, status, "Uploaded but failed to extract", code, err, append(uploads, upload))
+			}
+			for k, v := range extracted {
+				upload[k] = v
+			}
 		}
+
+		uploads = append(uploads, upload)
 	}
 
-	if filePart == nil {
+	if len(uploads) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(
 			models.NewErrorResponse("Bad Request", "FILE_REQUIRED", "File is required"),
 		)
 	}
 
-	filename := filePart.FileName()
-	if filename == "" {
-		filename = "uploaded_file"
+	// A single file keeps the original response shape
+	if len(uploads) == 1 {
+		delete(uploads[0], "filename")
+		return c.Status(fiber.StatusAccepted).JSON(models.NewSuccessResponse("Upload started", uploads[0]))
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(models.NewSuccessResponse("Upload started", fiber.Map{
+		"uploads": uploads,
+	}))
+}
+
+// uploadFailed answers an upload request that stopped at a failing file part. Files stored
+// before it are kept and listed in the response, so clients only resend the rest.
+func uploadFailed(c *fiber.Ctx, status int, message, code string, err error, uploads []fiber.Map) error {
+	response := models.NewErrorResponse(message, code, err.Error())
+	if len(uploads) > 0 {
+		response.Data = fiber.Map{"uploads": uploads}
+	}
+	return c.Status(status).JSON(response)
+}
+
+// partSize returns the Content-Length a multipart part declares, or -1 when it has none
+func partSize(part *multipart.Part) int64 {
+	size, err := strconv.ParseInt(part.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return -1
 	}
+	return size
+}
+
+// extractUploaded extracts a freshly uploaded archive into a sibling folder named after it
+func (h *UploadHandler) extractUploaded(c *fiber.Ctx, progress *models.Progress, destination string) (fiber.Map, error) {
+	userCtx := middleware.GetUserContext(c)
+	extractSvc := services.NewExtractService(userCtx.BasePath, userCtx.UserSite, h.progressStore)
+	extractSvc.SetPreserveOwner(userCtx.PreserveOwner)
+
+	archivePath := filepath.Join(destination, progress.Filename)
+	extractDest := filepath.Join(destination, services.ArchiveBaseName(progress.Filename))
 
-	// Upload using streaming - the reader will stream data as it's received
-	uploadID, err := svc.Upload(filename, destination, filePart, int64(c.Request().Header.ContentLength()))
+	// extractDest is already named after the archive
+	result, err := extractSvc.Extract(archivePath, extractDest, services.ExtractOptions{PreserveMode: true})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(
-			models.NewErrorResponse("Failed to upload file", "UPLOAD_ERROR", err.Error()),
-		)
+		return nil, err
 	}
 
-	progress, _ := svc.GetProgress(uploadID)
+	parts := strings.SplitN(result, ":", 2)
+	extractID := parts[0]
+	destPath := ""
+	if len(parts) > 1 {
+		destPath = parts[1]
+	}
 
-	return c.Status(fiber.StatusAccepted).JSON(models.NewSuccessResponse("Upload started", fiber.Map{
-		"upload_id": uploadID,
-		"progress":  progress,
-	}))
+	extractProgress, _ := extractSvc.GetProgress(extractID)
+
+	return fiber.Map{
+		"extract_id":       extractID,
+		"destination":      destPath,
+		"extract_progress": extractProgress,
+	}, nil
+}
+
+// partRelativePath returns the unmodified filename of a multipart part.
+// multipart.Part.FileName strips directories, which drops the tree of a folder upload.
+func partRelativePath(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return part.FileName()
+	}
+	return params["filename"]
 }
 
 // parseBoundary extracts the boundary parameter from Content-Type header
@@ -142,12 +311,11 @@ func parseBoundary(contentType string) (string, error) {
 
 // ChunkedUpload handles POST /api/v1/upload/chunked
 func (h *UploadHandler) ChunkedUpload(c *fiber.Ctx) error {
-	svc := h.getUploadService(c)
-	if svc == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(
-			models.NewErrorResponse("Unauthorized", "AUTH_ERROR", "User context not found"),
-		)
+	svc, err := h.getUploadService(c)
+	if err != nil {
+		return serviceError(c, err)
 	}
+	defer svc.Close()
 
 	// Check if this is init or chunk upload
 	action := c.FormValue("action", "upload")
@@ -165,7 +333,34 @@ func (h *UploadHandler) ChunkedUpload(c *fiber.Ctx) error {
 			)
 		}
 
-		chunk, err := svc.InitChunkedUpload(filename, destination, totalSize, chunkSize)
+		policy, err := services.ParseOverwritePolicy(c.FormValue("overwrite"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_OVERWRITE", err.Error()),
+			)
+		}
+
+		chunk, err := svc.InitChunkedUpload(filename, destination, totalSize, chunkSize, policy)
+		if errors.Is(err, services.ErrInvalidChunkSize) {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_CHUNK_SIZE", err.Error()),
+			)
+		}
+		if isInvalidPath(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_PATH", err.Error()),
+			)
+		}
+		if errors.Is(err, services.ErrAlreadyExists) {
+			return c.Status(fiber.StatusConflict).JSON(
+				models.NewErrorResponse("Failed to init chunked upload", "ALREADY_EXISTS", err.Error()),
+			)
+		}
+		if status, code, ok := ruleViolation(err); ok {
+			return c.Status(status).JSON(
+				models.NewErrorResponse("Upload rejected", code, err.Error()),
+			)
+		}
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(
 				models.NewErrorResponse("Failed to init chunked upload", "INIT_ERROR", err.Error()),
@@ -181,13 +376,18 @@ func (h *UploadHandler) ChunkedUpload(c *fiber.Ctx) error {
 
 	// Upload chunk
 	uploadID := c.FormValue("upload_id")
-	chunkIndex, _ := strconv.Atoi(c.FormValue("chunk_index", "0"))
+	chunkIndex, err := strconv.Atoi(c.FormValue("chunk_index", "0"))
 
 	if uploadID == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(
 			models.NewErrorResponse("Bad Request", "INVALID_UPLOAD_ID", "Upload ID is required"),
 		)
 	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_CHUNK_INDEX", "chunk_index must be a number"),
+		)
+	}
 
 	file, err := c.FormFile("chunk")
 	if err != nil {
@@ -204,14 +404,38 @@ func (h *UploadHandler) ChunkedUpload(c *fiber.Ctx) error {
 	}
 	defer src.Close()
 
-	data := make([]byte, file.Size)
-	if _, err := src.Read(data); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(
-			models.NewErrorResponse("Failed to read chunk", "CHUNK_READ_ERROR", err.Error()),
-		)
-	}
-
-	if err := svc.UploadChunk(uploadID, chunkIndex, data); err != nil {
+	// The part is streamed to disk once its size matches the chunk
+	if err := svc.UploadChunk(uploadID, chunkIndex, src, file.Size); err != nil {
+		if errors.Is(err, services.ErrRemoteMismatch) {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "REMOTE_MISMATCH", err.Error()),
+			)
+		}
+		if errors.Is(err, services.ErrInvalidChunkIndex) {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_CHUNK_INDEX", err.Error()),
+			)
+		}
+		if errors.Is(err, services.ErrInvalidChunkSize) {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_CHUNK_SIZE", err.Error()),
+			)
+		}
+		if errors.Is(err, services.ErrUploadSizeMismatch) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(
+				models.NewErrorResponse("Failed to assemble upload", "SIZE_MISMATCH", err.Error()),
+			)
+		}
+		if errors.Is(err, services.ErrAlreadyExists) {
+			return c.Status(fiber.StatusConflict).JSON(
+				models.NewErrorResponse("Failed to upload chunk", "ALREADY_EXISTS", err.Error()),
+			)
+		}
+		if status, code, ok := ruleViolation(err); ok {
+			return c.Status(status).JSON(
+				models.NewErrorResponse("Upload rejected", code, err.Error()),
+			)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(
 			models.NewErrorResponse("Failed to upload chunk", "CHUNK_UPLOAD_ERROR", err.Error()),
 		)
@@ -225,6 +449,54 @@ func (h *UploadHandler) ChunkedUpload(c *fiber.Ctx) error {
 	}))
 }
 
+// SetChunkedDestination handles PATCH /api/v1/upload/chunked/:id, changing the folder an
+// in-flight chunked upload is assembled in once its last chunk arrives
+func (h *UploadHandler) SetChunkedDestination(c *fiber.Ctx) error {
+	svc, err := h.getUploadService(c)
+	if err != nil {
+		return serviceError(c, err)
+	}
+	defer svc.Close()
+
+	var req models.ChunkedDestinationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+		)
+	}
+	if req.Destination == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(
+			models.NewErrorResponse("Bad Request", "INVALID_REQUEST", "Destination is required"),
+		)
+	}
+
+	uploadID := c.Params("id")
+	destination, err := svc.SetChunkedDestination(uploadID, req.Destination)
+	if err != nil {
+		status, code := fiber.StatusInternalServerError, "UPDATE_ERROR"
+		switch {
+		case isInvalidPath(err):
+			status, code = fiber.StatusBadRequest, "INVALID_PATH"
+		case errors.Is(err, services.ErrRemoteMismatch):
+			status, code = fiber.StatusBadRequest, "REMOTE_MISMATCH"
+		case errors.Is(err, services.ErrNotFound):
+			status, code = fiber.StatusNotFound, "NOT_FOUND"
+		case errors.Is(err, services.ErrUploadFinalized):
+			status, code = fiber.StatusConflict, "UPLOAD_FINALIZED"
+		case errors.Is(err, services.ErrAlreadyExists):
+			status, code = fiber.StatusConflict, "ALREADY_EXISTS"
+		}
+		return c.Status(status).JSON(
+			models.NewErrorResponse("Failed to change destination", code, err.Error()),
+		)
+	}
+
+	return c.JSON(models.NewSuccessResponse("Destination changed", fiber.Map{
+		"upload_id":   uploadID,
+		"destination": destination,
+	}))
+}
+
 // Progress handles GET /api/v1/upload/progress/:id (SSE)
 func (h *UploadHandler) Progress(c *fiber.Ctx) error {
 	uploadID := c.Params("id")
@@ -234,37 +506,12 @@ func (h *UploadHandler) Progress(c *fiber.Ctx) error {
 		)
 	}
 
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Set("Transfer-Encoding", "chunked")
-
-	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				progress, ok := h.progressStore.Get(uploadID)
-				if !ok {
-					fmt.Fprintf(w, "data: {\"error\": \"upload not found\"}\n\n")
-					w.Flush()
-					return
-				}
-
-				data, _ := json.Marshal(progress)
-				fmt.Fprintf(w, "data: %s\n\n", data)
-				w.Flush()
-
-				if progress.Status == models.StatusCompleted || progress.Status == models.StatusFailed {
-					return
-				}
-			}
-		}
-	})
+	return streamProgress(c, h.progressStore, uploadID, "upload")
+}
 
-	return nil
+// Status handles GET /api/v1/upload/status/:id
+func (h *UploadHandler) Status(c *fiber.Ctx) error {
+	return progressStatus(c, h.progressStore, c.Params("id"), "upload")
 }
 
 // WebSocketProgress handles WS /api/v1/upload/ws/:id
@@ -276,27 +523,52 @@ func (h *UploadHandler) WebSocketProgress(c *websocket.Conn) {
 		return
 	}
 
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+	userSite := ""
+	if userCtx, ok := c.Locals("user").(*middleware.UserContext); ok && userCtx != nil {
+		userSite = userCtx.UserSite
+	}
+	sub, ok := progressStreams.subscribe(h.progressStore, uploadID, userSite)
+	if !ok {
+		c.WriteJSON(fiber.Map{"error": "Too many concurrent progress streams", "code": "TOO_MANY_STREAMS"})
+		c.Close()
+		return
+	}
+	defer progressStreams.unsubscribe(sub)
+
+	// Detect the client closing the socket
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
 
 	for {
-		select {
-		case <-ticker.C:
-			progress, ok := h.progressStore.Get(uploadID)
-			if !ok {
+		data, ready, final, next := sub.latest()
+		if ready {
+			if data == nil {
 				c.WriteJSON(fiber.Map{"error": "upload not found"})
 				c.Close()
 				return
 			}
 
-			if err := c.WriteJSON(progress); err != nil {
+			if err := c.WriteMessage(websocket.TextMessage, data); err != nil {
 				return
 			}
 
-			if progress.Status == models.StatusCompleted || progress.Status == models.StatusFailed {
+			if final {
 				c.Close()
 				return
 			}
 		}
+
+		select {
+		case <-closed:
+			return
+		case <-next:
+		}
 	}
 }
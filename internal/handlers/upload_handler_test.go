@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/services"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// withLocalUser stands in for the auth middleware with a local usersite below base
+// that leaves ownership alone
+func withLocalUser(base string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user", &middleware.UserContext{UserSite: "u1", BasePath: base, LocalBasePath: base, PreserveOwner: true})
+		return c.Next()
+	}
+}
+
+// multipartField is one form field or, with a filename, one file of a multipart body
+type multipartField struct {
+	name, filename, value string
+}
+
+// multipartBody encodes fields in order and returns the body with its Content-Type
+func multipartBody(t *testing.T, fields ...multipartField) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, f := range fields {
+		var part io.Writer
+		var err error
+		if f.filename != "" {
+			part, err = w.CreateFormFile(f.name, f.filename)
+		} else {
+			part, err = w.CreateFormField(f.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, f.value)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, w.FormDataContentType()
+}
+
+// uploadResponse is the part of an upload response the tests look at
+type uploadResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Data    struct {
+		Progress  models.Progress `json:"progress"`
+		ExtractID string          `json:"extract_id"`
+		Uploads   []struct {
+			Filename string          `json:"filename"`
+			Progress models.Progress `json:"progress"`
+		} `json:"uploads"`
+	} `json:"data"`
+	Error *models.ErrorInfo `json:"error"`
+}
+
+func postUpload(t *testing.T, base string, fields ...multipartField) (int, uploadResponse) {
+	t.Helper()
+	return postUploadAs(t, withLocalUser(base), fields...)
+}
+
+// postUploadAs posts an upload for the usersite user sets up
+func postUploadAs(t *testing.T, user fiber.Handler, fields ...multipartField) (int, uploadResponse) {
+	t.Helper()
+	app := fiber.New()
+	h := NewUploadHandler(models.NewProgressStore(), services.NewChunkStore(), services.UploadRules{})
+	app.Post("/upload", user, h.Upload)
+
+	body, contentType := multipartBody(t, fields...)
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var decoded uploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, decoded
+}
+
+func TestUploadSeveralFilesReportsOwnSizes(t *testing.T) {
+	base := t.TempDir()
+	status, resp := postUpload(t, base,
+		multipartField{"file", "a.txt", "aaaa"},
+		multipartField{"file", "b.txt", "bbbbbbbbbb"},
+	)
+	if status != fiber.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %+v", status, resp.Error)
+	}
+	if len(resp.Data.Uploads) != 2 {
+		t.Fatalf("got %d uploads, want 2", len(resp.Data.Uploads))
+	}
+	for i, want := range []int64{4, 10} {
+		p := resp.Data.Uploads[i].Progress
+		if p.TotalBytes != want || p.UploadedBytes != want || p.Progress != 100 {
+			t.Fatalf("upload %d: %d of %d bytes at %d%%, want %d of %d at 100%%",
+				i, p.UploadedBytes, p.TotalBytes, p.Progress, want, want)
+		}
+	}
+}
+
+func TestUploadReturnsStoredFilesWhenALaterPartFails(t *testing.T) {
+	base := t.TempDir()
+	if err := os.WriteFile(filepath.Join(base, "b.txt"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := postUpload(t, base,
+		multipartField{"overwrite", "", "fail"},
+		multipartField{"file", "a.txt", "aaaa"},
+		multipartField{"file", "b.txt", "bbbb"},
+		multipartField{"file", "c.txt", "cccc"},
+	)
+	if status != fiber.StatusConflict || resp.Error == nil || resp.Error.Code != "ALREADY_EXISTS" {
+		t.Fatalf("status = %d, error = %+v, want 409 ALREADY_EXISTS", status, resp.Error)
+	}
+	if len(resp.Data.Uploads) != 1 || resp.Data.Uploads[0].Filename != "a.txt" {
+		t.Fatalf("uploads = %+v, want only a.txt", resp.Data.Uploads)
+	}
+	if _, err := os.Stat(filepath.Join(base, "a.txt")); err != nil {
+		t.Fatal("a.txt stored before the failure was removed")
+	}
+	if _, err := os.Stat(filepath.Join(base, "c.txt")); !os.IsNotExist(err) {
+		t.Fatal("c.txt after the failing part was stored")
+	}
+}
+
+func TestUploadRefusesAutoExtractBeforeStoringFiles(t *testing.T) {
+	base := t.TempDir()
+	defer services.ConfigureEncryption("")
+	if err := services.ConfigureEncryption(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	status, resp := postUpload(t, base,
+		multipartField{"auto_extract", "", "true"},
+		multipartField{"file", "a.zip", "PK"},
+	)
+	if status != fiber.StatusBadRequest || resp.Error == nil || resp.Error.Code != "AUTO_EXTRACT_UNAVAILABLE" {
+		t.Fatalf("status = %d, error = %+v, want 400 AUTO_EXTRACT_UNAVAILABLE", status, resp.Error)
+	}
+	if entries, _ := os.ReadDir(base); len(entries) != 0 {
+		t.Fatalf("base path holds %d entries after a refused upload, want none", len(entries))
+	}
+}
+
+func TestUploadAutoExtractChecksArchiveContent(t *testing.T) {
+	base := t.TempDir()
+
+	status, resp := postUpload(t, base,
+		multipartField{"auto_extract", "", "true"},
+		multipartField{"file", "notes.zip", "these are plain notes, not a zip"},
+	)
+	if status != fiber.StatusUnsupportedMediaType || resp.Error == nil || resp.Error.Code != "NOT_AN_ARCHIVE" {
+		t.Fatalf("status = %d, error = %+v, want 415 NOT_AN_ARCHIVE", status, resp.Error)
+	}
+	if resp.Message != "Unsupported Media Type" {
+		t.Fatalf("message = %q, want Unsupported Media Type", resp.Message)
+	}
+	if entries, _ := os.ReadDir(base); len(entries) != 0 {
+		t.Fatalf("base path holds %d entries after a refused upload, want none", len(entries))
+	}
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("inner.txt")
+	io.WriteString(w, "hello")
+	zw.Close()
+
+	status, resp = postUpload(t, base,
+		multipartField{"auto_extract", "", "true"},
+		multipartField{"file", "real.zip", archive.String()},
+	)
+	if status != fiber.StatusAccepted {
+		t.Fatalf("status = %d, error = %+v, want 202", status, resp.Error)
+	}
+	if resp.Data.ExtractID == "" {
+		t.Fatal("a real archive was stored but not extracted")
+	}
+}
+
+func TestChunkedUploadValidatesChunks(t *testing.T) {
+	base := t.TempDir()
+	if err := services.ConfigureTempDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer services.ConfigureTempDir("")
+
+	app := fiber.New()
+	h := NewUploadHandler(models.NewProgressStore(), services.NewChunkStore(), services.UploadRules{})
+	app.Post("/upload/chunked", withLocalUser(base), h.ChunkedUpload)
+	post := func(fields ...multipartField) (int, models.StandardResponse) {
+		t.Helper()
+		body, contentType := multipartBody(t, fields...)
+		req := httptest.NewRequest("POST", "/upload/chunked", body)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decoded models.StandardResponse
+		if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, decoded
+	}
+
+	status, resp := post(
+		multipartField{"action", "", "init"},
+		multipartField{"filename", "", "data.bin"},
+		multipartField{"total_size", "", "10"},
+		multipartField{"chunk_size", "", "0"},
+	)
+	if status != fiber.StatusBadRequest || resp.Error == nil || resp.Error.Code != "INVALID_CHUNK_SIZE" {
+		t.Fatalf("init with chunk_size 0: status = %d, error = %+v, want 400 INVALID_CHUNK_SIZE", status, resp.Error)
+	}
+
+	// 10 bytes in chunks of 4: two full chunks and a last one of 2 bytes
+	status, resp = post(
+		multipartField{"action", "", "init"},
+		multipartField{"filename", "", "data.bin"},
+		multipartField{"total_size", "", "10"},
+		multipartField{"chunk_size", "", "4"},
+	)
+	if status != fiber.StatusOK {
+		t.Fatalf("init: status = %d, error = %+v", status, resp.Error)
+	}
+	uploadID := resp.Data.(map[string]interface{})["upload_id"].(string)
+
+	tests := []struct {
+		name, index, data, code string
+	}{
+		{"index past the last chunk", "3", "ab", "INVALID_CHUNK_INDEX"},
+		{"negative index", "-1", "abcd", "INVALID_CHUNK_INDEX"},
+		{"index not a number", "one", "abcd", "INVALID_CHUNK_INDEX"},
+		{"oversized chunk", "0", "abcdefgh", "INVALID_CHUNK_SIZE"},
+		{"short chunk", "1", "ab", "INVALID_CHUNK_SIZE"},
+		{"oversized last chunk", "2", "abcd", "INVALID_CHUNK_SIZE"},
+	}
+	for _, tt := range tests {
+		status, resp := post(
+			multipartField{"upload_id", "", uploadID},
+			multipartField{"chunk_index", "", tt.index},
+			multipartField{"chunk", "chunk", tt.data},
+		)
+		if status != fiber.StatusBadRequest || resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("%s: status = %d, error = %+v, want 400 %s", tt.name, status, resp.Error, tt.code)
+		}
+	}
+
+	for i, data := range []string{"abcd", "efgh", "ij"} {
+		status, resp := post(
+			multipartField{"upload_id", "", uploadID},
+			multipartField{"chunk_index", "", strconv.Itoa(i)},
+			multipartField{"chunk", "chunk", data},
+		)
+		if status != fiber.StatusOK {
+			t.Fatalf("chunk %d: status = %d, error = %+v", i, status, resp.Error)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(base, "data.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcdefghij" {
+		t.Fatalf("assembled file holds %q, want abcdefghij", data)
+	}
+}
+
+func TestRemoteUploadOwnsNamesWithShellCharacters(t *testing.T) {
+	cfg, home := startSSHServer(t)
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := t.TempDir()
+	owner := func(c *fiber.Ctx) error {
+		c.Locals("user", &middleware.UserContext{UserSite: current.Username, BasePath: base, LocalBasePath: base,
+			IsRemote: true, SSHConfig: cfg})
+		return c.Next()
+	}
+
+	name := "my report $(touch pwned) `touch pwned2`;touch pwned3.txt"
+	status, resp := postUploadAs(t, owner, multipartField{"file", name, "hello"})
+	if status != fiber.StatusAccepted {
+		t.Fatalf("status = %d, error = %+v, want 202", status, resp.Error)
+	}
+	if data, err := os.ReadFile(filepath.Join(base, name)); err != nil || string(data) != "hello" {
+		t.Fatalf("uploaded file = %q, %v", data, err)
+	}
+	for _, injected := range []string{"pwned", "pwned2", "pwned3.txt"} {
+		if _, err := os.Stat(filepath.Join(home, injected)); !os.IsNotExist(err) {
+			t.Fatalf("the name ran a command on the SSH host, creating %s", injected)
+		}
+	}
+}
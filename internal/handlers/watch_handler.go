@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"filemanager-api/internal/middleware"
+	"filemanager-api/internal/services"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// WatchHandler streams directory change events over WebSocket
+type WatchHandler struct {
+	maxPerUser int
+
+	mu       sync.Mutex
+	watchers map[string]int
+}
+
+// NewWatchHandler creates a new watch handler allowing maxPerUser concurrent watchers per usersite
+func NewWatchHandler(maxPerUser int) *WatchHandler {
+	return &WatchHandler{
+		maxPerUser: maxPerUser,
+		watchers:   make(map[string]int),
+	}
+}
+
+// acquire reserves a watcher slot for the usersite
+func (h *WatchHandler) acquire(userSite string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxPerUser > 0 && h.watchers[userSite] >= h.maxPerUser {
+		return false
+	}
+	h.watchers[userSite]++
+	return true
+}
+
+// release frees a watcher slot for the usersite
+func (h *WatchHandler) release(userSite string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watchers[userSite]--
+	if h.watchers[userSite] <= 0 {
+		delete(h.watchers, userSite)
+	}
+}
+
+// WebSocketWatch handles WS /api/v1/fs/watch/ws?path=...
+func (h *WatchHandler) WebSocketWatch(c *websocket.Conn) {
+	defer c.Close()
+
+	userCtx, ok := c.Locals("user").(*middleware.UserContext)
+	if !ok || userCtx == nil {
+		c.WriteJSON(fiber.Map{"error": "User context not found"})
+		return
+	}
+
+	if userCtx.IsRemote {
+		c.WriteJSON(fiber.Map{"error": "Watching is only supported for local paths", "code": "NOT_SUPPORTED"})
+		return
+	}
+
+	if !h.acquire(userCtx.UserSite) {
+		c.WriteJSON(fiber.Map{"error": "Too many concurrent watchers", "code": "TOO_MANY_WATCHERS"})
+		return
+	}
+	defer h.release(userCtx.UserSite)
+
+	// Detect the client closing the socket
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	svc := services.NewFileManagerService(userCtx.BasePath, userCtx.UserSite)
+	err := svc.Watch(c.Query("path", ""), done, func(event services.WatchEvent) error {
+		return c.WriteJSON(event)
+	})
+	if err != nil {
+		code := "WATCH_ERROR"
+		if errors.Is(err, services.ErrNotFound) {
+			code = "NOT_FOUND"
+		} else if errors.Is(err, services.ErrNotAFolder) {
+			code = "NOT_A_FOLDER"
+		}
+		c.WriteJSON(fiber.Map{"error": err.Error(), "code": code})
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"filemanager-api/internal/models"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "filemanager"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	// RequestsTotal counts handled HTTP requests per route
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// RequestDuration observes HTTP request latency per route
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// BytesTransferred counts file bytes received (upload) and sent (download)
+	BytesTransferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_transferred_total",
+		Help:      "File bytes transferred, by direction.",
+	}, []string{"direction"})
+
+	// SSHConnections counts SSH connection attempts by result
+	SSHConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_connections_total",
+		Help:      "SSH connection attempts, by result.",
+	}, []string{"result"})
+
+	// SSHConnectionsOpen tracks currently open SSH connections
+	SSHConnectionsOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ssh_connections_open",
+		Help:      "SSH connections currently open.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		RequestDuration,
+		BytesTransferred,
+		SSHConnections,
+		SSHConnectionsOpen,
+	)
+}
+
+// Handler returns the HTTP handler serving the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterGaugeFunc exposes a gauge whose value is read from fn on every scrape
+func RegisterGaugeFunc(name, help string, fn func() float64) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// RegisterProgressStore exposes the unfinished operations held in store
+func RegisterProgressStore(store *models.ProgressStore) {
+	registry.MustRegister(&progressCollector{
+		store: store,
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "operations_active"),
+			"Unfinished operations tracked in the progress store, by kind.",
+			[]string{"operation"}, nil,
+		),
+	})
+}
+
+// progressCollector reads operation counts from a ProgressStore at scrape time
+type progressCollector struct {
+	store *models.ProgressStore
+	desc  *prometheus.Desc
+}
+
+func (pc *progressCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.desc
+}
+
+func (pc *progressCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := pc.store.CountActive()
+	// Always report the known operations so idle ones show up as 0
+	for _, op := range []string{models.OperationUpload, models.OperationCompress, models.OperationExtract, models.OperationMove, models.OperationFetch, models.OperationTransfer} {
+		ch <- prometheus.MustNewConstMetric(pc.desc, prometheus.GaugeValue, float64(counts[op]), op)
+	}
+}
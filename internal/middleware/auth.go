@@ -3,6 +3,7 @@ package middleware
 import (
 	"filemanager-api/internal/config"
 	"filemanager-api/internal/models"
+	"regexp"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -18,10 +19,21 @@ type SSHConfig struct {
 
 // UserContext holds the authenticated user information
 type UserContext struct {
-	UserSite  string
-	BasePath  string
-	SSHConfig *SSHConfig
-	IsRemote  bool
+	UserSite      string
+	BasePath      string
+	LocalBasePath string // usersite directory on this server, even when BasePath is remote
+	SSHConfig     *SSHConfig
+	IsRemote      bool
+	PreserveOwner bool // leave ownership as the filesystem sets it instead of chowning to UserSite
+}
+
+// userSitePattern allows a single path component that cannot start with a dot,
+// so "..", "." and hidden names are rejected along with separators and NUL bytes
+var userSitePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,63}$`)
+
+// isValidUserSite reports whether userSite is safe to join onto BASE_PATH
+func isValidUserSite(userSite string) bool {
+	return userSitePattern.MatchString(userSite) && !strings.Contains(userSite, "..")
 }
 
 // Auth middleware validates API key and extracts usersite/SSH from headers
@@ -47,6 +59,11 @@ func Auth() fiber.Handler {
 				models.NewErrorResponse("Bad Request", "USERSITE_REQUIRED", "X-User-Site header is required"),
 			)
 		}
+		if !isValidUserSite(userSite) {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_USERSITE", "X-User-Site may only contain letters, digits, '.', '-' and '_'"),
+			)
+		}
 
 		// Check for SSH headers for remote server access
 		sshHost := c.Get("X-Ssh-Host")
@@ -54,10 +71,13 @@ func Auth() fiber.Handler {
 		sshPort := c.Get("X-Ssh-Port")
 		sshKey := c.Get("X-Ssh-Key")
 
+		localBasePath := config.AppConfig.UserBasePath(userSite)
 		userCtx := &UserContext{
-			UserSite: userSite,
-			BasePath: config.AppConfig.BasePath + "/" + userSite,
-			IsRemote: false,
+			UserSite:      userSite,
+			BasePath:      localBasePath,
+			LocalBasePath: localBasePath,
+			IsRemote:      false,
+			PreserveOwner: c.QueryBool("preserve_owner", config.AppConfig.PreserveOwner),
 		}
 
 		// If SSH headers are present, configure for remote access
@@ -81,6 +101,8 @@ func Auth() fiber.Handler {
 			// Trim any extra whitespace
 			normalizedKey = strings.TrimSpace(normalizedKey)
 
+			// USER_BASE_PATHS describes local mounts only; remote paths keep the default layout
+			userCtx.BasePath = config.AppConfig.BasePath + "/" + userSite
 			userCtx.SSHConfig = &SSHConfig{
 				Host:       sshHost,
 				Port:       sshPort,
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"filemanager-api/internal/config"
+	"filemanager-api/internal/utils"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIsValidUserSite(t *testing.T) {
+	tests := []struct {
+		userSite string
+		valid    bool
+	}{
+		{"u1", true},
+		{"example.com", true},
+		{"site_2-b", true},
+		{"Example.COM", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../", false},
+		{"../etc", false},
+		{"a/../../etc", false},
+		{"a..b", false},
+		{".hidden", false},
+		{"/etc", false},
+		{"/", false},
+		{`..\..\windows`, false},
+		{"a\\b", false},
+		{"a/b", false},
+		{"u1\x00", false},
+		{"u1\x00/../../etc", false},
+		{"u1\n", false},
+		{"u 1", false},
+		{"%2e%2e", false},
+	}
+	for _, tt := range tests {
+		if got := isValidUserSite(tt.userSite); got != tt.valid {
+			t.Errorf("isValidUserSite(%q) = %v, want %v", tt.userSite, got, tt.valid)
+		}
+		// Files are handed to the account named like their usersite
+		if tt.valid && !utils.IsValidAccountName(tt.userSite) {
+			t.Errorf("usersite %q is not a valid account name", tt.userSite)
+		}
+	}
+}
+
+func TestAuthRejectsUnsafeUserSite(t *testing.T) {
+	defer func(cfg *config.Config) { config.AppConfig = cfg }(config.AppConfig)
+	config.AppConfig = &config.Config{APIKey: "key", BasePath: "/home"}
+
+	app := fiber.New()
+	app.Get("/", Auth(), func(c *fiber.Ctx) error {
+		return c.SendString(GetUserContext(c).BasePath)
+	})
+
+	for userSite, want := range map[string]int{
+		"u1":        fiber.StatusOK,
+		"../":       fiber.StatusBadRequest,
+		"..":        fiber.StatusBadRequest,
+		"/etc":      fiber.StatusBadRequest,
+		"/":         fiber.StatusBadRequest,
+		"u1/../..":  fiber.StatusBadRequest,
+		`..\..`:     fiber.StatusBadRequest,
+		"u1\x00etc": fiber.StatusBadRequest,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", "key")
+		req.Header.Set("X-User-Site", userSite)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("X-User-Site %q: status = %d, want %d", userSite, resp.StatusCode, want)
+		}
+	}
+}
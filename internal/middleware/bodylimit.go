@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"filemanager-api/internal/config"
+	"filemanager-api/internal/models"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// largeBodyRoutes stream their body to disk and are only bounded by the app-wide BodyLimit
+var largeBodyRoutes = []string{
+	"/api/v1/upload",
+	"/api/v1/extract/stream",
+}
+
+// isLargeBodyRoute reports whether the request targets an upload route
+func isLargeBodyRoute(c *fiber.Ctx) bool {
+	path := c.Path()
+	for _, prefix := range largeBodyRoutes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BodyLimit rejects bodies larger than MaxBodySize with 413 on every route but uploads,
+// as the other handlers read the whole body into memory. A body sent without a
+// Content-Length is read up to the limit, so it cannot grow past it either.
+func BodyLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := config.AppConfig.MaxBodySize
+		if limit <= 0 || isLargeBodyRoute(c) {
+			return c.Next()
+		}
+
+		req := c.Request()
+		length := int64(req.Header.ContentLength())
+		if length > limit {
+			return bodyTooLarge(c, limit)
+		}
+		if length < 0 && req.IsBodyStream() {
+			body, err := io.ReadAll(io.LimitReader(c.Context().RequestBodyStream(), limit+1))
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(
+					models.NewErrorResponse("Bad Request", "INVALID_BODY", err.Error()),
+				)
+			}
+			if int64(len(body)) > limit {
+				return bodyTooLarge(c, limit)
+			}
+			req.SetBody(body)
+		}
+		return c.Next()
+	}
+}
+
+func bodyTooLarge(c *fiber.Ctx, limit int64) error {
+	// The rest of the body is not read, so the connection cannot be reused
+	c.Context().SetConnectionClose()
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(
+		models.NewErrorResponse("Request Entity Too Large", "BODY_TOO_LARGE",
+			fmt.Sprintf("Request body exceeds the limit of %d bytes", limit)),
+	)
+}
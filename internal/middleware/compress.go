@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"filemanager-api/internal/config"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+)
+
+// streamingRouteMarkers identify routes that stream their body (downloads, SSE, WebSocket)
+var streamingRouteMarkers = []string{
+	"/download/",
+	"/compress/stream",
+	"/progress/",
+	"/tail/",
+	"/ws",
+}
+
+// isStreamingRoute reports whether the request targets a streaming endpoint
+func isStreamingRoute(c *fiber.Ctx) bool {
+	path := c.Path()
+	for _, marker := range streamingRouteMarkers {
+		if strings.Contains(path, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compress returns gzip/deflate/brotli response compression for JSON and text bodies.
+// Streaming routes are skipped so downloads, SSE and WebSocket are never buffered.
+// Bodies below ~200 bytes and non-text content types are left uncompressed by fasthttp.
+func Compress() fiber.Handler {
+	return compress.New(compress.Config{
+		Next:  isStreamingRoute,
+		Level: compress.Level(config.AppConfig.CompressLevel),
+	})
+}
@@ -9,9 +9,9 @@ import (
 func CORS() fiber.Handler {
 	return cors.New(cors.Config{
 		AllowOrigins:     "*",
-		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-API-Key,X-User-Site",
-		ExposeHeaders:    "Content-Length,Content-Disposition",
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-API-Key,X-User-Site,If-None-Match,If-Modified-Since",
+		ExposeHeaders:    "Content-Length,Content-Disposition,ETag",
 		AllowCredentials: false,
 		MaxAge:           86400,
 	})
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"filemanager-api/internal/config"
+	"filemanager-api/internal/models"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	// maxIdempotencyKeyLength bounds the Idempotency-Key header
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBody is the largest response kept for replay; larger ones run again
+	maxIdempotentBody = 1024 * 1024
+)
+
+// idempotentResponse is the answer to the first request with an idempotency key
+type idempotentResponse struct {
+	route       string // method and path the key was used for
+	done        bool   // false while the first request is still running
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time // also ends the wait for a first request that never finished
+}
+
+// Idempotency answers a repeated mutating request carrying the Idempotency-Key header of
+// an earlier one with the response of the earlier one instead of running it again, so
+// clients can retry uploads, archives and other writes after a timeout. Keys are scoped
+// per usersite and kept for IdempotencyTTL seconds. A repeat arriving while the first
+// request still runs gets 409, a key reused for another route 422. Server errors, rate
+// limits and streamed responses are not kept, so retrying them runs the request again.
+func Idempotency() fiber.Handler {
+	ttl := time.Duration(config.AppConfig.IdempotencyTTL) * time.Second
+	if ttl <= 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	var mu sync.Mutex
+	responses := make(map[string]*idempotentResponse)
+
+	go func() {
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			mu.Lock()
+			for key, res := range responses {
+				if now.After(res.expires) {
+					delete(responses, key)
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get("Idempotency-Key")
+		if key == "" || fiber.IsMethodSafe(c.Method()) {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(
+				models.NewErrorResponse("Bad Request", "INVALID_IDEMPOTENCY_KEY", "Idempotency-Key may be at most 255 characters"),
+			)
+		}
+
+		userSite := ""
+		if userCtx := GetUserContext(c); userCtx != nil {
+			userSite = userCtx.UserSite
+		}
+		// Both are copied out of the request buffers, which Fiber reuses
+		key = userSite + "\x00" + key
+		route := c.Method() + " " + c.Path()
+
+		mu.Lock()
+		res, ok := responses[key]
+		if ok && time.Now().After(res.expires) {
+			ok = false
+		}
+		var earlier idempotentResponse
+		if ok {
+			earlier = *res
+		} else {
+			res = &idempotentResponse{route: route, expires: time.Now().Add(ttl)}
+			responses[key] = res
+		}
+		mu.Unlock()
+
+		if ok {
+			switch {
+			case earlier.route != route:
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(
+					models.NewErrorResponse("Unprocessable Entity", "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used for "+earlier.route),
+				)
+			case !earlier.done:
+				return c.Status(fiber.StatusConflict).JSON(
+					models.NewErrorResponse("Conflict", "IDEMPOTENCY_IN_PROGRESS", "A request with this Idempotency-Key is still running"),
+				)
+			}
+			c.Set("Idempotent-Replayed", "true")
+			c.Set(fiber.HeaderContentType, earlier.contentType)
+			return c.Status(earlier.status).Send(earlier.body)
+		}
+
+		err := c.Next()
+
+		mu.Lock()
+		defer mu.Unlock()
+		resp := c.Response()
+		status := resp.StatusCode()
+		if responses[key] != res {
+			// Expired and replaced while running
+			return err
+		}
+		if err != nil || status >= fiber.StatusInternalServerError || status == fiber.StatusTooManyRequests ||
+			resp.IsBodyStream() || len(resp.Body()) > maxIdempotentBody {
+			delete(responses, key)
+			return err
+		}
+		res.done = true
+		res.status = status
+		res.contentType = string(resp.Header.ContentType())
+		res.body = utils.CopyBytes(resp.Body())
+		res.expires = time.Now().Add(ttl)
+		return nil
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"errors"
+	"filemanager-api/internal/metrics"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Metrics records the request count and latency of every request, labelled by route pattern
+func Metrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		// Fiber strings point into request buffers that streaming handlers reuse
+		method := utils.CopyString(c.Method())
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			}
+		}
+
+		// The route pattern (e.g. /api/v1/fs/info/*) keeps label cardinality bounded
+		route := c.Route().Path
+
+		metrics.RequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		metrics.RequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
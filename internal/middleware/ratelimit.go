@@ -50,3 +50,24 @@ func UploadRateLimit() fiber.Handler {
 		},
 	})
 }
+
+// RawCommandRateLimit returns rate limiting for raw command execution (most restrictive)
+func RawCommandRateLimit() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        config.AppConfig.RawRateLimitReqs,
+		Expiration: time.Duration(config.AppConfig.RawRateLimitWindow) * time.Second,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.Get("X-API-Key") + "-raw-" + c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"success": false,
+				"message": "Raw command rate limit exceeded",
+				"error": fiber.Map{
+					"code":    "RAW_RATE_LIMIT_EXCEEDED",
+					"details": "Too many raw command requests, please try again later",
+				},
+			})
+		},
+	})
+}
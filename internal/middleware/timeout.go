@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/config"
+	"filemanager-api/internal/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// longRunningRoutes copy or move any amount of data in the request itself; they are
+// followed through their progress and stopped through /api/v1/operations instead
+var longRunningRoutes = []string{
+	"/api/v1/fs/copy",
+	"/api/v1/fs/move",
+	"/api/v1/fs/duplicate",
+	"/api/v1/fs/transfer",
+}
+
+// isLongRunningRoute reports whether the request targets a copy or move
+func isLongRunningRoute(c *fiber.Ctx) bool {
+	path := c.Path()
+	for _, route := range longRunningRoutes {
+		if path == route {
+			return true
+		}
+	}
+	return strings.HasPrefix(path, "/api/v1/fs/transfer/")
+}
+
+// Timeout gives each request a context, available through c.UserContext(), that ends after
+// RequestTimeout. A handler that fails because of it is answered with 504, so a stuck
+// listing or remote du cannot hold the connection for the server's read timeout.
+// Streaming, upload and long-running routes get a context without a deadline; all
+// contexts still end when the server shuts down.
+func Timeout() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		timeout := time.Duration(config.AppConfig.RequestTimeout) * time.Second
+		if timeout <= 0 || isStreamingRoute(c) || isLargeBodyRoute(c) || isLongRunningRoute(c) {
+			c.SetUserContext(c.Context())
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.Context(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		// A handler that finished despite the deadline keeps its answer
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && (err != nil || c.Response().StatusCode() >= fiber.StatusInternalServerError) {
+			return c.Status(fiber.StatusGatewayTimeout).JSON(
+				models.NewErrorResponse("Gateway Timeout", "REQUEST_TIMEOUT",
+					"The request did not complete within "+timeout.String()),
+			)
+		}
+		return err
+	}
+}
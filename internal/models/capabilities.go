@@ -0,0 +1,66 @@
+package models
+
+// Capabilities describes what this instance supports, so clients can adapt to its
+// configuration instead of assuming defaults
+type Capabilities struct {
+	Version   string              `json:"version"`
+	Features  FeatureFlags        `json:"features"`
+	Limits    CapabilityLimits    `json:"limits"`
+	RateLimit CapabilityRateLimit `json:"rate_limit"`
+	Upload    UploadCapabilities  `json:"upload"`
+	Archives  ArchiveCapabilities `json:"archives"`
+}
+
+// FeatureFlags reports the optional features that are enabled
+type FeatureFlags struct {
+	Remote             bool `json:"remote"`              // operations on SSH hosts via the X-Ssh-* headers
+	Xattr              bool `json:"xattr"`               // extended attribute endpoints
+	Encryption         bool `json:"encryption"`          // uploads encrypted at rest
+	PersistentProgress bool `json:"persistent_progress"` // progress survives restarts
+	BlockPrivateFetch  bool `json:"block_private_fetch"` // fs/fetch refuses private addresses
+}
+
+// CapabilityLimits reports the configured size and count limits. Zero means no limit.
+type CapabilityLimits struct {
+	MaxUploadSize       int64 `json:"max_upload_size"`
+	MaxUploadFileSize   int64 `json:"max_upload_file_size"`
+	MaxBodySize         int64 `json:"max_body_size"`
+	ChunkSize           int   `json:"chunk_size"`
+	MaxBatchItems       int   `json:"max_batch_items"`
+	MaxPathDepth        int   `json:"max_path_depth"`
+	MaxNameLength       int   `json:"max_name_length"`
+	MaxTreeDepth        int   `json:"max_tree_depth"`
+	MaxThumbnailSize    int   `json:"max_thumbnail_size"`
+	MaxWatchersPerUser  int   `json:"max_watchers_per_user"`
+	FetchMaxSize        int64 `json:"fetch_max_size"`
+	ExtractMaxTotalSize int64 `json:"extract_max_total_size"`
+	ExtractMaxEntries   int   `json:"extract_max_entries"`
+	ExtractMaxEntrySize int64 `json:"extract_max_entry_size"`
+	ExtractMaxRatio     int64 `json:"extract_max_ratio"`
+	MaxConcurrentOps    int   `json:"max_concurrent_operations"`
+	OperationQueueSize  int   `json:"operation_queue_size"`
+}
+
+// CapabilityRateLimit reports the request limits per client, per window in seconds
+type CapabilityRateLimit struct {
+	Requests    int `json:"requests"`
+	Window      int `json:"window"`
+	RawRequests int `json:"raw_requests"`
+	RawWindow   int `json:"raw_window"`
+}
+
+// UploadCapabilities reports which uploads are accepted. Empty lists allow everything.
+type UploadCapabilities struct {
+	AllowedExtensions []string `json:"allowed_extensions"`
+	DeniedExtensions  []string `json:"denied_extensions"`
+	AllowedMimeTypes  []string `json:"allowed_mime_types"`
+}
+
+// ArchiveCapabilities reports the archive formats that can be written and extracted
+type ArchiveCapabilities struct {
+	Compress     []string `json:"compress"`
+	Extract      []string `json:"extract"`
+	MinLevel     int      `json:"min_level"`
+	MaxLevel     int      `json:"max_level"`
+	DefaultLevel int      `json:"default_level"`
+}
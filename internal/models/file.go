@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"os"
 	"time"
 )
@@ -16,6 +17,10 @@ type FileInfo struct {
 	Extension   string      `json:"extension,omitempty"`
 	MimeType    string      `json:"mime_type,omitempty"`
 	Permissions string      `json:"permissions"`
+	Owner       string      `json:"owner,omitempty"`
+	Group       string      `json:"group,omitempty"`
+	Hash        string      `json:"hash,omitempty"`
+	HashAlgo    string      `json:"hash_algo,omitempty"`
 }
 
 // FolderInfo represents folder metadata with contents
@@ -29,15 +34,215 @@ type FolderInfo struct {
 	Count    int         `json:"count"`
 }
 
-// CreateFileRequest represents a file creation request
-type CreateFileRequest struct {
-	Path    string `json:"path" validate:"required"`
+// FilesystemStats represents capacity of the filesystem backing a path
+type FilesystemStats struct {
+	TotalBytes  int64   `json:"total_bytes"`
+	FreeBytes   int64   `json:"free_bytes"`
+	UsedBytes   int64   `json:"used_bytes"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// MountInfo describes the mount a path lies on
+type MountInfo struct {
+	Path       string   `json:"path"`
+	MountPoint string   `json:"mount_point"`
+	Device     string   `json:"device"`
+	FSType     string   `json:"fstype"`
+	ReadOnly   bool     `json:"read_only"`
+	Options    []string `json:"options"`
+}
+
+// TreeStats summarises the files and folders below a directory. Anything that is not
+// a folder, symlinks included, counts as a file.
+type TreeStats struct {
+	Path          string     `json:"path"`
+	Files         int64      `json:"files"`
+	Folders       int64      `json:"folders"`
+	SizeBytes     int64      `json:"size_bytes"`
+	SizeHuman     string     `json:"size_human"`
+	LargestFile   string     `json:"largest_file,omitempty"`
+	LargestSize   int64      `json:"largest_size"`
+	NewestModTime *time.Time `json:"newest_mod_time,omitempty"`
+	OldestModTime *time.Time `json:"oldest_mod_time,omitempty"`
+	ComputedAt    time.Time  `json:"computed_at"`
+}
+
+// MarshalJSON emits ModTime in UTC regardless of the zone the filesystem reports
+func (f FileInfo) MarshalJSON() ([]byte, error) {
+	type alias FileInfo
+	a := alias(f)
+	a.ModTime = a.ModTime.UTC()
+	return json.Marshal(a)
+}
+
+// MarshalJSON emits ModTime in UTC regardless of the zone the filesystem reports
+func (f FolderInfo) MarshalJSON() ([]byte, error) {
+	type alias FolderInfo
+	a := alias(f)
+	a.ModTime = a.ModTime.UTC()
+	return json.Marshal(a)
+}
+
+// MarshalJSON flattens the file info next to the result fields; the promoted
+// FileInfo.MarshalJSON would otherwise emit the file info alone. Failed results
+// have no file info and leave its fields out.
+func (r CopyResult) MarshalJSON() ([]byte, error) {
+	type fileInfo FileInfo
+	var info *fileInfo
+	if r.FileInfo != nil {
+		copied := fileInfo(*r.FileInfo)
+		copied.ModTime = copied.ModTime.UTC()
+		info = &copied
+	}
+	return json.Marshal(struct {
+		*fileInfo
+		Source      string `json:"source"`
+		Action      string `json:"action"`
+		Transferred int    `json:"transferred,omitempty"`
+		Skipped     int    `json:"skipped,omitempty"`
+		Code        string `json:"code,omitempty"`
+		Error       string `json:"error,omitempty"`
+	}{info, r.Source, r.Action, r.Transferred, r.Skipped, r.Code, r.Error})
+}
+
+// TreeNode is a file or folder in a directory tree. Truncated marks a folder whose
+// children were not read, or not all of them, because of the depth or size limit.
+type TreeNode struct {
+	FileInfo
+	Children  []TreeNode `json:"children,omitempty"`
+	Truncated bool       `json:"truncated,omitempty"`
+}
+
+// MarshalJSON flattens the file info next to the children, as for CopyResult
+func (n TreeNode) MarshalJSON() ([]byte, error) {
+	type fileInfo FileInfo
+	info := fileInfo(n.FileInfo)
+	info.ModTime = info.ModTime.UTC()
+	return json.Marshal(struct {
+		fileInfo
+		Children  []TreeNode `json:"children,omitempty"`
+		Truncated bool       `json:"truncated,omitempty"`
+	}{info, n.Children, n.Truncated})
+}
+
+// FilePreview represents the leading portion of a text file
+type FilePreview struct {
+	Path      string `json:"path"`
+	MimeType  string `json:"mime_type"`
+	Content   string `json:"content"`
+	Encoding  string `json:"encoding"`
+	Size      int64  `json:"size"`
+	BytesRead int    `json:"bytes_read"`
+	Lines     int    `json:"lines"`
+	Truncated bool   `json:"truncated"`
+}
+
+// HexDump represents Length bytes of a file from Offset, as hex and as a `hexdump -C` dump
+type HexDump struct {
+	Path     string `json:"path"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Offset   int64  `json:"offset"`
+	Length   int    `json:"length"`
+	Hex      string `json:"hex"`
+	Dump     string `json:"dump"`
+}
+
+// LineRange represents lines Start to End (1-indexed, inclusive) of a text file
+type LineRange struct {
+	Path       string `json:"path"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	TotalLines int    `json:"total_lines"`
+	Content    string `json:"content,omitempty"`
+}
+
+// WriteLinesRequest represents a replacement of lines Start to End with Content
+type WriteLinesRequest struct {
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
 	Content string `json:"content"`
 }
 
+// DiffRequest represents a request to compare two paths.
+// ALocation/BLocation select "local" or "remote" (default: remote when SSH headers are present).
+type DiffRequest struct {
+	A         string `json:"a" validate:"required"`
+	B         string `json:"b" validate:"required"`
+	ALocation string `json:"a_location"`
+	BLocation string `json:"b_location"`
+	Hash      bool   `json:"hash"`
+}
+
+// DiffResult represents the outcome of comparing two files or directories
+type DiffResult struct {
+	Type      string   `json:"type"`
+	Identical bool     `json:"identical"`
+	OnlyInA   []string `json:"only_in_a,omitempty"`
+	OnlyInB   []string `json:"only_in_b,omitempty"`
+	Differing []string `json:"differing,omitempty"`
+}
+
+// InfoBatchRequest represents a request for the info of several paths
+type InfoBatchRequest struct {
+	Paths []string `json:"paths" validate:"required,min=1"`
+}
+
+// InfoBatchResult is the info of one requested path, or why it could not be read
+type InfoBatchResult struct {
+	Path  string    `json:"path"`
+	Info  *FileInfo `json:"info,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// DeleteBatchRequest represents a request to delete several paths
+type DeleteBatchRequest struct {
+	Paths     []string `json:"paths" validate:"required,min=1"`
+	Recursive bool     `json:"recursive"`
+}
+
+// PathResult reports whether an operation on one path of a batch succeeded
+type PathResult struct {
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReplaceRequest represents a find-and-replace across files.
+// With Regex, Find is a Go regular expression and Replace may use $1 or ${name}.
+type ReplaceRequest struct {
+	Paths   []string `json:"paths" validate:"required,min=1"`
+	Find    string   `json:"find" validate:"required"`
+	Replace string   `json:"replace"`
+	Regex   bool     `json:"regex"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+// ReplaceResult reports the matches replaced in one file, or why it was not changed
+type ReplaceResult struct {
+	Path    string `json:"path"`
+	Matches int    `json:"matches"`
+	Skipped bool   `json:"skipped,omitempty"` // binary file
+	Error   string `json:"error,omitempty"`
+}
+
+// CreateFileRequest represents a file creation request.
+// Encoding is "utf8" (default) or "base64" for binary content.
+type CreateFileRequest struct {
+	Path     string `json:"path" validate:"required"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
 // UpdateFileRequest represents a file update request
 type UpdateFileRequest struct {
-	Content string `json:"content"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+// WriteAtRequest represents a partial write of bytes at an offset
+type WriteAtRequest struct {
+	Offset        int64  `json:"offset"`
+	ContentBase64 string `json:"content_base64"`
 }
 
 // CreateFolderRequest represents a folder creation request
@@ -45,26 +250,131 @@ type CreateFolderRequest struct {
 	Path string `json:"path" validate:"required"`
 }
 
+// CreateFoldersRequest represents a request to create several folders
+type CreateFoldersRequest struct {
+	Paths []string `json:"paths" validate:"required,min=1"`
+}
+
+// CreateFolderResult is the info of one folder of a batch, or why it was not created.
+// Exists marks a path that was already taken, by a folder or a file.
+type CreateFolderResult struct {
+	Path   string    `json:"path"`
+	Info   *FileInfo `json:"info,omitempty"`
+	Exists bool      `json:"exists,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// DuplicateRequest represents a request to copy a file or folder next to itself
+type DuplicateRequest struct {
+	Path string `json:"path" validate:"required"`
+}
+
 // RenameRequest represents a rename request
 type RenameRequest struct {
 	NewName string `json:"new_name" validate:"required"`
 }
 
+// ChownRequest represents a request to change ownership
+type ChownRequest struct {
+	Path      string `json:"path" validate:"required"`
+	Owner     string `json:"owner" validate:"required"`
+	Group     string `json:"group"` // defaults to owner
+	Recursive bool   `json:"recursive"`
+}
+
+// XattrRequest represents a request to set and remove extended attributes.
+// Encoding is "utf8" (default) or "base64" for the values in Attributes.
+type XattrRequest struct {
+	Attributes map[string]string `json:"attributes"`
+	Remove     []string          `json:"remove"`
+	Encoding   string            `json:"encoding"`
+}
+
+// XattrInfo represents the extended attributes of a file or folder
+type XattrInfo struct {
+	Path       string            `json:"path"`
+	Attributes map[string]string `json:"attributes"`
+	Encoding   string            `json:"encoding"`
+}
+
 // CopyRequest represents a copy/move request
 type CopyRequest struct {
-	Sources     []string `json:"sources" validate:"required,min=1"`
-	Destination string   `json:"destination" validate:"required"`
-	Overwrite   bool     `json:"overwrite"`
+	Sources           []string `json:"sources" validate:"required,min=1"`
+	Destination       string   `json:"destination" validate:"required"`
+	Overwrite         bool     `json:"overwrite"`
+	OverwritePolicy   string   `json:"overwrite_policy"` // rename, always, never, if_newer or if_different; overrides overwrite
+	PreserveStructure bool     `json:"preserve_structure"`
+	Base              string   `json:"base"`
+	Preserve          bool     `json:"preserve"`      // keep the owner and group of the sources
+	Hardlink          bool     `json:"hardlink"`      // link local files instead of copying where possible
+	PreserveTree      bool     `json:"preserve_tree"` // keep the folders of glob matches below the pattern's fixed folders
 }
 
 // MoveRequest represents a move request
 type MoveRequest struct {
-	Sources     []string `json:"sources" validate:"required,min=1"`
-	Destination string   `json:"destination" validate:"required"`
-	Overwrite   bool     `json:"overwrite"`
+	Sources         []string `json:"sources" validate:"required,min=1"`
+	Destination     string   `json:"destination" validate:"required"`
+	Overwrite       bool     `json:"overwrite"`
+	OverwritePolicy string   `json:"overwrite_policy"`      // rename, always, never, if_newer or if_different; overrides overwrite
+	ProgressID      string   `json:"progress_id,omitempty"` // optional ID to follow cross-device copies
+	PreserveTree    bool     `json:"preserve_tree"`         // keep the folders of glob matches below the pattern's fixed folders
+}
+
+// CopyResult is the outcome of copying or moving one source: the info of where it ended up
+// and the action taken, one of copied, moved, renamed, overwritten, merged or skipped.
+// Merged folders count the entries that were transferred or kept as they were. A source
+// that could not be copied or moved has the action failed, no info and an error code.
+type CopyResult struct {
+	*FileInfo
+	Source      string `json:"source"`
+	Action      string `json:"action"`
+	Transferred int    `json:"transferred,omitempty"`
+	Skipped     int    `json:"skipped,omitempty"`
+	Code        string `json:"code,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Failed reports whether the source could not be copied or moved
+func (r CopyResult) Failed() bool {
+	return r.Error != ""
+}
+
+// TransferRequest represents a copy or move between the local base path and the SSH host.
+// SourceLocation is "local" (default, upload to the remote destination) or "remote" (download).
+type TransferRequest struct {
+	Sources        []string `json:"sources" validate:"required,min=1"`
+	Destination    string   `json:"destination" validate:"required"`
+	SourceLocation string   `json:"source_location"`
+	Overwrite      bool     `json:"overwrite"`
+	Move           bool     `json:"move"`                  // delete each source once transferred
+	ProgressID     string   `json:"progress_id,omitempty"` // optional ID to follow progress
+}
+
+// FetchRequest represents a request to download a URL into the user's space
+type FetchRequest struct {
+	URL         string `json:"url"`
+	Destination string `json:"destination"`
+	Filename    string `json:"filename,omitempty"`
 }
 
 // DeleteRequest represents a delete request with options
 type DeleteRequest struct {
 	Recursive bool `json:"recursive"`
 }
+
+// RemoteCheck reports a test of the SSH connection of a request
+type RemoteCheck struct {
+	Host             string `json:"host"`
+	Port             string `json:"port"`
+	Username         string `json:"username"`
+	ConnectMS        int64  `json:"connect_ms"` // dialing, handshake and authentication
+	CommandMS        int64  `json:"command_ms"` // one command round trip once connected
+	Hostname         string `json:"hostname"`
+	OS               string `json:"os"`
+	Kernel           string `json:"kernel"`
+	Arch             string `json:"arch"`
+	User             string `json:"user"`
+	UID              int    `json:"uid"`
+	BasePath         string `json:"base_path"`
+	BasePathWritable bool   `json:"base_path_writable"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampsMarshalAsUTC(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	modTime := time.Date(2026, 1, 18, 19, 0, 0, 0, jakarta)
+	const want = `"2026-01-18T12:00:00Z"`
+
+	tests := []struct {
+		name  string
+		value interface{}
+		field string
+	}{
+		{"file info", FileInfo{Name: "a.txt", ModTime: modTime}, "mod_time"},
+		{"file info pointer", &FileInfo{Name: "a.txt", ModTime: modTime}, "mod_time"},
+		{"folder info", FolderInfo{Name: "d", ModTime: modTime}, "mod_time"},
+		{"folder children", FolderInfo{Name: "d", ModTime: modTime, Children: []FileInfo{{ModTime: modTime}}}, "mod_time"},
+		{"response data", NewSuccessResponse("ok", FileInfo{ModTime: modTime}), "mod_time"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), `"`+tt.field+`":`+want) {
+				t.Fatalf("%s = %s, want %s:%s", tt.name, data, tt.field, want)
+			}
+			if strings.Contains(string(data), "+07:00") {
+				t.Fatalf("%s kept the local zone: %s", tt.name, data)
+			}
+		})
+	}
+}
+
+func TestResponseTimestampIsUTC(t *testing.T) {
+	for _, resp := range []StandardResponse{
+		NewSuccessResponse("ok", nil),
+		NewErrorResponse("failed", "CODE", "details"),
+	} {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Timestamp string `json:"timestamp"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(decoded.Timestamp, "Z") {
+			t.Fatalf("timestamp = %s, want a Z-suffixed UTC time", decoded.Timestamp)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, decoded.Timestamp); err != nil {
+			t.Fatalf("timestamp %s is not RFC3339: %v", decoded.Timestamp, err)
+		}
+	}
+}
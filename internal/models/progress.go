@@ -1,6 +1,15 @@
 package models
 
-import "sync"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
 
 // ProgressStatus represents the status of an operation
 type ProgressStatus string
@@ -11,29 +20,185 @@ const (
 	StatusProcessing ProgressStatus = "processing"
 	StatusCompleted  ProgressStatus = "completed"
 	StatusFailed     ProgressStatus = "failed"
+	StatusCancelled  ProgressStatus = "cancelled"
+)
+
+// Done reports whether the status is final
+func (s ProgressStatus) Done() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// Operation kinds tracked in the ProgressStore
+const (
+	OperationUpload   = "upload"
+	OperationCompress = "compress"
+	OperationExtract  = "extract"
+	OperationMove     = "move"
+	OperationFetch    = "fetch"
+	OperationTransfer = "transfer"
 )
 
 // Progress represents progress of an operation
 type Progress struct {
 	ID            string         `json:"id"`
+	Operation     string         `json:"operation,omitempty"`
 	Filename      string         `json:"filename,omitempty"`
 	Progress      int            `json:"progress"`
 	UploadedBytes int64          `json:"uploaded_bytes"`
 	TotalBytes    int64          `json:"total_bytes"`
 	Status        ProgressStatus `json:"status"`
 	Error         string         `json:"error,omitempty"`
+
+	// Per-entry detail, reported while extracting archives
+	CurrentFile    string `json:"current_file,omitempty"`
+	CurrentBytes   int64  `json:"current_bytes,omitempty"`
+	CurrentTotal   int64  `json:"current_total,omitempty"`
+	BytesPerSecond int64  `json:"bytes_per_second,omitempty"`
+
+	// Set when a deduplicated upload matched a file already stored
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+
+	// When the operation completed, failed or was cancelled; finished operations are
+	// dropped some time after it
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
 
-// ProgressStore stores progress information in memory
+// ProgressStore stores progress information in memory, optionally persisted to a JSON file
 type ProgressStore struct {
 	mu   sync.RWMutex
 	data map[string]*Progress
+
+	// Persistence (empty path = memory only)
+	path  string
+	dirty bool
+
+	// Subscribers signalled on every change of an operation, guarded by mu
+	watchers map[string]map[chan struct{}]struct{}
 }
 
 // NewProgressStore creates a new progress store
 func NewProgressStore() *ProgressStore {
 	return &ProgressStore{
-		data: make(map[string]*Progress),
+		data:     make(map[string]*Progress),
+		watchers: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// NewPersistentProgressStore creates a progress store backed by the JSON file at path.
+// Existing state is loaded; operations that were still running are marked failed since
+// they cannot continue after a restart. Changes are written every interval.
+func NewPersistentProgressStore(path string, interval time.Duration) (*ProgressStore, error) {
+	ps := NewProgressStore()
+	ps.path = path
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &ps.data); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, p := range ps.data {
+			if !p.Status.Done() {
+				p.Status = StatusFailed
+				p.Error = "interrupted by server restart"
+				ps.dirty = true
+			}
+			// Entries written before finish times were recorded are kept from now on
+			if p.FinishedAt == nil {
+				p.FinishedAt = &now
+				ps.dirty = true
+			}
+		}
+	}
+
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				ps.Flush()
+			}
+		}()
+	}
+	return ps, nil
+}
+
+// Flush writes pending changes to the backing file, if any
+func (ps *ProgressStore) Flush() error {
+	if ps.path == "" {
+		return nil
+	}
+
+	ps.mu.Lock()
+	if !ps.dirty {
+		ps.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(ps.data)
+	ps.dirty = false
+	ps.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so a crash never leaves a truncated file
+	tmp := ps.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(ps.path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ps.path)
+}
+
+// Subscribe returns a channel signalled whenever the progress of id is set, changed or
+// deleted, and a function that ends the subscription. Signals are coalesced: a subscriber
+// busy with one change receives a single signal for the changes made meanwhile, so it
+// should read the current progress with Get on each signal.
+func (ps *ProgressStore) Subscribe(id string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	ps.mu.Lock()
+	if ps.watchers[id] == nil {
+		ps.watchers[id] = make(map[chan struct{}]struct{})
+	}
+	ps.watchers[id][ch] = struct{}{}
+	ps.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			ps.mu.Lock()
+			defer ps.mu.Unlock()
+			delete(ps.watchers[id], ch)
+			if len(ps.watchers[id]) == 0 {
+				delete(ps.watchers, id)
+			}
+		})
+	}
+}
+
+// notify records when the operation id finished, if it just did, and signals its
+// subscribers; the caller holds mu
+func (ps *ProgressStore) notify(id string) {
+	if p, ok := ps.data[id]; ok {
+		if !p.Status.Done() {
+			p.FinishedAt = nil
+		} else if p.FinishedAt == nil {
+			now := time.Now()
+			p.FinishedAt = &now
+		}
+	}
+	for ch := range ps.watchers[id] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// A signal is already pending
+		}
 	}
 }
 
@@ -42,21 +207,150 @@ func (ps *ProgressStore) Set(id string, progress *Progress) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 	ps.data[id] = progress
+	ps.dirty = true
+	ps.notify(id)
 }
 
-// Get retrieves progress for an operation
+// Get returns a copy of the progress of an operation. Running operations keep changing the
+// stored entry, so it is only read under the lock; change it through Modify and the like.
 func (ps *ProgressStore) Get(id string) (*Progress, bool) {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
 	p, ok := ps.data[id]
-	return p, ok
+	if !ok {
+		return nil, false
+	}
+	copied := *p
+	return &copied, true
 }
 
 // Delete removes progress for an operation
 func (ps *ProgressStore) Delete(id string) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
+	ps.remove(id)
+}
+
+// Sweep drops finished operations that finished more than maxAge ago, then the oldest
+// finished operations beyond the newest maxFinished, and returns how many were dropped.
+// A maxAge or maxFinished of 0 disables that limit. Running operations are never dropped.
+// A persistent store writes the result on its next flush.
+func (ps *ProgressStore) Sweep(maxAge time.Duration, maxFinished int) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+	var finished []string
+	removed := 0
+	for id, p := range ps.data {
+		if !p.Status.Done() {
+			continue
+		}
+		if p.FinishedAt == nil {
+			p.FinishedAt = &now
+		}
+		if maxAge > 0 && now.Sub(*p.FinishedAt) > maxAge {
+			ps.remove(id)
+			removed++
+			continue
+		}
+		finished = append(finished, id)
+	}
+	if maxFinished > 0 && len(finished) > maxFinished {
+		sort.Slice(finished, func(i, j int) bool {
+			return ps.data[finished[i]].FinishedAt.After(*ps.data[finished[j]].FinishedAt)
+		})
+		for _, id := range finished[maxFinished:] {
+			ps.remove(id)
+			removed++
+		}
+	}
+	return removed
+}
+
+// remove drops the operation id; the caller holds mu
+func (ps *ProgressStore) remove(id string) {
 	delete(ps.data, id)
+	ps.dirty = true
+	ps.notify(id)
+}
+
+// StartSweeper sweeps finished operations with maxAge and maxFinished now and then every
+// interval, so the store does not grow without bound
+func (ps *ProgressStore) StartSweeper(interval, maxAge time.Duration, maxFinished int) {
+	if maxAge <= 0 && maxFinished <= 0 {
+		return
+	}
+	ps.Sweep(maxAge, maxFinished)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ps.Sweep(maxAge, maxFinished)
+		}
+	}()
+}
+
+// CountActive returns the number of unfinished operations per operation kind
+func (ps *ProgressStore) CountActive() map[string]int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, p := range ps.data {
+		if !p.Status.Done() {
+			counts[p.Operation]++
+		}
+	}
+	return counts
+}
+
+// Modify applies fn to the progress of an operation while holding the store lock
+func (ps *ProgressStore) Modify(id string, fn func(p *Progress)) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p, ok := ps.data[id]; ok {
+		fn(p)
+		ps.dirty = true
+		ps.notify(id)
+	}
+}
+
+// SetStatus changes the status of an operation, e.g. from pending to processing once it starts
+func (ps *ProgressStore) SetStatus(id string, status ProgressStatus) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if p, ok := ps.data[id]; ok {
+		p.Status = status
+		ps.dirty = true
+		ps.notify(id)
+	}
+}
+
+// Fail marks an operation failed with err, or cancelled when err comes from a cancelled context
+func (ps *ProgressStore) Fail(id string, err error) {
+	ps.Modify(id, func(p *Progress) {
+		if errors.Is(err, context.Canceled) {
+			p.Status = StatusCancelled
+			p.Error = "operation cancelled"
+			return
+		}
+		p.Status = StatusFailed
+		p.Error = err.Error()
+	})
+}
+
+// Complete marks an operation completed with all of its bytes done
+func (ps *ProgressStore) Complete(id string) {
+	ps.Modify(id, func(p *Progress) {
+		p.Status = StatusCompleted
+		p.Progress = 100
+		p.UploadedBytes = p.TotalBytes
+	})
 }
 
 // Update updates progress and calculates percentage
@@ -68,6 +362,8 @@ func (ps *ProgressStore) Update(id string, uploadedBytes int64) {
 		if p.TotalBytes > 0 {
 			p.Progress = int((uploadedBytes * 100) / p.TotalBytes)
 		}
+		ps.dirty = true
+		ps.notify(id)
 	}
 }
 
@@ -75,11 +371,53 @@ func (ps *ProgressStore) Update(id string, uploadedBytes int64) {
 type CompressRequest struct {
 	Paths            []string `json:"paths" validate:"required,min=1"`
 	Output           string   `json:"output" validate:"required"`
+	Format           string   `json:"format"`            // zip (default), tar, tar.gz, tar.zst or tar.br
+	CompressionLevel int      `json:"compression_level"` // 1 (fastest) to 9 (smallest), 0 for the default
+	Symlinks         string   `json:"symlinks"`          // skip (default) or follow symlinks inside folders
+	Manifest         string   `json:"manifest"`          // md5, sha1 or sha256 adds a MANIFEST.<algo> checksum listing
+}
+
+// CompressAddRequest represents a request to append paths to an existing ZIP archive
+type CompressAddRequest struct {
+	Archive          string   `json:"archive" validate:"required"`
+	Paths            []string `json:"paths" validate:"required,min=1"`
+	Overwrite        bool     `json:"overwrite"` // replace entries that are already in the archive
+	CompressionLevel int      `json:"compression_level"`
+	Symlinks         string   `json:"symlinks"`
+}
+
+// CompressStreamRequest represents a request to stream an archive as the response body
+type CompressStreamRequest struct {
+	Paths            []string `json:"paths" validate:"required,min=1"`
+	Format           string   `json:"format"`   // zip (default), tar, tar.gz, tar.zst or tar.br
+	Filename         string   `json:"filename"` // download name, defaults to archive.<ext>
 	CompressionLevel int      `json:"compression_level"`
+	Symlinks         string   `json:"symlinks"`
+	Manifest         string   `json:"manifest"`
+}
+
+// ChunkedDestinationRequest moves an in-flight chunked upload to another folder
+type ChunkedDestinationRequest struct {
+	Destination string `json:"destination" validate:"required"`
 }
 
 // ExtractRequest represents an extraction request
 type ExtractRequest struct {
 	Source      string `json:"source" validate:"required"`
 	Destination string `json:"destination" validate:"required"`
+	// IntoSubfolder extracts archives with several top-level entries into a folder named
+	// after the archive; nil means true
+	IntoSubfolder *bool `json:"into_subfolder"`
+	// PreserveMode keeps the permissions recorded in the archive; nil means true
+	PreserveMode *bool `json:"preserve_mode"`
+}
+
+// ArchiveSize reports how much extracting an archive would write and whether it fits
+type ArchiveSize struct {
+	Source    string `json:"source"`
+	Entries   int    `json:"entries"`
+	SizeBytes int64  `json:"size_bytes"`
+	SizeHuman string `json:"size_human"`
+	FreeBytes int64  `json:"free_bytes"` // free space at the destination
+	Fits      bool   `json:"fits"`
 }
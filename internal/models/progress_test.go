@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signalled reports whether ch is signalled within a short wait
+func signalled(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestSubscribeSignalsChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(ps *ProgressStore)
+		check  func(p *Progress, ok bool) bool
+	}{
+		{"Update", func(ps *ProgressStore) { ps.Update("op", 50) },
+			func(p *Progress, ok bool) bool { return ok && p.UploadedBytes == 50 && p.Progress == 50 }},
+		{"Modify", func(ps *ProgressStore) { ps.Modify("op", func(p *Progress) { p.CurrentFile = "a.txt" }) },
+			func(p *Progress, ok bool) bool { return ok && p.CurrentFile == "a.txt" }},
+		{"SetStatus", func(ps *ProgressStore) { ps.SetStatus("op", StatusProcessing) },
+			func(p *Progress, ok bool) bool { return ok && p.Status == StatusProcessing }},
+		{"Fail", func(ps *ProgressStore) { ps.Fail("op", errors.New("disk full")) },
+			func(p *Progress, ok bool) bool { return ok && p.Status == StatusFailed && p.Error == "disk full" }},
+		{"Complete", func(ps *ProgressStore) { ps.Complete("op") },
+			func(p *Progress, ok bool) bool { return ok && p.Status == StatusCompleted && p.Progress == 100 }},
+		{"Set", func(ps *ProgressStore) { ps.Set("op", &Progress{ID: "op", TotalBytes: 10}) },
+			func(p *Progress, ok bool) bool { return ok && p.TotalBytes == 10 }},
+		{"Delete", func(ps *ProgressStore) { ps.Delete("op") },
+			func(p *Progress, ok bool) bool { return !ok }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewProgressStore()
+			ps.Set("op", &Progress{ID: "op", TotalBytes: 100, Status: StatusPending})
+			changed, stop := ps.Subscribe("op")
+			defer stop()
+
+			tt.change(ps)
+			if !signalled(changed) {
+				t.Fatal("no signal after the change")
+			}
+			if p, ok := ps.Get("op"); !tt.check(p, ok) {
+				t.Fatalf("progress read on the signal = %+v, %v: the change is not visible", p, ok)
+			}
+		})
+	}
+}
+
+func TestSubscribeCoalescesAndStops(t *testing.T) {
+	ps := NewProgressStore()
+	ps.Set("op", &Progress{ID: "op", TotalBytes: 100})
+	ps.Set("other", &Progress{ID: "other", TotalBytes: 100})
+	changed, stop := ps.Subscribe("op")
+
+	// Changes to another operation are not signalled
+	ps.Update("other", 10)
+	if signalled(changed) {
+		t.Fatal("signalled for a change to another operation")
+	}
+
+	// A subscriber that is not reading gets one pending signal for many changes, and
+	// updates never block on it
+	done := make(chan struct{})
+	go func() {
+		for i := int64(1); i <= 100; i++ {
+			ps.Update("op", i)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Update blocked on a subscriber that is not reading")
+	}
+	if !signalled(changed) {
+		t.Fatal("no signal after 100 updates")
+	}
+	if signalled(changed) {
+		t.Fatal("more than one signal pending for changes made meanwhile")
+	}
+	if p, _ := ps.Get("op"); p.UploadedBytes != 100 {
+		t.Fatalf("progress read on the signal has %d bytes, want the latest 100", p.UploadedBytes)
+	}
+
+	stop()
+	stop()
+	ps.Update("op", 50)
+	if signalled(changed) {
+		t.Fatal("signalled after the subscription ended")
+	}
+}
+
+func TestSweepDropsFinishedOperations(t *testing.T) {
+	ago := func(d time.Duration) *time.Time {
+		at := time.Now().Add(-d)
+		return &at
+	}
+	ps := NewProgressStore()
+	ps.Set("running", &Progress{ID: "running", Status: StatusUploading})
+	ps.Set("expired", &Progress{ID: "expired", Status: StatusCompleted, FinishedAt: ago(2 * time.Hour)})
+	ps.Set("old", &Progress{ID: "old", Status: StatusFailed, FinishedAt: ago(30 * time.Minute)})
+	ps.Set("recent", &Progress{ID: "recent", Status: StatusCancelled, FinishedAt: ago(time.Minute)})
+	ps.Set("done", &Progress{ID: "done", Status: StatusPending})
+	ps.Complete("done")
+
+	if p, _ := ps.Get("done"); p.FinishedAt == nil {
+		t.Fatal("no finish time recorded on completion")
+	}
+	if p, _ := ps.Get("running"); p.FinishedAt != nil {
+		t.Fatal("finish time recorded for a running operation")
+	}
+
+	// The hour limit drops one entry, the cap of two the oldest remaining finished one
+	if removed := ps.Sweep(time.Hour, 2); removed != 2 {
+		t.Fatalf("Sweep removed %d operations, want 2", removed)
+	}
+	for id, kept := range map[string]bool{"running": true, "expired": false, "old": false, "recent": true, "done": true} {
+		if _, ok := ps.Get(id); ok != kept {
+			t.Fatalf("%s kept = %v, want %v", id, ok, kept)
+		}
+	}
+}
+
+func TestSweepReachesProgressFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.json")
+	ps, err := NewPersistentProgressStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Now().Add(-2 * time.Hour)
+	ps.Set("old", &Progress{ID: "old", Status: StatusCompleted, FinishedAt: &finished})
+	ps.Set("new", &Progress{ID: "new", Status: StatusPending})
+	ps.Complete("new")
+	if err := ps.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	ps.Sweep(time.Hour, 0)
+	if err := ps.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewPersistentProgressStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Get("old"); ok {
+		t.Fatal("the swept operation is still in the progress file")
+	}
+	p, ok := reloaded.Get("new")
+	if !ok || p.FinishedAt == nil || !p.FinishedAt.After(finished) {
+		t.Fatalf("reloaded new = %+v, %v, want it with its finish time", p, ok)
+	}
+}
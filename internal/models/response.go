@@ -2,7 +2,8 @@ package models
 
 import "time"
 
-// StandardResponse is the standard API response wrapper
+// StandardResponse is the standard API response wrapper.
+// All timestamps emitted by the API are UTC in RFC3339 format.
 type StandardResponse struct {
 	Success   bool        `json:"success"`
 	Message   string      `json:"message"`
@@ -24,7 +25,7 @@ func NewSuccessResponse(message string, data interface{}) StandardResponse {
 		Message:   message,
 		Data:      data,
 		Error:     nil,
-		Timestamp: time.Now(),
+		Timestamp: time.Now().UTC(),
 	}
 }
 
@@ -38,7 +39,7 @@ func NewErrorResponse(message string, code string, details string) StandardRespo
 			Code:    code,
 			Details: details,
 		},
-		Timestamp: time.Now(),
+		Timestamp: time.Now().UTC(),
 	}
 }
 
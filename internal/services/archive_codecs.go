@@ -0,0 +1,120 @@
+package services
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression levels accepted by the API. Each codec maps them onto its own range.
+const (
+	MinCompressionLevel     = 1
+	MaxCompressionLevel     = 9
+	DefaultCompressionLevel = 6
+)
+
+// ArchiveFormats maps the archive formats that can be written to their file extension and content type
+var ArchiveFormats = map[string][2]string{
+	"zip":     {".zip", "application/zip"},
+	"tar":     {".tar", "application/x-tar"},
+	"tar.gz":  {".tar.gz", "application/gzip"},
+	"tar.zst": {".tar.zst", "application/zstd"},
+	"tar.br":  {".tar.br", "application/x-brotli"},
+}
+
+// ArchiveFormatNames lists the keys of ArchiveFormats for error messages
+const ArchiveFormatNames = "zip, tar, tar.gz, tar.zst or tar.br"
+
+// CompressFormats returns the names of the archive formats that can be written, sorted
+func CompressFormats() []string {
+	formats := make([]string, 0, len(ArchiveFormats))
+	for format := range ArchiveFormats {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// normalizeLevel clamps level to the API range, using the default for 0 and below
+func normalizeLevel(level int) int {
+	if level < MinCompressionLevel {
+		return DefaultCompressionLevel
+	}
+	if level > MaxCompressionLevel {
+		return MaxCompressionLevel
+	}
+	return level
+}
+
+// zstdLevel maps an API level onto the four zstd encoder presets
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 3:
+		return zstd.SpeedFastest
+	case level <= 6:
+		return zstd.SpeedDefault
+	case level <= 8:
+		return zstd.SpeedBetterCompression
+	}
+	return zstd.SpeedBestCompression
+}
+
+// brotliLevel maps an API level onto brotli's 0-11 range
+func brotliLevel(level int) int {
+	return (level*brotli.BestCompression + MaxCompressionLevel/2) / MaxCompressionLevel
+}
+
+// newTarCompressor wraps w in the compressor for a tar based format.
+// Closing the returned writer flushes the compressor but leaves w open.
+func newTarCompressor(w io.Writer, format string, level int) (io.WriteCloser, error) {
+	level = normalizeLevel(level)
+	switch format {
+	case "tar":
+		return nopWriteCloser{w}, nil
+	case "tar.gz":
+		return gzip.NewWriterLevel(w, level)
+	case "tar.zst":
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)))
+	case "tar.br":
+		return brotli.NewWriterLevel(w, brotliLevel(level)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, format)
+}
+
+// newTarDecompressor returns a reader decompressing r according to the archive extension ext
+func newTarDecompressor(r io.Reader, ext string) (io.ReadCloser, error) {
+	switch ext {
+	case ".tar":
+		return io.NopCloser(r), nil
+	case ".tar.gz", ".tgz":
+		return gzip.NewReader(r)
+	case ".tar.zst":
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case ".tar.br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, ext)
+}
+
+// newZipCompressor returns a Deflate compressor for zip.Writer.RegisterCompressor at the given API level
+func newZipCompressor(level int) func(io.Writer) (io.WriteCloser, error) {
+	level = normalizeLevel(level)
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
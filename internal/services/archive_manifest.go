@@ -0,0 +1,95 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/hex"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+)
+
+// archiveManifest collects a digest of every file written to an archive. The listing is
+// stored as the archive's last entry, in the format sha256sum -c (or md5sum -c) checks.
+type archiveManifest struct {
+	algo    string
+	entries []manifestEntry
+}
+
+// manifestEntry is a file of the archive and the hash fed while writing it
+type manifestEntry struct {
+	name string
+	hash hash.Hash
+}
+
+// newArchiveManifest returns a manifest using algo (md5, sha1 or sha256), or nil when algo is empty
+func newArchiveManifest(algo string) (*archiveManifest, error) {
+	if algo == "" {
+		return nil, nil
+	}
+	algo = strings.ToLower(algo)
+	if _, err := utils.NewHasher(algo); err != nil {
+		return nil, err
+	}
+	return &archiveManifest{algo: algo}, nil
+}
+
+// tee returns w extended to also hash what is written for the entry name, so files are
+// read only once. A nil manifest returns w unchanged.
+func (m *archiveManifest) tee(w io.Writer, name string) io.Writer {
+	if m == nil {
+		return w
+	}
+	h, _ := utils.NewHasher(m.algo)
+	m.entries = append(m.entries, manifestEntry{name: name, hash: h})
+	return io.MultiWriter(w, h)
+}
+
+// name returns the entry name of the manifest, MANIFEST.<algo>
+func (m *archiveManifest) name() string {
+	return "MANIFEST." + m.algo
+}
+
+// content returns the manifest listing, one "digest  name" line per file
+func (m *archiveManifest) content() []byte {
+	var buf bytes.Buffer
+	for _, entry := range m.entries {
+		fmt.Fprintf(&buf, "%s  %s\n", hex.EncodeToString(entry.hash.Sum(nil)), entry.name)
+	}
+	return buf.Bytes()
+}
+
+// writeZip adds the manifest to a ZIP archive
+func (m *archiveManifest) writeZip(zipWriter *zip.Writer) error {
+	header := &zip.FileHeader{
+		Name:     m.name(),
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+	header.SetMode(utils.FileMode())
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(m.content())
+	return err
+}
+
+// writeTar adds the manifest to a tar archive
+func (m *archiveManifest) writeTar(tarWriter *tar.Writer) error {
+	content := m.content()
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name:    m.name(),
+		Mode:    int64(utils.FileMode()),
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tarWriter.Write(content)
+	return err
+}
@@ -0,0 +1,715 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrToolUnavailable is returned when a program an archive operation runs over SSH is not installed on the host
+var ErrToolUnavailable = errors.New("required program is not available on the SSH host")
+
+// remoteTarCompressors maps the extension of a tar based archive to the program filtering
+// the tar stream on the SSH host, empty for a plain tar
+var remoteTarCompressors = map[string]string{
+	".tar":     "",
+	".tar.gz":  "gzip",
+	".tgz":     "gzip",
+	".tar.zst": "zstd",
+	".tar.br":  "brotli",
+}
+
+// remoteEntry is a file or folder listed on the SSH host, named relative to the folder it was listed from
+type remoteEntry struct {
+	name       string
+	size       int64
+	compressed int64
+	dir        bool
+}
+
+// remoteSource is an archived path with the entries below it, listed from its parent folder
+type remoteSource struct {
+	parent  string
+	entries []remoteEntry
+}
+
+// NewRemoteCompressService creates a compress service archiving files on the SSH host of
+// remote with the zip and tar programs there. The caller closes it with Close; once a job
+// was queued, the job closes the connection when it is done instead.
+func NewRemoteCompressService(remote *FileManagerService, progressStore *models.ProgressStore) *CompressService {
+	return &CompressService{
+		basePath:      remote.basePath,
+		progressStore: progressStore,
+		owner:         remote.owner,
+		uid:           -1,
+		gid:           -1,
+		remote:        remote,
+	}
+}
+
+// Close closes the SSH connection of a remote compress service, unless a queued job took it over
+func (s *CompressService) Close() {
+	if s.remote != nil && !s.detached {
+		s.remote.Close()
+	}
+}
+
+// IsRemote reports whether archives are created on an SSH host
+func (s *CompressService) IsRemote() bool {
+	return s.remote != nil
+}
+
+// NewRemoteExtractService creates an extract service extracting archives on the SSH host of
+// remote with the unzip and tar programs there. It is closed like a remote compress service.
+func NewRemoteExtractService(remote *FileManagerService, progressStore *models.ProgressStore) *ExtractService {
+	return &ExtractService{
+		basePath:      remote.basePath,
+		progressStore: progressStore,
+		owner:         remote.owner,
+		uid:           -1,
+		gid:           -1,
+		remote:        remote,
+	}
+}
+
+// Close closes the SSH connection of a remote extract service, unless a queued job took it over
+func (s *ExtractService) Close() {
+	if s.remote != nil && !s.detached {
+		s.remote.Close()
+	}
+}
+
+// IsRemote reports whether archives are extracted on an SSH host
+func (s *ExtractService) IsRemote() bool {
+	return s.remote != nil
+}
+
+// compressRemote is Compress for an SSH host. The entries are listed with find, then tar or
+// zip archives them into a staging file next to the output, progress following the names
+// the program reports. The staging file is renamed over the output once it is complete.
+func (s *CompressService) compressRemote(paths []string, output, format string, level int, symlinks utils.SymlinkPolicy) (string, error) {
+	tools := []string{"zip"}
+	if format != "zip" {
+		tools = []string{"tar"}
+		if program := remoteTarCompressors["."+format]; program != "" {
+			tools = append(tools, program)
+		}
+	}
+	if err := s.remote.requireTools(tools...); err != nil {
+		return "", err
+	}
+
+	outputPath, err := utils.ValidatePath(s.basePath, output)
+	if err != nil {
+		return "", err
+	}
+	if err := s.remote.mkdirAllOwned(filepath.Dir(outputPath)); err != nil {
+		return "", err
+	}
+	outputPath = s.remote.uniqueName(outputPath)
+
+	compressID := uuid.New().String()
+	staging := stagingPath(outputPath, compressID)
+
+	// The output may sit inside one of the archived folders; it must not archive itself
+	skip := map[string]bool{outputPath: true, staging: true}
+	sources, totalSize, err := s.remote.listRemoteSources(paths, symlinks, skip)
+	if err != nil {
+		return "", err
+	}
+
+	// Create the archive now so its unique name is reserved before the job runs
+	file, err := s.remote.sftpClient.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	s.progressStore.Set(compressID, &models.Progress{
+		ID:            compressID,
+		Operation:     models.OperationCompress,
+		Filename:      filepath.Base(outputPath),
+		Progress:      0,
+		UploadedBytes: 0,
+		TotalBytes:    totalSize,
+		Status:        models.StatusPending,
+	})
+
+	ctx, release := TrackOperation(context.Background(), compressID, s.owner)
+	job := func() {
+		defer release()
+		defer s.remote.Close()
+
+		s.progressStore.SetStatus(compressID, models.StatusProcessing)
+		progress := newRemoteProgress(s.progressStore, compressID, sources)
+		var err error
+		if format == "zip" {
+			err = s.remote.zipRemote(ctx, sources, staging, level, progress.zipLine)
+		} else {
+			err = s.remote.tarRemote(ctx, sources, staging, "."+format, level, symlinks, progress.tarLine)
+		}
+		if err == nil {
+			err = s.remote.replaceFull(staging, outputPath)
+		}
+		if err != nil {
+			// A partial archive is of no use
+			s.remote.sftpClient.Remove(staging)
+			s.remote.sftpClient.Remove(outputPath)
+			s.updateProgressError(compressID, err)
+			return
+		}
+
+		if err := s.remote.sftpClient.Chmod(outputPath, utils.FileMode()); err != nil {
+			utils.Errorf("Failed to set mode for %s: %v", outputPath, err)
+		}
+		s.remote.setOwner(outputPath)
+		s.updateProgressCompleted(compressID)
+	}
+
+	// The job now owns the connection
+	s.detached = true
+	if err := operationPool.Submit(job); err != nil {
+		s.detached = false
+		release()
+		s.remote.sftpClient.Remove(outputPath)
+		s.updateProgressError(compressID, err)
+		return compressID, err
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, outputPath)
+	return compressID + ":" + relPath, nil
+}
+
+// listRemoteSources validates the requested paths, skipping invalid and missing ones, and
+// lists the files and folders below each with find, symlinks inside folders left out or
+// followed according to symlinks. Paths in skip are left out. ErrNotFound is returned when
+// nothing is left to archive.
+func (s *FileManagerService) listRemoteSources(paths []string, symlinks utils.SymlinkPolicy, skip map[string]bool) ([]remoteSource, int64, error) {
+	// -H follows the named path itself, as it was named explicitly; -L follows every link
+	follow := "-H"
+	if symlinks == utils.SymlinksFollow {
+		follow = "-L"
+	}
+
+	var sources []remoteSource
+	var totalSize int64
+	for _, p := range paths {
+		fullPath, err := utils.ValidatePath(s.basePath, p)
+		if err != nil || !s.pathExists(fullPath) {
+			continue
+		}
+
+		source := remoteSource{parent: filepath.Dir(fullPath)}
+		cmd := fmt.Sprintf("cd %s && find %s %s \\( -type f -o -type d \\) -printf '%%Y %%s %%p\\0'",
+			shellQuote(source.parent), follow, shellQuote("./"+filepath.Base(fullPath)))
+		var parseErr error
+		err = s.runSSHCommandStream(context.Background(), cmd, nil, scanNull, func(line string) {
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) != 3 {
+				parseErr = fmt.Errorf("unexpected output from find: %s", line)
+				return
+			}
+			name := strings.TrimPrefix(fields[2], "./")
+			if skip[filepath.Join(source.parent, name)] {
+				return
+			}
+			entry := remoteEntry{name: name, dir: fields[0] == "d"}
+			if !entry.dir {
+				entry.size, _ = strconv.ParseInt(fields[1], 10, 64)
+				totalSize += entry.size
+			}
+			source.entries = append(source.entries, entry)
+		})
+		if err == nil {
+			err = parseErr
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("remote listing of %s failed: %v", p, err)
+		}
+		if len(source.entries) > 0 {
+			sources = append(sources, source)
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, 0, ErrNotFound
+	}
+	return sources, totalSize, nil
+}
+
+// tarRemote archives the entries of sources into archivePath with tar, compressed by the
+// program of ext at level. The names below each parent folder are written to a NUL separated
+// list next to the archive, which tar reads verbatim after changing to the folder, so no
+// name is ever taken for an option. The lists are removed afterwards.
+func (s *FileManagerService) tarRemote(ctx context.Context, sources []remoteSource, archivePath, ext string, level int, symlinks utils.SymlinkPolicy, onLine func(string)) error {
+	args := []string{"tar", "--null", "--no-recursion", "--quoting-style=literal"}
+	if symlinks == utils.SymlinksFollow {
+		args = append(args, "-h")
+	}
+	if program := remoteTarCompressors[ext]; program != "" {
+		args = append(args, "-I", shellQuote(program+" "+remoteCompressorLevel(program, level)))
+	}
+	args = append(args, "-cvf", shellQuote(archivePath))
+
+	for i, source := range sources {
+		listPath := fmt.Sprintf("%s.list%d", archivePath, i)
+		defer s.sftpClient.Remove(listPath)
+
+		file, err := s.sftpClient.Create(listPath)
+		if err != nil {
+			return err
+		}
+		var list bytes.Buffer
+		for _, entry := range source.entries {
+			list.WriteString(entry.name + "\x00")
+		}
+		_, err = file.Write(list.Bytes())
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		args = append(args, "-C", shellQuote(source.parent), "-T", shellQuote(listPath))
+	}
+
+	return s.runSSHCommandStream(ctx, strings.Join(args, " "), nil, bufio.ScanLines, onLine)
+}
+
+// zipRemote archives the entries of sources into archivePath with zip, running it once per
+// parent folder so entries are named relative to it. Names are passed one per line on stdin
+// with wildcards disabled; a name holding a line break cannot be passed and is rejected.
+func (s *FileManagerService) zipRemote(ctx context.Context, sources []remoteSource, archivePath string, level int, onLine func(string)) error {
+	for _, source := range sources {
+		var list bytes.Buffer
+		for _, entry := range source.entries {
+			if strings.ContainsAny(entry.name, "\r\n") {
+				return fmt.Errorf("%w: %q holds a line break", utils.ErrInvalidPath, entry.name)
+			}
+			list.WriteString(entry.name + "\n")
+		}
+
+		cmd := fmt.Sprintf("cd %s && zip -%d -nw %s -@", shellQuote(source.parent), normalizeLevel(level), shellQuote(archivePath))
+		if err := s.runSSHCommandStream(ctx, cmd, &list, bufio.ScanLines, onLine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteProgress turns the entry names tar and zip report into progress, counting the
+// listed size of each entry once the program names it
+type remoteProgress struct {
+	store *models.ProgressStore
+	id    string
+	sizes map[string]int64
+	done  int64
+}
+
+func newRemoteProgress(store *models.ProgressStore, id string, sources []remoteSource) *remoteProgress {
+	sizes := make(map[string]int64)
+	for _, source := range sources {
+		for _, entry := range source.entries {
+			sizes[strings.TrimSuffix(entry.name, "/")] = entry.size
+		}
+	}
+	return &remoteProgress{store: store, id: id, sizes: sizes}
+}
+
+// tarLine counts an entry named by tar -v
+func (p *remoteProgress) tarLine(line string) {
+	p.advance(line)
+}
+
+// zipLine counts an entry named by zip, e.g. "  adding: docs/a.txt (deflated 61%)"
+func (p *remoteProgress) zipLine(line string) {
+	line = strings.TrimSpace(line)
+	for _, prefix := range []string{"adding: ", "updating: ", "inflating: ", "extracting: ", "creating: "} {
+		if strings.HasPrefix(line, prefix) {
+			name := strings.TrimPrefix(line, prefix)
+			if i := strings.LastIndex(name, " ("); i > 0 {
+				name = name[:i]
+			}
+			p.advance(strings.TrimSpace(name))
+			return
+		}
+	}
+}
+
+func (p *remoteProgress) advance(name string) {
+	name = strings.TrimSuffix(name, "/")
+	p.done += p.sizes[name]
+	p.store.Modify(p.id, func(progress *models.Progress) {
+		if progress.TotalBytes > 0 {
+			progress.Progress = int((p.done * 100) / progress.TotalBytes)
+		}
+		progress.UploadedBytes = p.done
+		progress.CurrentFile = name
+	})
+}
+
+// extractRemote is Extract for an SSH host. The archive is listed with unzip or tar first, so
+// unsafe names and archives over the limits are rejected before anything is written; links
+// and special entries, which the local extractor skips, are rejected as they cannot be left
+// out. After extraction the entries get the default modes, or keep theirs without setuid,
+// setgid and sticky bits when opts.PreserveMode is set, and are handed to the owner.
+func (s *ExtractService) extractRemote(source, destination string, opts ExtractOptions) (string, error) {
+	sourcePath, err := utils.ValidatePath(s.basePath, source)
+	if err != nil {
+		return "", err
+	}
+	info, err := s.remote.statFull(sourcePath)
+	if err != nil || info.IsDir() {
+		return "", ErrNotFound
+	}
+	destPath, err := utils.ValidatePath(s.basePath, destination)
+	if err != nil {
+		return "", err
+	}
+
+	ext := archiveExtension(sourcePath)
+	entries, err := s.remote.listRemoteArchive(sourcePath, ext)
+	if err != nil {
+		return "", err
+	}
+
+	budget := archiveBudget{limits: extractLimits}
+	roots := make(map[string]bool)
+	for _, entry := range entries {
+		if _, err := entryPath(destPath, entry.name); err != nil {
+			return "", err
+		}
+		if err := budget.admit(entry.name, entry.size, entry.compressed); err != nil {
+			return "", err
+		}
+		// "./site/index.html" and "site/" both belong to "site"
+		if root := strings.SplitN(strings.TrimPrefix(path.Clean("/"+entry.name), "/"), "/", 2)[0]; root != "" {
+			roots[root] = true
+		}
+	}
+	if ext != ".zip" && ext != "" && exceedsRatio(extractLimits.MaxRatio, budget.total, info.Size()) {
+		return "", fmt.Errorf("%w: %s expands %d times", ErrZipBomb, filepath.Base(sourcePath), budget.total/info.Size())
+	}
+
+	// A single top-level entry already keeps the destination tidy, as for local archives
+	if opts.IntoSubfolder && len(roots) > 1 {
+		destPath = s.remote.uniqueName(filepath.Join(destPath, ArchiveBaseName(sourcePath)))
+	}
+
+	extractID := uuid.New().String()
+	s.progressStore.Set(extractID, &models.Progress{
+		ID:            extractID,
+		Operation:     models.OperationExtract,
+		Filename:      filepath.Base(sourcePath),
+		Progress:      0,
+		UploadedBytes: 0,
+		TotalBytes:    budget.total,
+		Status:        models.StatusPending,
+	})
+
+	ctx, release := TrackOperation(context.Background(), extractID, s.owner)
+	job := func() {
+		defer release()
+		defer s.remote.Close()
+
+		s.progressStore.SetStatus(extractID, models.StatusProcessing)
+		if err := s.remote.mkdirAllOwned(destPath); err != nil {
+			s.updateProgressError(extractID, err)
+			return
+		}
+
+		progress := newRemoteProgress(s.progressStore, extractID, []remoteSource{{entries: entries}})
+		err := s.remote.unpackRemote(ctx, sourcePath, ext, destPath, opts.PreserveMode, progress)
+		// Whatever was extracted is fixed up, even when extraction stopped part way
+		s.remote.settleExtracted(destPath, entries, opts.PreserveMode)
+		if err != nil {
+			s.updateProgressError(extractID, err)
+			return
+		}
+		s.updateProgressCompleted(extractID)
+	}
+
+	// The job now owns the connection
+	s.detached = true
+	if err := operationPool.Submit(job); err != nil {
+		s.detached = false
+		release()
+		s.updateProgressError(extractID, err)
+		return extractID, err
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, destPath)
+	return extractID + ":" + relPath, nil
+}
+
+// extractedSizeRemote is ExtractedSize for an SSH host, listing the archive with unzip or tar
+func (s *ExtractService) extractedSizeRemote(source, destination string) (*models.ArchiveSize, error) {
+	sourcePath, err := utils.ValidatePath(s.basePath, source)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := s.remote.statFull(sourcePath); err != nil || info.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	destPath := filepath.Dir(sourcePath)
+	if destination != "" {
+		if destPath, err = utils.ValidatePath(s.basePath, destination); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := s.remote.listRemoteArchive(sourcePath, archiveExtension(sourcePath))
+	if err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.size
+	}
+
+	// The destination may not exist yet; its nearest existing parent is on the same filesystem
+	for !s.remote.pathExists(destPath) && destPath != s.basePath {
+		destPath = filepath.Dir(destPath)
+	}
+	destRel, _ := utils.GetRelativePath(s.basePath, destPath)
+	stats, err := s.remote.GetFilesystemStats(destRel)
+	if err != nil {
+		return nil, err
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, sourcePath)
+	return &models.ArchiveSize{
+		Source:    relPath,
+		Entries:   len(entries),
+		SizeBytes: size,
+		SizeHuman: utils.FormatFileSize(size),
+		FreeBytes: stats.FreeBytes,
+		Fits:      size <= stats.FreeBytes,
+	}, nil
+}
+
+// listRemoteArchive lists the entries of the archive at archivePath on the SSH host, with
+// zipinfo for ZIP archives (anything not recognised as tar, as locally) and tar -tv for tar
+// based ones. Links and special entries are rejected with ErrUnsupportedType.
+func (s *FileManagerService) listRemoteArchive(archivePath, ext string) ([]remoteEntry, error) {
+	isZip := ext == "" || ext == ".zip"
+	program := remoteTarCompressors[ext]
+	tools := []string{"unzip"}
+	if !isZip {
+		tools = []string{"tar"}
+		if program != "" {
+			tools = append(tools, program)
+		}
+	}
+	if err := s.requireTools(tools...); err != nil {
+		return nil, err
+	}
+
+	cmd := "unzip -Zl " + shellQuote(archivePath)
+	if !isZip {
+		cmd = "tar --quoting-style=literal " + tarFilterFlag(program) + "-tvf " + shellQuote(archivePath)
+	}
+
+	var entries []remoteEntry
+	var parseErr error
+	err := s.runSSHCommandStream(context.Background(), cmd, nil, bufio.ScanLines, func(line string) {
+		if parseErr != nil {
+			return
+		}
+		var entry remoteEntry
+		var ok bool
+		if isZip {
+			// -rw-r--r--  3.0 unx     1043 tx      512 defN 26-Oct-16 14:39 docs/a.txt
+			var fields []string
+			fields, entry.name, ok = splitFields(line, 9)
+			if !ok || !isNumeric(fields[3]) || !isNumeric(fields[5]) {
+				return // header and totals
+			}
+			entry.size, _ = strconv.ParseInt(fields[3], 10, 64)
+			entry.compressed, _ = strconv.ParseInt(fields[5], 10, 64)
+			entry.dir = fields[0][0] == 'd' || strings.HasSuffix(entry.name, "/")
+			if !entry.dir && fields[0][0] != '-' {
+				parseErr = fmt.Errorf("%w: %s is a link or special file, which cannot be extracted over SSH", ErrUnsupportedType, entry.name)
+			}
+		} else {
+			// -rw-r--r-- user/group      1043 2026-10-16 14:39 docs/a.txt
+			var fields []string
+			fields, entry.name, ok = splitFields(line, 5)
+			if !ok {
+				parseErr = fmt.Errorf("unexpected output from tar: %s", line)
+				return
+			}
+			entry.dir = fields[0][0] == 'd'
+			if !entry.dir && fields[0][0] != '-' {
+				// Links name their target after the entry
+				for _, sep := range []string{" -> ", " link to "} {
+					if i := strings.Index(entry.name, sep); i > 0 {
+						entry.name = entry.name[:i]
+					}
+				}
+				parseErr = fmt.Errorf("%w: %s is a link or special file, which cannot be extracted over SSH", ErrUnsupportedType, entry.name)
+				return
+			}
+			if !entry.dir {
+				entry.size, _ = strconv.ParseInt(fields[2], 10, 64)
+			}
+		}
+		if entry.dir {
+			entry.size = 0
+		}
+		entries = append(entries, entry)
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if err != nil {
+		if isZip {
+			ext = ".zip"
+		}
+		return nil, fmt.Errorf("%w: invalid %s archive: %v", ErrUnsupportedType, ext, err)
+	}
+	return entries, nil
+}
+
+// unpackRemote extracts the archive at archivePath into destPath on the SSH host, reporting
+// the entries the program names to progress. Existing files are replaced, as locally.
+func (s *FileManagerService) unpackRemote(ctx context.Context, archivePath, ext, destPath string, preserveMode bool, progress *remoteProgress) error {
+	if ext == "" || ext == ".zip" {
+		cmd := fmt.Sprintf("cd %s && unzip -o %s", shellQuote(destPath), shellQuote(archivePath))
+		return s.runSSHCommandStream(ctx, cmd, nil, bufio.ScanLines, progress.zipLine)
+	}
+
+	cmd := "tar " + tarFilterFlag(remoteTarCompressors[ext]) + "-xvf " + shellQuote(archivePath) + " -C " + shellQuote(destPath) + " --no-same-owner"
+	if !preserveMode {
+		cmd += " --no-same-permissions"
+	}
+	return s.runSSHCommandStream(ctx, cmd, nil, bufio.ScanLines, progress.tarLine)
+}
+
+// settleExtracted gives the entries extracted into destPath, and the folders implied by
+// their names, the default modes, or strips setuid, setgid and sticky bits with
+// preserveMode, and hands them to the owner. Failures are logged, as for local extraction.
+func (s *FileManagerService) settleExtracted(destPath string, entries []remoteEntry, preserveMode bool) {
+	var files, dirs bytes.Buffer
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := path.Clean(entry.name)
+		if !entry.dir {
+			files.WriteString(name + "\x00")
+			name = path.Dir(name)
+		}
+		for ; name != "." && !seen[name]; name = path.Dir(name) {
+			seen[name] = true
+			dirs.WriteString(name + "\x00")
+		}
+	}
+	all := files.String() + dirs.String()
+
+	// Names are passed NUL separated on stdin; missing ones (extraction stopped early) only produce warnings
+	run := func(args, list string) {
+		if list == "" {
+			return
+		}
+		cmd := fmt.Sprintf("cd %s && xargs -0 %s --", shellQuote(destPath), args)
+		if err := s.runSSHCommandStream(context.Background(), cmd, strings.NewReader(list), bufio.ScanLines, func(string) {}); err != nil {
+			utils.Errorf("Failed to run %s on extracted entries in %s: %v", args, destPath, err)
+		}
+	}
+	if preserveMode {
+		run("chmod ug-s,-t", all)
+	} else {
+		run(fmt.Sprintf("chmod %o", utils.FileMode().Perm()), files.String())
+		run(fmt.Sprintf("chmod %o", utils.DirMode().Perm()), dirs.String())
+	}
+	if s.owner != "" && !s.preserveOwner {
+		run("chown -h "+shellQuote(s.owner+":"+s.owner), all)
+	}
+}
+
+// requireTools returns ErrToolUnavailable naming the programs missing on the SSH host
+func (s *FileManagerService) requireTools(tools ...string) error {
+	cmd := fmt.Sprintf("for t in %s; do command -v $t >/dev/null 2>&1 || echo $t; done", strings.Join(tools, " "))
+	output, err := s.runSSHCommandOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("remote tool check failed: %v", err)
+	}
+	if missing := strings.Fields(string(output)); len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrToolUnavailable, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// tarFilterFlag returns the tar option filtering the archive through program, followed by a
+// space, or nothing for a plain tar. On extraction tar adds -d itself.
+func tarFilterFlag(program string) string {
+	if program == "" {
+		return ""
+	}
+	return "-I " + program + " "
+}
+
+// remoteCompressorLevel returns the option selecting an API compression level for program
+func remoteCompressorLevel(program string, level int) string {
+	level = normalizeLevel(level)
+	switch program {
+	case "zstd":
+		// The levels the zstd encoder presets correspond to
+		switch zstdLevel(level) {
+		case zstd.SpeedFastest:
+			return "-1"
+		case zstd.SpeedDefault:
+			return "-3"
+		case zstd.SpeedBetterCompression:
+			return "-7"
+		}
+		return "-11"
+	case "brotli":
+		return fmt.Sprintf("-q %d", brotliLevel(level))
+	}
+	return fmt.Sprintf("-%d", level)
+}
+
+// shellQuote quotes s as a single word for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// splitFields splits the first n space separated fields off line and returns them with the
+// rest of the line, which follows the last of them after a single space
+func splitFields(line string, n int) ([]string, string, bool) {
+	fields := make([]string, 0, n)
+	rest := line
+	for len(fields) < n {
+		rest = strings.TrimLeft(rest, " ")
+		end := strings.IndexByte(rest, ' ')
+		if end <= 0 {
+			return nil, "", false
+		}
+		fields = append(fields, rest[:end])
+		rest = rest[end:]
+	}
+	return fields, rest[1:], rest != " "
+}
+
+// scanNull is a bufio.SplitFunc for NUL terminated tokens
+func scanNull(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
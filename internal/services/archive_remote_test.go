@@ -0,0 +1,27 @@
+package services
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{
+		"/home/u1/plain.txt",
+		"/home/u1/it's.txt",
+		"/home/u1/x'; touch pwned; echo '",
+		"/home/u1/$(id) `id` $HOME",
+		"/home/u1/a\"b\\c",
+		"/home/u1/new\nline",
+		"-rf",
+		"",
+	} {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("sh rejected %q: %v", s, err)
+		}
+		if string(out) != s {
+			t.Fatalf("shellQuote(%q) reached the shell as %q", s, out)
+		}
+	}
+}
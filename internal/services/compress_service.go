@@ -1,13 +1,16 @@
 package services
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"context"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/utils"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync/atomic"
 
 	"github.com/google/uuid"
@@ -20,6 +23,14 @@ type CompressService struct {
 	owner         string
 	uid           int
 	gid           int
+
+	// remote, when set, is the SSH host archives are created on; detached is set once a
+	// queued job took over its connection
+	remote   *FileManagerService
+	detached bool
+	// preserveOwner leaves files with the ownership the filesystem gives them instead of
+	// handing them to owner
+	preserveOwner bool
 }
 
 // NewCompressService creates a new compress service
@@ -38,30 +49,56 @@ func NewCompressService(basePath string, owner string, progressStore *models.Pro
 			svc.uid = uid
 			svc.gid = gid
 		} else {
-			fmt.Printf("[ERROR] Failed to resolve user %s: %v\n", owner, err)
+			utils.Errorf("Failed to resolve user %s: %v", owner, err)
 		}
 	}
 
 	return svc
 }
 
+// SetPreserveOwner makes the service leave archives with the ownership the filesystem gives
+// them, instead of handing them to the usersite owner
+func (s *CompressService) SetPreserveOwner(preserve bool) {
+	s.preserveOwner = preserve
+	if s.remote != nil {
+		s.remote.SetPreserveOwner(preserve)
+	}
+}
+
 // setOwner sets the file owner to the service configured user
 func (s *CompressService) setOwner(path string) error {
-	if s.owner == "" {
+	if s.owner == "" || s.preserveOwner {
 		return nil
 	}
 	return utils.SudoChown(path, s.owner)
 }
 
-// Compress creates a ZIP archive from the given paths
-func (s *CompressService) Compress(paths []string, output string, compressionLevel int) (string, error) {
+// Compress validates the request and creates an archive in the given format (see ArchiveFormats)
+// from the given paths in the background, returning "compressID:relativePath" once the job is queued.
+// Symlinks inside archived folders are left out or archived as their targets according to symlinks.
+// A manifest algorithm (md5, sha1 or sha256) adds a MANIFEST.<algo> entry listing the digest of every file.
+func (s *CompressService) Compress(paths []string, output, format string, compressionLevel int, symlinks utils.SymlinkPolicy, manifestAlgo string) (string, error) {
+	if _, ok := ArchiveFormats[format]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, format)
+	}
+	manifest, err := newArchiveManifest(manifestAlgo)
+	if err != nil {
+		return "", err
+	}
+	if s.remote != nil {
+		if manifest != nil {
+			return "", ErrNotSupported
+		}
+		return s.compressRemote(paths, output, format, compressionLevel, symlinks)
+	}
+
 	outputPath, err := utils.ValidatePath(s.basePath, output)
 	if err != nil {
 		return "", err
 	}
 
 	// Ensure output directory exists
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(outputPath), utils.DirMode()); err != nil {
 		return "", err
 	}
 
@@ -71,6 +108,234 @@ func (s *CompressService) Compress(paths []string, output string, compressionLev
 	}
 
 	// Calculate total size for progress
+	validPaths, totalSize, err := s.ResolvePaths(paths, symlinks)
+	if err != nil {
+		return "", err
+	}
+
+	// Create the archive now so its unique name is reserved before the job runs
+	archiveFile, err := utils.CreateFile(outputPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Generate compress ID for progress tracking
+	compressID := uuid.New().String()
+
+	// Initialize progress
+	s.progressStore.Set(compressID, &models.Progress{
+		ID:            compressID,
+		Operation:     models.OperationCompress,
+		Filename:      filepath.Base(outputPath),
+		Progress:      0,
+		UploadedBytes: 0,
+		TotalBytes:    totalSize,
+		Status:        models.StatusPending,
+	})
+
+	// The job outlives the request; it is stopped through CancelOperation
+	ctx, release := TrackOperation(context.Background(), compressID, s.owner)
+	job := func() {
+		defer release()
+		s.progressStore.SetStatus(compressID, models.StatusProcessing)
+		if err := s.writeArchiveFile(ctx, archiveFile, validPaths, format, compressionLevel, totalSize, compressID, outputPath, symlinks, manifest); err != nil {
+			os.Remove(outputPath) // A partial archive is of no use
+			s.updateProgressError(compressID, err)
+			return
+		}
+
+		// Set owner of the archive
+		s.setOwner(outputPath)
+
+		s.updateProgressCompleted(compressID)
+	}
+	if err := operationPool.Submit(job); err != nil {
+		release()
+		archiveFile.Close()
+		os.Remove(outputPath)
+		s.updateProgressError(compressID, err)
+		return compressID, err
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, outputPath)
+	return compressID + ":" + relPath, nil
+}
+
+// writeArchiveFile archives fullPaths into file at outputPath, reporting progress under compressID, and closes it
+func (s *CompressService) writeArchiveFile(ctx context.Context, file *os.File, fullPaths []string, format string, level int, totalSize int64, compressID, outputPath string, symlinks utils.SymlinkPolicy, manifest *archiveManifest) error {
+	defer file.Close()
+
+	// The output may sit inside one of the archived directories; it must not archive itself
+	skip := map[string]bool{outputPath: true}
+	if err := s.writeArchive(ctx, file, fullPaths, format, level, totalSize, compressID, skip, symlinks, manifest); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// AddToArchive rewrites an existing ZIP archive with the given paths appended, in the
+// background, returning "compressID:relativePath" once the job is queued. Existing entries
+// are copied without recompressing. Entries whose names are already in the archive are
+// rejected with ErrAlreadyExists unless overwrite is set, in which case they are replaced.
+// The result is written next to the archive and renamed over it once complete.
+// Symlinks inside added folders are treated according to symlinks, as for Compress.
+func (s *CompressService) AddToArchive(archive string, paths []string, overwrite bool, compressionLevel int, symlinks utils.SymlinkPolicy) (string, error) {
+	archivePath, err := utils.ValidatePath(s.basePath, archive)
+	if err != nil {
+		return "", err
+	}
+	if !utils.PathExists(archivePath) || utils.IsDir(archivePath) {
+		return "", ErrNotFound
+	}
+
+	validPaths, totalSize, err := s.ResolvePaths(paths, symlinks)
+	if err != nil {
+		return "", err
+	}
+
+	// Open the archive now so a corrupt one is reported to the caller
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s is not a valid ZIP archive", ErrUnsupportedType, archive)
+	}
+
+	compressID := uuid.New().String()
+	staging := stagingPath(archivePath, compressID)
+	// Neither the archive nor its replacement being written belong in the result
+	skip := map[string]bool{archivePath: true, staging: true}
+
+	added, err := zipEntryNames(validPaths, skip, symlinks)
+	if err != nil {
+		zipReader.Close()
+		return "", err
+	}
+	if !overwrite {
+		for _, f := range zipReader.File {
+			// Directory entries may be shared; only files clash
+			if added[f.Name] && !strings.HasSuffix(f.Name, "/") {
+				zipReader.Close()
+				return "", fmt.Errorf("%w: %s is already in the archive", ErrAlreadyExists, f.Name)
+			}
+		}
+	}
+
+	s.progressStore.Set(compressID, &models.Progress{
+		ID:            compressID,
+		Operation:     models.OperationCompress,
+		Filename:      filepath.Base(archivePath),
+		Progress:      0,
+		UploadedBytes: 0,
+		TotalBytes:    totalSize,
+		Status:        models.StatusPending,
+	})
+
+	ctx, release := TrackOperation(context.Background(), compressID, s.owner)
+	job := func() {
+		defer release()
+		defer zipReader.Close()
+		defer os.Remove(staging) // No-op once renamed into place
+
+		s.progressStore.SetStatus(compressID, models.StatusProcessing)
+		if err := s.rewriteZip(ctx, staging, zipReader, added, validPaths, compressionLevel, totalSize, compressID, skip, symlinks); err != nil {
+			s.updateProgressError(compressID, err)
+			return
+		}
+		if err := os.Rename(staging, archivePath); err != nil {
+			s.updateProgressError(compressID, err)
+			return
+		}
+
+		s.setOwner(archivePath)
+		s.updateProgressCompleted(compressID)
+	}
+	if err := operationPool.Submit(job); err != nil {
+		release()
+		zipReader.Close()
+		s.updateProgressError(compressID, err)
+		return compressID, err
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, archivePath)
+	return compressID + ":" + relPath, nil
+}
+
+// rewriteZip writes the entries of existing not named in replaced, followed by fullPaths, to a new ZIP at path
+func (s *CompressService) rewriteZip(ctx context.Context, path string, existing *zip.ReadCloser, replaced map[string]bool, fullPaths []string, level int, totalSize int64, compressID string, skip map[string]bool, symlinks utils.SymlinkPolicy) error {
+	file, err := utils.CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	zipWriter.RegisterCompressor(zip.Deflate, newZipCompressor(level))
+	zipWriter.SetComment(existing.Comment)
+
+	for _, f := range existing.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if replaced[f.Name] {
+			continue
+		}
+		if err := zipWriter.Copy(f); err != nil {
+			return err
+		}
+	}
+
+	var compressedBytes int64
+	for _, fullPath := range fullPaths {
+		if utils.IsDir(fullPath) {
+			err = s.addDirectoryToZip(ctx, zipWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, compressID, skip, symlinks, nil)
+		} else {
+			err = s.addFileToZip(ctx, zipWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, compressID, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// zipEntryNames returns the entry names addFileToZip and addDirectoryToZip would create for fullPaths
+func zipEntryNames(fullPaths []string, skip map[string]bool, symlinks utils.SymlinkPolicy) (map[string]bool, error) {
+	names := make(map[string]bool)
+	for _, fullPath := range fullPaths {
+		base := filepath.Base(fullPath)
+		if !utils.IsDir(fullPath) {
+			names[base] = true
+			continue
+		}
+		err := utils.Walk(fullPath, symlinks, func(path string, info os.FileInfo) error {
+			if skip[path] || (!info.Mode().IsRegular() && !info.IsDir()) {
+				return nil
+			}
+			relPath, err := filepath.Rel(fullPath, path)
+			if err != nil {
+				return err
+			}
+			name := filepath.Join(base, relPath)
+			if info.IsDir() {
+				name += "/"
+			}
+			names[name] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// ResolvePaths validates the requested paths, skipping invalid and missing ones,
+// and returns their full paths with their combined size, counting symlinks inside
+// folders according to symlinks. ErrNotFound is returned when nothing is left to archive.
+func (s *CompressService) ResolvePaths(paths []string, symlinks utils.SymlinkPolicy) ([]string, int64, error) {
 	var totalSize int64
 	validPaths := make([]string, 0)
 
@@ -86,7 +351,7 @@ func (s *CompressService) Compress(paths []string, output string, compressionLev
 		validPaths = append(validPaths, fullPath)
 
 		if utils.IsDir(fullPath) {
-			size, _ := utils.GetDirectorySize(fullPath)
+			size, _ := utils.GetDirectorySize(context.Background(), fullPath, symlinks)
 			totalSize += size
 		} else {
 			info, _ := os.Stat(fullPath)
@@ -95,80 +360,133 @@ func (s *CompressService) Compress(paths []string, output string, compressionLev
 	}
 
 	if len(validPaths) == 0 {
-		return "", ErrNotFound
+		return nil, 0, ErrNotFound
 	}
+	return validPaths, totalSize, nil
+}
 
-	// Generate compress ID for progress tracking
-	compressID := uuid.New().String()
-
-	// Initialize progress
-	s.progressStore.Set(compressID, &models.Progress{
-		ID:            compressID,
-		Filename:      filepath.Base(outputPath),
-		Progress:      0,
-		UploadedBytes: 0,
-		TotalBytes:    totalSize,
-		Status:        models.StatusProcessing,
-	})
-
-	// Create ZIP file
-	zipFile, err := os.Create(outputPath)
+// CompressStream writes an archive of fullPaths (as returned by ResolvePaths) to w
+// without creating a file. No progress is tracked; the caller sees the bytes arrive.
+// It stops with ctx.Err() once ctx ends. manifestAlgo adds a checksum listing as for Compress.
+func (s *CompressService) CompressStream(ctx context.Context, w io.Writer, fullPaths []string, format string, level int, symlinks utils.SymlinkPolicy, manifestAlgo string) error {
+	manifest, err := newArchiveManifest(manifestAlgo)
 	if err != nil {
-		s.updateProgressError(compressID, err.Error())
-		return compressID, err
+		return err
 	}
-	// Defer close using closure to handle error logic if needed, but structure requires simple defer.
-	// We will chown after close if possible, but we can only chown by path after creation.
-	// Actually os.Chown works on file path even if open.
-	defer zipFile.Close()
-
-	zipWriter := zip.NewWriter(zipFile)
-
-	// We must close zipWriter to flush data
-	// Defer LIFO: zipWriter.Close() runs first, then zipFile.Close()
-	defer zipWriter.Close()
+	return s.writeArchive(ctx, w, fullPaths, format, level, 0, "", nil, symlinks, manifest)
+}
 
+// writeArchive writes an archive of fullPaths to w, reporting progress under progressID when totalSize is known.
+// Files in skip are left out of directory walks, symlinks in them are treated according to symlinks.
+// A non-nil manifest is filled while the files are written and added as the last entry.
+func (s *CompressService) writeArchive(ctx context.Context, w io.Writer, fullPaths []string, format string, level int, totalSize int64, progressID string, skip map[string]bool, symlinks utils.SymlinkPolicy, manifest *archiveManifest) error {
 	// Track compressed bytes
 	var compressedBytes int64
 
-	// Add files to archive
-	for _, fullPath := range validPaths {
-		if utils.IsDir(fullPath) {
-			err = s.addDirectoryToZip(zipWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, compressID)
-		} else {
-			err = s.addFileToZip(zipWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, compressID)
+	if format == "zip" {
+		zipWriter := zip.NewWriter(w)
+		zipWriter.RegisterCompressor(zip.Deflate, newZipCompressor(level))
+		for _, fullPath := range fullPaths {
+			var err error
+			if utils.IsDir(fullPath) {
+				err = s.addDirectoryToZip(ctx, zipWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, progressID, skip, symlinks, manifest)
+			} else {
+				err = s.addFileToZip(ctx, zipWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, progressID, manifest)
+			}
+			if err != nil {
+				return err
+			}
 		}
-		if err != nil {
-			s.updateProgressError(compressID, err.Error())
-			return compressID, err
+		if manifest != nil {
+			if err := manifest.writeZip(zipWriter); err != nil {
+				return err
+			}
 		}
+		// Closing the writer flushes the central directory; its error matters
+		return zipWriter.Close()
 	}
 
-	// Set owner of the zip file
-	s.setOwner(outputPath)
+	compressor, err := newTarCompressor(w, format, level)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(compressor)
+	for _, fullPath := range fullPaths {
+		if err := s.addPathToTar(ctx, tarWriter, fullPath, filepath.Base(fullPath), &compressedBytes, totalSize, progressID, skip, symlinks, manifest); err != nil {
+			return err
+		}
+	}
+	if manifest != nil {
+		if err := manifest.writeTar(tarWriter); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
 
-	s.updateProgressCompleted(compressID)
+// addPathToTar adds a file or directory tree to a tar archive under archivePath
+func (s *CompressService) addPathToTar(ctx context.Context, tarWriter *tar.Writer, fullPath, archivePath string, compressedBytes *int64, totalSize int64, progressID string, skip map[string]bool, symlinks utils.SymlinkPolicy, manifest *archiveManifest) error {
+	return utils.Walk(fullPath, symlinks, func(path string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if skip[path] {
+			return nil
+		}
 
-	relPath, _ := utils.GetRelativePath(s.basePath, outputPath)
-	return compressID + ":" + relPath, nil
+		relPath, err := filepath.Rel(fullPath, path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // Devices and sockets are not archived
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(archivePath, relPath))
+		if info.IsDir() {
+			header.Name += "/"
+			return tarWriter.WriteHeader(header)
+		}
+
+		// Encrypted files are archived by their content
+		file, size, _, err := openEncrypted(path, s.owner)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		header.Size = size
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		return s.copyWithProgress(ctx, manifest.tee(tarWriter, header.Name), file, compressedBytes, totalSize, progressID)
+	})
 }
 
-func (s *CompressService) addFileToZip(zipWriter *zip.Writer, filePath, zipPath string, compressedBytes *int64, totalSize int64, progressID string) error {
-	file, err := os.Open(filePath)
+func (s *CompressService) addFileToZip(ctx context.Context, zipWriter *zip.Writer, filePath, zipPath string, compressedBytes *int64, totalSize int64, progressID string, manifest *archiveManifest) error {
+	info, err := os.Stat(filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	info, err := file.Stat()
+	// Encrypted files are archived by their content
+	file, size, _, err := openEncrypted(filePath, s.owner)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	header, err := zip.FileInfoHeader(info)
 	if err != nil {
 		return err
 	}
+	header.UncompressedSize64 = uint64(size)
 
 	header.Name = zipPath
 	header.Method = zip.Deflate
@@ -178,9 +496,16 @@ func (s *CompressService) addFileToZip(zipWriter *zip.Writer, filePath, zipPath
 		return err
 	}
 
-	// Copy with progress tracking
-	buf := make([]byte, utils.DefaultBufferSize)
+	return s.copyWithProgress(ctx, manifest.tee(writer, zipPath), file, compressedBytes, totalSize, progressID)
+}
+
+// copyWithProgress copies file into an archive entry, adding the bytes to compressedBytes
+func (s *CompressService) copyWithProgress(ctx context.Context, writer io.Writer, file io.Reader, compressedBytes *int64, totalSize int64, progressID string) error {
+	buf := utils.NewBuffer()
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		n, err := file.Read(buf)
 		if n > 0 {
 			if _, werr := writer.Write(buf[:n]); werr != nil {
@@ -189,10 +514,10 @@ func (s *CompressService) addFileToZip(zipWriter *zip.Writer, filePath, zipPath
 			newVal := atomic.AddInt64(compressedBytes, int64(n))
 			if totalSize > 0 {
 				progress := int((newVal * 100) / totalSize)
-				if p, ok := s.progressStore.Get(progressID); ok {
+				s.progressStore.Modify(progressID, func(p *models.Progress) {
 					p.Progress = progress
 					p.UploadedBytes = newVal
-				}
+				})
 			}
 		}
 		if err == io.EOF {
@@ -206,11 +531,14 @@ func (s *CompressService) addFileToZip(zipWriter *zip.Writer, filePath, zipPath
 	return nil
 }
 
-func (s *CompressService) addDirectoryToZip(zipWriter *zip.Writer, dirPath, zipPath string, compressedBytes *int64, totalSize int64, progressID string) error {
-	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+func (s *CompressService) addDirectoryToZip(ctx context.Context, zipWriter *zip.Writer, dirPath, zipPath string, compressedBytes *int64, totalSize int64, progressID string, skip map[string]bool, symlinks utils.SymlinkPolicy, manifest *archiveManifest) error {
+	return utils.Walk(dirPath, symlinks, func(path string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		if skip[path] || (!info.Mode().IsRegular() && !info.IsDir()) {
+			return nil // Devices and sockets are not archived
+		}
 
 		relPath, err := filepath.Rel(dirPath, path)
 		if err != nil {
@@ -225,7 +553,7 @@ func (s *CompressService) addDirectoryToZip(zipWriter *zip.Writer, dirPath, zipP
 			return err
 		}
 
-		return s.addFileToZip(zipWriter, path, entryPath, compressedBytes, totalSize, progressID)
+		return s.addFileToZip(ctx, zipWriter, path, entryPath, compressedBytes, totalSize, progressID, manifest)
 	})
 }
 
@@ -234,19 +562,10 @@ func (s *CompressService) GetProgress(compressID string) (*models.Progress, bool
 	return s.progressStore.Get(compressID)
 }
 
-func (s *CompressService) updateProgressError(compressID, errorMsg string) {
-	if p, ok := s.progressStore.Get(compressID); ok {
-		p.Status = models.StatusFailed
-		p.Error = errorMsg
-		s.progressStore.Set(compressID, p)
-	}
+func (s *CompressService) updateProgressError(compressID string, err error) {
+	s.progressStore.Fail(compressID, err)
 }
 
 func (s *CompressService) updateProgressCompleted(compressID string) {
-	if p, ok := s.progressStore.Get(compressID); ok {
-		p.Status = models.StatusCompleted
-		p.Progress = 100
-		p.UploadedBytes = p.TotalBytes
-		s.progressStore.Set(compressID, p)
-	}
+	s.progressStore.Complete(compressID)
 }
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForProgress waits until the operation reporting under id completes or fails
+func waitForProgress(t testing.TB, store *models.ProgressStore, id string) *models.Progress {
+	t.Helper()
+	changed, stop := store.Subscribe(id)
+	defer stop()
+	deadline := time.After(10 * time.Second)
+	for {
+		if p, ok := store.Get(id); ok && (p.Status == models.StatusCompleted || p.Status == models.StatusFailed) {
+			return p
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("operation %s did not finish", id)
+		}
+	}
+}
+
+// archiveEntries lists the entry names of a ZIP or tar.gz archive
+func archiveEntries(t *testing.T, path string) []string {
+	t.Helper()
+	var names []string
+	if strings.HasSuffix(path, ".zip") {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTestCompressService(t *testing.T) (*CompressService, *models.ProgressStore, string) {
+	t.Helper()
+	base := t.TempDir()
+	store := models.NewProgressStore()
+	svc := NewCompressService(base, "", store)
+	svc.SetPreserveOwner(true)
+	return svc, store, base
+}
+
+func TestCompressFolderIntoItself(t *testing.T) {
+	for _, format := range []string{"zip", "tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			svc, store, base := newTestCompressService(t)
+			writeFiles(t, base, "folder/a.txt", "folder/sub/b.txt")
+
+			output := "folder/archive." + format
+			result, err := svc.Compress([]string{"folder"}, output, format, 0, utils.SymlinksSkip, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := strings.SplitN(result, ":", 2)[0]
+			if p := waitForProgress(t, store, id); p.Status != models.StatusCompleted {
+				t.Fatalf("compress %s: %s", p.Status, p.Error)
+			}
+
+			for _, name := range archiveEntries(t, filepath.Join(base, output)) {
+				if strings.Contains(name, "archive.") {
+					t.Fatalf("the archive contains itself as %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAddToArchiveInsideItsInputs(t *testing.T) {
+	svc, store, base := newTestCompressService(t)
+	writeFiles(t, base, "folder/a.txt")
+
+	result, err := svc.Compress([]string{"folder/a.txt"}, "folder/archive.zip", "zip", 0, utils.SymlinksSkip, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForProgress(t, store, strings.SplitN(result, ":", 2)[0])
+
+	writeFiles(t, base, "folder/b.txt")
+	result, err = svc.AddToArchive("folder/archive.zip", []string{"folder"}, true, 0, utils.SymlinksSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := waitForProgress(t, store, strings.SplitN(result, ":", 2)[0]); p.Status != models.StatusCompleted {
+		t.Fatalf("add %s: %s", p.Status, p.Error)
+	}
+
+	for _, name := range archiveEntries(t, filepath.Join(base, "folder/archive.zip")) {
+		if strings.Contains(name, "archive.zip") {
+			t.Fatalf("the archive contains itself or its staging copy as %s", name)
+		}
+	}
+}
+
+func TestCompressFollowsSymlinkLoops(t *testing.T) {
+	svc, store, base := newTestCompressService(t)
+	writeFiles(t, base, "site/a/file.txt")
+	for link, target := range map[string]string{"site/self": "self", "site/a/up": "..", "site/ping": "pong", "site/pong": "ping"} {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := svc.Compress([]string{"site"}, "site.zip", "zip", 0, utils.SymlinksFollow, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := waitForProgress(t, store, strings.SplitN(result, ":", 2)[0]); p.Status != models.StatusCompleted {
+		t.Fatalf("compress %s: %s", p.Status, p.Error)
+	}
+
+	var files []string
+	for _, name := range archiveEntries(t, filepath.Join(base, "site.zip")) {
+		if !strings.HasSuffix(name, "/") {
+			files = append(files, name)
+		}
+	}
+	if len(files) != 1 || files[0] != "site/a/file.txt" {
+		t.Fatalf("archived files = %v, want site/a/file.txt once", files)
+	}
+}
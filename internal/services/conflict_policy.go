@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictPolicy controls what Copy and Move do with a source whose target already exists
+type ConflictPolicy string
+
+const (
+	// ConflictRename places the source under a unique name next to the existing target
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictAlways replaces an existing file and merges into an existing folder
+	ConflictAlways ConflictPolicy = "always"
+	// ConflictNever keeps existing files; only what is missing is added
+	ConflictNever ConflictPolicy = "never"
+	// ConflictIfNewer replaces existing files with an older modification time
+	ConflictIfNewer ConflictPolicy = "if_newer"
+	// ConflictIfDifferent replaces existing files whose size or SHA-256 differs
+	ConflictIfDifferent ConflictPolicy = "if_different"
+)
+
+// Actions reported per source in the result of Copy and Move
+const (
+	ActionCopied      = "copied"
+	ActionMoved       = "moved"
+	ActionRenamed     = "renamed"
+	ActionOverwritten = "overwritten"
+	ActionMerged      = "merged"
+	ActionSkipped     = "skipped"
+	ActionFailed      = "failed"
+)
+
+// ErrInvalidConflictPolicy is returned for an unknown overwrite_policy
+var ErrInvalidConflictPolicy = errors.New("overwrite_policy must be one of rename, always, never, if_newer, if_different")
+
+// ParseConflictPolicy parses an overwrite_policy value. Without one, the legacy overwrite
+// flag selects always or rename.
+func ParseConflictPolicy(value string, overwrite bool) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(value)); policy {
+	case "":
+		if overwrite {
+			return ConflictAlways, nil
+		}
+		return ConflictRename, nil
+	case ConflictRename, ConflictAlways, ConflictNever, ConflictIfNewer, ConflictIfDifferent:
+		return policy, nil
+	default:
+		return "", ErrInvalidConflictPolicy
+	}
+}
+
+// merges reports whether the policy decides file by file, merging folders into existing ones
+func (p ConflictPolicy) merges() bool {
+	return p == ConflictNever || p == ConflictIfNewer || p == ConflictIfDifferent
+}
+
+// placedItem records where a source ended up and how, or why it could not be placed
+type placedItem struct {
+	source string
+	target string
+	action string
+	counts mergeCounts
+	err    error
+}
+
+// failedItem records a source that could not be copied or moved. Its source is kept as
+// requested, as it may not even be a valid path.
+func failedItem(source string, err error) placedItem {
+	return placedItem{source: source, action: ActionFailed, err: err}
+}
+
+// mergeCounts counts the entries of a merge that were transferred or kept as they were
+type mergeCounts struct {
+	transferred int
+	skipped     int
+}
+
+// copyResults returns the results of placed items, in order, with the info of each target.
+// Failed items report an error code, fallback unless the error has a more specific one.
+func (s *FileManagerService) copyResults(items []placedItem, fallback string) []models.CopyResult {
+	var relPaths []string
+	for _, item := range items {
+		if item.err == nil {
+			relPath, _ := utils.GetRelativePath(s.basePath, item.target)
+			relPaths = append(relPaths, relPath)
+		}
+	}
+	infos := s.GetInfoBatch(relPaths)
+
+	results := make([]models.CopyResult, 0, len(items))
+	for _, item := range items {
+		if item.err != nil {
+			results = append(results, models.CopyResult{
+				Source: item.source,
+				Action: ActionFailed,
+				Code:   failureCode(item.err, fallback),
+				Error:  item.err.Error(),
+			})
+			continue
+		}
+
+		info := infos[0].Info
+		infos = infos[1:]
+		if info == nil {
+			continue
+		}
+		source, _ := utils.GetRelativePath(s.basePath, item.source)
+		results = append(results, models.CopyResult{
+			FileInfo:    info,
+			Source:      source,
+			Action:      item.action,
+			Transferred: item.counts.transferred,
+			Skipped:     item.counts.skipped,
+		})
+	}
+	return results
+}
+
+// failureCode returns the error code reported for a source that failed with err
+func failureCode(err error, fallback string) string {
+	switch {
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, ErrNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, utils.ErrInvalidPath), errors.Is(err, utils.ErrPathTraversal), errors.Is(err, utils.ErrOutsideBasePath):
+		return "INVALID_PATH"
+	case errors.Is(err, ErrOutsideCopyBase):
+		return "OUTSIDE_COPY_BASE"
+	case errors.Is(err, os.ErrPermission), errors.Is(err, ErrPermissionDenied):
+		return "PERMISSION_DENIED"
+	case errors.Is(err, ErrCopyVerification):
+		return "VERIFICATION_FAILED"
+	}
+	return fallback
+}
+
+// mergedItem records the outcome of merging src onto the existing dst. Only folders
+// report their counts; a file was either overwritten or skipped.
+func mergedItem(src, dst string, isDir bool, counts mergeCounts) placedItem {
+	item := placedItem{source: src, target: dst, action: ActionSkipped}
+	switch {
+	case isDir:
+		item.counts = counts
+		if counts.transferred > 0 {
+			item.action = ActionMerged
+		}
+	case counts.transferred > 0:
+		item.action = ActionOverwritten
+	}
+	return item
+}
+
+// mergeInto copies or moves src onto the existing dst under a merging policy. Folders are
+// merged entry by entry; an existing file is replaced only when the policy says so, and a
+// file meeting a folder of the same name (or the other way round) is left alone. Moved
+// folders are removed once everything in them was moved. Copied files are hardlinked where
+// possible when hardlink is set.
+func (s *FileManagerService) mergeInto(ctx context.Context, src, dst string, policy ConflictPolicy, move, hardlink bool, counts *mergeCounts) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	srcInfo, err := s.statFull(src)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := s.statFull(dst)
+	if err != nil {
+		if err := s.transferItem(ctx, src, dst, srcInfo, move, hardlink); err != nil {
+			return err
+		}
+		counts.transferred++
+		return nil
+	}
+
+	if srcInfo.IsDir() != dstInfo.IsDir() {
+		counts.skipped++
+		return nil
+	}
+
+	if srcInfo.IsDir() {
+		var names []string
+		if s.isRemote {
+			entries, err := s.sftpReadDir(src)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+		} else {
+			entries, err := os.ReadDir(src)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+		}
+
+		for _, name := range names {
+			if err := s.mergeInto(ctx, filepath.Join(src, name), filepath.Join(dst, name), policy, move, hardlink, counts); err != nil {
+				return err
+			}
+		}
+		if move {
+			// Fails, leaving the folder in place, when skipped entries remain in it
+			if s.isRemote {
+				s.sftpClient.RemoveDirectory(src)
+			} else {
+				os.Remove(src)
+			}
+		}
+		return nil
+	}
+
+	replace, err := s.shouldReplace(policy, src, dst, srcInfo, dstInfo)
+	if err != nil {
+		return err
+	}
+	if !replace {
+		counts.skipped++
+		return nil
+	}
+	if err := s.transferItem(ctx, src, dst, srcInfo, move, hardlink); err != nil {
+		return err
+	}
+	counts.transferred++
+	return nil
+}
+
+// shouldReplace reports whether the existing file dst is replaced by the file src under policy
+func (s *FileManagerService) shouldReplace(policy ConflictPolicy, src, dst string, srcInfo, dstInfo os.FileInfo) (bool, error) {
+	switch policy {
+	case ConflictNever:
+		return false, nil
+	case ConflictIfNewer:
+		return srcInfo.ModTime().After(dstInfo.ModTime()), nil
+	case ConflictIfDifferent:
+		if srcInfo.Size() != dstInfo.Size() {
+			return true, nil
+		}
+		srcHash, err := s.hashFull(src)
+		if err != nil {
+			return false, err
+		}
+		dstHash, err := s.hashFull(dst)
+		if err != nil {
+			return false, err
+		}
+		return srcHash != dstHash, nil
+	default:
+		return true, nil
+	}
+}
+
+// hashFull returns the SHA-256 of an already validated file path
+func (s *FileManagerService) hashFull(fullPath string) (string, error) {
+	if !s.isRemote {
+		file, _, _, err := openEncrypted(fullPath, s.owner)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		return utils.HashReader(file, "sha256")
+	}
+	output, err := s.runSSHCommandOutput(fmt.Sprintf("sha256sum %s", shellQuote(fullPath)))
+	if err != nil {
+		return "", fmt.Errorf("remote hash failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", errors.New("unexpected output from sha256sum")
+	}
+	return fields[0], nil
+}
+
+// transferItem copies or moves src to dst, replacing a file already at dst. A move that
+// cannot rename copies and removes the source once the copy is complete.
+func (s *FileManagerService) transferItem(ctx context.Context, src, dst string, srcInfo os.FileInfo, move, hardlink bool) error {
+	if move {
+		if s.isRemote {
+			if err := s.sftpClient.PosixRename(src, dst); err == nil {
+				return nil
+			}
+		} else if err := os.Rename(src, dst); err == nil {
+			return nil
+		}
+	}
+
+	if err := s.copyItem(ctx, src, dst, srcInfo, hardlink); err != nil || !move {
+		return err
+	}
+	return s.removeFull(src)
+}
+
+// copyItem copies the file or folder src to dst. Local copies are hardlinked where possible
+// when hardlink is set, and otherwise cloned on filesystems that support it.
+func (s *FileManagerService) copyItem(ctx context.Context, src, dst string, srcInfo os.FileInfo, hardlink bool) error {
+	switch {
+	case s.isRemote && srcInfo.IsDir():
+		return s.copyDirRemote(ctx, src, dst)
+	case s.isRemote:
+		return s.copyFileRemote(ctx, src, dst)
+	case hardlink && srcInfo.IsDir():
+		return utils.LinkDir(ctx, src, dst)
+	case hardlink:
+		return utils.LinkFile(ctx, src, dst)
+	case srcInfo.IsDir():
+		return utils.CopyDir(ctx, src, dst, true)
+	default:
+		return utils.CopyFile(ctx, src, dst, true)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"errors"
+	"filemanager-api/internal/models"
+	"path/filepath"
+	"sort"
+)
+
+const maxDiffEntries = 100000
+
+var ErrTooManyEntries = errors.New("too many entries to compare")
+
+// Diff compares pathA served by a with pathB served by b. Either side may be local or remote.
+// Files are identical when their sizes match and, with useHash, their sha256 digests match.
+// Directories are compared recursively by relative path; entries with the same size are
+// considered differing when their hashes (useHash) or modification times differ.
+func Diff(a *FileManagerService, pathA string, b *FileManagerService, pathB string, useHash bool) (*models.DiffResult, error) {
+	infoA, err := a.GetInfo(pathA)
+	if err != nil {
+		return nil, err
+	}
+	infoB, err := b.GetInfo(pathB)
+	if err != nil {
+		return nil, err
+	}
+
+	if infoA.IsDir != infoB.IsDir {
+		return &models.DiffResult{Type: "mismatch", Identical: false}, nil
+	}
+
+	if !infoA.IsDir {
+		same, err := sameFile(a, infoA, b, infoB, useHash)
+		if err != nil {
+			return nil, err
+		}
+		return &models.DiffResult{Type: "file", Identical: same}, nil
+	}
+
+	treeA, err := a.walkTree(pathA)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := b.walkTree(pathB)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.DiffResult{
+		Type:      "directory",
+		OnlyInA:   []string{},
+		OnlyInB:   []string{},
+		Differing: []string{},
+	}
+
+	for rel, entryA := range treeA {
+		entryB, ok := treeB[rel]
+		if !ok {
+			result.OnlyInA = append(result.OnlyInA, rel)
+			continue
+		}
+		if entryA.IsDir != entryB.IsDir {
+			result.Differing = append(result.Differing, rel)
+			continue
+		}
+		if entryA.IsDir {
+			continue
+		}
+		same, err := sameFile(a, &entryA, b, &entryB, useHash)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			result.Differing = append(result.Differing, rel)
+		}
+	}
+	for rel := range treeB {
+		if _, ok := treeA[rel]; !ok {
+			result.OnlyInB = append(result.OnlyInB, rel)
+		}
+	}
+
+	sort.Strings(result.OnlyInA)
+	sort.Strings(result.OnlyInB)
+	sort.Strings(result.Differing)
+	result.Identical = len(result.OnlyInA) == 0 && len(result.OnlyInB) == 0 && len(result.Differing) == 0
+
+	return result, nil
+}
+
+// sameFile compares two files by size first, then by hash or modification time
+func sameFile(a *FileManagerService, infoA *models.FileInfo, b *FileManagerService, infoB *models.FileInfo, useHash bool) (bool, error) {
+	sizeA, err := a.contentSize(infoA)
+	if err != nil {
+		return false, err
+	}
+	sizeB, err := b.contentSize(infoB)
+	if err != nil {
+		return false, err
+	}
+	if sizeA != sizeB {
+		return false, nil
+	}
+	if !useHash {
+		return infoA.ModTime.Equal(infoB.ModTime), nil
+	}
+
+	hashA, err := a.Hash(infoA.Path, "sha256")
+	if err != nil {
+		return false, err
+	}
+	hashB, err := b.Hash(infoB.Path, "sha256")
+	if err != nil {
+		return false, err
+	}
+	return hashA == hashB, nil
+}
+
+// contentSize returns the size of a file's content, which is smaller than the stored size of an encrypted file
+func (s *FileManagerService) contentSize(info *models.FileInfo) (int64, error) {
+	encrypted, size, err := s.Encrypted(info.Path)
+	if err != nil {
+		return 0, err
+	}
+	if encrypted {
+		return size, nil
+	}
+	return info.Size, nil
+}
+
+// walkTree lists a directory recursively, keyed by path relative to it
+func (s *FileManagerService) walkTree(relativePath string) (map[string]models.FileInfo, error) {
+	tree := make(map[string]models.FileInfo)
+
+	var walk func(dir, prefix string) error
+	walk = func(dir, prefix string) error {
+		items, err := s.List(dir, ListFilter{})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			rel := filepath.Join(prefix, item.Name)
+			tree[rel] = item
+			if len(tree) > maxDiffEntries {
+				return ErrTooManyEntries
+			}
+			if item.IsDir {
+				if err := walk(item.Path, rel); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(relativePath, ""); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted files start with a header of encryptionMagic and a random nonce prefix,
+// followed by the content sealed with AES-256-GCM in chunks of encryptionChunkSize bytes.
+// The nonce of each chunk is the prefix, the chunk counter and a flag marking the last
+// chunk, so chunks cannot be reordered, dropped or truncated without failing to open.
+const (
+	encryptionMagic       = "FMENC\x00\x01\x00"
+	encryptionPrefixSize  = 7
+	encryptionHeaderSize  = len(encryptionMagic) + encryptionPrefixSize
+	encryptionChunkSize   = 64 * 1024
+	encryptionTagSize     = 16
+	encryptionSealedChunk = encryptionChunkSize + encryptionTagSize
+)
+
+// ErrDecryptionFailed is returned when an encrypted file was modified or the master key changed
+var ErrDecryptionFailed = errors.New("encrypted file is corrupt or was encrypted with another key")
+
+// encryptionKey is the master key usersite keys are derived from; nil leaves uploads unencrypted
+var encryptionKey []byte
+
+// ConfigureEncryption enables encryption at rest of uploaded files with a 32-byte master
+// key given as 64 hex characters or base64. An empty key disables it.
+func ConfigureEncryption(masterKey string) error {
+	if masterKey == "" {
+		encryptionKey = nil
+		return nil
+	}
+
+	key, err := hex.DecodeString(masterKey)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(masterKey)
+	}
+	if err != nil || len(key) != 32 {
+		return errors.New("master key must be 32 bytes, as 64 hex characters or base64")
+	}
+	encryptionKey = key
+	return nil
+}
+
+// EncryptionEnabled reports whether uploads are encrypted at rest
+func EncryptionEnabled() bool {
+	return encryptionKey != nil
+}
+
+// usersiteCipher returns the AEAD keyed for usersite, so one usersite's key never opens another's files
+func usersiteCipher(usersite string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, encryptionKey, nil, []byte("filemanager-api usersite "+usersite))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of chunk counter
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// EncryptedSize returns the size on disk of size bytes of content once encrypted
+func EncryptedSize(size int64) int64 {
+	chunks := size / encryptionChunkSize
+	if size%encryptionChunkSize != 0 || size == 0 {
+		chunks++
+	}
+	return int64(encryptionHeaderSize) + size + chunks*encryptionTagSize
+}
+
+// decryptedSize returns the content size of an encrypted file of size bytes on disk
+func decryptedSize(size int64) int64 {
+	body := size - int64(encryptionHeaderSize)
+	full, rest := body/encryptionSealedChunk, body%encryptionSealedChunk
+	if rest == 0 {
+		return full * encryptionChunkSize
+	}
+	return full*encryptionChunkSize + rest - encryptionTagSize
+}
+
+// encryptWriter seals everything written to it in chunks; Close seals the last chunk
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+// newEncryptWriter writes the header to w and returns a writer encrypting for usersite
+func newEncryptWriter(w io.Writer, usersite string) (io.WriteCloser, error) {
+	aead, err := usersiteCipher(usersite)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, encryptionPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(encryptionMagic), prefix...)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, encryptionChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, as the last chunk is marked
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk; it does not close the underlying writer
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("file too large to encrypt")
+	}
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader opens the chunks of an encrypted file after its header
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one when nothing follows it
+		if _, peekErr := d.r.Peek(1); peekErr == io.EOF {
+			d.done = true
+		}
+	}
+	if n < encryptionTagSize {
+		return fmt.Errorf("%w: truncated", ErrDecryptionFailed)
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.prefix, d.counter, d.done), d.chunk[:n], nil)
+	if err != nil {
+		return ErrDecryptionFailed
+	}
+	d.counter++
+	d.plain = plain
+	return nil
+}
+
+// Encrypted reports whether a local file is stored encrypted, and the size of its content
+func (s *FileManagerService) Encrypted(relativePath string) (bool, int64, error) {
+	if s.isRemote || !EncryptionEnabled() {
+		return false, 0, nil
+	}
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return false, 0, err
+	}
+	file, size, encrypted, err := openEncrypted(fullPath, s.owner)
+	if err != nil {
+		return false, 0, err
+	}
+	file.Close()
+	return encrypted, size, nil
+}
+
+// openEncrypted opens a local file, returning a reader of its content, the content size and
+// whether it is encrypted.
+// Files without the encryption header, or all files while encryption is disabled, are returned as stored.
+func openEncrypted(fullPath, usersite string) (io.ReadCloser, int64, bool, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, false, err
+	}
+	if !EncryptionEnabled() {
+		return file, info.Size(), false, nil
+	}
+
+	header := make([]byte, encryptionHeaderSize)
+	if n, _ := io.ReadFull(file, header); n < encryptionHeaderSize || !bytes.HasPrefix(header, []byte(encryptionMagic)) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, 0, false, err
+		}
+		return file, info.Size(), false, nil
+	}
+
+	aead, err := usersiteCipher(usersite)
+	if err != nil {
+		file.Close()
+		return nil, 0, false, err
+	}
+	reader := &decryptReader{
+		r:      bufio.NewReaderSize(file, encryptionSealedChunk),
+		aead:   aead,
+		prefix: header[len(encryptionMagic):],
+		chunk:  make([]byte, encryptionSealedChunk),
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, file}, decryptedSize(info.Size()), true, nil
+}
+
+// openContent opens an already validated file for reading its content: local files stored
+// encrypted are decrypted. It returns the content size and whether the file is encrypted.
+func (s *FileManagerService) openContent(fullPath string) (io.ReadCloser, int64, bool, error) {
+	if !s.isRemote {
+		return openEncrypted(fullPath, s.owner)
+	}
+	file, err := s.sftpOpen(fullPath)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, false, err
+	}
+	return file, info.Size(), false, nil
+}
+
+// createContent creates a file at an already validated path, encrypting what is written
+// with the usersite key when encrypt is set. Closing the writer closes the file.
+func (s *FileManagerService) createContent(fullPath string, encrypt bool) (io.WriteCloser, error) {
+	file, err := s.createFull(fullPath)
+	if err != nil || !encrypt {
+		return file, err
+	}
+	enc, err := newEncryptWriter(file, s.owner)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &sealingWriter{WriteCloser: enc, file: file}, nil
+}
+
+// sealingWriter seals the last chunk of an encrypted file before closing the file
+type sealingWriter struct {
+	io.WriteCloser
+	file io.Closer
+}
+
+func (w *sealingWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeEncryptedAt writes data into the encrypted local file at fullPath at offset of its
+// content. Sealed chunks cannot be patched in place, so the content is re-encrypted into a
+// temp file renamed into place.
+func (s *FileManagerService) writeEncryptedAt(fullPath string, offset int64, data []byte) error {
+	// Renaming over a symlink would replace the link itself
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return ErrNotAFile
+	}
+
+	reader, _, _, err := openEncrypted(fullPath, s.owner)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	staging := stagingPath(fullPath, uuid.New().String())
+	out, err := s.createContent(staging, true)
+	if err != nil {
+		return err
+	}
+	// The content before offset, data, then what follows the bytes data overwrites
+	buf := utils.NewBuffer()
+	_, err = utils.CopyBuffer(out, io.LimitReader(reader, offset), buf)
+	if err == nil {
+		_, err = out.Write(data)
+	}
+	if err == nil {
+		_, err = io.CopyN(io.Discard, reader, int64(len(data)))
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	if err == nil {
+		_, err = utils.CopyBuffer(out, reader, buf)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(staging, info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(staging, fullPath)
+	}
+	if err != nil {
+		os.Remove(staging)
+		return err
+	}
+	return nil
+}
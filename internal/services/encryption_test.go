@@ -0,0 +1,211 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// enableEncryption turns encryption at rest on for the test
+func enableEncryption(t *testing.T) {
+	t.Helper()
+	if err := ConfigureEncryption(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ConfigureEncryption("") })
+}
+
+// writeEncrypted stores content encrypted for svc's usersite at name below base
+func writeEncrypted(t *testing.T, svc *FileManagerService, base, name, content string) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(base, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	enc, err := newEncryptWriter(file, svc.owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(enc, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readEncrypted returns the content of name below base, failing when it is not stored encrypted
+func readEncrypted(t *testing.T, svc *FileManagerService, base, name string) string {
+	t.Helper()
+	stored, err := os.ReadFile(filepath.Join(base, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(stored, []byte(encryptionMagic)) {
+		t.Fatalf("%s is stored as plaintext %q", name, stored)
+	}
+	reader, _, err := svc.GetContent(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return string(content)
+}
+
+func TestEditsKeepEncryptedFilesEncrypted(t *testing.T) {
+	enableEncryption(t)
+	const original = "hello world\nline two\nline three\n"
+
+	tests := []struct {
+		name string
+		edit func(svc *FileManagerService) error
+		want string
+	}{
+		{"WriteAt", func(svc *FileManagerService) error {
+			_, err := svc.WriteAt("a.txt", 6, []byte("there"))
+			return err
+		}, "hello there\nline two\nline three\n"},
+		{"WriteAtPastEnd", func(svc *FileManagerService) error {
+			_, err := svc.WriteAt("a.txt", int64(len(original)), []byte("line four\n"))
+			return err
+		}, original + "line four\n"},
+		{"WriteLines", func(svc *FileManagerService) error {
+			_, err := svc.WriteLines("a.txt", 2, 2, "second line")
+			return err
+		}, "hello world\nsecond line\nline three\n"},
+		{"Replace", func(svc *FileManagerService) error {
+			r, err := NewReplacer("line", "row", false)
+			if err != nil {
+				return err
+			}
+			if res := svc.Replace([]string{"a.txt"}, r, false); res[0].Error != "" || res[0].Matches != 2 {
+				return fmt.Errorf("%d matches, error %q", res[0].Matches, res[0].Error)
+			}
+			return nil
+		}, "hello world\nrow two\nrow three\n"},
+		{"UpdateFile", func(svc *FileManagerService) error {
+			_, err := svc.UpdateFile("a.txt", "replaced")
+			return err
+		}, "replaced"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, base := newTestService(t)
+			writeEncrypted(t, svc, base, "a.txt", original)
+
+			if err := tt.edit(svc); err != nil {
+				t.Fatal(err)
+			}
+			if got := readEncrypted(t, svc, base, "a.txt"); got != tt.want {
+				t.Fatalf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteAtChecksEncryptedContentSize(t *testing.T) {
+	enableEncryption(t)
+	svc, base := newTestService(t)
+	writeEncrypted(t, svc, base, "a.txt", "hello")
+
+	// The stored file is larger than its content, the offset must be within the content
+	if _, err := svc.WriteAt("a.txt", 6, []byte("x")); !errors.Is(err, ErrInvalidOffset) {
+		t.Fatalf("err = %v, want ErrInvalidOffset for an offset past the content", err)
+	}
+	if got := readEncrypted(t, svc, base, "a.txt"); got != "hello" {
+		t.Fatalf("content = %q after a refused write, want hello", got)
+	}
+}
+
+func TestReadsDecryptEncryptedFiles(t *testing.T) {
+	enableEncryption(t)
+	svc, base := newTestService(t)
+	const content = "hello world\nline two\nline three\n"
+	writeEncrypted(t, svc, base, "a.txt", content)
+	if err := os.WriteFile(filepath.Join(base, "b.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := svc.ReadLines("a.txt", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines.Content != "line two\nline three\n" || lines.TotalLines != 3 {
+		t.Fatalf("lines = %q of %d, want lines two and three of 3", lines.Content, lines.TotalLines)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	if hash, err := svc.Hash("a.txt", "sha256"); err != nil || hash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("hash = %s, %v, want the hash of the content", hash, err)
+	}
+
+	// An encrypted file and a plaintext copy of its content are the same
+	diff, err := Diff(svc, "a.txt", svc, "b.txt", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Identical {
+		t.Fatal("an encrypted file differs from a plaintext copy of its content")
+	}
+}
+
+func TestCompressArchivesEncryptedContent(t *testing.T) {
+	enableEncryption(t)
+	for _, format := range []string{"zip", "tar"} {
+		t.Run(format, func(t *testing.T) {
+			svc, _, base := newTestCompressService(t)
+			fm := NewFileManagerService(base, "")
+			writeEncrypted(t, fm, base, "a.txt", "secret content")
+
+			var archive bytes.Buffer
+			if err := svc.CompressStream(context.Background(), &archive, []string{filepath.Join(base, "a.txt")}, format, 0, utils.SymlinksSkip, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			var content []byte
+			if format == "zip" {
+				zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				f, err := zr.File[0].Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err = io.ReadAll(f)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				tr := tar.NewReader(&archive)
+				if _, err := tr.Next(); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if content, err = io.ReadAll(tr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(content) != "secret content" {
+				t.Fatalf("archived content = %q, want the decrypted content", content)
+			}
+		})
+	}
+}
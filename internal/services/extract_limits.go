@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// bombRatioMinSize is the smallest entry the compression ratio limit applies to; small
+// files of zeros or repeated text legitimately compress far better than real data
+const bombRatioMinSize = 1 << 20
+
+var (
+	// ErrArchiveTooLarge is returned for an archive whose entries exceed the size or count limits
+	ErrArchiveTooLarge = errors.New("archive exceeds the extraction limits")
+	// ErrZipBomb is returned for an archive that compresses suspiciously well or holds more than it declares
+	ErrZipBomb = errors.New("archive looks like a decompression bomb")
+)
+
+// ExtractLimits bound what extracting a single archive may write. Zero disables a limit.
+type ExtractLimits struct {
+	MaxTotalSize int64 // uncompressed bytes of all entries
+	MaxEntries   int   // files and folders
+	MaxEntrySize int64 // uncompressed bytes of one entry
+	MaxRatio     int64 // uncompressed to compressed size, per ZIP entry and overall for tar
+}
+
+// extractLimits applies to every extraction
+var extractLimits ExtractLimits
+
+// ConfigureExtractLimits sets the limits checked while extracting archives
+func ConfigureExtractLimits(limits ExtractLimits) {
+	extractLimits = limits
+}
+
+// archiveBudget counts the entries of one archive against extractLimits
+type archiveBudget struct {
+	limits  ExtractLimits
+	entries int
+	total   int64
+}
+
+// admit counts an entry of name declaring size uncompressed bytes. compressed is its
+// compressed size, or 0 where the format does not record one.
+func (b *archiveBudget) admit(name string, size, compressed int64) error {
+	b.entries++
+	b.total += size
+
+	switch l := b.limits; {
+	case l.MaxEntries > 0 && b.entries > l.MaxEntries:
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveTooLarge, l.MaxEntries)
+	case l.MaxEntrySize > 0 && size > l.MaxEntrySize:
+		return fmt.Errorf("%w: %s is %d bytes, the limit per entry is %d", ErrArchiveTooLarge, name, size, l.MaxEntrySize)
+	case l.MaxTotalSize > 0 && b.total > l.MaxTotalSize:
+		return fmt.Errorf("%w: more than %d bytes uncompressed", ErrArchiveTooLarge, l.MaxTotalSize)
+	case exceedsRatio(l.MaxRatio, size, compressed):
+		return fmt.Errorf("%w: %s expands %d times", ErrZipBomb, name, size/compressed)
+	}
+	return nil
+}
+
+// exceedsRatio reports whether size bytes extracted from compressed bytes exceed ratio.
+// A compressed size of 0 means it is not known.
+func exceedsRatio(ratio, size, compressed int64) bool {
+	return ratio > 0 && compressed > 0 && size >= bombRatioMinSize && size/compressed >= ratio
+}
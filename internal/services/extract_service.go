@@ -1,25 +1,41 @@
 package services
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/utils"
+	"filemanager-api/pkg/progresswriter"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"sync/atomic"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-// ExtractService handles ZIP extraction operations
+// ExtractService handles archive extraction operations
 type ExtractService struct {
 	basePath      string
 	progressStore *models.ProgressStore
 	owner         string
 	uid           int
 	gid           int
+
+	// remote, when set, is the SSH host archives are extracted on; detached is set once a
+	// queued job took over its connection
+	remote   *FileManagerService
+	detached bool
+	// preserveOwner leaves files with the ownership the filesystem gives them instead of
+	// handing them to owner
+	preserveOwner bool
 }
 
 // NewExtractService creates a new extract service
@@ -38,15 +54,107 @@ func NewExtractService(basePath string, owner string, progressStore *models.Prog
 			svc.uid = uid
 			svc.gid = gid
 		} else {
-			fmt.Printf("[ERROR] Failed to resolve user %s: %v\n", owner, err)
+			utils.Errorf("Failed to resolve user %s: %v", owner, err)
 		}
 	}
 
 	return svc
 }
 
-// Extract extracts a ZIP archive to the destination
-func (s *ExtractService) Extract(source, destination string) (string, error) {
+// supportedArchiveExtensions lists the archive formats Extract understands.
+// Longer extensions come first so ".tar.gz" is not taken for ".gz" or ".tar".
+var supportedArchiveExtensions = []string{".tar.gz", ".tar.zst", ".tar.br", ".tgz", ".tar", ".zip"}
+
+// ExtractFormats returns the archive extensions Extract understands, without the leading dot
+func ExtractFormats() []string {
+	formats := make([]string, len(supportedArchiveExtensions))
+	for i, ext := range supportedArchiveExtensions {
+		formats[i] = strings.TrimPrefix(ext, ".")
+	}
+	return formats
+}
+
+// IsSupportedArchive reports whether the filename has an extension Extract can handle
+func IsSupportedArchive(filename string) bool {
+	return archiveExtension(filename) != ""
+}
+
+// ArchiveBaseName returns the filename stripped of its archive extension
+func ArchiveBaseName(filename string) string {
+	base := filepath.Base(filename)
+	return strings.TrimSuffix(base, base[len(base)-len(archiveExtension(base)):])
+}
+
+func archiveExtension(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, ext := range supportedArchiveExtensions {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// ErrNotAnArchive is returned for content that does not start like the archive its name claims
+var ErrNotAnArchive = errors.New("content does not match the archive type")
+
+// archiveSignature is a byte sequence an archive format starts with, at offset
+type archiveSignature struct {
+	offset int
+	magic  string
+}
+
+// archiveSignatures lists the signatures each archive extension may start with. Brotli
+// streams have none, so .tar.br content is not checked.
+var archiveSignatures = map[string][]archiveSignature{
+	".zip":     {{0, "PK\x03\x04"}, {0, "PK\x05\x06"}, {0, "PK\x07\x08"}},
+	".tar":     {{257, "ustar"}},
+	".tar.gz":  {{0, "\x1f\x8b"}},
+	".tgz":     {{0, "\x1f\x8b"}},
+	".tar.zst": {{0, "\x28\xb5\x2f\xfd"}},
+}
+
+// CheckArchiveContent checks that the content read from reader starts like the archive
+// filename's extension claims, so other files are refused before anything is stored.
+// The returned reader yields the full content, including the bytes inspected.
+func CheckArchiveContent(filename string, reader io.Reader) (io.Reader, error) {
+	ext := archiveExtension(filename)
+	if ext == "" {
+		return nil, fmt.Errorf("%w: %s is not a supported archive", ErrNotAnArchive, filename)
+	}
+	signatures, ok := archiveSignatures[ext]
+	if !ok {
+		return reader, nil
+	}
+
+	buffered := bufio.NewReaderSize(reader, utils.SniffLength)
+	header, err := buffered.Peek(utils.SniffLength)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	for _, sig := range signatures {
+		if len(header) >= sig.offset+len(sig.magic) && bytes.Equal(header[sig.offset:sig.offset+len(sig.magic)], []byte(sig.magic)) {
+			return buffered, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s does not contain %s data", ErrNotAnArchive, filename, strings.TrimPrefix(ext, "."))
+}
+
+// ExtractOptions controls where and how entries are extracted
+type ExtractOptions struct {
+	// IntoSubfolder extracts an archive with several top-level entries into a new folder named after it
+	IntoSubfolder bool
+	// PreserveMode applies the permissions recorded in the archive; otherwise the default modes are used
+	PreserveMode bool
+}
+
+// Extract validates an archive and extracts it to the destination in the background,
+// returning "extractID:relativePath" once the job is queued.
+func (s *ExtractService) Extract(source, destination string, opts ExtractOptions) (string, error) {
+	if s.remote != nil {
+		return s.extractRemote(source, destination, opts)
+	}
+
 	sourcePath, err := utils.ValidatePath(s.basePath, source)
 	if err != nil {
 		return "", err
@@ -56,89 +164,330 @@ func (s *ExtractService) Extract(source, destination string) (string, error) {
 		return "", ErrNotFound
 	}
 
-	destPath, err := utils.ValidatePath(s.basePath, destination)
+	return s.extractArchive(sourcePath, filepath.Base(sourcePath), destination, opts, func() {})
+}
+
+// ExtractStream stages an archive read from reader in a temp file, extracts it to the
+// destination like Extract and removes the staged archive afterwards
+func (s *ExtractService) ExtractStream(reader io.Reader, filename, destination string, opts ExtractOptions) (string, error) {
+	if !IsSupportedArchive(filename) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, filename)
+	}
+
+	if _, err := utils.ValidatePath(s.basePath, destination); err != nil {
+		return "", err
+	}
+
+	stagingDir := tempPath("filemanager-archives")
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(stagingDir, "stream-*"+archiveExtension(filename))
 	if err != nil {
 		return "", err
 	}
+	// The staged archive outlives this call and is removed once the background extraction is done
+	removeStaged := func() { os.Remove(tmp.Name()) }
 
-	// Open ZIP file
-	zipReader, err := zip.OpenReader(sourcePath)
+	buf := utils.NewBuffer()
+	_, err = utils.CopyBuffer(tmp, reader, buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		removeStaged()
 		return "", err
 	}
-	defer zipReader.Close()
 
-	// Calculate total size for progress
-	var totalSize int64
-	for _, f := range zipReader.File {
-		totalSize += int64(f.UncompressedSize64)
+	return s.extractArchive(tmp.Name(), filepath.Base(filename), destination, opts, removeStaged)
+}
+
+// ExtractedSize reports how many bytes extracting source would write and whether they fit
+// in the free space of destination, which defaults to the folder holding the archive
+func (s *ExtractService) ExtractedSize(source, destination string) (*models.ArchiveSize, error) {
+	if s.remote != nil {
+		return s.extractedSizeRemote(source, destination)
+	}
+
+	sourcePath, err := utils.ValidatePath(s.basePath, source)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.IsFile(sourcePath) {
+		return nil, ErrNotFound
+	}
+
+	destPath := filepath.Dir(sourcePath)
+	if destination != "" {
+		if destPath, err = utils.ValidatePath(s.basePath, destination); err != nil {
+			return nil, err
+		}
+	}
+
+	archive, err := openArchive(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+	defer archive.close()
+
+	size, entries, err := archive.extractedSize()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+
+	// The destination may not exist yet; its nearest existing parent is on the same filesystem
+	for !utils.PathExists(destPath) && destPath != s.basePath {
+		destPath = filepath.Dir(destPath)
+	}
+	_, free, _, err := utils.GetFilesystemStats(destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, sourcePath)
+	return &models.ArchiveSize{
+		Source:    relPath,
+		Entries:   entries,
+		SizeBytes: size,
+		SizeHuman: utils.FormatFileSize(size),
+		FreeBytes: free,
+		Fits:      size <= free,
+	}, nil
+}
+
+// extractArchive validates the archive at sourcePath (already validated) and extracts it
+// into destination in the background, reporting progress under displayName.
+// cleanup runs once the archive is no longer needed, whether or not extraction started.
+func (s *ExtractService) extractArchive(sourcePath, displayName, destination string, opts ExtractOptions, cleanup func()) (string, error) {
+	destPath, err := utils.ValidatePath(s.basePath, destination)
+	if err != nil {
+		cleanup()
+		return "", err
+	}
+
+	// A single top-level folder or file already keeps the destination tidy; nesting it
+	// again would give foo/foo. Entries are then confined to the subfolder.
+	if opts.IntoSubfolder {
+		single, err := hasSingleRoot(sourcePath)
+		if err != nil {
+			cleanup()
+			return "", err
+		}
+		if !single {
+			destPath = filepath.Join(destPath, ArchiveBaseName(displayName))
+			if utils.PathExists(destPath) {
+				destPath = utils.GenerateUniqueName(destPath)
+			}
+		}
 	}
 
+	// Open the archive now so a corrupt one, or one over the limits, is reported to the caller
+	archive, err := openArchive(sourcePath)
+	if err != nil {
+		cleanup()
+		return "", err
+	}
+	if err := archive.checkLimits(); err != nil {
+		archive.close()
+		cleanup()
+		return "", err
+	}
+	totalSize := archive.size()
+
 	// Generate extract ID for progress tracking
 	extractID := uuid.New().String()
 
 	// Initialize progress
 	s.progressStore.Set(extractID, &models.Progress{
 		ID:            extractID,
-		Filename:      filepath.Base(sourcePath),
+		Operation:     models.OperationExtract,
+		Filename:      displayName,
 		Progress:      0,
 		UploadedBytes: 0,
 		TotalBytes:    totalSize,
-		Status:        models.StatusProcessing,
+		Status:        models.StatusPending,
 	})
 
-	// Ensure destination directory exists
-	if err := os.MkdirAll(destPath, 0755); err != nil {
-		s.updateProgressError(extractID, err.Error())
+	// The job outlives the request; it is stopped through CancelOperation
+	ctx, release := TrackOperation(context.Background(), extractID, s.owner)
+	job := func() {
+		defer release()
+		defer cleanup()
+		defer archive.close()
+
+		s.progressStore.SetStatus(extractID, models.StatusProcessing)
+		if err := s.extractAll(ctx, archive, destPath, totalSize, extractID, opts.PreserveMode); err != nil {
+			s.updateProgressError(extractID, err)
+			return
+		}
+		s.updateProgressCompleted(extractID)
+	}
+	if err := operationPool.Submit(job); err != nil {
+		release()
+		archive.close()
+		cleanup()
+		s.updateProgressError(extractID, err)
 		return extractID, err
 	}
 
-	var extractedBytes int64
+	relPath, _ := utils.GetRelativePath(s.basePath, destPath)
+	return extractID + ":" + relPath, nil
+}
 
-	// Extract files
-	for _, f := range zipReader.File {
-		err := s.extractFile(f, destPath, &extractedBytes, totalSize, extractID)
-		if err != nil {
-			s.updateProgressError(extractID, err.Error())
-			return extractID, err
-		}
+// extractAll extracts every entry of archive into destPath, reporting progress under extractID.
+// Entries already extracted when ctx ends are kept; the one being written is removed.
+func (s *ExtractService) extractAll(ctx context.Context, archive openedArchive, destPath string, totalSize int64, extractID string, preserveMode bool) error {
+	tracker := &extractTracker{ctx: ctx, store: s.progressStore, id: extractID, total: totalSize, started: time.Now(), source: archive.source(), preserveMode: preserveMode}
+	tracker.budget.limits = extractLimits
+
+	// Recorded directory modes are applied last so a read-only directory can still be filled
+	defer tracker.applyDirModes()
+
+	// Everything extraction creates is chowned in one batch at the end;
+	// pre-existing directories keep their owner
+	var created []string
+	defer func() { s.setOwnerBatch(created) }()
+
+	// Ensure destination directory exists
+	if err := mkdirAllTracked(destPath, utils.DirMode(), &created); err != nil {
+		return err
 	}
 
-	s.updateProgressCompleted(extractID)
+	return archive.extractTo(s, destPath, tracker, &created)
+}
 
-	relPath, _ := utils.GetRelativePath(s.basePath, destPath)
-	return extractID + ":" + relPath, nil
+// SetPreserveOwner makes the service leave extracted files with the ownership the filesystem
+// gives them, instead of handing them to the usersite owner
+func (s *ExtractService) SetPreserveOwner(preserve bool) {
+	s.preserveOwner = preserve
+	if s.remote != nil {
+		s.remote.SetPreserveOwner(preserve)
+	}
+}
+
+// setOwnerBatch sets the owner of all given paths with as few chown calls as possible
+func (s *ExtractService) setOwnerBatch(paths []string) {
+	if s.preserveOwner {
+		return
+	}
+	if err := utils.SudoChownPaths(paths, s.owner, false); err != nil {
+		utils.Errorf("Failed to set owner for %d extracted paths: %v", len(paths), err)
+	}
 }
 
-// setOwner sets the file owner to the service configured user
-func (s *ExtractService) setOwner(path string) error {
-	if s.owner == "" {
-		return nil
+// mkdirAllTracked is os.MkdirAll that appends the directories it created to created, parents first
+func mkdirAllTracked(dir string, mode os.FileMode, created *[]string) error {
+	var missing []string
+	for p := dir; p != filepath.Dir(p) && !utils.PathExists(p); p = filepath.Dir(p) {
+		missing = append(missing, p)
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
 	}
-	return utils.SudoChown(path, s.owner)
+	for i := len(missing) - 1; i >= 0; i-- {
+		*created = append(*created, missing[i])
+	}
+	return nil
 }
 
-func (s *ExtractService) extractFile(f *zip.File, destPath string, extractedBytes *int64, totalSize int64, progressID string) error {
-	// Construct destination path
-	filePath := filepath.Join(destPath, f.Name)
+// extractTracker reports overall and per-entry extraction progress
+type extractTracker struct {
+	ctx     context.Context
+	store   *models.ProgressStore
+	id      string
+	total   int64
+	done    int64
+	started time.Time
+	// source, when set, counts the archive bytes read; progress then follows it
+	// because the uncompressed size of a compressed tar is not known up front
+	source *progresswriter.ProgressReader
 
-	// Security check: prevent path traversal
-	if !filepath.HasPrefix(filePath, filepath.Clean(destPath)+string(os.PathSeparator)) {
-		return utils.ErrPathTraversal
+	// With preserveMode, entries keep the permissions recorded in the archive
+	preserveMode bool
+	dirModes     []dirMode
+
+	// budget counts tar entries against the extraction limits as they are read
+	budget archiveBudget
+}
+
+// dirMode is the permissions recorded for a directory entry
+type dirMode struct {
+	path string
+	mode os.FileMode
+}
+
+// fileMode returns the permissions to give a file entry recorded with mode.
+// Only permission bits are kept, so setuid and similar bits are never extracted;
+// archives recording none get the default mode.
+func (t *extractTracker) fileMode(mode os.FileMode) os.FileMode {
+	if t.preserveMode && mode.Perm() != 0 {
+		return mode.Perm()
 	}
+	return utils.FileMode()
+}
 
-	if f.FileInfo().IsDir() {
-		if err := os.MkdirAll(filePath, f.Mode()); err != nil {
-			return err
+// mkdirEntry creates the directory of a directory entry recorded with mode
+func (t *extractTracker) mkdirEntry(dir string, mode os.FileMode, created *[]string) error {
+	if err := mkdirAllTracked(dir, utils.DirMode(), created); err != nil {
+		return err
+	}
+	if t.preserveMode && mode.Perm() != 0 {
+		t.dirModes = append(t.dirModes, dirMode{path: dir, mode: mode.Perm()})
+	}
+	return nil
+}
+
+// applyDirModes sets the permissions recorded for directory entries, deepest first
+func (t *extractTracker) applyDirModes() {
+	for i := len(t.dirModes) - 1; i >= 0; i-- {
+		if err := os.Chmod(t.dirModes[i].path, t.dirModes[i].mode); err != nil {
+			utils.Errorf("Failed to set mode for %s: %v", t.dirModes[i].path, err)
 		}
-		return s.setOwner(filePath)
 	}
+}
 
-	// Create parent directories
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+// sourceRead returns the archive bytes read so far, or 0 when they are not counted
+func (t *extractTracker) sourceRead() int64 {
+	if t.source == nil {
+		return 0
+	}
+	return t.source.ReadBytes()
+}
+
+// advance records n more bytes of entry name, which has entryDone of entryTotal bytes written
+func (t *extractTracker) advance(name string, n, entryDone, entryTotal int64) {
+	t.done += n
+	var speed int64
+	if elapsed := time.Since(t.started).Seconds(); elapsed > 0 {
+		speed = int64(float64(t.done) / elapsed)
+	}
+	position := t.done
+	if t.source != nil {
+		position = t.source.ReadBytes()
+	}
+	t.store.Modify(t.id, func(p *models.Progress) {
+		if t.total > 0 {
+			p.Progress = int((position * 100) / t.total)
+		}
+		p.UploadedBytes = position
+		p.CurrentFile = name
+		p.CurrentBytes = entryDone
+		p.CurrentTotal = entryTotal
+		p.BytesPerSecond = speed
+	})
+}
+
+func (s *ExtractService) extractFile(f *zip.File, destPath string, tracker *extractTracker, created *[]string) error {
+	// Construct destination path
+	filePath, err := entryPath(destPath, f.Name)
+	if err != nil {
 		return err
 	}
-	// Note: We might want to set owner for parent directories too, but usually it's recursive from top level call or expected to exist.
+
+	if f.FileInfo().IsDir() {
+		return tracker.mkdirEntry(filePath, f.Mode(), created)
+	}
 
 	// Open source file from ZIP
 	srcFile, err := f.Open()
@@ -147,29 +496,72 @@ func (s *ExtractService) extractFile(f *zip.File, destPath string, extractedByte
 	}
 	defer srcFile.Close()
 
+	return writeEntry(srcFile, filePath, f.Name, tracker.fileMode(f.Mode()), int64(f.UncompressedSize64), tracker, created)
+}
+
+// entryPath joins an archive entry name onto destPath, rejecting names that escape it
+func entryPath(destPath, name string) (string, error) {
+	filePath := filepath.Join(destPath, name)
+
+	// Security check: prevent path traversal
+	if !filepath.HasPrefix(filePath, filepath.Clean(destPath)+string(os.PathSeparator)) {
+		return "", utils.ErrPathTraversal
+	}
+	return filePath, nil
+}
+
+// writeEntry copies the contents of an archive entry into filePath with progress tracking
+func writeEntry(src io.Reader, filePath, name string, mode os.FileMode, entryTotal int64, tracker *extractTracker, created *[]string) error {
+	// Create parent directories
+	if err := mkdirAllTracked(filepath.Dir(filePath), utils.DirMode(), created); err != nil {
+		return err
+	}
+
 	// Create destination file
-	dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
+	*created = append(*created, filePath)
 	// Defer close first
 	defer dstFile.Close()
 
+	// Recorded modes win over the umask and over the mode of a file being replaced
+	if tracker.preserveMode {
+		if err := dstFile.Chmod(mode); err != nil {
+			return err
+		}
+	}
+
+	// discard removes the partly written entry
+	discard := func(err error) error {
+		dstFile.Close()
+		os.Remove(filePath)
+		*created = (*created)[:len(*created)-1]
+		return err
+	}
+
 	// Copy with progress tracking
-	buf := make([]byte, utils.DefaultBufferSize)
+	var entryDone int64
+	tracker.advance(name, 0, 0, entryTotal)
+	buf := utils.NewBuffer()
 	for {
-		n, err := srcFile.Read(buf)
+		if err := tracker.ctx.Err(); err != nil {
+			return discard(err)
+		}
+		n, err := src.Read(buf)
 		if n > 0 {
+			entryDone += int64(n)
+			// The declared sizes are what the limits were checked against
+			if entryDone > entryTotal {
+				return discard(fmt.Errorf("%w: %s holds more than the %d bytes it declares", ErrZipBomb, name, entryTotal))
+			}
 			if _, werr := dstFile.Write(buf[:n]); werr != nil {
 				return werr
 			}
-			newVal := atomic.AddInt64(extractedBytes, int64(n))
-			if totalSize > 0 {
-				progress := int((newVal * 100) / totalSize)
-				if p, ok := s.progressStore.Get(progressID); ok {
-					p.Progress = progress
-					p.UploadedBytes = newVal
-				}
+			tracker.advance(name, int64(n), entryDone, entryTotal)
+			if read := tracker.sourceRead(); exceedsRatio(tracker.budget.limits.MaxRatio, tracker.done, read) {
+				return discard(fmt.Errorf("%w: the archive expands %d times", ErrZipBomb, tracker.done/read))
 			}
 		}
 		if err == io.EOF {
@@ -180,9 +572,240 @@ func (s *ExtractService) extractFile(f *zip.File, destPath string, extractedByte
 		}
 	}
 
-	// Set owner
-	s.setOwner(filePath)
+	return nil
+}
+
+// openedArchive is an archive ready to be extracted
+type openedArchive interface {
+	// size is the total used for progress: the uncompressed size of a zip,
+	// or the file size of a tar based archive
+	size() int64
+	// source returns the reader counting archive bytes, or nil when progress follows extracted bytes
+	source() *progresswriter.ProgressReader
+	// entryNames calls fn with the name of every entry. A tar stream is read to its end.
+	entryNames(fn func(name string)) error
+	// extractedSize sums the uncompressed sizes of the entries extraction writes and counts them.
+	// A tar stream is read to its end, so the archive cannot be extracted afterwards.
+	extractedSize() (int64, int, error)
+	// checkLimits checks the entries against the extraction limits where the archive lists
+	// them up front; a tar stream is checked while it is extracted instead
+	checkLimits() error
+	extractTo(s *ExtractService, destPath string, tracker *extractTracker, created *[]string) error
+	close() error
+}
+
+// hasSingleRoot reports whether every entry of the archive at sourcePath lies below one
+// top-level name. A tar archive is opened separately and read once for this.
+func hasSingleRoot(sourcePath string) (bool, error) {
+	archive, err := openArchive(sourcePath)
+	if err != nil {
+		return false, err
+	}
+	defer archive.close()
+
+	roots := make(map[string]bool)
+	err = archive.entryNames(func(name string) {
+		// "./site/index.html" and "site/" both belong to "site"
+		if root := strings.SplitN(strings.TrimPrefix(path.Clean("/"+name), "/"), "/", 2)[0]; root != "" {
+			roots[root] = true
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(roots) <= 1, nil
+}
+
+// openArchive opens sourcePath according to its extension; anything not recognised as tar is read as ZIP
+func openArchive(sourcePath string) (openedArchive, error) {
+	ext := archiveExtension(sourcePath)
+	if ext == "" || ext == ".zip" {
+		zipReader, err := zip.OpenReader(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		return zipArchive{zipReader}, nil
+	}
+	return openTarArchive(sourcePath, ext)
+}
+
+type zipArchive struct {
+	reader *zip.ReadCloser
+}
+
+func (a zipArchive) size() int64 {
+	var totalSize int64
+	for _, f := range a.reader.File {
+		totalSize += int64(f.UncompressedSize64)
+	}
+	return totalSize
+}
+
+func (a zipArchive) source() *progresswriter.ProgressReader { return nil }
 
+func (a zipArchive) entryNames(fn func(name string)) error {
+	for _, f := range a.reader.File {
+		fn(f.Name)
+	}
+	return nil
+}
+
+func (a zipArchive) extractedSize() (int64, int, error) {
+	return a.size(), len(a.reader.File), nil
+}
+
+func (a zipArchive) checkLimits() error {
+	budget := archiveBudget{limits: extractLimits}
+	for _, f := range a.reader.File {
+		if err := budget.admit(f.Name, int64(f.UncompressedSize64), int64(f.CompressedSize64)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a zipArchive) extractTo(s *ExtractService, destPath string, tracker *extractTracker, created *[]string) error {
+	for _, f := range a.reader.File {
+		if err := s.extractFile(f, destPath, tracker, created); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a zipArchive) close() error { return a.reader.Close() }
+
+// tarArchive is a possibly compressed tar stream. The first header is read when
+// opening so an archive that is not a tar at all is rejected up front.
+type tarArchive struct {
+	file         *os.File
+	fileSize     int64
+	counter      *progresswriter.ProgressReader
+	decompressor io.ReadCloser
+	reader       *tar.Reader
+	first        *tar.Header
+}
+
+func openTarArchive(sourcePath, ext string) (*tarArchive, error) {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	counter := progresswriter.NewProgressReader(file, info.Size(), nil)
+	decompressor, err := newTarDecompressor(counter, ext)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reader := tar.NewReader(decompressor)
+	first, err := reader.Next()
+	if err != nil && err != io.EOF {
+		decompressor.Close()
+		file.Close()
+		return nil, fmt.Errorf("invalid %s archive: %w", ext, err)
+	}
+
+	return &tarArchive{file: file, fileSize: info.Size(), counter: counter, decompressor: decompressor, reader: reader, first: first}, nil
+}
+
+func (a *tarArchive) size() int64 { return a.fileSize }
+
+func (a *tarArchive) source() *progresswriter.ProgressReader { return a.counter }
+
+func (a *tarArchive) entryNames(fn func(name string)) error {
+	for header := a.first; header != nil; {
+		fn(header.Name)
+
+		next, err := a.reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		header = next
+	}
+	return nil
+}
+
+func (a *tarArchive) extractedSize() (int64, int, error) {
+	var total int64
+	var entries int
+	for header := a.first; header != nil; {
+		switch header.Typeflag {
+		case tar.TypeReg:
+			total += header.Size
+			entries++
+		case tar.TypeDir:
+			entries++
+		}
+
+		next, err := a.reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		header = next
+	}
+	return total, entries, nil
+}
+
+func (a *tarArchive) checkLimits() error { return nil }
+
+func (a *tarArchive) extractTo(s *ExtractService, destPath string, tracker *extractTracker, created *[]string) error {
+	header := a.first
+	for header != nil {
+		if err := extractTarEntry(a.reader, header, destPath, tracker, created); err != nil {
+			return err
+		}
+
+		next, err := a.reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		header = next
+	}
+	return nil
+}
+
+func (a *tarArchive) close() error {
+	a.decompressor.Close()
+	return a.file.Close()
+}
+
+// extractTarEntry writes one tar entry. Only directories and regular files are extracted;
+// links, devices and other special entries are skipped.
+func extractTarEntry(reader *tar.Reader, header *tar.Header, destPath string, tracker *extractTracker, created *[]string) error {
+	filePath, err := entryPath(destPath, header.Name)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir, tar.TypeReg:
+		if err := tracker.budget.admit(header.Name, header.Size, 0); err != nil {
+			return err
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return tracker.mkdirEntry(filePath, header.FileInfo().Mode(), created)
+	case tar.TypeReg:
+		return writeEntry(reader, filePath, header.Name, tracker.fileMode(header.FileInfo().Mode()), header.Size, tracker, created)
+	}
 	return nil
 }
 
@@ -191,19 +814,10 @@ func (s *ExtractService) GetProgress(extractID string) (*models.Progress, bool)
 	return s.progressStore.Get(extractID)
 }
 
-func (s *ExtractService) updateProgressError(extractID, errorMsg string) {
-	if p, ok := s.progressStore.Get(extractID); ok {
-		p.Status = models.StatusFailed
-		p.Error = errorMsg
-		s.progressStore.Set(extractID, p)
-	}
+func (s *ExtractService) updateProgressError(extractID string, err error) {
+	s.progressStore.Fail(extractID, err)
 }
 
 func (s *ExtractService) updateProgressCompleted(extractID string) {
-	if p, ok := s.progressStore.Get(extractID); ok {
-		p.Status = models.StatusCompleted
-		p.Progress = 100
-		p.UploadedBytes = p.TotalBytes
-		s.progressStore.Set(extractID, p)
-	}
+	s.progressStore.Complete(extractID)
 }
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveBytes returns a small archive holding one file, in the format of ext
+func archiveBytes(t *testing.T, ext string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if ext == ".zip" {
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "hello")
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	var out io.WriteCloser = nopCloser{&buf}
+	switch ext {
+	case ".tar.gz", ".tgz":
+		out = gzip.NewWriter(&buf)
+	case ".tar.zst":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = zw
+	}
+	tw := tar.NewWriter(out)
+	if err := tw.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0644, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(tw, "hello")
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestCheckArchiveContent(t *testing.T) {
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		filename string
+		content  []byte
+		valid    bool
+	}{
+		{"zip", "a.zip", archiveBytes(t, ".zip"), true},
+		{"empty zip", "a.zip", []byte("PK\x05\x06" + string(make([]byte, 18))), true},
+		{"tar", "a.tar", archiveBytes(t, ".tar"), true},
+		{"tar.gz", "a.tar.gz", archiveBytes(t, ".tar.gz"), true},
+		{"tgz", "a.TGZ", archiveBytes(t, ".tgz"), true},
+		{"tar.zst", "a.tar.zst", archiveBytes(t, ".tar.zst"), true},
+		{"brotli is not checked", "a.tar.br", []byte("anything"), true},
+		{"text named zip", "a.zip", []byte("just some text"), false},
+		{"png named zip", "photo.zip", pngHeader, false},
+		{"zip named tar.gz", "a.tar.gz", archiveBytes(t, ".zip"), false},
+		{"gzip named tar", "a.tar", archiveBytes(t, ".tar.gz"), false},
+		{"empty file", "a.zip", nil, false},
+		{"not an archive name", "a.txt", archiveBytes(t, ".zip"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := CheckArchiveContent(tt.filename, bytes.NewReader(tt.content))
+			if !tt.valid {
+				if !errors.Is(err, ErrNotAnArchive) {
+					t.Fatalf("CheckArchiveContent(%s) = %v, want ErrNotAnArchive", tt.filename, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckArchiveContent(%s) = %v", tt.filename, err)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Fatalf("the checked reader returned %d bytes, want the %d bytes of the content", len(got), len(tt.content))
+			}
+		})
+	}
+}
+
+// modeArchive writes an archive in the format of ext holding bin/run.sh as 0755 and notes.txt as 0600
+func modeArchive(t *testing.T, path, ext string) {
+	t.Helper()
+	entries := []struct {
+		name string
+		mode os.FileMode
+	}{{"bin/run.sh", 0755}, {"notes.txt", 0600}}
+
+	var buf bytes.Buffer
+	if ext == ".zip" {
+		zw := zip.NewWriter(&buf)
+		for _, e := range entries {
+			header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+			header.SetMode(e.mode)
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, e.name)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		gz := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gz)
+		for _, e := range entries {
+			if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: int64(e.mode), Size: int64(len(e.name)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(tw, e.name)
+		}
+		tw.Close()
+		gz.Close()
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractPreserveMode(t *testing.T) {
+	// A umask stricter than the recorded modes shows they are applied rather than left to it
+	defer syscall.Umask(syscall.Umask(0077))
+
+	tests := []struct {
+		ext       string
+		preserve  bool
+		run, note os.FileMode
+	}{
+		{".zip", true, 0755, 0600},
+		{".tar.gz", true, 0755, 0600},
+		{".zip", false, utils.FileMode() &^ 0077, utils.FileMode() &^ 0077},
+		{".tar.gz", false, utils.FileMode() &^ 0077, utils.FileMode() &^ 0077},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s preserve=%v", tt.ext, tt.preserve), func(t *testing.T) {
+			_, base := newTestService(t)
+			store := models.NewProgressStore()
+			s := NewExtractService(base, "", store)
+			s.SetPreserveOwner(true)
+			modeArchive(t, filepath.Join(base, "app"+tt.ext), tt.ext)
+
+			result, err := s.Extract("app"+tt.ext, "out", ExtractOptions{PreserveMode: tt.preserve})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id := strings.SplitN(result, ":", 2)[0]
+			if p := waitForProgress(t, store, id); p.Status != models.StatusCompleted {
+				t.Fatalf("extract %s: %s", p.Status, p.Error)
+			}
+
+			for name, want := range map[string]os.FileMode{"bin/run.sh": tt.run, "notes.txt": tt.note} {
+				info, err := os.Stat(filepath.Join(base, "out", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode().Perm(); got != want {
+					t.Errorf("%s extracted as %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkExtractManyFiles extracts an archive of 1000 small files, leaving ownership alone
+// and handing the files to an owner, whose chown calls are batched
+func BenchmarkExtractManyFiles(b *testing.B) {
+	base := b.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < 1000; i++ {
+		w, err := zw.Create(fmt.Sprintf("site/dir%02d/file%04d.txt", i%20, i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.WriteString(w, "content")
+	}
+	if err := zw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "site.zip"), buf.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		b.Skip(err)
+	}
+	for _, owner := range []string{"", current.Username} {
+		name := "preserve owner"
+		if owner != "" {
+			name = "owner " + owner
+			if err := utils.SudoChownTo(base, owner, owner, false); err != nil {
+				b.Skipf("chown to %s:%s is not possible here: %v", owner, owner, err)
+			}
+		}
+		b.Run(name, func(b *testing.B) {
+			store := models.NewProgressStore()
+			s := NewExtractService(base, owner, store)
+			s.SetPreserveOwner(owner == "")
+			for i := 0; i < b.N; i++ {
+				result, err := s.Extract("site.zip", fmt.Sprintf("out-%s-%d", name, i), ExtractOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if p := waitForProgress(b, store, strings.SplitN(result, ":", 2)[0]); p.Status != models.StatusCompleted {
+					b.Fatalf("extract %s: %s", p.Status, p.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"filemanager-api/pkg/progresswriter"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidURL      = errors.New("URL must be an absolute http or https URL")
+	ErrBlockedAddress  = errors.New("URL resolves to a private or local address")
+	ErrFetchFailed     = errors.New("remote server returned an error")
+	ErrInvalidFilename = errors.New("invalid filename")
+	ErrMaxRedirects    = errors.New("too many redirects")
+)
+
+const fetchRedirectLimit = 10
+
+var (
+	fetchCGNATNetwork   = mustParseCIDR("100.64.0.0/10")
+	fetchBenchmarkRange = mustParseCIDR("198.18.0.0/15")
+)
+
+// FetchOptions limits what a FetchService may download
+type FetchOptions struct {
+	MaxSize      int64 // 0 = unlimited
+	BlockPrivate bool  // refuse loopback, private, link-local and similar addresses
+	Timeout      time.Duration
+}
+
+// FetchService downloads files from remote URLs into the user's space
+type FetchService struct {
+	basePath      string
+	progressStore *models.ProgressStore
+	owner         string
+	opts          FetchOptions
+	client        *http.Client
+	// preserveOwner leaves files with the ownership the filesystem gives them instead of
+	// handing them to owner
+	preserveOwner bool
+}
+
+// NewFetchService creates a new fetch service
+func NewFetchService(basePath string, owner string, progressStore *models.ProgressStore, opts FetchOptions) *FetchService {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if opts.BlockPrivate {
+		// Checking the address actually dialled also covers redirects and DNS rebinding
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &FetchService{
+		basePath:      basePath,
+		progressStore: progressStore,
+		owner:         owner,
+		opts:          opts,
+		client: &http.Client{
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= fetchRedirectLimit {
+					return ErrMaxRedirects
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return ErrInvalidURL
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// SetPreserveOwner makes the service leave fetched files with the ownership the filesystem
+// gives them, instead of handing them to the usersite owner
+func (s *FetchService) SetPreserveOwner(preserve bool) {
+	s.preserveOwner = preserve
+}
+
+// setOwner sets the file owner to the service configured user
+func (s *FetchService) setOwner(path string) error {
+	if s.owner == "" || s.preserveOwner {
+		return nil
+	}
+	return utils.SudoChown(path, s.owner)
+}
+
+// Fetch starts downloading rawURL into destination and returns "fetchID:relativePath".
+// The request is made before returning so connection and HTTP errors are reported
+// directly; the body is then streamed in the background with progress tracking.
+func (s *FetchService) Fetch(rawURL, destination, filename string) (string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || !target.IsAbs() || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+		return "", ErrInvalidURL
+	}
+
+	destPath, err := utils.ValidatePath(s.basePath, destination)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(destPath); err != nil || !info.IsDir() {
+		return "", ErrNotFound
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		cancel()
+		return "", ErrInvalidURL
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		if errors.Is(err, ErrBlockedAddress) || errors.Is(err, ErrInvalidURL) || errors.Is(err, ErrMaxRedirects) {
+			return "", unwrapURLError(err)
+		}
+		return "", fmt.Errorf("%w: %v", ErrFetchFailed, err)
+	}
+
+	fail := func(err error) (string, error) {
+		resp.Body.Close()
+		cancel()
+		return "", err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fail(fmt.Errorf("%w: %s", ErrFetchFailed, resp.Status))
+	}
+	if s.opts.MaxSize > 0 && resp.ContentLength > s.opts.MaxSize {
+		return fail(fmt.Errorf("%w of %d bytes", ErrFileTooLarge, s.opts.MaxSize))
+	}
+
+	if filename == "" {
+		filename = fetchFilename(resp)
+	}
+	if filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, `/\`) {
+		return fail(ErrInvalidFilename)
+	}
+	if err := utils.ValidateName(filename); err != nil {
+		return fail(err)
+	}
+
+	fullPath := filepath.Join(destPath, filename)
+	if utils.PathExists(fullPath) {
+		fullPath = utils.GenerateUniqueName(fullPath)
+	}
+
+	fetchID := uuid.New().String()
+	s.progressStore.Set(fetchID, &models.Progress{
+		ID:            fetchID,
+		Operation:     models.OperationFetch,
+		Filename:      filepath.Base(fullPath),
+		Progress:      0,
+		UploadedBytes: 0,
+		TotalBytes:    resp.ContentLength,
+		Status:        models.StatusUploading,
+	})
+
+	go func() {
+		defer cancel()
+		defer resp.Body.Close()
+
+		if err := s.download(fetchID, resp, fullPath); err != nil {
+			s.updateProgressError(fetchID, err.Error())
+			return
+		}
+		s.updateProgressCompleted(fetchID)
+	}()
+
+	relPath, _ := utils.GetRelativePath(s.basePath, fullPath)
+	return fetchID + ":" + relPath, nil
+}
+
+// download streams the response body into a staging file that is renamed into place on success
+func (s *FetchService) download(fetchID string, resp *http.Response, fullPath string) error {
+	staging := stagingPath(fullPath, fetchID)
+	file, err := utils.CreateFile(staging)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staging) // No-op once renamed into place
+	defer file.Close()
+
+	var body io.Reader = resp.Body
+	if s.opts.MaxSize > 0 {
+		body = &sizeLimitedReader{reader: body, remaining: s.opts.MaxSize, limit: s.opts.MaxSize}
+	}
+
+	pw := progresswriter.NewProgressWriter(file, resp.ContentLength, func(written, total int64) {
+		s.progressStore.Update(fetchID, written)
+	})
+
+	buf := utils.NewBuffer()
+	if _, err := io.CopyBuffer(pw, body, buf); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(staging, fullPath); err != nil {
+		return err
+	}
+	s.setOwner(fullPath)
+	return nil
+}
+
+// GetProgress returns progress for a fetch
+func (s *FetchService) GetProgress(fetchID string) (*models.Progress, bool) {
+	return s.progressStore.Get(fetchID)
+}
+
+func (s *FetchService) updateProgressError(fetchID, errorMsg string) {
+	s.progressStore.Modify(fetchID, func(p *models.Progress) {
+		p.Status = models.StatusFailed
+		p.Error = errorMsg
+	})
+}
+
+func (s *FetchService) updateProgressCompleted(fetchID string) {
+	s.progressStore.Modify(fetchID, func(p *models.Progress) {
+		p.Status = models.StatusCompleted
+		p.Progress = 100
+		p.TotalBytes = p.UploadedBytes
+	})
+}
+
+// fetchFilename picks a filename from Content-Disposition or the final URL path
+func fetchFilename(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(strings.ReplaceAll(params["filename"], `\`, "/")); name != "." && name != "/" && name != "" {
+			return name
+		}
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "." && name != "/" && name != "" {
+		return name
+	}
+	return "download"
+}
+
+// isBlockedIP reports whether ip is not a public unicast address
+func isBlockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || fetchCGNATNetwork.Contains(ip) || fetchBenchmarkRange.Contains(ip)
+}
+
+// unwrapURLError strips the *url.Error wrapper so the sentinel message is returned as is
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		var opErr *net.OpError
+		if errors.As(urlErr.Err, &opErr) {
+			return opErr.Err
+		}
+		return urlErr.Err
+	}
+	return err
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
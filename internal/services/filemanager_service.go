@@ -1,7 +1,11 @@
 package services
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"filemanager-api/internal/metrics"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/utils"
 	"fmt"
@@ -11,7 +15,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 
+	"github.com/google/uuid"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
@@ -24,6 +30,13 @@ var (
 	ErrFolderNotEmpty   = errors.New("folder is not empty")
 	ErrPermissionDenied = errors.New("permission denied")
 	ErrSSHConnection    = errors.New("SSH connection failed")
+	ErrBinaryFile       = errors.New("file appears to be binary")
+	ErrOutsideCopyBase  = errors.New("source is not inside the copy base")
+	ErrNoMatches        = errors.New("pattern matched no files")
+	ErrInvalidOffset    = errors.New("offset is out of range")
+	ErrCopyVerification = errors.New("copied data does not match the source")
+	ErrUnknownOwner     = errors.New("owner or group does not exist")
+	ErrTooManyMatches   = errors.New("sources expand to too many paths")
 )
 
 // SSHConfig holds SSH connection details
@@ -44,6 +57,10 @@ type FileManagerService struct {
 	owner      string
 	uid        int
 	gid        int
+
+	// preserveOwner leaves files with the ownership the filesystem gives them instead of
+	// handing them to owner
+	preserveOwner bool
 }
 
 // NewFileManagerService creates a new file manager service for local operations
@@ -61,12 +78,12 @@ func NewFileManagerService(basePath string, owner string) *FileManagerService {
 		if err == nil {
 			svc.uid = uid
 			svc.gid = gid
-			fmt.Printf("[INFO] Ownership resolved: %s -> UID:%d, GID:%d\n", owner, svc.uid, svc.gid)
+			utils.Debugf("Ownership resolved: %s -> UID:%d, GID:%d", owner, svc.uid, svc.gid)
 		} else {
-			fmt.Printf("[ERROR] Failed to resolve user %s: %v. Files will be owned by root.\n", owner, err)
+			utils.Errorf("Failed to resolve user %s: %v. Files will be owned by root.", owner, err)
 		}
 	} else {
-		fmt.Printf("[WARN] No owner specified for FileManagerService\n")
+		utils.Warnf("No owner specified for FileManagerService")
 	}
 
 	return svc
@@ -86,7 +103,7 @@ func NewRemoteFileManagerService(basePath string, sshConfig *SSHConfig, owner st
 	}
 
 	if owner != "" {
-		fmt.Printf("[INFO] Remote service with ownership: %s\n", owner)
+		utils.Debugf("Remote service with ownership: %s", owner)
 	}
 
 	return svc, nil
@@ -94,36 +111,53 @@ func NewRemoteFileManagerService(basePath string, sshConfig *SSHConfig, owner st
 
 // connectSSH establishes SSH and SFTP connections
 func (s *FileManagerService) connectSSH() error {
-	signer, err := ssh.ParsePrivateKey([]byte(s.sshConfig.PrivateKey))
+	config, err := sshClientConfig(s.sshConfig)
 	if err != nil {
-		return fmt.Errorf("%w: failed to parse private key: %v", ErrSSHConnection, err)
-	}
-
-	config := &ssh.ClientConfig{
-		User: s.sshConfig.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use known_hosts
+		return fmt.Errorf("%w: %v", ErrSSHConnection, err)
 	}
 
 	addr := fmt.Sprintf("%s:%s", s.sshConfig.Host, s.sshConfig.Port)
-	client, err := ssh.Dial("tcp", addr, config)
+	var client *ssh.Client
+	err = withRetry("SSH connect to "+addr, func() (err error) {
+		client, err = ssh.Dial("tcp", addr, config)
+		return err
+	}, nil)
 	if err != nil {
+		metrics.SSHConnections.WithLabelValues("failed").Inc()
 		return fmt.Errorf("%w: %v", ErrSSHConnection, err)
 	}
-	s.sshClient = client
 
 	sftpClient, err := sftp.NewClient(client)
 	if err != nil {
 		client.Close()
+		metrics.SSHConnections.WithLabelValues("failed").Inc()
 		return fmt.Errorf("%w: failed to create SFTP client: %v", ErrSSHConnection, err)
 	}
+	s.sshClient = client
 	s.sftpClient = sftpClient
+	metrics.SSHConnections.WithLabelValues("success").Inc()
+	metrics.SSHConnectionsOpen.Inc()
 
 	return nil
 }
 
+// sshClientConfig returns the client configuration authenticating with the key of cfg
+func sshClientConfig(cfg *SSHConfig) (*ssh.ClientConfig, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	return &ssh.ClientConfig{
+		User: cfg.Username,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use known_hosts
+		Timeout:         sshRetry.connectTimeout,
+	}, nil
+}
+
 // Close closes SSH connections
 func (s *FileManagerService) Close() {
 	if s.sftpClient != nil {
@@ -131,6 +165,8 @@ func (s *FileManagerService) Close() {
 	}
 	if s.sshClient != nil {
 		s.sshClient.Close()
+		s.sshClient = nil
+		metrics.SSHConnectionsOpen.Dec()
 	}
 }
 
@@ -163,44 +199,50 @@ func (s *FileManagerService) runSSHCommand(cmd string) error {
 	return nil
 }
 
+// SetPreserveOwner makes the service leave the files it creates and changes with the
+// ownership the filesystem gives them, instead of handing them to the usersite owner
+func (s *FileManagerService) SetPreserveOwner(preserve bool) {
+	s.preserveOwner = preserve
+}
+
 // setOwner sets the file owner to the service configured user
 func (s *FileManagerService) setOwner(path string) error {
-	fmt.Printf("[DEBUG] setOwner called: path=%s, owner=%s, isRemote=%v\n", path, s.owner, s.isRemote)
+	utils.Debugf("setOwner called: path=%s, owner=%s, isRemote=%v", path, s.owner, s.isRemote)
 
-	if s.owner == "" {
-		fmt.Printf("[WARN] setOwner: owner is empty, skipping\n")
+	if s.owner == "" || s.preserveOwner {
+		utils.Debugf("setOwner: owner is empty or preserved, skipping")
 		return nil
 	}
 
 	if s.isRemote {
 		// Execute chown via SSH
-		cmd := fmt.Sprintf("chown %s:%s %s", s.owner, s.owner, path)
-		fmt.Printf("[DEBUG] Running SSH chown: %s\n", cmd)
+		cmd := fmt.Sprintf("chown -h %s %s", shellQuote(s.owner+":"+s.owner), shellQuote(path))
+		utils.Debugf("Running SSH chown: %s", cmd)
 		err := s.runSSHCommand(cmd)
 		if err != nil {
-			fmt.Printf("[ERROR] SSH chown failed: %v\n", err)
+			utils.Errorf("SSH chown failed: %v", err)
 		}
 		return err
 	}
 
 	// Local: use chown command
-	fmt.Printf("[DEBUG] Running local chown: chown %s:%s %s\n", s.owner, s.owner, path)
+	utils.Debugf("Running local chown: chown %s:%s %s", s.owner, s.owner, path)
 	err := utils.SudoChown(path, s.owner)
 	if err != nil {
-		fmt.Printf("[ERROR] Local chown failed: %v\n", err)
+		utils.Errorf("Local chown failed: %v", err)
 	}
 	return err
 }
 
 // setOwnerRecursive sets the file owner recursively
 func (s *FileManagerService) setOwnerRecursive(path string) error {
-	if s.owner == "" {
+	if s.owner == "" || s.preserveOwner {
 		return nil
 	}
 
 	if s.isRemote {
 		// Execute chown -R via SSH
-		cmd := fmt.Sprintf("chown -R %s:%s %s", s.owner, s.owner, path)
+		cmd := fmt.Sprintf("chown -R %s %s", shellQuote(s.owner+":"+s.owner), shellQuote(path))
 		return s.runSSHCommand(cmd)
 	}
 
@@ -208,8 +250,8 @@ func (s *FileManagerService) setOwnerRecursive(path string) error {
 	return utils.SudoChownRecursive(path, s.owner)
 }
 
-// List lists all files and folders in a directory
-func (s *FileManagerService) List(relativePath string) ([]models.FileInfo, error) {
+// List lists the files and folders in a directory that pass filter
+func (s *FileManagerService) List(relativePath string, filter ListFilter) ([]models.FileInfo, error) {
 	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
 	if err != nil {
 		return nil, err
@@ -227,6 +269,14 @@ func (s *FileManagerService) List(relativePath string) ([]models.FileInfo, error
 		return nil, err
 	}
 
+	matched := items[:0]
+	for _, item := range items {
+		if filter.Match(item) {
+			matched = append(matched, item)
+		}
+	}
+	items = matched
+
 	// Sort: folders first, then files, alphabetically
 	sort.Slice(items, func(i, j int) bool {
 		if items[i].IsDir != items[j].IsDir {
@@ -248,6 +298,7 @@ func (s *FileManagerService) listLocal(fullPath string) ([]models.FileInfo, erro
 		return nil, err
 	}
 
+	owners := utils.NewOwnerNames()
 	var items []models.FileInfo
 	for _, entry := range entries {
 		info, err := entry.Info()
@@ -266,6 +317,7 @@ func (s *FileManagerService) listLocal(fullPath string) ([]models.FileInfo, erro
 			ModTime:     info.ModTime(),
 			Permissions: utils.FormatPermissions(info.Mode()),
 		}
+		item.Owner, item.Group = owners.Lookup(info)
 
 		if !entry.IsDir() {
 			item.Extension = strings.TrimPrefix(filepath.Ext(entry.Name()), ".")
@@ -279,7 +331,7 @@ func (s *FileManagerService) listLocal(fullPath string) ([]models.FileInfo, erro
 }
 
 func (s *FileManagerService) listRemote(fullPath string) ([]models.FileInfo, error) {
-	info, err := s.sftpClient.Stat(fullPath)
+	info, err := s.sftpStat(fullPath)
 	if err != nil {
 		return nil, ErrNotFound
 	}
@@ -287,11 +339,12 @@ func (s *FileManagerService) listRemote(fullPath string) ([]models.FileInfo, err
 		return nil, ErrNotAFolder
 	}
 
-	entries, err := s.sftpClient.ReadDir(fullPath)
+	entries, err := s.sftpReadDir(fullPath)
 	if err != nil {
 		return nil, err
 	}
 
+	users, groups := s.remoteOwnerNames(entries)
 	var items []models.FileInfo
 	for _, entry := range entries {
 		entryPath := filepath.Join(fullPath, entry.Name())
@@ -306,6 +359,7 @@ func (s *FileManagerService) listRemote(fullPath string) ([]models.FileInfo, err
 			ModTime:     entry.ModTime(),
 			Permissions: utils.FormatPermissions(entry.Mode()),
 		}
+		item.Owner, item.Group = remoteOwnership(entry, users, groups)
 
 		if !entry.IsDir() {
 			item.Extension = strings.TrimPrefix(filepath.Ext(entry.Name()), ".")
@@ -318,6 +372,55 @@ func (s *FileManagerService) listRemote(fullPath string) ([]models.FileInfo, err
 	return items, nil
 }
 
+// Stat returns the raw file info of a path. Unlike GetInfo it reads nothing
+// but the metadata, so it is cheap enough for cache validation.
+func (s *FileManagerService) Stat(relativePath string) (os.FileInfo, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var info os.FileInfo
+	if s.isRemote {
+		info, err = s.sftpStat(fullPath)
+	} else {
+		info, err = os.Stat(fullPath)
+	}
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return info, nil
+}
+
+// StatFile returns the raw file info of a path that must be a file, as Stat does, but tells
+// the failures apart the same way locally and over SFTP: ErrNotFound when nothing is at the
+// path, ErrNotAFile for a folder and ErrSSHConnection when the SSH host could not be reached.
+func (s *FileManagerService) StatFile(relativePath string) (os.FileInfo, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var info os.FileInfo
+	if s.isRemote {
+		info, err = s.sftpStat(fullPath)
+		err = remoteError(err)
+	} else {
+		info, err = os.Stat(fullPath)
+		// A file in the middle of the path counts as missing, as with OpenSSH's SFTP server
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			err = ErrNotFound
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrNotAFile
+	}
+	return info, nil
+}
+
 // GetInfo gets file or folder information
 func (s *FileManagerService) GetInfo(relativePath string) (*models.FileInfo, error) {
 	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
@@ -351,12 +454,13 @@ func (s *FileManagerService) getInfoLocal(fullPath string) (*models.FileInfo, er
 		ModTime:     info.ModTime(),
 		Permissions: utils.FormatPermissions(info.Mode()),
 	}
+	item.Owner, item.Group = utils.NewOwnerNames().Lookup(info)
 
 	if !info.IsDir() {
 		item.Extension = strings.TrimPrefix(filepath.Ext(info.Name()), ".")
-		item.MimeType = utils.GetMimeType(info.Name())
+		item.MimeType = s.sniffMimeType(fullPath, info.Name())
 	} else {
-		size, _ := utils.GetDirectorySize(fullPath)
+		size, _ := utils.GetDirectorySize(context.Background(), fullPath, utils.SymlinksSkip)
 		item.Size = size
 	}
 
@@ -364,11 +468,79 @@ func (s *FileManagerService) getInfoLocal(fullPath string) (*models.FileInfo, er
 }
 
 func (s *FileManagerService) getInfoRemote(fullPath string) (*models.FileInfo, error) {
-	info, err := s.sftpClient.Stat(fullPath)
+	info, err := s.sftpStat(fullPath)
 	if err != nil {
 		return nil, ErrNotFound
 	}
 
+	users, groups := s.remoteOwnerNames([]os.FileInfo{info})
+	return s.remoteInfoItem(fullPath, info, users, groups), nil
+}
+
+// GetInfoBatch gets information for several paths, in request order. A path that cannot
+// be read gets an error instead of failing the batch. Remote paths share this service's
+// SFTP session and their owners are resolved with a single lookup.
+func (s *FileManagerService) GetInfoBatch(paths []string) []models.InfoBatchResult {
+	results := make([]models.InfoBatchResult, len(paths))
+	if !s.isRemote {
+		for i, p := range paths {
+			results[i].Path = p
+			info, err := s.GetInfo(p)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].Info = info
+		}
+		return results
+	}
+
+	fullPaths := make([]string, len(paths))
+	infos := make([]os.FileInfo, len(paths))
+	var found []os.FileInfo
+	for i, p := range paths {
+		results[i].Path = p
+		fullPath, err := utils.ValidatePath(s.basePath, p)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		info, err := s.sftpStat(fullPath)
+		if err != nil {
+			results[i].Error = ErrNotFound.Error()
+			continue
+		}
+		fullPaths[i], infos[i] = fullPath, info
+		found = append(found, info)
+	}
+
+	users, groups := s.remoteOwnerNames(found)
+	for i, info := range infos {
+		if info != nil {
+			results[i].Info = s.remoteInfoItem(fullPaths[i], info, users, groups)
+		}
+	}
+	return results
+}
+
+// infoFor returns the info of those full paths that can still be read, looked up as one batch
+func (s *FileManagerService) infoFor(fullPaths []string) []models.FileInfo {
+	relPaths := make([]string, len(fullPaths))
+	for i, fullPath := range fullPaths {
+		relPaths[i], _ = utils.GetRelativePath(s.basePath, fullPath)
+	}
+
+	var infos []models.FileInfo
+	for _, result := range s.GetInfoBatch(relPaths) {
+		if result.Info != nil {
+			infos = append(infos, *result.Info)
+		}
+	}
+	return infos
+}
+
+// remoteInfoItem builds the FileInfo of a remote path from its stat result
+func (s *FileManagerService) remoteInfoItem(fullPath string, info os.FileInfo, users, groups map[uint32]string) *models.FileInfo {
 	relPath, _ := utils.GetRelativePath(s.basePath, fullPath)
 
 	item := &models.FileInfo{
@@ -380,13 +552,147 @@ func (s *FileManagerService) getInfoRemote(fullPath string) (*models.FileInfo, e
 		ModTime:     info.ModTime(),
 		Permissions: utils.FormatPermissions(info.Mode()),
 	}
+	item.Owner, item.Group = remoteOwnership(info, users, groups)
 
 	if !info.IsDir() {
 		item.Extension = strings.TrimPrefix(filepath.Ext(info.Name()), ".")
-		item.MimeType = utils.GetMimeType(info.Name())
+		item.MimeType = s.sniffMimeType(fullPath, info.Name())
 	}
 
-	return item, nil
+	return item
+}
+
+// remoteOwnerNames resolves the owner and group IDs of infos to names on the remote
+// host with a single getent call. IDs getent does not know are left out.
+func (s *FileManagerService) remoteOwnerNames(infos []os.FileInfo) (map[uint32]string, map[uint32]string) {
+	users := map[uint32]string{}
+	groups := map[uint32]string{}
+
+	uidSet := map[uint32]bool{}
+	gidSet := map[uint32]bool{}
+	var uids, gids []string
+	for _, info := range infos {
+		stat, ok := info.Sys().(*sftp.FileStat)
+		if !ok {
+			continue
+		}
+		if !uidSet[stat.UID] {
+			uidSet[stat.UID] = true
+			uids = append(uids, strconv.FormatUint(uint64(stat.UID), 10))
+		}
+		if !gidSet[stat.GID] {
+			gidSet[stat.GID] = true
+			gids = append(gids, strconv.FormatUint(uint64(stat.GID), 10))
+		}
+	}
+	if len(uids) == 0 {
+		return users, groups
+	}
+
+	// getent exits non-zero when any ID is unknown but still prints the others
+	cmd := fmt.Sprintf("getent passwd %s; echo --; getent group %s", strings.Join(uids, " "), strings.Join(gids, " "))
+	output, _ := s.runSSHCommandOutput(cmd)
+
+	target := users
+	for _, line := range strings.Split(string(output), "\n") {
+		if line == "--" {
+			target = groups
+			continue
+		}
+		// name:password:id:...
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if id, err := strconv.ParseUint(fields[2], 10, 32); err == nil {
+			target[uint32(id)] = fields[0]
+		}
+	}
+	return users, groups
+}
+
+// remoteOwnership returns the owner and group names of a remote file,
+// falling back to the numeric IDs when they could not be resolved
+func remoteOwnership(info os.FileInfo, users, groups map[uint32]string) (string, string) {
+	stat, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return "", ""
+	}
+	owner, ok := users[stat.UID]
+	if !ok {
+		owner = strconv.FormatUint(uint64(stat.UID), 10)
+	}
+	group, ok := groups[stat.GID]
+	if !ok {
+		group = strconv.FormatUint(uint64(stat.GID), 10)
+	}
+	return owner, group
+}
+
+// sniffMimeType detects a file's MIME type from its first bytes, reading only that
+// much (also remotely). Listings use the cheaper extension-only GetMimeType instead.
+func (s *FileManagerService) sniffMimeType(fullPath, name string) string {
+	var file io.ReadCloser
+	var err error
+	file, _, _, err = s.openContent(fullPath)
+	if err != nil {
+		return utils.GetMimeType(name)
+	}
+	defer file.Close()
+
+	header := make([]byte, utils.SniffLength)
+	n, _ := io.ReadFull(file, header)
+	return utils.DetectMimeType(name, header[:n])
+}
+
+// Hash computes the hex digest of a file with the given algorithm (md5, sha1, sha256).
+// Remote files are hashed on the server with the matching *sum command instead of being transferred.
+func (s *FileManagerService) Hash(relativePath, algo string) (string, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return "", err
+	}
+
+	if s.isRemote {
+		hashCmd, err := utils.HashCommand(algo)
+		if err != nil {
+			return "", err
+		}
+		info, err := s.sftpStat(fullPath)
+		if err != nil {
+			return "", ErrNotFound
+		}
+		if info.IsDir() {
+			return "", ErrNotAFile
+		}
+
+		output, err := s.runSSHCommandOutput(fmt.Sprintf("%s %s", hashCmd, shellQuote(fullPath)))
+		if err != nil {
+			return "", fmt.Errorf("remote hash failed: %v, output: %s", err, strings.TrimSpace(string(output)))
+		}
+		fields := strings.Fields(string(output))
+		if len(fields) == 0 {
+			return "", fmt.Errorf("unexpected output from %s", hashCmd)
+		}
+		return fields[0], nil
+	}
+
+	if _, err := utils.NewHasher(algo); err != nil {
+		return "", err
+	}
+	if !utils.PathExists(fullPath) {
+		return "", ErrNotFound
+	}
+	if utils.IsDir(fullPath) {
+		return "", ErrNotAFile
+	}
+	// Encrypted files are hashed by their content
+	file, _, _, err := openEncrypted(fullPath, s.owner)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return utils.HashReader(file, algo)
 }
 
 // GetContent reads file content
@@ -406,20 +712,80 @@ func (s *FileManagerService) GetContent(relativePath string) (io.ReadCloser, *mo
 	}
 
 	if s.isRemote {
-		file, err := s.sftpClient.Open(fullPath)
+		file, err := s.sftpOpen(fullPath)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, remoteError(err)
 		}
 		return file, info, nil
 	}
 
-	file, err := os.Open(fullPath)
+	// Encrypted uploads are decrypted transparently
+	file, size, _, err := openEncrypted(fullPath, s.owner)
 	if err != nil {
 		return nil, nil, err
 	}
+	info.Size = size
 	return file, info, nil
 }
 
+// Preview reads at most maxBytes (and, when maxLines > 0, at most maxLines lines)
+// from the start of a file. Only the requested slice is read, also for remote files.
+// Binary files are rejected unless allowBinary is set.
+func (s *FileManagerService) Preview(relativePath string, maxBytes int64, maxLines int, allowBinary bool) (*models.FilePreview, error) {
+	reader, info, err := s.GetContent(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Read one extra byte to know whether the file continues past the limit
+	data, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := int64(len(data)) > maxBytes
+	if truncated {
+		data = data[:maxBytes]
+	}
+
+	if !allowBinary && bytes.IndexByte(data, 0) >= 0 {
+		return nil, ErrBinaryFile
+	}
+
+	if maxLines > 0 {
+		offset := 0
+		for i := 0; i < maxLines; i++ {
+			idx := bytes.IndexByte(data[offset:], '\n')
+			if idx < 0 {
+				offset = len(data)
+				break
+			}
+			offset += idx + 1
+		}
+		if offset < len(data) {
+			data = data[:offset]
+			truncated = true
+		}
+	}
+
+	lines := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+
+	return &models.FilePreview{
+		Path:      info.Path,
+		MimeType:  info.MimeType,
+		Content:   string(data),
+		Encoding:  "utf8",
+		Size:      info.Size,
+		BytesRead: len(data),
+		Lines:     lines,
+		Truncated: truncated,
+	}, nil
+}
+
 // CreateFile creates a new file with content
 func (s *FileManagerService) CreateFile(relativePath string, content string) (*models.FileInfo, error) {
 	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
@@ -439,25 +805,25 @@ func (s *FileManagerService) createFileLocal(fullPath, relativePath, content str
 	}
 
 	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, utils.DirMode()); err != nil {
 		return nil, err
 	}
 
-	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+	if err := os.WriteFile(fullPath, []byte(content), utils.FileMode()); err != nil {
 		return nil, err
 	}
 
 	// Set owner
 	if err := s.setOwner(fullPath); err != nil {
 		// Log error but continue
-		fmt.Printf("Failed to set owner for %s: %v\n", fullPath, err)
+		utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
 	}
 
 	return s.GetInfo(relativePath)
 }
 
 func (s *FileManagerService) createFileRemote(fullPath, relativePath, content string) (*models.FileInfo, error) {
-	_, err := s.sftpClient.Stat(fullPath)
+	_, err := s.sftpStat(fullPath)
 	if err == nil {
 		return nil, ErrAlreadyExists
 	}
@@ -474,10 +840,13 @@ func (s *FileManagerService) createFileRemote(fullPath, relativePath, content st
 	if _, err := file.Write([]byte(content)); err != nil {
 		return nil, err
 	}
+	if err := file.Chmod(utils.FileMode()); err != nil {
+		utils.Errorf("Failed to set mode for %s: %v", fullPath, err)
+	}
 
 	// Set owner via SSH
 	if err := s.setOwner(fullPath); err != nil {
-		fmt.Printf("Failed to set owner for %s: %v\n", fullPath, err)
+		utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
 	}
 
 	return s.GetInfo(relativePath)
@@ -505,20 +874,48 @@ func (s *FileManagerService) updateFileLocal(fullPath, relativePath, content str
 		return nil, ErrNotAFile
 	}
 
-	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+	// Encrypted files stay encrypted, through a temp file renamed into place
+	encrypted, _, err := s.Encrypted(relativePath)
+	if err != nil {
 		return nil, err
 	}
+	if encrypted {
+		info, err := os.Lstat(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			return nil, ErrNotAFile
+		}
+		staging := stagingPath(fullPath, uuid.New().String())
+		if err := s.writeStaged(staging, []byte(content), info.Mode().Perm(), true); err != nil {
+			os.Remove(staging)
+			return nil, err
+		}
+		if err := os.Rename(staging, fullPath); err != nil {
+			os.Remove(staging)
+			return nil, err
+		}
+	} else {
+		// A deduplicated upload shares its content with other names
+		if err := breakHardLink(fullPath); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(fullPath, []byte(content), utils.FileMode()); err != nil {
+			return nil, err
+		}
+	}
 
 	// Set owner (ensure owner stays correct)
 	if err := s.setOwner(fullPath); err != nil {
-		fmt.Printf("Failed to set owner for %s: %v\n", fullPath, err)
+		utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
 	}
 
 	return s.GetInfo(relativePath)
 }
 
 func (s *FileManagerService) updateFileRemote(fullPath, relativePath, content string) (*models.FileInfo, error) {
-	info, err := s.sftpClient.Stat(fullPath)
+	info, err := s.sftpStat(fullPath)
 	if err != nil {
 		return nil, ErrNotFound
 	}
@@ -539,7 +936,77 @@ func (s *FileManagerService) updateFileRemote(fullPath, relativePath, content st
 
 	// Set owner via SSH
 	if err := s.setOwner(fullPath); err != nil {
-		fmt.Printf("Failed to set owner for %s: %v\n", fullPath, err)
+		utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
+	}
+
+	return s.GetInfo(relativePath)
+}
+
+// WriteAt writes data into an existing file at offset, extending the file when the
+// write runs past its end. The offset may not lie beyond the current end of the file.
+func (s *FileManagerService) WriteAt(relativePath string, offset int64, data []byte) (*models.FileInfo, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var info os.FileInfo
+	if s.isRemote {
+		info, err = s.sftpStat(fullPath)
+	} else {
+		info, err = os.Stat(fullPath)
+	}
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if info.IsDir() {
+		return nil, ErrNotAFile
+	}
+	// The offset is into the content, which is smaller than an encrypted file
+	size := info.Size()
+	encrypted, contentSize, err := s.Encrypted(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		size = contentSize
+	}
+	if offset < 0 || offset > size {
+		return nil, fmt.Errorf("%w: %d (file size %d)", ErrInvalidOffset, offset, size)
+	}
+
+	if encrypted {
+		if err := s.writeEncryptedAt(fullPath, offset, data); err != nil {
+			return nil, err
+		}
+		if err := s.setOwner(fullPath); err != nil {
+			utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
+		}
+	} else if s.isRemote {
+		file, err := s.sftpClient.OpenFile(fullPath, os.O_WRONLY)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if _, err := file.WriteAt(data, offset); err != nil {
+			return nil, err
+		}
+	} else {
+		// A deduplicated upload shares its content with other names
+		if err := breakHardLink(fullPath); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(fullPath, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if _, err := file.WriteAt(data, offset); err != nil {
+			return nil, err
+		}
+		if err := s.setOwner(fullPath); err != nil {
+			utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
+		}
 	}
 
 	return s.GetInfo(relativePath)
@@ -553,50 +1020,72 @@ func (s *FileManagerService) CreateFolder(relativePath string) (*models.FileInfo
 	}
 
 	if s.isRemote {
-		_, statErr := s.sftpClient.Stat(fullPath)
+		_, statErr := s.sftpStat(fullPath)
 		if statErr == nil {
 			return nil, ErrAlreadyExists
 		}
-		if err := s.sftpClient.MkdirAll(fullPath); err != nil {
+		// Missing parents are created too, and handed to the owner like the folder itself
+		if err := s.mkdirAllOwned(fullPath); err != nil {
 			return nil, err
 		}
-		// Set owner via SSH
-		if err := s.setOwner(fullPath); err != nil {
-			fmt.Printf("Failed to set owner for %s: %v\n", fullPath, err)
+		if err := s.sftpClient.Chmod(fullPath, utils.DirMode()); err != nil {
+			utils.Errorf("Failed to set mode for %s: %v", fullPath, err)
 		}
 	} else {
 		if utils.PathExists(fullPath) {
 			return nil, ErrAlreadyExists
 		}
-		if err := os.MkdirAll(fullPath, 0755); err != nil {
+		if err := s.mkdirAllOwned(fullPath); err != nil {
 			return nil, err
 		}
-		if err := s.setOwner(fullPath); err != nil {
-			fmt.Printf("Failed to set owner for %s: %v\n", fullPath, err)
-		}
 	}
 
 	return s.GetInfo(relativePath)
 }
 
+// CreateFolders creates every path like CreateFolder, in order, so a folder may be listed
+// before the folders inside it. A path that cannot be created, or already exists, gets an
+// error instead of stopping the batch.
+func (s *FileManagerService) CreateFolders(paths []string) []models.CreateFolderResult {
+	results := make([]models.CreateFolderResult, len(paths))
+	for i, p := range paths {
+		results[i].Path = p
+		info, err := s.CreateFolder(p)
+		if err != nil {
+			results[i].Exists = errors.Is(err, ErrAlreadyExists)
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Info = info
+	}
+	return results
+}
+
 // Rename renames a file or folder
 func (s *FileManagerService) Rename(relativePath, newName string) (*models.FileInfo, error) {
 	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
 	if err != nil {
 		return nil, err
 	}
+	// A rename stays in the same directory; moving elsewhere goes through Move
+	if newName == "." || newName == ".." || strings.ContainsAny(newName, `/\`) {
+		return nil, fmt.Errorf("%w: %q must be a single name, use move to change directories", ErrInvalidFilename, newName)
+	}
+	if err := utils.ValidateName(newName); err != nil {
+		return nil, err
+	}
 
 	dir := filepath.Dir(fullPath)
 	newPath := filepath.Join(dir, newName)
 
 	if s.isRemote {
-		if _, err := s.sftpClient.Stat(fullPath); err != nil {
+		if _, err := s.sftpStat(fullPath); err != nil {
 			return nil, ErrNotFound
 		}
-		if _, err := s.sftpClient.Stat(newPath); err == nil {
+		if _, err := s.sftpStat(newPath); err == nil {
 			return nil, ErrAlreadyExists
 		}
-		if err := s.sftpClient.Rename(fullPath, newPath); err != nil {
+		if err := s.sftpRename(fullPath, newPath); err != nil {
 			return nil, err
 		}
 	} else {
@@ -615,28 +1104,204 @@ func (s *FileManagerService) Rename(relativePath, newName string) (*models.FileI
 	return s.GetInfo(newRelPath)
 }
 
-// Delete deletes a file or folder
-func (s *FileManagerService) Delete(relativePath string, recursive bool) error {
-	fmt.Printf("[DEBUG] Delete: relativePath=%s, basePath=%s\n", relativePath, s.basePath)
-
+// Chown changes the owner and group of a file or folder. An empty group
+// defaults to the owner, matching how the service chowns new files.
+func (s *FileManagerService) Chown(relativePath, owner, group string, recursive bool) (*models.FileInfo, error) {
 	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
 	if err != nil {
-		fmt.Printf("[ERROR] Delete: ValidatePath error: %v\n", err)
-		return err
+		return nil, err
+	}
+	if group == "" {
+		group = owner
+	}
+	if !utils.IsValidAccountName(owner) || !utils.IsValidAccountName(group) {
+		return nil, fmt.Errorf("%w: %s:%s", ErrUnknownOwner, owner, group)
 	}
-
-	fmt.Printf("[DEBUG] Delete: fullPath=%s, isRemote=%v\n", fullPath, s.isRemote)
 
 	if s.isRemote {
-		return s.deleteRemote(fullPath, recursive)
+		if _, err := s.sftpStat(fullPath); err != nil {
+			return nil, ErrNotFound
+		}
+		if _, err := s.runSSHCommandOutput(fmt.Sprintf("id -u %s && getent group %s", shellQuote(owner), shellQuote(group))); err != nil {
+			return nil, fmt.Errorf("%w: %s:%s", ErrUnknownOwner, owner, group)
+		}
+		// Symlinks are changed themselves rather than the files they point to
+		flag := "-h "
+		if recursive {
+			flag = "-R -h "
+		}
+		if err := s.runSSHCommand(fmt.Sprintf("chown %s%s %s", flag, shellQuote(owner+":"+group), shellQuote(fullPath))); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := os.Lstat(fullPath); err != nil {
+			return nil, ErrNotFound
+		}
+		if _, _, err := utils.ResolveUser(owner); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownOwner, err)
+		}
+		if _, err := utils.ResolveGroup(group); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownOwner, err)
+		}
+		if err := utils.SudoChownTo(fullPath, owner, group, recursive); err != nil {
+			return nil, err
+		}
 	}
-	return s.deleteLocal(fullPath, recursive)
+
+	return s.GetInfo(relativePath)
 }
 
-func (s *FileManagerService) deleteLocal(fullPath string, recursive bool) error {
-	if !utils.PathExists(fullPath) {
-		return ErrNotFound
-	}
+// maxExpandedSources bounds the paths the sources of one request expand to, see
+// ConfigureMaxExpandedSources. Zero means unlimited.
+var maxExpandedSources = 1000
+
+// ConfigureMaxExpandedSources sets how many paths the sources of one copy, move, transfer
+// or delete may expand to, so one pattern cannot exceed the batch limit. Zero means unlimited.
+func ConfigureMaxExpandedSources(max int) {
+	maxExpandedSources = max
+}
+
+// isPattern reports whether src is expanded as a glob pattern: it contains *, ? or [ and
+// nothing exists under that literal name, so names such as report[1].pdf keep working
+func (s *FileManagerService) isPattern(src string) bool {
+	if !utils.HasGlob(src) {
+		return false
+	}
+	fullPath, err := utils.ValidatePath(s.basePath, src)
+	if err != nil {
+		return true // expanding reports the invalid path
+	}
+	_, err = s.statFull(fullPath)
+	return err != nil
+}
+
+// ExpandSources resolves glob patterns (containing *, ? or [) to the relative paths
+// they match under the base path. Literal paths, including existing names containing
+// those characters, are passed through unchanged. More paths than maxExpandedSources
+// in total fail with ErrTooManyMatches.
+func (s *FileManagerService) ExpandSources(sources []string) ([]string, error) {
+	expanded, err := s.expandSources(sources)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(expanded))
+	for i, source := range expanded {
+		paths[i] = source.path
+	}
+	return paths, nil
+}
+
+// expandedSource is a source of Copy or Move with the folder its tree is kept relative to:
+// the fixed folders leading the pattern it matched, or its own folder for a literal path
+type expandedSource struct {
+	path string
+	root string
+}
+
+// expandSources resolves glob patterns like ExpandSources, keeping the root of each match
+func (s *FileManagerService) expandSources(sources []string) ([]expandedSource, error) {
+	expanded := make([]expandedSource, 0, len(sources))
+
+	for _, src := range sources {
+		if !s.isPattern(src) {
+			expanded = append(expanded, expandedSource{path: src, root: filepath.Dir(utils.SanitizePath(src))})
+			continue
+		}
+
+		pattern, err := utils.ValidatePath(s.basePath, src)
+		if err != nil {
+			return nil, err
+		}
+
+		var matches []string
+		if s.isRemote {
+			matches, err = s.sftpClient.Glob(pattern)
+		} else {
+			matches, err = filepath.Glob(pattern)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", src, err)
+		}
+
+		root := utils.GlobRoot(src)
+		count := 0
+		for _, match := range matches {
+			relPath, err := utils.GetRelativePath(s.basePath, match)
+			if err != nil || relPath == "." {
+				continue
+			}
+			expanded = append(expanded, expandedSource{path: relPath, root: root})
+			count++
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNoMatches, src)
+		}
+		if maxExpandedSources > 0 && len(expanded) > maxExpandedSources {
+			return nil, fmt.Errorf("%w: more than %d paths, %s alone matches %d", ErrTooManyMatches, maxExpandedSources, src, count)
+		}
+	}
+
+	return expanded, nil
+}
+
+// treeTarget returns where source goes under destination when its tree is preserved: its
+// path relative to its root, joined to destination and validated like any requested path
+func (s *FileManagerService) treeTarget(destination string, source expandedSource) (string, error) {
+	rel, err := filepath.Rel(source.root, utils.SanitizePath(source.path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%w: %s is not below %s", utils.ErrInvalidPath, source.path, source.root)
+	}
+	return utils.ValidatePath(s.basePath, filepath.Join(utils.SanitizePath(destination), rel))
+}
+
+// Delete deletes a file or folder. A glob pattern deletes every match.
+func (s *FileManagerService) Delete(relativePath string, recursive bool) error {
+	if s.isPattern(relativePath) {
+		matches, err := s.ExpandSources([]string{relativePath})
+		if err != nil {
+			return err
+		}
+		for _, match := range matches {
+			if err := s.Delete(match, recursive); err != nil {
+				return fmt.Errorf("%s: %w", match, err)
+			}
+		}
+		return nil
+	}
+
+	utils.Debugf("Delete: relativePath=%s, basePath=%s", relativePath, s.basePath)
+
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		utils.Debugf("Delete: ValidatePath error: %v", err)
+		return err
+	}
+
+	utils.Debugf("Delete: fullPath=%s, isRemote=%v", fullPath, s.isRemote)
+
+	if s.isRemote {
+		return s.deleteRemote(fullPath, recursive)
+	}
+	return s.deleteLocal(fullPath, recursive)
+}
+
+// DeleteBatch deletes every path like Delete, in order. A path that cannot be deleted gets
+// an error instead of stopping the batch.
+func (s *FileManagerService) DeleteBatch(paths []string, recursive bool) []models.PathResult {
+	results := make([]models.PathResult, len(paths))
+	for i, p := range paths {
+		results[i].Path = p
+		if err := s.Delete(p, recursive); err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results
+}
+
+func (s *FileManagerService) deleteLocal(fullPath string, recursive bool) error {
+	if !utils.PathExists(fullPath) {
+		return ErrNotFound
+	}
 
 	if utils.IsDir(fullPath) {
 		if !recursive {
@@ -656,14 +1321,14 @@ func (s *FileManagerService) deleteLocal(fullPath string, recursive bool) error
 }
 
 func (s *FileManagerService) deleteRemote(fullPath string, recursive bool) error {
-	info, err := s.sftpClient.Stat(fullPath)
+	info, err := s.sftpStat(fullPath)
 	if err != nil {
 		return ErrNotFound
 	}
 
 	if info.IsDir() {
 		if !recursive {
-			entries, err := s.sftpClient.ReadDir(fullPath)
+			entries, err := s.sftpReadDir(fullPath)
 			if err != nil {
 				return err
 			}
@@ -672,14 +1337,60 @@ func (s *FileManagerService) deleteRemote(fullPath string, recursive bool) error
 			}
 			return s.sftpClient.RemoveDirectory(fullPath)
 		}
+		if s.removeTreeRemote(fullPath) == nil {
+			return nil
+		}
 		return s.removeAllRemote(fullPath)
 	}
 
 	return s.sftpClient.Remove(fullPath)
 }
 
+// remoteRmMinEntries is the number of top-level entries from which a remote
+// directory is removed with a single rm -rf instead of one SFTP call per entry
+const remoteRmMinEntries = 32
+
+// removeTreeRemote removes a large remote directory with rm -rf over SSH. It returns an error
+// without touching anything when the directory is small enough for removeAllRemote or the path
+// is unsafe to hand to the shell, and when the command fails; the caller then falls back to SFTP.
+func (s *FileManagerService) removeTreeRemote(fullPath string) error {
+	entries, err := s.sftpReadDir(fullPath)
+	if err != nil {
+		return err
+	}
+	large := len(entries) >= remoteRmMinEntries
+	for _, entry := range entries {
+		large = large || entry.IsDir()
+	}
+	if !large {
+		return errors.New("small directory")
+	}
+
+	cleaned := filepath.Clean(fullPath)
+	if !rmTreeAllowed(s.basePath, cleaned) {
+		return errors.New("path not allowed for rm -rf")
+	}
+	if err := s.runSSHCommand("rm -rf -- " + shellQuote(cleaned)); err != nil {
+		utils.Warnf("rm -rf of %s failed, removing over SFTP: %v", cleaned, err)
+		return err
+	}
+	return nil
+}
+
+// rmTreeAllowed reports whether rm -rf may be run on path: an absolute path strictly below
+// basePath, so neither the root nor the base path itself is ever handed to the shell.
+// fullPath was validated against basePath already; this guards against that ever changing.
+func rmTreeAllowed(basePath, path string) bool {
+	base := filepath.Clean(basePath)
+	cleaned := filepath.Clean(path)
+	if !filepath.IsAbs(cleaned) || cleaned == "/" || cleaned == base {
+		return false
+	}
+	return base == "/" || strings.HasPrefix(cleaned, base+string(filepath.Separator))
+}
+
 func (s *FileManagerService) removeAllRemote(path string) error {
-	entries, err := s.sftpClient.ReadDir(path)
+	entries, err := s.sftpReadDir(path)
 	if err != nil {
 		return err
 	}
@@ -700,112 +1411,311 @@ func (s *FileManagerService) removeAllRemote(path string) error {
 	return s.sftpClient.RemoveDirectory(path)
 }
 
-// Copy copies files/folders to destination
-func (s *FileManagerService) Copy(sources []string, destination string, overwrite bool) ([]models.FileInfo, error) {
+// CopyOptions controls how Copy places sources under the destination
+type CopyOptions struct {
+	Policy ConflictPolicy
+	// Preserve keeps the owner and group of the sources instead of handing copies to the usersite
+	Preserve bool
+	// Hardlink links local files instead of copying them where source and target share a
+	// filesystem; both names then share one file, changes included
+	Hardlink bool
+	// PreserveStructure recreates each source's path relative to Base under the destination
+	// instead of flattening all sources to their basenames
+	PreserveStructure bool
+	Base              string
+	// PreserveTree recreates the path of each source matched by a glob pattern relative to
+	// the fixed folders leading the pattern, e.g. a/x.log for logs/a/x.log matched by
+	// logs/*/*.log, so same-named files from different folders do not collide
+	PreserveTree bool
+}
+
+// pathExists checks if a path exists on the local or remote filesystem
+func (s *FileManagerService) pathExists(path string) bool {
+	if s.isRemote {
+		_, err := s.sftpStat(path)
+		return err == nil
+	}
+	return utils.PathExists(path)
+}
+
+// mkdirAllOwned creates dir and any missing parents, handing the newly created ones to the owner
+func (s *FileManagerService) mkdirAllOwned(dir string) error {
+	var created []string
+	for p := dir; p != filepath.Dir(p) && !s.pathExists(p); p = filepath.Dir(p) {
+		created = append(created, p)
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	if s.isRemote {
+		if err := s.sftpClient.MkdirAll(dir); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(dir, utils.DirMode()); err != nil {
+		return err
+	}
+
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := s.setOwner(created[i]); err != nil {
+			utils.Errorf("Failed to set owner for %s: %v", created[i], err)
+		}
+	}
+	return nil
+}
+
+// Copy copies files/folders to destination, applying opts.Policy to targets that already
+// exist, and reports what happened to each source. A source that fails is reported as failed
+// and the remaining sources are still copied. When ctx ends the copy stops, the item being
+// copied is removed unless it was merged into an existing folder, and ctx.Err() is returned.
+func (s *FileManagerService) Copy(ctx context.Context, sources []string, destination string, opts CopyOptions) ([]models.CopyResult, error) {
 	destPath, err := utils.ValidatePath(s.basePath, destination)
 	if err != nil {
 		return nil, err
 	}
 
+	var copyBase string
+	if opts.PreserveStructure {
+		copyBase, err = utils.ValidatePath(s.basePath, opts.Base)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	expanded, err := s.expandSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
 	if s.isRemote {
 		s.sftpClient.MkdirAll(destPath)
 	} else {
-		if err := os.MkdirAll(destPath, 0755); err != nil {
+		if err := os.MkdirAll(destPath, utils.DirMode()); err != nil {
 			return nil, err
 		}
 	}
 
-	var copied []models.FileInfo
+	// Local copies are chowned in batches once every item is in place,
+	// rather than with one chown process per item
+	var placed []placedItem
+	var ownFiles, ownDirs []string
+	chownCopies := func() {
+		if s.preserveOwner {
+			return
+		}
+		if err := utils.SudoChownPaths(ownFiles, s.owner, false); err != nil {
+			utils.Errorf("Failed to set owner for copied files: %v", err)
+		}
+		if err := utils.SudoChownPaths(ownDirs, s.owner, true); err != nil {
+			utils.Errorf("Failed to set owner for copied folders: %v", err)
+		}
+		ownFiles, ownDirs = nil, nil
+	}
+	defer chownCopies()
+	ownCopy := func(src, dst string, isDir bool) {
+		switch {
+		case opts.Preserve:
+			if err := s.copyOwnership(src, dst); err != nil {
+				utils.Errorf("Failed to keep owner for %s: %v", dst, err)
+			}
+		case s.isRemote:
+		case isDir:
+			ownDirs = append(ownDirs, dst)
+		default:
+			ownFiles = append(ownFiles, dst)
+		}
+	}
 
-	for _, src := range sources {
+	for _, source := range expanded {
+		src := source.path
 		srcPath, err := utils.ValidatePath(s.basePath, src)
 		if err != nil {
-			return nil, err
+			placed = append(placed, failedItem(src, err))
+			continue
 		}
 
 		var srcInfo os.FileInfo
 		if s.isRemote {
-			srcInfo, err = s.sftpClient.Stat(srcPath)
+			srcInfo, err = s.sftpStat(srcPath)
 		} else {
 			srcInfo, err = os.Stat(srcPath)
 		}
+		if errors.Is(err, os.ErrNotExist) {
+			err = ErrNotFound
+		}
 		if err != nil {
+			placed = append(placed, failedItem(src, err))
 			continue
 		}
 
 		dstItem := filepath.Join(destPath, srcInfo.Name())
 
-		if s.isRemote {
-			if _, err := s.sftpClient.Stat(dstItem); err == nil && !overwrite {
-				dstItem = utils.GenerateUniqueName(dstItem)
+		if opts.PreserveStructure {
+			rel, err := filepath.Rel(copyBase, srcPath)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				placed = append(placed, failedItem(src, fmt.Errorf("%w: %s", ErrOutsideCopyBase, src)))
+				continue
 			}
-		} else {
-			if utils.PathExists(dstItem) && !overwrite {
-				dstItem = utils.GenerateUniqueName(dstItem)
+			dstItem = filepath.Join(destPath, rel)
+			if err := s.mkdirAllOwned(filepath.Dir(dstItem)); err != nil {
+				placed = append(placed, failedItem(src, err))
+				continue
+			}
+		} else if opts.PreserveTree {
+			if dstItem, err = s.treeTarget(destination, source); err == nil {
+				err = s.mkdirAllOwned(filepath.Dir(dstItem))
+			}
+			if err != nil {
+				placed = append(placed, failedItem(src, err))
+				continue
 			}
 		}
 
-		if srcInfo.IsDir() {
-			if s.isRemote {
-				if err := s.copyDirRemote(srcPath, dstItem); err != nil {
-					return nil, err
-				}
-			} else {
-				if err := utils.CopyDir(srcPath, dstItem, true); err != nil {
-					return nil, err
+		action := ActionCopied
+		if s.pathExists(dstItem) {
+			switch {
+			case opts.Policy == ConflictAlways:
+				action = ActionOverwritten
+				if srcInfo.IsDir() {
+					action = ActionMerged
 				}
-				// Recursive set owner for copied folder
-				if err := s.setOwnerRecursive(dstItem); err != nil {
-					fmt.Printf("Failed to set owner for %s: %v\n", dstItem, err)
+			case opts.Policy.merges():
+				var counts mergeCounts
+				if err := s.mergeInto(ctx, srcPath, dstItem, opts.Policy, false, opts.Hardlink, &counts); err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					placed = append(placed, failedItem(src, err))
+					continue
 				}
+				ownCopy(srcPath, dstItem, srcInfo.IsDir())
+				placed = append(placed, mergedItem(srcPath, dstItem, srcInfo.IsDir(), counts))
+				continue
+			default:
+				dstItem = s.uniqueName(dstItem)
+				action = ActionRenamed
 			}
-		} else {
-			if s.isRemote {
-				if err := s.copyFileRemote(srcPath, dstItem); err != nil {
-					return nil, err
-				}
-			} else {
-				if err := utils.CopyFile(srcPath, dstItem, true); err != nil {
-					return nil, err
-				}
-				// Set owner for copied file
-				if err := s.setOwner(dstItem); err != nil {
-					fmt.Printf("Failed to set owner for %s: %v\n", dstItem, err)
-				}
+		}
+		existed := action != ActionCopied && action != ActionRenamed
+
+		if err := s.copyItem(ctx, srcPath, dstItem, srcInfo, opts.Hardlink); err != nil {
+			// A partial copy is of no use
+			if !existed {
+				s.removeFull(dstItem)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
+			placed = append(placed, failedItem(src, err))
+			continue
 		}
+		ownCopy(srcPath, dstItem, srcInfo.IsDir())
 
-		relPath, _ := utils.GetRelativePath(s.basePath, dstItem)
-		info, _ := s.GetInfo(relPath)
-		if info != nil {
-			copied = append(copied, *info)
+		placed = append(placed, placedItem{source: srcPath, target: dstItem, action: action})
+	}
+
+	chownCopies()
+
+	return s.copyResults(placed, "COPY_ERROR"), nil
+}
+
+// Duplicate copies a file or folder next to itself under a numbered name, e.g.
+// report_1.pdf for report.pdf, and returns the info of the copy
+func (s *FileManagerService) Duplicate(ctx context.Context, relativePath string) (*models.FileInfo, error) {
+	srcPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	// A copy of the base folder would land outside it
+	if srcPath == filepath.Clean(s.basePath) {
+		return nil, fmt.Errorf("%w: the base folder cannot be duplicated", utils.ErrInvalidPath)
+	}
+
+	srcInfo, err := s.statFull(srcPath)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	dstPath := s.uniqueName(srcPath)
+	if srcInfo.IsDir() {
+		if s.isRemote {
+			err = s.copyDirRemote(ctx, srcPath, dstPath)
+		} else {
+			err = utils.CopyDir(ctx, srcPath, dstPath, true)
+		}
+	} else {
+		if s.isRemote {
+			err = s.copyFileRemote(ctx, srcPath, dstPath)
+		} else {
+			err = utils.CopyFile(ctx, srcPath, dstPath, true)
+		}
+	}
+	if err != nil {
+		s.removeFull(dstPath)
+		return nil, err
+	}
+
+	if !s.isRemote && !s.preserveOwner {
+		if err := utils.SudoChownPaths([]string{dstPath}, s.owner, srcInfo.IsDir()); err != nil {
+			utils.Errorf("Failed to set owner for %s: %v", dstPath, err)
 		}
 	}
 
-	return copied, nil
+	infos := s.infoFor([]string{dstPath})
+	if len(infos) == 0 {
+		return nil, ErrNotFound
+	}
+	return &infos[0], nil
 }
 
-func (s *FileManagerService) copyFileRemote(src, dst string) error {
-	srcFile, err := s.sftpClient.Open(src)
+// copyFileRemote copies a file on the remote host, keeping its permissions and modification time
+func (s *FileManagerService) copyFileRemote(ctx context.Context, src, dst string) error {
+	srcFile, err := s.sftpOpen(src)
 	if err != nil {
 		return err
 	}
 	defer srcFile.Close()
 
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+
 	dstFile, err := s.sftpClient.Create(dst)
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
+	if _, err := io.CopyBuffer(dstFile, utils.ContextReader(ctx, srcFile), utils.NewBuffer()); err != nil {
+		dstFile.Close()
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+	return s.copyMetadataRemote(dst, srcInfo)
+}
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+// copyMetadataRemote gives the remote path dst the permissions and modification time of srcInfo
+func (s *FileManagerService) copyMetadataRemote(dst string, srcInfo os.FileInfo) error {
+	if err := s.sftpClient.Chmod(dst, srcInfo.Mode()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := s.sftpClient.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime()); err != nil {
+		return fmt.Errorf("failed to set timestamps: %w", err)
+	}
+	return nil
 }
 
-func (s *FileManagerService) copyDirRemote(src, dst string) error {
+// copyDirRemote copies a folder on the remote host recursively, keeping permissions and
+// modification times
+func (s *FileManagerService) copyDirRemote(ctx context.Context, src, dst string) error {
+	srcInfo, err := s.sftpStat(src)
+	if err != nil {
+		return err
+	}
 	s.sftpClient.MkdirAll(dst)
 	
-	entries, err := s.sftpClient.ReadDir(src)
+	entries, err := s.sftpReadDir(src)
 	if err != nil {
 		return err
 	}
@@ -815,20 +1725,70 @@ func (s *FileManagerService) copyDirRemote(src, dst string) error {
 		dstPath := filepath.Join(dst, entry.Name())
 
 		if entry.IsDir() {
-			if err := s.copyDirRemote(srcPath, dstPath); err != nil {
+			if err := s.copyDirRemote(ctx, srcPath, dstPath); err != nil {
 				return err
 			}
 		} else {
-			if err := s.copyFileRemote(srcPath, dstPath); err != nil {
+			if err := s.copyFileRemote(ctx, srcPath, dstPath); err != nil {
 				return err
 			}
 		}
 	}
-	return nil
+	// Set last, as copying the entries changes the folder's modification time
+	return s.copyMetadataRemote(dst, srcInfo)
+}
+
+// copyOwnership gives dst and everything below it the owner and group of the matching
+// path under src, for copies that keep the owner of their sources
+func (s *FileManagerService) copyOwnership(src, dst string) error {
+	if s.isRemote {
+		walker := s.sftpClient.Walk(src)
+		for walker.Step() {
+			if walker.Err() != nil {
+				continue
+			}
+			stat, ok := walker.Stat().Sys().(*sftp.FileStat)
+			if !ok {
+				continue
+			}
+			rel, err := filepath.Rel(src, walker.Path())
+			if err != nil {
+				return err
+			}
+			if err := s.sftpClient.Chown(filepath.Join(dst, rel), int(stat.UID), int(stat.GID)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(filepath.Join(dst, rel), int(stat.Uid), int(stat.Gid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
 }
 
 // runSSHCommandOutput executes a command on the remote server via SSH and returns output
 func (s *FileManagerService) runSSHCommandOutput(cmd string) ([]byte, error) {
+	return s.runSSHCommandOutputContext(context.Background(), cmd)
+}
+
+// runSSHCommandOutputContext is runSSHCommandOutput killing the command once ctx ends,
+// in which case ctx.Err() is returned
+func (s *FileManagerService) runSSHCommandOutputContext(ctx context.Context, cmd string) ([]byte, error) {
 	if s.sshClient == nil {
 		return nil, fmt.Errorf("SSH client not connected")
 	}
@@ -839,20 +1799,101 @@ func (s *FileManagerService) runSSHCommandOutput(cmd string) ([]byte, error) {
 	}
 	defer session.Close()
 
-	return session.CombinedOutput(cmd)
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Not every server honours signals; closing the session ends the wait either way
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-finished:
+		}
+	}()
+
+	output, err := session.CombinedOutput(cmd)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return output, err
+}
+
+// runSSHCommandStream executes a command on the remote server fed from stdin, when not nil,
+// calling fn with every token of its output as split splits it. The command is killed once
+// ctx ends, in which case ctx.Err() is returned; a failing command reports its stderr.
+func (s *FileManagerService) runSSHCommandStream(ctx context.Context, cmd string, stdin io.Reader, split bufio.SplitFunc, fn func(token string)) error {
+	if s.sshClient == nil {
+		return fmt.Errorf("SSH client not connected")
+	}
+
+	session, err := s.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %v", err)
+	}
+	defer session.Close()
+
+	var stderr bytes.Buffer
+	session.Stdin = stdin
+	session.Stderr = &stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		case <-finished:
+		}
+	}()
+
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(split)
+	for scanner.Scan() {
+		fn(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		// Nothing reads the rest of the output, so the command would never finish
+		session.Close()
+		return err
+	}
+
+	err = session.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
 }
 
-// GetDiskUsage calculates the total size of a file or directory
-func (s *FileManagerService) GetDiskUsage(relativePath string) (int64, error) {
+// GetDiskUsage calculates the total size of a file or directory, with symlinks inside it
+// left out or counted as their targets according to symlinks. It stops once ctx ends.
+func (s *FileManagerService) GetDiskUsage(ctx context.Context, relativePath string, symlinks utils.SymlinkPolicy) (int64, error) {
 	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
 	if err != nil {
 		return 0, err
 	}
 
 	if s.isRemote {
-		// Use du -sb for remote calculation (much faster than recursive sftp)
-		cmd := fmt.Sprintf("du -sb '%s' | awk '{print $1}'", fullPath)
-		output, err := s.runSSHCommandOutput(cmd)
+		// Use du -sb for remote calculation (much faster than recursive sftp); -L follows
+		// symlinks, counting each target once. Broken links only produce warnings.
+		flags := "-sb"
+		if symlinks == utils.SymlinksFollow {
+			flags = "-sbL"
+		}
+		cmd := fmt.Sprintf("du %s %s 2>/dev/null | awk '{print $1}'", flags, shellQuote(fullPath))
+		output, err := s.runSSHCommandOutputContext(ctx, cmd)
 		if err != nil {
 			return 0, fmt.Errorf("remote disk usage check failed: %v", err)
 		}
@@ -867,7 +1908,48 @@ func (s *FileManagerService) GetDiskUsage(relativePath string) (int64, error) {
 	}
 
 	// Local calculation
-	return utils.GetDirectorySize(fullPath)
+	return utils.GetDirectorySize(ctx, fullPath, symlinks)
+}
+
+// GetFilesystemStats returns capacity of the filesystem backing a path
+func (s *FileManagerService) GetFilesystemStats(relativePath string) (*models.FilesystemStats, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var total, free, used int64
+
+	if s.isRemote {
+		// POSIX output format with 1K blocks: Filesystem 1024-blocks Used Available Capacity Mounted
+		cmd := fmt.Sprintf("df -Pk %s | awk 'NR==2 {print $2, $3, $4}'", shellQuote(fullPath))
+		output, err := s.runSSHCommandOutput(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("remote filesystem check failed: %v", err)
+		}
+
+		fields := strings.Fields(string(output))
+		if len(fields) != 3 || !isNumeric(fields[0]) || !isNumeric(fields[1]) || !isNumeric(fields[2]) {
+			return nil, fmt.Errorf("unexpected output from df: %s", strings.TrimSpace(string(output)))
+		}
+
+		total, _ = strconv.ParseInt(fields[0], 10, 64)
+		used, _ = strconv.ParseInt(fields[1], 10, 64)
+		free, _ = strconv.ParseInt(fields[2], 10, 64)
+		total, used, free = total*1024, used*1024, free*1024
+	} else {
+		total, free, used, err = utils.GetFilesystemStats(fullPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.FilesystemStats{
+		TotalBytes:  total,
+		FreeBytes:   free,
+		UsedBytes:   used,
+		UsedPercent: utils.UsedPercent(used, free),
+	}, nil
 }
 
 func isNumeric(s string) bool {
@@ -878,93 +1960,218 @@ func isNumeric(s string) bool {
 
 
 
-// Move moves files/folders to destination
-func (s *FileManagerService) Move(sources []string, destination string, overwrite bool) ([]models.FileInfo, error) {
+// MoveProgressFunc receives the bytes copied so far and the bytes to copy in total for
+// sources that could not be renamed in place (e.g. moves across filesystems)
+type MoveProgressFunc func(copied, total int64)
+
+// copyVerified copies src to dst with progress and checks that every byte arrived
+func copyVerified(ctx context.Context, src, dst string, srcInfo os.FileInfo, size int64, progress func(copied int64)) error {
+	if srcInfo.IsDir() {
+		copied, err := utils.CopyDirWithProgress(ctx, src, dst, progress)
+		if err != nil {
+			return err
+		}
+		if copied != size {
+			return fmt.Errorf("%w: copied %d of %d bytes from %s", ErrCopyVerification, copied, size, src)
+		}
+		return nil
+	}
+
+	err := utils.CopyFileWithProgress(ctx, src, dst, func(written, total int64) {
+		progress(written)
+	})
+	if err != nil {
+		return err
+	}
+	os.Chmod(dst, srcInfo.Mode())
+	os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if dstInfo.Size() != size {
+		return fmt.Errorf("%w: copied %d of %d bytes from %s", ErrCopyVerification, dstInfo.Size(), size, src)
+	}
+	return nil
+}
+
+// Move moves files/folders to destination, applying policy to targets that already exist,
+// and reports what happened to each source. A source that fails is reported as failed and the
+// remaining sources are still moved. Sources that have to be copied are only removed once
+// copied; when ctx ends first the partial copy is removed and ctx.Err() returned.
+// Files a merging policy skips stay where they were, as do the folders holding them.
+// With preserveTree, sources matched by a glob pattern keep their path relative to the
+// fixed folders leading the pattern, as with CopyOptions.PreserveTree.
+func (s *FileManagerService) Move(ctx context.Context, sources []string, destination string, policy ConflictPolicy, preserveTree bool, progress MoveProgressFunc) ([]models.CopyResult, error) {
 	destPath, err := utils.ValidatePath(s.basePath, destination)
 	if err != nil {
 		return nil, err
 	}
 
+	expanded, err := s.expandSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
 	if s.isRemote {
 		s.sftpClient.MkdirAll(destPath)
 	} else {
-		if err := os.MkdirAll(destPath, 0755); err != nil {
+		if err := os.MkdirAll(destPath, utils.DirMode()); err != nil {
 			return nil, err
 		}
 	}
 
-	var moved []models.FileInfo
+	overwrite := policy == ConflictAlways
+	var placed []placedItem
+	var copiedBefore, total int64
 
-	for _, src := range sources {
+	// moveItem renames srcPath to dstItem, or copies it there and removes it where a rename is
+	// not possible. A failed copy is removed unless it went into something that already existed.
+	moveItem := func(srcPath, dstItem string, srcInfo os.FileInfo) error {
+		if s.isRemote {
+			if err := s.sftpRename(srcPath, dstItem); err != nil {
+				// Fallback to copy + delete
+				existed := s.pathExists(dstItem)
+				if srcInfo.IsDir() {
+					err = s.copyDirRemote(ctx, srcPath, dstItem)
+				} else {
+					err = s.copyFileRemote(ctx, srcPath, dstItem)
+				}
+				if err != nil {
+					if !existed {
+						s.removeFull(dstItem)
+					}
+					return err
+				}
+				if srcInfo.IsDir() {
+					s.removeAllRemote(srcPath)
+				} else {
+					s.sftpClient.Remove(srcPath)
+				}
+			}
+			return nil
+		}
+
+		if err := os.Rename(srcPath, dstItem); err != nil {
+			// Only a cross-device move (or merging into an existing folder) can be done by copying
+			if !errors.Is(err, syscall.EXDEV) && !overwrite {
+				return err
+			}
+
+			// Linked files are copied as their content, so their targets count towards the size
+			size := srcInfo.Size()
+			if srcInfo.IsDir() {
+				if size, err = utils.GetDirectorySize(ctx, srcPath, utils.SymlinksFollow); err != nil {
+					return err
+				}
+			}
+			total += size
+
+			existed := utils.PathExists(dstItem)
+			err := copyVerified(ctx, srcPath, dstItem, srcInfo, size, func(copied int64) {
+				if progress != nil {
+					progress(copiedBefore+copied, total)
+				}
+			})
+			if err != nil {
+				if !existed {
+					os.RemoveAll(dstItem)
+				}
+				return err
+			}
+			copiedBefore += size
+
+			// The source is only removed once the copy is known to be complete
+			if err := os.RemoveAll(srcPath); err != nil {
+				return fmt.Errorf("copied, but the source could not be removed: %w", err)
+			}
+		}
+
+		// Enforce ownership
+		if srcInfo.IsDir() {
+			s.setOwnerRecursive(dstItem)
+		} else {
+			s.setOwner(dstItem)
+		}
+		return nil
+	}
+
+	for _, source := range expanded {
+		src := source.path
 		srcPath, err := utils.ValidatePath(s.basePath, src)
 		if err != nil {
-			return nil, err
+			placed = append(placed, failedItem(src, err))
+			continue
 		}
 
 		var srcInfo os.FileInfo
 		if s.isRemote {
-			srcInfo, err = s.sftpClient.Stat(srcPath)
+			srcInfo, err = s.sftpStat(srcPath)
 		} else {
 			srcInfo, err = os.Stat(srcPath)
 		}
+		if errors.Is(err, os.ErrNotExist) {
+			err = ErrNotFound
+		}
 		if err != nil {
+			placed = append(placed, failedItem(src, err))
 			continue
 		}
 
 		dstItem := filepath.Join(destPath, srcInfo.Name())
-
-		if s.isRemote {
-			if _, err := s.sftpClient.Stat(dstItem); err == nil && !overwrite {
-				dstItem = utils.GenerateUniqueName(dstItem)
-			}
-			if err := s.sftpClient.Rename(srcPath, dstItem); err != nil {
-				// Fallback to copy + delete
-				if srcInfo.IsDir() {
-					if err := s.copyDirRemote(srcPath, dstItem); err != nil {
-						return nil, err
-					}
-					s.removeAllRemote(srcPath)
-				} else {
-					if err := s.copyFileRemote(srcPath, dstItem); err != nil {
-						return nil, err
-					}
-					s.sftpClient.Remove(srcPath)
-				}
+		if preserveTree {
+			if dstItem, err = s.treeTarget(destination, source); err == nil {
+				err = s.mkdirAllOwned(filepath.Dir(dstItem))
 			}
-		} else {
-			if utils.PathExists(dstItem) && !overwrite {
-				dstItem = utils.GenerateUniqueName(dstItem)
+			if err != nil {
+				placed = append(placed, failedItem(src, err))
+				continue
 			}
-			if err := os.Rename(srcPath, dstItem); err != nil {
+		}
+
+		action := ActionMoved
+		if s.pathExists(dstItem) {
+			switch {
+			case overwrite:
+				action = ActionOverwritten
 				if srcInfo.IsDir() {
-					if err := utils.CopyDir(srcPath, dstItem, true); err != nil {
-						return nil, err
-					}
-					os.RemoveAll(srcPath)
-					s.setOwnerRecursive(dstItem)
-				} else {
-					if err := utils.CopyFile(srcPath, dstItem, true); err != nil {
-						return nil, err
+					action = ActionMerged
+				}
+			case policy.merges():
+				var counts mergeCounts
+				if err := s.mergeInto(ctx, srcPath, dstItem, policy, true, false, &counts); err != nil {
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
 					}
-					os.Remove(srcPath)
-					s.setOwner(dstItem)
+					placed = append(placed, failedItem(src, err))
+					continue
 				}
-			} else {
-				// Rename successful, enforce ownership
-				if srcInfo.IsDir() {
-					s.setOwnerRecursive(dstItem)
-				} else {
-					s.setOwner(dstItem)
+				if !s.isRemote {
+					if srcInfo.IsDir() {
+						s.setOwnerRecursive(dstItem)
+					} else {
+						s.setOwner(dstItem)
+					}
 				}
+				placed = append(placed, mergedItem(srcPath, dstItem, srcInfo.IsDir(), counts))
+				continue
+			default:
+				dstItem = s.uniqueName(dstItem)
+				action = ActionRenamed
 			}
 		}
 
-		relPath, _ := utils.GetRelativePath(s.basePath, dstItem)
-		info, _ := s.GetInfo(relPath)
-		if info != nil {
-			moved = append(moved, *info)
+		if err := moveItem(srcPath, dstItem, srcInfo); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			placed = append(placed, failedItem(src, err))
+			continue
 		}
+
+		placed = append(placed, placedItem{source: srcPath, target: dstItem, action: action})
 	}
 
-	return moved, nil
+	return s.copyResults(placed, "MOVE_ERROR"), nil
 }
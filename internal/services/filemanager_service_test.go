@@ -0,0 +1,414 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestService returns a local service below a fresh base path that leaves ownership alone
+func newTestService(t testing.TB) (*FileManagerService, string) {
+	t.Helper()
+	base := t.TempDir()
+	svc := NewFileManagerService(base, "")
+	svc.SetPreserveOwner(true)
+	return svc, base
+}
+
+// newTestRemoteService returns a remote service below a fresh base path whose SFTP client
+// talks to an in-process server on the local filesystem, for operations that need no shell
+func newTestRemoteService(t testing.TB) (*FileManagerService, string) {
+	t.Helper()
+	base := t.TempDir()
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return &FileManagerService{basePath: base, sftpClient: client, isRemote: true, uid: -1, gid: -1, preserveOwner: true}, base
+}
+
+// writeFiles creates the files at the relative paths below base, with their folders
+func writeFiles(t *testing.T, base string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		full := filepath.Join(base, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpandSources(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base,
+		"logs/a.log", "logs/b.log", "logs/notes.txt",
+		"logs/2024/jan.log", "logs/2025/feb.log", "logs/2025/keep.txt",
+		"data/report[1].pdf", "data/report1.pdf",
+		"mixed/one.d/x", "mixed/two.d", "mixed/three.txt",
+	)
+	if err := os.MkdirAll(filepath.Join(base, "mixed/four.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		sources []string
+		want    []string
+		wantErr error
+	}{
+		{"literal", []string{"logs/a.log"}, []string{"logs/a.log"}, nil},
+		{"single level", []string{"logs/*.log"}, []string{"logs/a.log", "logs/b.log"}, nil},
+		{"nested", []string{"logs/*/*.log"}, []string{"logs/2024/jan.log", "logs/2025/feb.log"}, nil},
+		{"glob in the middle", []string{"logs/202?/*"}, []string{"logs/2024/jan.log", "logs/2025/feb.log", "logs/2025/keep.txt"}, nil},
+		{"files and folders", []string{"mixed/*.d"}, []string{"mixed/four.d", "mixed/one.d", "mixed/two.d"}, nil},
+		{"existing name with brackets", []string{"data/report[1].pdf"}, []string{"data/report[1].pdf"}, nil},
+		{"character class", []string{"data/report[0-9].pdf"}, []string{"data/report1.pdf"}, nil},
+		{"several patterns", []string{"logs/*.txt", "logs/2025/*.txt"}, []string{"logs/2025/keep.txt", "logs/notes.txt"}, nil},
+		{"no matches", []string{"logs/*.gz"}, nil, ErrNoMatches},
+		{"outside base", []string{"../*"}, nil, utils.ErrPathTraversal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := svc.ExpandSources(tt.sources)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ExpandSources(%v) error = %v, want %v", tt.sources, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandSources(%v) = %v", tt.sources, err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ExpandSources(%v) = %v, want %v", tt.sources, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandSourcesLimit(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base, "a/1", "a/2", "a/3")
+
+	defer ConfigureMaxExpandedSources(maxExpandedSources)
+	ConfigureMaxExpandedSources(2)
+
+	if _, err := svc.ExpandSources([]string{"a/*"}); !errors.Is(err, ErrTooManyMatches) {
+		t.Fatalf("three matches with a limit of 2: error = %v, want ErrTooManyMatches", err)
+	}
+	if _, err := svc.ExpandSources([]string{"a/1", "a/[23]"}); !errors.Is(err, ErrTooManyMatches) {
+		t.Fatalf("literal and pattern over the limit: error = %v, want ErrTooManyMatches", err)
+	}
+	if err := svc.Delete("a/*", false); !errors.Is(err, ErrTooManyMatches) {
+		t.Fatalf("Delete over the limit: error = %v, want ErrTooManyMatches", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "a/1")); err != nil {
+		t.Fatal("a refused delete removed files")
+	}
+	if got, err := svc.ExpandSources([]string{"a/[12]"}); err != nil || len(got) != 2 {
+		t.Fatalf("two matches with a limit of 2 = %v, %v", got, err)
+	}
+}
+
+func TestDeleteGlob(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base, "d/report[1].pdf", "d/report1.pdf", "d/x.log", "d/sub/y.log", "d/keep.txt")
+
+	// The literal name is deleted, not the file the pattern would match
+	if err := svc.Delete("d/report[1].pdf", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "d/report1.pdf")); err != nil {
+		t.Fatal("deleting report[1].pdf removed report1.pdf")
+	}
+	if _, err := os.Stat(filepath.Join(base, "d/report[1].pdf")); !os.IsNotExist(err) {
+		t.Fatal("report[1].pdf was not deleted")
+	}
+
+	if err := svc.Delete("d/*/*.log", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Delete("d/*.log", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"d/x.log", "d/sub/y.log"} {
+		if _, err := os.Stat(filepath.Join(base, p)); !os.IsNotExist(err) {
+			t.Fatalf("%s was not deleted", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, "d/keep.txt")); err != nil {
+		t.Fatal("keep.txt was deleted")
+	}
+}
+
+func TestCopyGlobFilesAndFolders(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base, "src/a.d/inner.txt", "src/b.d", "src/c.txt")
+
+	results, err := svc.Copy(context.Background(), []string{"src/*.d"}, "dst", CopyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("copied %d sources, want 2", len(results))
+	}
+	for _, p := range []string{"dst/a.d/inner.txt", "dst/b.d"} {
+		if _, err := os.Stat(filepath.Join(base, p)); err != nil {
+			t.Fatalf("%s missing after copy: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(base, "dst/c.txt")); !os.IsNotExist(err) {
+		t.Fatal("c.txt was copied although it does not match")
+	}
+}
+
+func TestDeleteNonRecursiveFolderNotEmpty(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base, "full/a.txt")
+	if err := os.MkdirAll(filepath.Join(base, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Delete("full", false); !errors.Is(err, ErrFolderNotEmpty) {
+		t.Fatalf("Delete of a non-empty folder without recursive = %v, want ErrFolderNotEmpty", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "full/a.txt")); err != nil {
+		t.Fatal("a refused delete removed the folder's content")
+	}
+	if err := svc.Delete("empty", false); err != nil {
+		t.Fatalf("Delete of an empty folder without recursive = %v", err)
+	}
+	if err := svc.Delete("full", true); err != nil {
+		t.Fatalf("recursive Delete = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(base, "full")); !os.IsNotExist(err) {
+		t.Fatal("recursive delete kept the folder")
+	}
+}
+
+func TestRmTreeAllowed(t *testing.T) {
+	tests := []struct {
+		base, path string
+		allowed    bool
+	}{
+		{"/home/u1", "/home/u1/dir", true},
+		{"/home/u1", "/home/u1/a/b/", true},
+		{"/home/u1", "/home/u1/it's", true},
+		{"/", "/srv", true},
+		{"/home/u1", "/home/u1", false},
+		{"/home/u1", "/home/u1/", false},
+		{"/home/u1", "/home/u1/sub/..", false},
+		{"/home/u1", "/", false},
+		{"/", "/", false},
+		{"/home/u1", "/home/u10/dir", false},
+		{"/home/u1", "/home", false},
+		{"/home/u1", "relative/dir", false},
+	}
+	for _, tt := range tests {
+		if got := rmTreeAllowed(tt.base, tt.path); got != tt.allowed {
+			t.Errorf("rmTreeAllowed(%q, %q) = %v, want %v", tt.base, tt.path, got, tt.allowed)
+		}
+	}
+}
+
+func TestCopyPreserveStructureSameNames(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base, "site/a/index.html", "site/b/index.html", "site/b/deep/index.html")
+
+	results, err := svc.Copy(context.Background(),
+		[]string{"site/a/index.html", "site/b/index.html", "site/b/deep/index.html"}, "backup",
+		CopyOptions{PreserveStructure: true, Base: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Failed() || r.Action != ActionCopied {
+			t.Fatalf("%s: action %s, error %q, want copied", r.Source, r.Action, r.Error)
+		}
+	}
+	// Each copy keeps the content of its own source rather than one overwriting or renaming the other
+	for _, p := range []string{"a/index.html", "b/index.html", "b/deep/index.html"} {
+		data, err := os.ReadFile(filepath.Join(base, "backup", p))
+		if err != nil {
+			t.Fatalf("backup/%s missing: %v", p, err)
+		}
+		if want := "site/" + p; string(data) != want {
+			t.Fatalf("backup/%s holds %q, want %q", p, data, want)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(base, "backup")); len(entries) != 2 {
+		t.Fatalf("backup holds %d entries, want the folders a and b only", len(entries))
+	}
+
+	// Without it the second file of the same name is renamed next to the first
+	results, err = svc.Copy(context.Background(), []string{"site/a/index.html", "site/b/index.html"}, "flat", CopyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[1].Action != ActionRenamed {
+		t.Fatalf("flattened copy of two index.html files = %+v, want the second renamed", results)
+	}
+}
+
+func TestCopyPreserveStructureOutsideBase(t *testing.T) {
+	svc, base := newTestService(t)
+	writeFiles(t, base, "site/a/index.html", "other/index.html")
+
+	results, err := svc.Copy(context.Background(), []string{"other/index.html", "site/a/index.html"}, "backup",
+		CopyOptions{PreserveStructure: true, Base: "site"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Code != "OUTSIDE_COPY_BASE" {
+		t.Fatalf("source outside base = %+v, want OUTSIDE_COPY_BASE", results[0])
+	}
+	if results[1].Failed() {
+		t.Fatalf("source inside base failed: %s", results[1].Error)
+	}
+}
+
+func TestGetInfoSniffsMimeType(t *testing.T) {
+	svc, base := newTestService(t)
+	files := map[string]string{
+		"photo.dat": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"deploy":    "#!/bin/bash\necho deploy\n",
+		"manage":    "#!/usr/bin/env python3\n",
+		"style.css": "body { color: red }",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(base, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"photo.dat": "image/png",
+		"deploy":    "text/x-shellscript",
+		"manage":    "text/x-python",
+		"style.css": "text/css; charset=utf-8",
+	} {
+		info, err := svc.GetInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.MimeType != want {
+			t.Errorf("GetInfo(%s).MimeType = %q, want %q", name, info.MimeType, want)
+		}
+	}
+}
+
+func TestCopyKeepsModeAndModTime(t *testing.T) {
+	fileTime := time.Date(2020, 5, 17, 8, 30, 0, 0, time.UTC)
+	dirTime := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for name, newService := range map[string]func(testing.TB) (*FileManagerService, string){
+		"local":  newTestService,
+		"remote": newTestRemoteService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			svc, base := newService(t)
+			writeFiles(t, base, "src/run.sh", "src/dir/inner.txt")
+			for p, mode := range map[string]os.FileMode{"src/run.sh": 0750, "src/dir/inner.txt": 0600, "src/dir": 0710} {
+				if err := os.Chmod(filepath.Join(base, p), mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			os.Chtimes(filepath.Join(base, "src/run.sh"), fileTime, fileTime)
+			os.Chtimes(filepath.Join(base, "src/dir/inner.txt"), fileTime, fileTime)
+			os.Chtimes(filepath.Join(base, "src/dir"), dirTime, dirTime)
+
+			results, err := svc.Copy(context.Background(), []string{"src/run.sh", "src/dir"}, "dst", CopyOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range results {
+				if r.Failed() {
+					t.Fatalf("%s: %s", r.Source, r.Error)
+				}
+			}
+
+			tests := []struct {
+				path    string
+				mode    os.FileMode
+				modTime time.Time
+			}{
+				{"dst/run.sh", 0750, fileTime},
+				{"dst/dir/inner.txt", 0600, fileTime},
+				{"dst/dir", 0710, dirTime},
+			}
+			for _, tt := range tests {
+				info, err := os.Stat(filepath.Join(base, tt.path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != tt.mode {
+					t.Errorf("%s mode = %v, want %v", tt.path, info.Mode().Perm(), tt.mode)
+				}
+				if !info.ModTime().Equal(tt.modTime) {
+					t.Errorf("%s modified %v, want %v", tt.path, info.ModTime().UTC(), tt.modTime)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCopyHardlink copies one large file and a folder of many small files with a plain
+// copy, which clones the data on filesystems that support it, and with hardlinks
+func BenchmarkCopyHardlink(b *testing.B) {
+	svc, base := newTestService(b)
+	if err := os.WriteFile(filepath.Join(base, "large.bin"), make([]byte, 32<<20), 0644); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(base, "small"), 0755); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if err := os.WriteFile(filepath.Join(base, "small", fmt.Sprintf("%03d.txt", i)), make([]byte, 4096), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, source := range []string{"large.bin", "small"} {
+		for _, hardlink := range []bool{false, true} {
+			name := source + "/copy"
+			if hardlink {
+				name = source + "/hardlink"
+			}
+			b.Run(name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					results, err := svc.Copy(context.Background(), []string{source}, "dst", CopyOptions{Hardlink: hardlink})
+					if err != nil || results[0].Failed() {
+						b.Fatalf("copy: %v %+v", err, results)
+					}
+					b.StopTimer()
+					os.RemoveAll(filepath.Join(base, "dst"))
+					b.StartTimer()
+				}
+			})
+		}
+	}
+}
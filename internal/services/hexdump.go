@@ -0,0 +1,91 @@
+package services
+
+import (
+	"encoding/hex"
+	"filemanager-api/internal/models"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// hexDumpWidth is the number of bytes per line of a hex dump
+const hexDumpWidth = 16
+
+// HexDump reads length bytes of a file from offset, fewer at the end of the file, and returns
+// them as hex and as a dump in the layout of `hexdump -C`. Only that slice is read: the file
+// is seeked to offset, locally and over SFTP; encrypted uploads are decrypted up to it.
+func (s *FileManagerService) HexDump(relativePath string, offset int64, length int) (*models.HexDump, error) {
+	if length < 1 {
+		length = 1
+	}
+
+	reader, info, err := s.GetContent(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if offset < 0 || offset > info.Size {
+		return nil, fmt.Errorf("%w: %d (file size %d)", ErrInvalidOffset, offset, info.Size)
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(io.Discard, reader, offset); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	n, err := io.ReadFull(reader, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	data = data[:n]
+
+	return &models.HexDump{
+		Path:     info.Path,
+		MimeType: info.MimeType,
+		Size:     info.Size,
+		Offset:   offset,
+		Length:   n,
+		Hex:      hex.EncodeToString(data),
+		Dump:     hexDumpLines(data, offset),
+	}, nil
+}
+
+// hexDumpLines formats data like `hexdump -C`, numbering the lines from offset: the offset,
+// sixteen bytes in hex in two groups of eight, the printable ASCII characters, and a last
+// line with the offset past the end
+func hexDumpLines(data []byte, offset int64) string {
+	var b strings.Builder
+	for start := 0; start < len(data); start += hexDumpWidth {
+		end := start + hexDumpWidth
+		if end > len(data) {
+			end = len(data)
+		}
+		line := data[start:end]
+
+		fmt.Fprintf(&b, "%08x  ", offset+int64(start))
+		for i := 0; i < hexDumpWidth; i++ {
+			if i < len(line) {
+				fmt.Fprintf(&b, "%02x ", line[i])
+			} else {
+				b.WriteString("   ")
+			}
+			if i == hexDumpWidth/2-1 {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(" |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+	}
+	fmt.Fprintf(&b, "%08x\n", offset+int64(len(data)))
+	return b.String()
+}
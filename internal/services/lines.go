@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// maxLineRangeBytes is the largest line range ReadLines returns
+const maxLineRangeBytes = 10 * 1024 * 1024
+
+// ErrInvalidRange is returned for a line range that is empty or outside the file
+var ErrInvalidRange = errors.New("invalid line range")
+
+// ReadLines returns lines start to end (1-indexed, inclusive) of a text file.
+// An end of 0 or past the last line reads to the end of the file.
+func (s *FileManagerService) ReadLines(relativePath string, start, end int) (*models.LineRange, error) {
+	if start < 1 || (end != 0 && end < start) {
+		return nil, fmt.Errorf("%w: start must be at least 1 and end at least start", ErrInvalidRange)
+	}
+
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.statFull(fullPath)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if info.IsDir() {
+		return nil, ErrNotAFile
+	}
+
+	reader, _, _, err := s.openContent(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var content bytes.Buffer
+	total, err := eachLine(reader, func(line int, piece []byte) error {
+		if line < start || (end != 0 && line > end) {
+			return nil
+		}
+		if content.Len()+len(piece) > maxLineRangeBytes {
+			return fmt.Errorf("%w: the range exceeds %d bytes", ErrFileTooLarge, maxLineRangeBytes)
+		}
+		content.Write(piece)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if start > total {
+		return nil, fmt.Errorf("%w: start %d is past the last line %d", ErrInvalidRange, start, total)
+	}
+	if end == 0 || end > total {
+		end = total
+	}
+
+	relPath, _ := utils.GetRelativePath(s.basePath, fullPath)
+	return &models.LineRange{
+		Path:       relPath,
+		Start:      start,
+		End:        end,
+		TotalLines: total,
+		Content:    content.String(),
+	}, nil
+}
+
+// WriteLines replaces lines start to end (1-indexed, inclusive) of a text file with content,
+// streaming the rest of the file into a temp file that is renamed into place. Empty content
+// deletes the lines. A newline is added to content when lines follow it.
+func (s *FileManagerService) WriteLines(relativePath string, start, end int, content string) (*models.LineRange, error) {
+	if start < 1 || end < start {
+		return nil, fmt.Errorf("%w: start must be at least 1 and end at least start", ErrInvalidRange)
+	}
+
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	// Renaming over a symlink would replace the link itself
+	info, err := s.lstatFull(fullPath)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if !info.Mode().IsRegular() {
+		return nil, ErrNotAFile
+	}
+
+	reader, _, encrypted, err := s.openContent(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Encrypted files stay encrypted
+	staging := stagingPath(fullPath, uuid.New().String())
+	file, err := s.createContent(staging, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*models.LineRange, error) {
+		file.Close()
+		s.removeFull(staging)
+		return nil, err
+	}
+
+	out := bufio.NewWriterSize(file, utils.DefaultBufferSize)
+	written := false
+	total, err := eachLine(reader, func(line int, piece []byte) error {
+		if line >= start && line <= end {
+			return nil
+		}
+		if line > end && !written {
+			written = true
+			if content != "" && !strings.HasSuffix(content, "\n") {
+				content += "\n"
+			}
+			if _, err := out.WriteString(content); err != nil {
+				return err
+			}
+		}
+		_, err := out.Write(piece)
+		return err
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if end > total {
+		return fail(fmt.Errorf("%w: end %d is past the last line %d", ErrInvalidRange, end, total))
+	}
+	if !written {
+		if _, err := out.WriteString(content); err != nil {
+			return fail(err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		s.removeFull(staging)
+		return nil, err
+	}
+
+	if err := s.chmodFull(staging, info.Mode().Perm()); err != nil {
+		s.removeFull(staging)
+		return nil, err
+	}
+	if err := s.replaceFull(staging, fullPath); err != nil {
+		s.removeFull(staging)
+		return nil, err
+	}
+	if err := s.setOwner(fullPath); err != nil {
+		utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
+	}
+
+	lines := countLines(content)
+	relPath, _ := utils.GetRelativePath(s.basePath, fullPath)
+	return &models.LineRange{
+		Path:       relPath,
+		Start:      start,
+		End:        start + lines - 1,
+		TotalLines: total - (end - start + 1) + lines,
+	}, nil
+}
+
+// eachLine calls fn with every line of r including its newline, in several pieces for lines
+// longer than the read buffer, and returns the number of lines. A last line without a
+// newline counts; content with NUL bytes is rejected as binary.
+func eachLine(r io.Reader, fn func(line int, piece []byte) error) (int, error) {
+	reader := bufio.NewReaderSize(r, utils.DefaultBufferSize)
+	line, partial := 1, false
+	for {
+		piece, err := reader.ReadSlice('\n')
+		if len(piece) > 0 {
+			if bytes.IndexByte(piece, 0) >= 0 {
+				return 0, ErrBinaryFile
+			}
+			if ferr := fn(line, piece); ferr != nil {
+				return 0, ferr
+			}
+			partial = piece[len(piece)-1] != '\n'
+			if !partial {
+				line++
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF:
+			if partial {
+				return line, nil
+			}
+			return line - 1, nil
+		case err != nil:
+			return 0, err
+		}
+	}
+}
+
+// countLines returns the number of lines in content, counting a last line without a newline
+func countLines(content string) int {
+	lines := strings.Count(content, "\n")
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		lines++
+	}
+	return lines
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"filemanager-api/internal/models"
+	"strings"
+)
+
+var (
+	// ErrInvalidFilter is returned for a list filter with an unknown type
+	ErrInvalidFilter = errors.New("type must be file or dir")
+	// ErrInvalidHiddenFilter is returned for a list filter with an unknown hidden mode
+	ErrInvalidHiddenFilter = errors.New("hidden must be include, exclude or only")
+)
+
+// ListFilter selects the entries a listing returns; empty fields match everything
+type ListFilter struct {
+	Type       string   // "file" or "dir"
+	Extensions []string // lowercase, without the dot
+	MimeTypes  []string // e.g. "application/pdf", or "image/*" for every subtype
+	Hidden     string   // "exclude" or "only" for entries whose name starts with a dot
+}
+
+// ParseListFilter builds a filter from comma-separated type, ext and mime query values and
+// a hidden mode of include (the default), exclude or only
+func ParseListFilter(typ, ext, mime, hidden string) (ListFilter, error) {
+	filter := ListFilter{Type: strings.ToLower(typ)}
+	if filter.Type != "" && filter.Type != "file" && filter.Type != "dir" {
+		return ListFilter{}, ErrInvalidFilter
+	}
+	switch hidden = strings.ToLower(hidden); hidden {
+	case "", "include":
+	case "exclude", "only":
+		filter.Hidden = hidden
+	default:
+		return ListFilter{}, ErrInvalidHiddenFilter
+	}
+	for _, e := range splitList(ext) {
+		filter.Extensions = append(filter.Extensions, strings.ToLower(strings.TrimPrefix(e, ".")))
+	}
+	filter.MimeTypes = splitList(mime)
+	return filter, nil
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Match reports whether item passes the filter. Extension and MIME filters only match files.
+func (f ListFilter) Match(item models.FileInfo) bool {
+	if (f.Type == "file" && item.IsDir) || (f.Type == "dir" && !item.IsDir) {
+		return false
+	}
+	if f.Hidden != "" && strings.HasPrefix(item.Name, ".") != (f.Hidden == "only") {
+		return false
+	}
+	if len(f.Extensions) == 0 && len(f.MimeTypes) == 0 {
+		return true
+	}
+	if item.IsDir {
+		return false
+	}
+
+	if len(f.Extensions) > 0 {
+		ext := strings.ToLower(item.Extension)
+		found := false
+		for _, e := range f.Extensions {
+			if e == ext {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(f.MimeTypes) == 0 || matchMimeType(f.MimeTypes, item.MimeType)
+}
+
+// matchMimeType reports whether mimeType, ignoring parameters such as charset, is one of
+// patterns, where "image/*" matches every image subtype
+func matchMimeType(patterns []string, mimeType string) bool {
+	if idx := strings.IndexByte(mimeType, ';'); idx >= 0 {
+		mimeType = mimeType[:idx]
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, mimeType) {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimeType, strings.ToLower(strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
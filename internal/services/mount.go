@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bufio"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mountsFile lists the mounts of the host, local or remote
+const mountsFile = "/proc/mounts"
+
+// Mount returns the mount backing relativePath: its mount point, filesystem type and
+// whether it is read-only. Symlinks are resolved first, so a link reports the mount
+// of its target. Remote hosts are read through /proc/mounts as well.
+func (s *FileManagerService) Mount(relativePath string) (*models.MountInfo, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	if !s.pathExists(fullPath) {
+		return nil, ErrNotFound
+	}
+
+	var resolved, mounts string
+	if s.isRemote {
+		output, err := s.runSSHCommandOutput(fmt.Sprintf("readlink -f %s && cat %s", shellQuote(fullPath), mountsFile))
+		if err != nil {
+			return nil, fmt.Errorf("remote mount lookup failed: %v", err)
+		}
+		resolved, mounts, _ = strings.Cut(string(output), "\n")
+	} else {
+		if resolved, err = filepath.EvalSymlinks(fullPath); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(mountsFile)
+		if err != nil {
+			return nil, err
+		}
+		mounts = string(data)
+	}
+
+	info, err := findMount(mounts, strings.TrimSpace(resolved))
+	if err != nil {
+		return nil, err
+	}
+	info.Path = relativePath
+	return info, nil
+}
+
+// findMount returns the entry of mounts, in the format of /proc/mounts, that path lies
+// on: the one with the longest mount point containing it. Of mounts stacked on the same
+// point the last one is visible.
+func findMount(mounts, path string) (*models.MountInfo, error) {
+	var found *models.MountInfo
+	scanner := bufio.NewScanner(strings.NewReader(mounts))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		point := unescapeMountField(fields[1])
+		if !containsPath(point, path) || (found != nil && len(point) < len(found.MountPoint)) {
+			continue
+		}
+		options := strings.Split(fields[3], ",")
+		found = &models.MountInfo{
+			MountPoint: point,
+			Device:     unescapeMountField(fields[0]),
+			FSType:     fields[2],
+			Options:    options,
+		}
+		for _, option := range options {
+			if option == "ro" {
+				found.ReadOnly = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no mount found for %s", path)
+	}
+	return found, nil
+}
+
+// containsPath reports whether path is the folder dir or lies below it
+func containsPath(dir, path string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// unescapeMountField decodes the octal escapes (\040 for a space) /proc/mounts uses for
+// whitespace and backslashes in device names and mount points
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// trackedOperation is a running operation that can be cancelled by the usersite that started it
+type trackedOperation struct {
+	cancel context.CancelFunc
+	owner  string
+}
+
+// runningOperations holds every tracked operation by progress ID
+var runningOperations = struct {
+	sync.Mutex
+	ops map[string]trackedOperation
+}{ops: make(map[string]trackedOperation)}
+
+// TrackOperation returns a context for the operation reporting progress under id on behalf
+// of the usersite owner. It ends with parent or once CancelOperation(id, owner) is called.
+// release must be called when the operation is over.
+// Request contexts only end at shutdown, not when the client disconnects, so CancelOperation
+// is how an abandoned operation is stopped.
+func TrackOperation(parent context.Context, id, owner string) (ctx context.Context, release func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	runningOperations.Lock()
+	runningOperations.ops[id] = trackedOperation{cancel: cancel, owner: owner}
+	runningOperations.Unlock()
+
+	return ctx, func() {
+		runningOperations.Lock()
+		delete(runningOperations.ops, id)
+		runningOperations.Unlock()
+		cancel()
+	}
+}
+
+// CancelOperation cancels the tracked operation with the given progress ID and reports
+// whether there was one started by owner. Operations of other usersites are reported as
+// missing, so their IDs cannot be probed. The operation stops at its next check and removes
+// partial output.
+func CancelOperation(id, owner string) bool {
+	runningOperations.Lock()
+	op, ok := runningOperations.ops[id]
+	runningOperations.Unlock()
+	if !ok || op.owner != owner {
+		return false
+	}
+	op.cancel()
+	return true
+}
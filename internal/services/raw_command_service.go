@@ -88,25 +88,34 @@ func (s *RawCommandService) executeCommand(command string) CommandResult {
 	return result
 }
 
-// validateCommand checks if a command is allowed based on security restrictions
-func (s *RawCommandService) validateCommand(command string) error {
-	// Deny commands that try to escape the base path
-	dangerousPatterns := []string{
-		"rm -rf /",
-		"rm -rf /*",
-		"mkfs",
-		"dd if=",
-		"> /dev/",
-		"chmod 777 /",
-		"chown root",
-	}
+// dangerousPatterns are refused anywhere in a command
+var dangerousPatterns = []string{
+	"rm -rf /",
+	"rm -rf /*",
+	"mkfs",
+	"dd if=",
+	"> /dev/",
+	"chmod 777 /",
+	"chown root",
+}
 
+// checkDangerousPatterns rejects commands containing any of dangerousPatterns
+func checkDangerousPatterns(command string) error {
 	lowerCmd := strings.ToLower(command)
 	for _, pattern := range dangerousPatterns {
 		if strings.Contains(lowerCmd, strings.ToLower(pattern)) {
 			return fmt.Errorf("command contains dangerous pattern: %s", pattern)
 		}
 	}
+	return nil
+}
+
+// validateCommand checks if a command is allowed based on security restrictions
+func (s *RawCommandService) validateCommand(command string) error {
+	// Deny commands that try to escape the base path
+	if err := checkDangerousPatterns(command); err != nil {
+		return err
+	}
 
 	// If basePath is set, ensure command doesn't try to escape
 	if s.basePath != "" {
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxRecentFiles is the most files a recent listing returns, whatever limit is requested
+	MaxRecentFiles = 1000
+	// maxRecentScan bounds the entries a recent listing looks at, so a huge base path
+	// cannot turn one request into a walk of millions of files
+	maxRecentScan = 200000
+)
+
+// errRecentScanDone stops a local recent walk that reached maxRecentScan
+var errRecentScanDone = errors.New("recent scan limit reached")
+
+// Recent returns up to limit files below relativePath modified after since, newest first,
+// searching every subfolder. A zero since matches every file. With skipHidden, files and
+// folders whose name starts with a dot are left out and hidden folders are not entered.
+// Symlinks are not followed, unreadable subfolders are skipped, and the walk stops after
+// maxRecentScan entries; locally at most that many are looked at, on SSH hosts at most that
+// many matching files are sorted. It stops with ctx.Err() once ctx ends.
+func (s *FileManagerService) Recent(ctx context.Context, relativePath string, since time.Time, limit int, skipHidden bool) ([]models.FileInfo, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.statFull(fullPath)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if !info.IsDir() {
+		return nil, ErrNotAFolder
+	}
+	if limit < 1 || limit > MaxRecentFiles {
+		limit = MaxRecentFiles
+	}
+
+	if s.isRemote {
+		return s.recentRemote(ctx, fullPath, since, limit, skipHidden)
+	}
+	return s.recentLocal(ctx, fullPath, since, limit, skipHidden)
+}
+
+func (s *FileManagerService) recentLocal(ctx context.Context, fullPath string, since time.Time, limit int, skipHidden bool) ([]models.FileInfo, error) {
+	owners := utils.NewOwnerNames()
+	var items []models.FileInfo
+	scanned := 0
+	err := filepath.WalkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if p == fullPath {
+				return err
+			}
+			return nil
+		}
+		if p == fullPath {
+			return nil
+		}
+		if scanned++; scanned > maxRecentScan {
+			return errRecentScanDone
+		}
+		if skipHidden && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().After(since) {
+			return nil // removed during the walk, or too old
+		}
+
+		relPath, _ := utils.GetRelativePath(s.basePath, p)
+		item := models.FileInfo{
+			Name:        d.Name(),
+			Path:        relPath,
+			Size:        info.Size(),
+			Mode:        info.Mode(),
+			ModTime:     info.ModTime(),
+			Extension:   strings.TrimPrefix(filepath.Ext(d.Name()), "."),
+			MimeType:    utils.GetMimeType(d.Name()),
+			Permissions: utils.FormatPermissions(info.Mode()),
+		}
+		item.Owner, item.Group = owners.Lookup(info)
+		items = append(items, item)
+
+		// Keep memory bounded by the limit rather than by the number of matches
+		if len(items) >= 2*limit {
+			items = newestFiles(items, limit)
+		}
+		return nil
+	})
+	if err != nil && err != errRecentScanDone {
+		return nil, err
+	}
+	return newestFiles(items, limit), nil
+}
+
+// recentRemote lets find select and sort the files on the remote host, so only the newest
+// limit cross the connection. Each line holds mtime, size, permission bits, owner, group
+// and the path relative to fullPath.
+func (s *FileManagerService) recentRemote(ctx context.Context, fullPath string, since time.Time, limit int, skipHidden bool) ([]models.FileInfo, error) {
+	prune := ""
+	if skipHidden {
+		prune = `-name '.*' -prune -o `
+	}
+	newer := ""
+	if !since.IsZero() {
+		newer = fmt.Sprintf("-newermt '@%d' ", since.Unix())
+	}
+	cmd := fmt.Sprintf("find %s -mindepth 1 %s-type f %s-printf '%%T@\\t%%s\\t%%m\\t%%u\\t%%g\\t%%P\\n' 2>/dev/null | head -n %d | sort -t '\t' -k1,1rn -k6,6 | head -n %d",
+		shellQuote(fullPath), prune, newer, maxRecentScan, limit)
+	output, err := s.runSSHCommandOutputContext(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("remote recent files failed: %w", err)
+	}
+
+	var items []models.FileInfo
+	for _, line := range strings.Split(strings.TrimSuffix(string(output), "\n"), "\n") {
+		fields := strings.SplitN(line, "\t", 6)
+		if len(fields) != 6 || fields[5] == "" {
+			continue
+		}
+		modTime, ok := parseFindTime(fields[0])
+		if !ok {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		perm, _ := strconv.ParseUint(fields[2], 8, 32)
+		// -newermt compares whole seconds
+		if !modTime.After(since) {
+			continue
+		}
+
+		mode := os.FileMode(perm) & os.ModePerm
+		name := filepath.Base(fields[5])
+		relPath, _ := utils.GetRelativePath(s.basePath, filepath.Join(fullPath, fields[5]))
+		items = append(items, models.FileInfo{
+			Name:        name,
+			Path:        relPath,
+			Size:        size,
+			Mode:        mode,
+			ModTime:     modTime,
+			Extension:   strings.TrimPrefix(filepath.Ext(name), "."),
+			MimeType:    utils.GetMimeType(name),
+			Permissions: utils.FormatPermissions(mode),
+			Owner:       fields[3],
+			Group:       fields[4],
+		})
+	}
+	return newestFiles(items, limit), nil
+}
+
+// parseFindTime parses a %T@ timestamp of find, seconds with a fraction of up to ten digits,
+// without the rounding of a float
+func parseFindTime(value string) (time.Time, bool) {
+	secs, frac, _ := strings.Cut(value, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	frac = (frac + "000000000")[:9]
+	nsec, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, nsec), true
+}
+
+// newestFiles sorts items by modification time, newest first and by path among equal
+// times, and keeps the first limit
+func newestFiles(items []models.FileInfo, limit int) []models.FileInfo {
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].ModTime.Equal(items[j].ModTime) {
+			return items[i].ModTime.After(items[j].ModTime)
+		}
+		return items[i].Path < items[j].Path
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"filemanager-api/internal/metrics"
+	"filemanager-api/internal/models"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrSSHAuth         = errors.New("SSH authentication failed")
+	ErrSSHUnreachable  = errors.New("SSH host unreachable")
+	ErrBasePathMissing = errors.New("base path does not exist")
+)
+
+// CheckRemote connects to the SSH host of cfg once, without retries, and reports how long
+// connecting and a command took, who the session runs as and whether basePath is a folder
+// it can write to. The connection is closed before returning. Failures are reported as
+// ErrSSHAuth for a rejected key, ErrSSHUnreachable when the host cannot be reached and
+// ErrBasePathMissing when basePath is not a folder.
+func CheckRemote(basePath string, cfg *SSHConfig) (*models.RemoteCheck, error) {
+	config, err := sshClientConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSHAuth, err)
+	}
+
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
+	start := time.Now()
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		metrics.SSHConnections.WithLabelValues("failed").Inc()
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr):
+			return nil, fmt.Errorf("%w: %s: %v", ErrSSHUnreachable, addr, err)
+		case strings.Contains(err.Error(), "unable to authenticate"):
+			return nil, fmt.Errorf("%w: %s as %s: %v", ErrSSHAuth, addr, cfg.Username, err)
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrSSHConnection, addr, err)
+	}
+	connected := time.Since(start)
+	metrics.SSHConnections.WithLabelValues("success").Inc()
+	metrics.SSHConnectionsOpen.Inc()
+
+	svc := &FileManagerService{basePath: basePath, sshConfig: cfg, sshClient: client, isRemote: true}
+	defer svc.Close()
+
+	// One line per value, so a missing program leaves just its value empty
+	base := shellQuote(basePath)
+	cmd := "printf 'hostname=%s\\nos=%s\\nkernel=%s\\narch=%s\\nuser=%s\\nuid=%s\\n' " +
+		`"$(hostname)" "$(uname -s)" "$(uname -r)" "$(uname -m)" "$(id -un)" "$(id -u)"; ` +
+		"test -d " + base + " && echo base_dir=1; test -w " + base + " && echo base_writable=1; true"
+	start = time.Now()
+	output, err := svc.runSSHCommandOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSSHConnection, err)
+	}
+	ran := time.Since(start)
+
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[key] = strings.TrimSpace(value)
+		}
+	}
+	if values["base_dir"] != "1" {
+		return nil, fmt.Errorf("%w: %s on %s", ErrBasePathMissing, basePath, cfg.Host)
+	}
+
+	uid, err := strconv.Atoi(values["uid"])
+	if err != nil {
+		uid = -1
+	}
+	return &models.RemoteCheck{
+		Host:             cfg.Host,
+		Port:             cfg.Port,
+		Username:         cfg.Username,
+		ConnectMS:        connected.Milliseconds(),
+		CommandMS:        ran.Milliseconds(),
+		Hostname:         values["hostname"],
+		OS:               values["os"],
+		Kernel:           values["kernel"],
+		Arch:             values["arch"],
+		User:             values["user"],
+		UID:              uid,
+		BasePath:         basePath,
+		BasePathWritable: values["base_writable"] == "1",
+	}, nil
+}
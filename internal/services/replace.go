@@ -0,0 +1,174 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// maxReplaceFileSize is the largest file Replace reads into memory
+const maxReplaceFileSize = 10 * 1024 * 1024
+
+// ErrInvalidPattern is returned for an empty search string or a regular expression that does not compile
+var ErrInvalidPattern = errors.New("invalid search pattern")
+
+// Replacer substitutes a literal string or a regular expression in file content
+type Replacer struct {
+	find        string
+	replacement string
+	pattern     *regexp.Regexp
+}
+
+// NewReplacer returns a Replacer for find. With useRegex, find is a Go regular
+// expression and replacement may refer to its groups as $1 or ${name}.
+func NewReplacer(find, replacement string, useRegex bool) (*Replacer, error) {
+	if find == "" {
+		return nil, fmt.Errorf("%w: find is empty", ErrInvalidPattern)
+	}
+	r := &Replacer{find: find, replacement: replacement}
+	if useRegex {
+		pattern, err := regexp.Compile(find)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+		}
+		r.pattern = pattern
+	}
+	return r, nil
+}
+
+// apply returns content with every match replaced and the number of matches
+func (r *Replacer) apply(content []byte) ([]byte, int) {
+	if r.pattern != nil {
+		matches := len(r.pattern.FindAllIndex(content, -1))
+		if matches == 0 {
+			return content, 0
+		}
+		return r.pattern.ReplaceAll(content, []byte(r.replacement)), matches
+	}
+	matches := bytes.Count(content, []byte(r.find))
+	if matches == 0 {
+		return content, 0
+	}
+	return bytes.ReplaceAll(content, []byte(r.find), []byte(r.replacement)), matches
+}
+
+// Replace applies r to each of paths, reporting the number of matches per file. Files are
+// rewritten through a temp file renamed into place, so readers never see a partial write.
+// Binary files are skipped; with dryRun nothing is written.
+func (s *FileManagerService) Replace(paths []string, r *Replacer, dryRun bool) []models.ReplaceResult {
+	results := make([]models.ReplaceResult, len(paths))
+	for i, p := range paths {
+		results[i].Path = p
+		matches, err := s.replaceInFile(p, r, dryRun)
+		results[i].Matches = matches
+		if errors.Is(err, ErrBinaryFile) {
+			results[i].Skipped = true
+		} else if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results
+}
+
+// replaceInFile applies r to the file at relativePath
+func (s *FileManagerService) replaceInFile(relativePath string, r *Replacer, dryRun bool) (int, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return 0, err
+	}
+
+	// Symlinks are rejected too, renaming over one would replace the link itself
+	info, err := s.lstatFull(fullPath)
+	if err != nil {
+		return 0, ErrNotFound
+	}
+	if !info.Mode().IsRegular() {
+		return 0, ErrNotAFile
+	}
+
+	reader, size, encrypted, err := s.openContent(fullPath)
+	if err != nil {
+		return 0, err
+	}
+	if size > maxReplaceFileSize {
+		reader.Close()
+		return 0, fmt.Errorf("%w of %d bytes", ErrFileTooLarge, maxReplaceFileSize)
+	}
+	content, err := io.ReadAll(io.LimitReader(reader, maxReplaceFileSize+1))
+	reader.Close()
+	if err != nil {
+		return 0, err
+	}
+	if bytes.IndexByte(content, 0) >= 0 {
+		return 0, ErrBinaryFile
+	}
+
+	replaced, matches := r.apply(content)
+	if matches == 0 || dryRun {
+		return matches, nil
+	}
+
+	staging := stagingPath(fullPath, uuid.New().String())
+	if err := s.writeStaged(staging, replaced, info.Mode().Perm(), encrypted); err != nil {
+		s.removeFull(staging)
+		return 0, err
+	}
+	if err := s.replaceFull(staging, fullPath); err != nil {
+		s.removeFull(staging)
+		return 0, err
+	}
+
+	if err := s.setOwner(fullPath); err != nil {
+		utils.Errorf("Failed to set owner for %s: %v", fullPath, err)
+	}
+	return matches, nil
+}
+
+// lstatFull stats an already validated path without following a final symlink
+func (s *FileManagerService) lstatFull(fullPath string) (os.FileInfo, error) {
+	if s.isRemote {
+		return s.sftpClient.Lstat(fullPath)
+	}
+	return os.Lstat(fullPath)
+}
+
+// writeStaged writes content to a new file at path with the given permissions,
+// encrypted with the usersite key when encrypt is set
+func (s *FileManagerService) writeStaged(path string, content []byte, mode os.FileMode, encrypt bool) error {
+	file, err := s.createContent(path, encrypt)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return s.chmodFull(path, mode)
+}
+
+// chmodFull changes the permissions of an already validated path
+func (s *FileManagerService) chmodFull(fullPath string, mode os.FileMode) error {
+	if s.isRemote {
+		return s.sftpClient.Chmod(fullPath, mode)
+	}
+	return os.Chmod(fullPath, mode)
+}
+
+// replaceFull renames src over dst, replacing it atomically
+func (s *FileManagerService) replaceFull(src, dst string) error {
+	if s.isRemote {
+		// Plain SFTP rename refuses to overwrite; the OpenSSH extension replaces atomically
+		return s.sftpClient.PosixRename(src, dst)
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTP status codes reporting a broken link rather than a refused request
+const (
+	sftpNoConnection   = 6
+	sftpConnectionLost = 7
+)
+
+// sshRetry controls how remote operations are retried, see ConfigureSSHRetry
+var sshRetry = struct {
+	attempts       int
+	delay          time.Duration
+	connectTimeout time.Duration
+}{attempts: 3, delay: 200 * time.Millisecond, connectTimeout: 15 * time.Second}
+
+// ConfigureSSHRetry sets how often SSH connects and SFTP calls are attempted, the delay
+// before the first retry (doubled on each further one) and the timeout of a single connect
+func ConfigureSSHRetry(attempts int, initialDelay, connectTimeout time.Duration) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	sshRetry.attempts = attempts
+	sshRetry.delay = initialDelay
+	sshRetry.connectTimeout = connectTimeout
+}
+
+// isRetryable reports whether err looks like a transient network failure.
+// Anything the remote side answered, such as not found or permission denied, is permanent.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrExist) {
+		return false
+	}
+
+	var status *sftp.StatusError
+	if errors.As(err, &status) {
+		return status.Code == sftpNoConnection || status.Code == sftpConnectionLost
+	}
+
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// remoteError maps an error of an SFTP call to ErrNotFound when the path does not exist and
+// to ErrSSHConnection when the connection failed, even after retries. Others are returned as is.
+func remoteError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return ErrNotFound
+	case isRetryable(err):
+		return fmt.Errorf("%w: %v", ErrSSHConnection, err)
+	}
+	return err
+}
+
+// withRetry runs fn until it succeeds, fails permanently or runs out of attempts, waiting with
+// exponential backoff in between. reconnect, when set, runs before each retry. An error that
+// survived retries names the operation and the number of attempts.
+func withRetry(op string, fn func() error, reconnect func() error) error {
+	delay := sshRetry.delay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt >= sshRetry.attempts {
+			if attempt == 1 {
+				return err
+			}
+			return fmt.Errorf("%s failed after %d attempts: %w", op, attempt, err)
+		}
+
+		utils.Warnf("%s failed (attempt %d of %d), retrying in %s: %v", op, attempt, sshRetry.attempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+
+		if reconnect != nil {
+			if err := reconnect(); err != nil {
+				utils.Warnf("Reconnecting for %s failed: %v", op, err)
+			}
+		}
+	}
+}
+
+// retrySFTP retries an SFTP call, reconnecting first since a transient error usually means
+// the connection is gone
+func (s *FileManagerService) retrySFTP(op string, fn func() error) error {
+	return withRetry(op, fn, s.reconnect)
+}
+
+// reconnect replaces the SSH and SFTP connections
+func (s *FileManagerService) reconnect() error {
+	s.Close()
+	return s.connectSSH()
+}
+
+func (s *FileManagerService) sftpStat(path string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := s.retrySFTP("SFTP stat "+path, func() (err error) {
+		info, err = s.sftpClient.Stat(path)
+		return err
+	})
+	return info, err
+}
+
+func (s *FileManagerService) sftpReadDir(path string) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	err := s.retrySFTP("SFTP readdir "+path, func() (err error) {
+		entries, err = s.sftpClient.ReadDir(path)
+		return err
+	})
+	return entries, err
+}
+
+func (s *FileManagerService) sftpOpen(path string) (*sftp.File, error) {
+	var file *sftp.File
+	err := s.retrySFTP("SFTP open "+path, func() (err error) {
+		file, err = s.sftpClient.Open(path)
+		return err
+	})
+	return file, err
+}
+
+func (s *FileManagerService) sftpRename(oldPath, newPath string) error {
+	return s.retrySFTP("SFTP rename "+oldPath, func() error {
+		return s.sftpClient.Rename(oldPath, newPath)
+	})
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	tailPollInterval    = 500 * time.Millisecond
+	maxTailBacklogBytes = 8 * 1024 * 1024
+)
+
+// Tail emits the last backlog lines of a file and then every line appended to it.
+// emit is called once per poll interval, with an empty slice when nothing new arrived,
+// and following stops as soon as emit returns an error (e.g. the client disconnected).
+// Truncated and rotated files are detected and re-opened.
+func (s *FileManagerService) Tail(relativePath string, backlog int, emit func(lines []string) error) error {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return err
+	}
+
+	if s.isRemote {
+		return s.tailRemote(fullPath, backlog, emit)
+	}
+	return s.tailLocal(fullPath, backlog, emit)
+}
+
+func (s *FileManagerService) tailLocal(fullPath string, backlog int, emit func(lines []string) error) error {
+	file, err := os.Open(fullPath)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrNotAFile
+	}
+
+	initial, err := readLastLines(file, info.Size(), backlog)
+	if err != nil {
+		return err
+	}
+	if err := emit(initial); err != nil {
+		return nil
+	}
+
+	offset := info.Size()
+	if _, err := file.Seek(offset, 0); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	partial := ""
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Re-open when the file was rotated (different inode) or truncated
+		if current, err := os.Stat(fullPath); err == nil {
+			opened, _ := file.Stat()
+			if !os.SameFile(current, opened) || current.Size() < offset {
+				if reopened, err := os.Open(fullPath); err == nil {
+					file.Close()
+					file = reopened
+					reader.Reset(file)
+					offset = 0
+					partial = ""
+				}
+			}
+		}
+
+		var lines []string
+		for {
+			chunk, err := reader.ReadString('\n')
+			offset += int64(len(chunk))
+			if err != nil {
+				partial += chunk
+				break
+			}
+			lines = append(lines, strings.TrimRight(partial+chunk, "\r\n"))
+			partial = ""
+		}
+
+		if err := emit(lines); err != nil {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *FileManagerService) tailRemote(fullPath string, backlog int, emit func(lines []string) error) error {
+	if _, err := s.sftpStat(fullPath); err != nil {
+		return ErrNotFound
+	}
+
+	session, err := s.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %v", err)
+	}
+	defer session.Close()
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	// -F follows by name so rotated and truncated files are re-opened
+	if err := session.Start(fmt.Sprintf("tail -n %d -F %s", backlog, shellQuote(fullPath))); err != nil {
+		return fmt.Errorf("failed to start remote tail: %v", err)
+	}
+
+	lineCh := make(chan string, 256)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(lineCh)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case lineCh <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	var pending []string
+	for {
+		select {
+		case line, ok := <-lineCh:
+			if !ok {
+				emit(pending)
+				return nil
+			}
+			pending = append(pending, line)
+		case <-ticker.C:
+			if err := emit(pending); err != nil {
+				return nil
+			}
+			pending = nil
+		}
+	}
+}
+
+// readLastLines returns up to n trailing lines of a file of the given size,
+// reading backwards in blocks so large files are not loaded entirely
+func readLastLines(file *os.File, size int64, n int) ([]string, error) {
+	if n <= 0 || size == 0 {
+		return nil, nil
+	}
+
+	var buf []byte
+	pos := size
+	for pos > 0 && bytes.Count(buf, []byte("\n")) <= n && len(buf) < maxTailBacklogBytes {
+		readSize := int64(utils.DefaultBufferSize)
+		if readSize > pos {
+			readSize = pos
+		}
+		pos -= readSize
+
+		block := make([]byte, readSize)
+		if _, err := file.ReadAt(block, pos); err != nil {
+			return nil, err
+		}
+		buf = append(block, buf...)
+	}
+
+	text := strings.TrimRight(string(buf), "\r\n")
+	if text == "" {
+		return nil, nil
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], "\r")
+	}
+	return lines, nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// tempRoot holds chunked uploads, staged archives, cached thumbnails and dedup indexes
+var tempRoot = os.TempDir()
+
+// ConfigureTempDir sets the temp root, falling back to os.TempDir() when dir is empty.
+// The directory must already exist and be writable.
+func ConfigureTempDir(dir string) error {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	// Writability is only proven by actually writing
+	probe, err := os.CreateTemp(dir, ".filemanager-probe-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	tempRoot = dir
+	return nil
+}
+
+// tempPath returns a path below the temp root
+func tempPath(elem ...string) string {
+	return filepath.Join(append([]string{tempRoot}, elem...)...)
+}
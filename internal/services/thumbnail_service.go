@@ -0,0 +1,252 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported file type")
+	ErrImageTooLarge   = errors.New("image dimensions exceed the thumbnail limit")
+)
+
+const (
+	DefaultThumbnailSize = 200
+	MaxThumbnailSize     = 2048
+)
+
+// ThumbnailLimits bound the images thumbnails are rendered from and the cache they are kept in.
+// Zero disables a limit.
+type ThumbnailLimits struct {
+	MaxPixels    int64         // width x height of a source image
+	CacheMaxSize int64         // bytes of all cached thumbnails
+	CacheMaxAge  time.Duration // time since a cached thumbnail was last served
+}
+
+// thumbnailLimits applies to every thumbnail
+var thumbnailLimits ThumbnailLimits
+
+// ConfigureThumbnails sets the limits checked while rendering and caching thumbnails
+func ConfigureThumbnails(limits ThumbnailLimits) {
+	thumbnailLimits = limits
+}
+
+// thumbnailPruneInterval spaces out cache scans, which only run after a thumbnail is rendered
+const thumbnailPruneInterval = time.Minute
+
+var (
+	thumbnailPruneMu   sync.Mutex
+	thumbnailLastPrune time.Time
+)
+
+// thumbnailFormats maps supported source extensions to the format thumbnails are encoded in
+var thumbnailFormats = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"png":  "png",
+	"gif":  "png",
+	"webp": "png",
+}
+
+// ThumbnailService generates and caches scaled-down previews of images
+type ThumbnailService struct {
+	fm       *FileManagerService
+	cacheDir string
+}
+
+// NewThumbnailService creates a thumbnail service reading files through the given file manager service
+func NewThumbnailService(fm *FileManagerService) *ThumbnailService {
+	return &ThumbnailService{
+		fm:       fm,
+		cacheDir: tempPath("filemanager-thumbnails"),
+	}
+}
+
+// Thumbnail returns the path of a cached thumbnail fitting within width x height and its content type.
+// The cache key is derived from the path, modification time and size so edits invalidate it.
+func (s *ThumbnailService) Thumbnail(relativePath string, width, height int) (string, string, error) {
+	info, err := s.fm.GetInfo(relativePath)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir {
+		return "", "", ErrNotAFile
+	}
+
+	format, ok := thumbnailFormats[strings.ToLower(info.Extension)]
+	if !ok {
+		return "", "", ErrUnsupportedType
+	}
+	contentType := "image/" + format
+
+	key := fmt.Sprintf("%s|%s|%d|%d|%dx%d", s.fm.basePath, info.Path, info.ModTime.UnixNano(), info.Size, width, height)
+	sum := sha256.Sum256([]byte(key))
+	cachePath := filepath.Join(s.cacheDir, hex.EncodeToString(sum[:])+"."+format)
+
+	if utils.PathExists(cachePath) {
+		// Serving a thumbnail keeps it from being evicted by age
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		return cachePath, contentType, nil
+	}
+
+	reader, _, err := s.fm.GetContent(relativePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer reader.Close()
+
+	// The header declares the dimensions, so oversized images are refused before a pixel
+	// buffer is allocated; the bytes it took are replayed for the full decode
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+	if max := thumbnailLimits.MaxPixels; max > 0 && int64(config.Width)*int64(config.Height) > max {
+		return "", "", fmt.Errorf("%w: %dx%d is over %d pixels", ErrImageTooLarge, config.Width, config.Height, max)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&header, reader))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+
+	thumb := scaleToFit(src, width, height)
+
+	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
+		return "", "", err
+	}
+
+	tmp, err := os.CreateTemp(s.cacheDir, "thumb-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if format == "jpeg" {
+		err = jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(tmp, thumb)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return "", "", err
+	}
+
+	s.pruneCache(cachePath)
+	return cachePath, contentType, nil
+}
+
+// pruneCache removes cached thumbnails not served within CacheMaxAge, then the least recently
+// served ones until the cache fits CacheMaxSize. keep, the thumbnail just rendered, is never removed.
+// Scans run at most once per thumbnailPruneInterval.
+func (s *ThumbnailService) pruneCache(keep string) {
+	limits := thumbnailLimits
+	if limits.CacheMaxSize <= 0 && limits.CacheMaxAge <= 0 {
+		return
+	}
+
+	thumbnailPruneMu.Lock()
+	defer thumbnailPruneMu.Unlock()
+	if time.Since(thumbnailLastPrune) < thumbnailPruneInterval {
+		return
+	}
+	thumbnailLastPrune = time.Now()
+
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		return
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, entry := range entries {
+		// Temp files of renders in progress start with "thumb-"
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), "thumb-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(s.cacheDir, entry.Name())
+		if path != keep && limits.CacheMaxAge > 0 && time.Since(info.ModTime()) > limits.CacheMaxAge {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, cached{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	if limits.CacheMaxSize <= 0 || total <= limits.CacheMaxSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= limits.CacheMaxSize {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
+
+// scaleToFit scales src down to fit within width x height preserving aspect ratio.
+// Images already smaller than the bounds are returned unchanged.
+func scaleToFit(src image.Image, width, height int) image.Image {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= width && srcH <= height {
+		return src
+	}
+
+	dstW, dstH := width, srcH*width/srcW
+	if dstH > height {
+		dstW, dstH = srcW*height/srcH, height
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePNG encodes a width x height image to the relative path below base
+func writePNG(t *testing.T, base, path string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, path), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writePNGBomb writes a tiny PNG whose header declares width x height pixels
+func writePNGBomb(t *testing.T, base, path string, width, height uint32) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// IHDR follows the 8 byte signature: length, type, width, height, ..., CRC over type and data
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if err := os.WriteFile(filepath.Join(base, path), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestThumbnailService(t *testing.T) (*ThumbnailService, string) {
+	t.Helper()
+	fm, base := newTestService(t)
+	s := NewThumbnailService(fm)
+	s.cacheDir = t.TempDir()
+	return s, base
+}
+
+func TestThumbnailMaxPixels(t *testing.T) {
+	s, base := newTestThumbnailService(t)
+	writePNG(t, base, "photo.png", 400, 300)
+	writePNGBomb(t, base, "bomb.png", 100000, 100000)
+
+	defer ConfigureThumbnails(thumbnailLimits)
+	ConfigureThumbnails(ThumbnailLimits{MaxPixels: 1000 * 1000})
+
+	path, contentType, err := s.Thumbnail("photo.png", 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/png" {
+		t.Fatalf("content type = %s, want image/png", contentType)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 200 || config.Height != 150 {
+		t.Fatalf("thumbnail is %dx%d, want 200x150", config.Width, config.Height)
+	}
+
+	if _, _, err := s.Thumbnail("bomb.png", 200, 200); !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("Thumbnail of a 100000x100000 header = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestThumbnailCachePrune(t *testing.T) {
+	s, base := newTestThumbnailService(t)
+	writePNG(t, base, "photo.png", 10, 10)
+
+	old := time.Now().Add(-2 * time.Hour)
+	for name, size := range map[string]int{"stale.png": 10, "older.png": 600, "newer.png": 600} {
+		if err := os.WriteFile(filepath.Join(s.cacheDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Chtimes(filepath.Join(s.cacheDir, "stale.png"), old.Add(-24*time.Hour), old.Add(-24*time.Hour))
+	os.Chtimes(filepath.Join(s.cacheDir, "older.png"), old, old)
+	os.Chtimes(filepath.Join(s.cacheDir, "newer.png"), old.Add(time.Hour), old.Add(time.Hour))
+
+	defer ConfigureThumbnails(thumbnailLimits)
+	ConfigureThumbnails(ThumbnailLimits{CacheMaxSize: 1000, CacheMaxAge: 12 * time.Hour})
+	thumbnailPruneMu.Lock()
+	thumbnailLastPrune = time.Time{}
+	thumbnailPruneMu.Unlock()
+
+	path, _, err := s.Thumbnail("photo.png", 200, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, kept := range map[string]bool{"stale.png": false, "older.png": false, "newer.png": true} {
+		_, err := os.Stat(filepath.Join(s.cacheDir, name))
+		if kept && err != nil {
+			t.Fatalf("%s was evicted: %v", name, err)
+		}
+		if !kept && !os.IsNotExist(err) {
+			t.Fatalf("%s was kept, want evicted", name)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("the new thumbnail was evicted: %v", err)
+	}
+}
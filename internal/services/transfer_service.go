@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrSameLocation = errors.New("source and destination are on the same side; use copy or move")
+
+// TransferOptions controls how Transfer places sources under the destination
+type TransferOptions struct {
+	Overwrite bool
+	// Move removes each source once it has been transferred completely
+	Move bool
+}
+
+// transferEntry is one file or directory to create on the destination side
+type transferEntry struct {
+	src   string
+	dst   string
+	isDir bool
+	size  int64
+}
+
+// Transfer copies sources served by src into destination served by dst, where one side is
+// local and the other remote. Each side validates its paths against its own base path.
+// Files are streamed through this server; progress receives the bytes sent so far and the
+// total size of all sources, which is measured before anything is written. When ctx ends
+// the transfer stops, the item being transferred is removed from the destination unless
+// it already existed there, and ctx.Err() is returned.
+func Transfer(ctx context.Context, src *FileManagerService, sources []string, dst *FileManagerService, destination string, opts TransferOptions, progress MoveProgressFunc) ([]models.FileInfo, error) {
+	if src.isRemote == dst.isRemote {
+		return nil, ErrSameLocation
+	}
+
+	destPath, err := utils.ValidatePath(dst.basePath, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err = src.ExpandSources(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plan every source first so the total is known before the first byte is sent
+	type item struct {
+		srcPath string
+		dstItem string
+		entries []transferEntry
+	}
+	var items []item
+	var total int64
+	for _, source := range sources {
+		srcPath, err := utils.ValidatePath(src.basePath, source)
+		if err != nil {
+			return nil, err
+		}
+		srcInfo, err := src.statFull(srcPath)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, source)
+		}
+
+		entries, err := src.planTransfer(srcPath, srcInfo, "")
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			continue // Nothing transferable, e.g. a socket
+		}
+		for _, e := range entries {
+			total += e.size
+		}
+		items = append(items, item{srcPath: srcPath, dstItem: filepath.Join(destPath, srcInfo.Name()), entries: entries})
+	}
+
+	if err := dst.mkdirAllOwned(destPath); err != nil {
+		return nil, err
+	}
+
+	var sent int64
+	var transferred []string
+	for _, it := range items {
+		dstItem := it.dstItem
+		if !opts.Overwrite {
+			dstItem = dst.uniqueName(dstItem)
+		}
+		existed := dst.pathExists(dstItem)
+
+		for _, e := range it.entries {
+			target := filepath.Join(dstItem, e.dst)
+			var err error
+			if e.isDir {
+				err = dst.mkdirFull(target)
+			} else {
+				err = transferFile(ctx, src, e.src, dst, target, func(n int64) {
+					if progress != nil {
+						progress(sent+n, total)
+					}
+				})
+				sent += e.size
+			}
+			if err != nil {
+				if ctx.Err() != nil && !existed {
+					dst.removeFull(dstItem)
+				}
+				return dst.infoFor(transferred), err
+			}
+		}
+
+		if it.entries[0].isDir {
+			dst.setOwnerRecursive(dstItem)
+		} else {
+			dst.setOwner(dstItem)
+		}
+
+		// The source is only removed once its copy is complete
+		if opts.Move {
+			if err := src.removeFull(it.srcPath); err != nil {
+				return dst.infoFor(transferred), err
+			}
+		}
+
+		transferred = append(transferred, dstItem)
+	}
+
+	return dst.infoFor(transferred), nil
+}
+
+// planTransfer lists fullPath and everything below it, parents before children.
+// Destination paths are relative to the transferred item; the item itself is "".
+// Only directories and regular files are transferred.
+func (s *FileManagerService) planTransfer(fullPath string, info os.FileInfo, rel string) ([]transferEntry, error) {
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			return nil, nil
+		}
+		return []transferEntry{{src: fullPath, dst: rel, size: info.Size()}}, nil
+	}
+
+	entries := []transferEntry{{src: fullPath, dst: rel, isDir: true}}
+	children, err := s.readDirFull(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		childEntries, err := s.planTransfer(filepath.Join(fullPath, child.Name()), child, filepath.Join(rel, child.Name()))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, childEntries...)
+	}
+	return entries, nil
+}
+
+// transferFile streams one file from src to dst, reporting the bytes written so far
+func transferFile(ctx context.Context, src *FileManagerService, srcPath string, dst *FileManagerService, dstPath string, progress func(written int64)) error {
+	reader, err := src.openFull(srcPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := dst.createFull(dstPath)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	var written int64
+	buf := utils.NewBuffer()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, rerr := reader.Read(buf)
+		if n > 0 {
+			if _, err := writer.Write(buf[:n]); err != nil {
+				return err
+			}
+			written += int64(n)
+			progress(written)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return writer.Close()
+}
+
+// statFull stats an already validated path on this service's side
+func (s *FileManagerService) statFull(fullPath string) (os.FileInfo, error) {
+	if s.isRemote {
+		return s.sftpStat(fullPath)
+	}
+	return os.Stat(fullPath)
+}
+
+// readDirFull lists an already validated directory on this service's side
+func (s *FileManagerService) readDirFull(fullPath string) ([]os.FileInfo, error) {
+	if s.isRemote {
+		return s.sftpReadDir(fullPath)
+	}
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed while listing
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s *FileManagerService) openFull(fullPath string) (io.ReadCloser, error) {
+	if s.isRemote {
+		return s.sftpOpen(fullPath)
+	}
+	return os.Open(fullPath)
+}
+
+func (s *FileManagerService) createFull(fullPath string) (io.WriteCloser, error) {
+	if s.isRemote {
+		return s.sftpClient.Create(fullPath)
+	}
+	return utils.CreateFile(fullPath)
+}
+
+func (s *FileManagerService) mkdirFull(fullPath string) error {
+	if s.isRemote {
+		return s.sftpClient.MkdirAll(fullPath)
+	}
+	return os.MkdirAll(fullPath, utils.DirMode())
+}
+
+func (s *FileManagerService) removeFull(fullPath string) error {
+	if s.isRemote {
+		return s.deleteRemote(fullPath, true)
+	}
+	return s.deleteLocal(fullPath, true)
+}
+
+// uniqueName is utils.GenerateUniqueName checked against this service's side
+func (s *FileManagerService) uniqueName(path string) string {
+	if !s.pathExists(path) {
+		return path
+	}
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	for counter := 1; ; counter++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s_%d%s", name, counter, ext))
+		if !s.pathExists(candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"os"
+)
+
+const (
+	// MaxTreeDepth is the deepest a tree is expanded, whatever depth is requested
+	MaxTreeDepth = 10
+	// maxTreeNodes bounds the entries of one tree, so a wide hierarchy cannot turn a
+	// request into a walk of the whole base path
+	maxTreeNodes = 5000
+)
+
+// treeLevel is a folder of the tree waiting to be expanded
+type treeLevel struct {
+	node  *models.TreeNode
+	level int
+}
+
+// Tree returns the folder at relativePath with its subfolders expanded depth levels deep,
+// each level sorted as List sorts it. Folders are expanded breadth first, so once the tree
+// holds maxTreeNodes entries the deepest levels are the ones left out. Folders with children
+// that were not expanded are marked truncated. It stops with ctx.Err() once ctx ends.
+func (s *FileManagerService) Tree(ctx context.Context, relativePath string, depth int) (*models.TreeNode, error) {
+	info, err := s.GetInfo(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, ErrNotAFolder
+	}
+	if depth > MaxTreeDepth {
+		depth = MaxTreeDepth
+	}
+
+	root := &models.TreeNode{FileInfo: *info}
+	budget := maxTreeNodes
+	queue := []treeLevel{{node: root}}
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		next := queue[0]
+		queue = queue[1:]
+
+		if next.level >= depth || budget == 0 {
+			next.node.Truncated = s.hasChildren(next.node.Path)
+			continue
+		}
+
+		items, err := s.List(next.node.Path, ListFilter{})
+		if err != nil {
+			if next.node == root {
+				return nil, err
+			}
+			// An unreadable subfolder is shown without children rather than failing the tree
+			continue
+		}
+		if len(items) > budget {
+			items = items[:budget]
+			next.node.Truncated = true
+		}
+		budget -= len(items)
+
+		next.node.Children = make([]models.TreeNode, len(items))
+		for i, item := range items {
+			next.node.Children[i].FileInfo = item
+			if item.IsDir {
+				queue = append(queue, treeLevel{node: &next.node.Children[i], level: next.level + 1})
+			}
+		}
+	}
+	return root, nil
+}
+
+// hasChildren reports whether the folder at relativePath has any entries, reading only the first
+func (s *FileManagerService) hasChildren(relativePath string) bool {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return false
+	}
+	if s.isRemote {
+		entries, err := s.sftpReadDir(fullPath)
+		return err == nil && len(entries) > 0
+	}
+
+	dir, err := os.Open(fullPath)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	return err == nil
+}
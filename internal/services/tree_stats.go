@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// treeStatsTTL is how long a computed summary is reused for the same directory
+const treeStatsTTL = 10 * time.Second
+
+// treeStatsCache holds recent summaries by connection and full path, so a properties
+// panel and the operation it precedes do not walk a large tree twice
+var treeStatsCache = struct {
+	sync.Mutex
+	entries map[string]*models.TreeStats
+}{entries: make(map[string]*models.TreeStats)}
+
+// TreeStats counts the files and folders below a directory and sums their size, reusing a
+// summary computed within the last few seconds unless refresh is set. Unreadable
+// subfolders are skipped rather than failing the whole walk, and symlinks are not counted,
+// as with the default of GetDiskUsage. The walk stops once ctx ends.
+func (s *FileManagerService) TreeStats(ctx context.Context, relativePath string, refresh bool) (*models.TreeStats, error) {
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.statFull(fullPath)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if !info.IsDir() {
+		return nil, ErrNotAFolder
+	}
+
+	key := "local:" + fullPath
+	if s.isRemote {
+		key = fmt.Sprintf("%s@%s:%s:%s", s.sshConfig.Username, s.sshConfig.Host, s.sshConfig.Port, fullPath)
+	}
+	if !refresh {
+		if stats, ok := cachedTreeStats(key); ok {
+			return stats, nil
+		}
+	}
+
+	var stats *models.TreeStats
+	if s.isRemote {
+		stats, err = s.remoteTreeStats(ctx, fullPath)
+	} else {
+		stats, err = localTreeStats(ctx, fullPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Path, _ = utils.GetRelativePath(s.basePath, fullPath)
+	stats.SizeHuman = utils.FormatFileSize(stats.SizeBytes)
+	stats.ComputedAt = time.Now().UTC()
+	if stats.LargestFile != "" {
+		stats.LargestFile = path.Join(stats.Path, filepath.ToSlash(stats.LargestFile))
+	}
+	storeTreeStats(key, stats)
+	return stats, nil
+}
+
+// localTreeStats walks fullPath once, with paths in the result relative to it
+func localTreeStats(ctx context.Context, fullPath string) (*models.TreeStats, error) {
+	stats := &models.TreeStats{}
+	err := filepath.WalkDir(fullPath, func(p string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if p == fullPath {
+				return err
+			}
+			return nil
+		}
+		if p == fullPath {
+			return nil
+		}
+		if d.IsDir() {
+			stats.Folders++
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed during the walk
+		}
+		rel, _ := filepath.Rel(fullPath, p)
+		addTreeFile(stats, rel, info.Size(), info.ModTime())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// remoteTreeStats aggregates a find listing on the remote host, so only the summary
+// crosses the connection. The first output line holds the counts, the second the largest file.
+func (s *FileManagerService) remoteTreeStats(ctx context.Context, fullPath string) (*models.TreeStats, error) {
+	script := `{ if ($1 == "d") { d++; next }
+if ($1 == "l") next
+f++; t += $2
+if (f == 1 || $2 > ls) { ls = $2; lp = $0; sub(/^[^\t]*\t[^\t]*\t[^\t]*\t/, "", lp) }
+if (f == 1 || $3 > nt) nt = $3
+if (f == 1 || $3 < ot) ot = $3 }
+END { printf "%.0f %.0f %.0f %.0f %.0f %.0f\n%s\n", f, d, t, ls, nt, ot, lp }`
+	cmd := fmt.Sprintf("find %s -mindepth 1 -printf '%%y\\t%%s\\t%%T@\\t%%P\\n' 2>/dev/null | awk -F'\\t' %s", shellQuote(fullPath), shellQuote(script))
+	output, err := s.runSSHCommandOutputContext(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("remote tree stats failed: %w", err)
+	}
+
+	lines := strings.SplitN(strings.TrimSuffix(string(output), "\n"), "\n", 2)
+	fields := strings.Fields(lines[0])
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("unexpected output from find: %s", strings.TrimSpace(string(output)))
+	}
+	var values [6]int64
+	for i, field := range fields {
+		if values[i], err = strconv.ParseInt(field, 10, 64); err != nil {
+			return nil, fmt.Errorf("unexpected output from find: %s", lines[0])
+		}
+	}
+
+	stats := &models.TreeStats{Files: values[0], Folders: values[1], SizeBytes: values[2]}
+	if stats.Files > 0 && len(lines) == 2 {
+		stats.LargestFile = lines[1]
+		stats.LargestSize = values[3]
+		newest, oldest := time.Unix(values[4], 0).UTC(), time.Unix(values[5], 0).UTC()
+		stats.NewestModTime, stats.OldestModTime = &newest, &oldest
+	}
+	return stats, nil
+}
+
+// addTreeFile counts one file in stats
+func addTreeFile(stats *models.TreeStats, rel string, size int64, modTime time.Time) {
+	stats.Files++
+	stats.SizeBytes += size
+	if stats.LargestFile == "" || size > stats.LargestSize {
+		stats.LargestFile, stats.LargestSize = rel, size
+	}
+	modTime = modTime.UTC()
+	if stats.NewestModTime == nil || modTime.After(*stats.NewestModTime) {
+		newest := modTime
+		stats.NewestModTime = &newest
+	}
+	if stats.OldestModTime == nil || modTime.Before(*stats.OldestModTime) {
+		oldest := modTime
+		stats.OldestModTime = &oldest
+	}
+}
+
+// cachedTreeStats returns a copy of the summary stored under key if it is still fresh
+func cachedTreeStats(key string) (*models.TreeStats, bool) {
+	treeStatsCache.Lock()
+	defer treeStatsCache.Unlock()
+
+	stats, ok := treeStatsCache.entries[key]
+	if !ok || time.Since(stats.ComputedAt) > treeStatsTTL {
+		return nil, false
+	}
+	copied := *stats
+	return &copied, true
+}
+
+// storeTreeStats caches a copy of stats under key, dropping summaries that have expired
+func storeTreeStats(key string, stats *models.TreeStats) {
+	treeStatsCache.Lock()
+	defer treeStatsCache.Unlock()
+
+	for k, entry := range treeStatsCache.entries {
+		if time.Since(entry.ComputedAt) > treeStatsTTL {
+			delete(treeStatsCache.entries, k)
+		}
+	}
+	copied := *stats
+	treeStatsCache.entries[key] = &copied
+}
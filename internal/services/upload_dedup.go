@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"filemanager-api/internal/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// legacyDedupIndexName is the file in a usersite's base path the index was kept in before
+// it moved below the temp root; it is read once and removed on the next write
+const legacyDedupIndexName = ".filemanager-dedup.json"
+
+// dedupIndexPath returns the file mapping content hashes to stored uploads of basePath. Indexes
+// are kept below the temp root, out of reach of the usersite, named by a hash of the base path.
+func dedupIndexPath(basePath string) string {
+	sum := sha256.Sum256([]byte(basePath))
+	return tempPath("filemanager-dedup", hex.EncodeToString(sum[:16])+".json")
+}
+
+// dedupEntry is a file stored by a deduplicating upload. Size and ModTime tell whether it
+// has been changed since, in which case it no longer matches its hash.
+type dedupEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// dedupIndexMu serialises reads and writes of the index files
+var dedupIndexMu sync.Mutex
+
+// loadDedupIndex reads the index of basePath, which is empty until the first deduplicating upload
+func loadDedupIndex(basePath string) (map[string]dedupEntry, error) {
+	index := make(map[string]dedupEntry)
+	data, err := os.ReadFile(dedupIndexPath(basePath))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(filepath.Join(basePath, legacyDedupIndexName))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// saveDedupIndex writes the index of basePath through a temp file so a crash never truncates it
+func saveDedupIndex(basePath string, index map[string]dedupEntry) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	path := dedupIndexPath(basePath)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(basePath, legacyDedupIndexName))
+	return nil
+}
+
+// findDuplicate returns the full path of an unchanged file stored earlier with the given content hash.
+// size is the size on disk, which includes the overhead of encryption at rest.
+func (s *UploadService) findDuplicate(hash string, size int64) (string, bool) {
+	dedupIndexMu.Lock()
+	defer dedupIndexMu.Unlock()
+
+	index, err := loadDedupIndex(s.basePath)
+	if err != nil {
+		utils.Errorf("Failed to read dedup index of %s: %v", s.basePath, err)
+		return "", false
+	}
+	entry, ok := index[hash]
+	if !ok || entry.Size != size {
+		return "", false
+	}
+
+	fullPath, err := utils.ValidatePath(s.basePath, entry.Path)
+	if err == nil {
+		if info, statErr := os.Stat(fullPath); statErr == nil && info.Mode().IsRegular() &&
+			info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) {
+			return fullPath, true
+		}
+	}
+
+	// The stored file was removed or changed, so it no longer stands for this hash
+	delete(index, hash)
+	if err := saveDedupIndex(s.basePath, index); err != nil {
+		utils.Errorf("Failed to write dedup index of %s: %v", s.basePath, err)
+	}
+	return "", false
+}
+
+// rememberUpload records fullPath as the stored copy of content with the given hash
+func (s *UploadService) rememberUpload(hash, fullPath string) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return
+	}
+	relPath, err := utils.GetRelativePath(s.basePath, fullPath)
+	if err != nil {
+		return
+	}
+
+	dedupIndexMu.Lock()
+	defer dedupIndexMu.Unlock()
+
+	index, err := loadDedupIndex(s.basePath)
+	if err != nil {
+		utils.Errorf("Failed to read dedup index of %s: %v", s.basePath, err)
+		return
+	}
+	index[hash] = dedupEntry{Path: relPath, Size: info.Size(), ModTime: info.ModTime()}
+	if err := saveDedupIndex(s.basePath, index); err != nil {
+		utils.Errorf("Failed to write dedup index of %s: %v", s.basePath, err)
+	}
+}
+
+// linkDuplicate hard-links existing into place at fullPath, replacing whatever is there
+func linkDuplicate(existing, fullPath, uploadID string) error {
+	tmp := stagingPath(fullPath, uploadID+"-link")
+	if err := os.Link(existing, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fullPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// breakHardLink gives a local file that has other hard links, such as a deduplicated upload,
+// its own copy through a temp file renamed into place, so writing to it in place leaves the
+// other names unchanged. A symlink is followed to the file it points to.
+func breakHardLink(fullPath string) error {
+	target, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink <= 1 || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	tmp := stagingPath(target, uuid.New().String())
+	if err := utils.CopyFile(context.Background(), target, tmp, true); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
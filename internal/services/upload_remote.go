@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"io"
+	"os"
+)
+
+// ErrRemoteMismatch is returned for a chunk sent for another host than its upload was started for
+var ErrRemoteMismatch = errors.New("chunks must be sent with the SSH host the upload was started for")
+
+// NewRemoteUploadService creates an upload service writing to the SSH host of remote. Files
+// are streamed to the host over SFTP; chunked uploads collect their chunks locally and
+// stream the assembled file once the last one arrives. The caller closes it with Close.
+func NewRemoteUploadService(remote *FileManagerService, progressStore *models.ProgressStore, chunkStore *ChunkStore, rules UploadRules) *UploadService {
+	return &UploadService{
+		basePath:      remote.basePath,
+		progressStore: progressStore,
+		chunkStore:    chunkStore,
+		rules:         rules,
+		owner:         remote.owner,
+		uid:           -1,
+		gid:           -1,
+		remote:        remote,
+	}
+}
+
+// Close closes the SSH connection of a remote upload service
+func (s *UploadService) Close() {
+	if s.remote != nil {
+		s.remote.Close()
+	}
+}
+
+// IsRemote reports whether uploads are written to an SSH host
+func (s *UploadService) IsRemote() bool {
+	return s.remote != nil
+}
+
+// host identifies the SSH host uploads are written to, or is empty for local uploads
+func (s *UploadService) host() string {
+	if s.remote == nil {
+		return ""
+	}
+	cfg := s.remote.sshConfig
+	return cfg.Username + "@" + cfg.Host + ":" + cfg.Port
+}
+
+// pathExists checks if path exists where uploads are written
+func (s *UploadService) pathExists(path string) bool {
+	if s.remote != nil {
+		return s.remote.pathExists(path)
+	}
+	return utils.PathExists(path)
+}
+
+// createFile creates or truncates the file at path with the default file mode
+func (s *UploadService) createFile(path string) (io.WriteCloser, error) {
+	if s.remote == nil {
+		return utils.CreateFile(path)
+	}
+	file, err := s.remote.sftpClient.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(utils.FileMode()); err != nil {
+		utils.Errorf("Failed to set mode for %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// renameFile moves a staged upload into place, replacing an existing file
+func (s *UploadService) renameFile(oldPath, newPath string) error {
+	if s.remote != nil {
+		return s.remote.replaceFull(oldPath, newPath)
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// removeFile removes a partial or staged upload
+func (s *UploadService) removeFile(path string) error {
+	if s.remote != nil {
+		return s.remote.sftpClient.Remove(path)
+	}
+	return os.Remove(path)
+}
+
+// closeSynced closes a written upload, first flushing local files to disk
+func closeSynced(file io.WriteCloser) error {
+	if f, ok := file.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
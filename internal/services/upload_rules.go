@@ -0,0 +1,123 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrFileTooLarge       = errors.New("file exceeds the maximum allowed size")
+	ErrFileTypeNotAllowed = errors.New("file type is not allowed")
+)
+
+// UploadRules restricts what may be uploaded. Empty lists and a zero size disable the check.
+type UploadRules struct {
+	MaxFileSize       int64
+	AllowedExtensions []string
+	DeniedExtensions  []string
+	AllowedMimeTypes  []string
+}
+
+// CheckName validates the extension of filename against the allow and deny lists
+func (r UploadRules) CheckName(filename string) error {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+
+	if containsFold(r.DeniedExtensions, ext) {
+		return fmt.Errorf("%w: extension %q is denied", ErrFileTypeNotAllowed, ext)
+	}
+	if len(r.AllowedExtensions) > 0 && !containsFold(r.AllowedExtensions, ext) {
+		return fmt.Errorf("%w: extension %q is not in the allowlist", ErrFileTypeNotAllowed, ext)
+	}
+	return nil
+}
+
+// CheckSize validates a declared file size
+func (r UploadRules) CheckSize(size int64) error {
+	if r.MaxFileSize > 0 && size > r.MaxFileSize {
+		return fmt.Errorf("%w of %d bytes", ErrFileTooLarge, r.MaxFileSize)
+	}
+	return nil
+}
+
+// CheckContent validates the MIME type sniffed from the leading bytes of a file
+func (r UploadRules) CheckContent(filename string, header []byte) error {
+	if len(r.AllowedMimeTypes) == 0 {
+		return nil
+	}
+
+	// Only text content may be refined by its extension; binary content that
+	// sniffs as octet-stream must not pass as whatever its name claims
+	mimeType := http.DetectContentType(header)
+	if strings.HasPrefix(mimeType, "text/plain") {
+		mimeType = utils.DetectMimeType(filename, header)
+	}
+	if idx := strings.IndexByte(mimeType, ';'); idx >= 0 {
+		mimeType = mimeType[:idx]
+	}
+	mimeType = strings.TrimSpace(mimeType)
+
+	// "image/*" allows every subtype
+	if matchMimeType(r.AllowedMimeTypes, mimeType) {
+		return nil
+	}
+	return fmt.Errorf("%w: content type %s is not in the allowlist", ErrFileTypeNotAllowed, mimeType)
+}
+
+// Wrap checks the content of reader before any of it is consumed and
+// returns a reader that fails with ErrFileTooLarge once MaxFileSize is exceeded
+func (r UploadRules) Wrap(filename string, reader io.Reader) (io.Reader, error) {
+	if len(r.AllowedMimeTypes) > 0 {
+		buffered := bufio.NewReaderSize(reader, utils.SniffLength)
+		header, err := buffered.Peek(utils.SniffLength)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if err := r.CheckContent(filename, header); err != nil {
+			return nil, err
+		}
+		reader = buffered
+	}
+
+	if r.MaxFileSize > 0 {
+		reader = &sizeLimitedReader{reader: reader, remaining: r.MaxFileSize, limit: r.MaxFileSize}
+	}
+	return reader, nil
+}
+
+// sizeLimitedReader is like io.LimitReader but reports an error instead of a silent EOF
+type sizeLimitedReader struct {
+	reader    io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w of %d bytes", ErrFileTooLarge, l.limit)
+	}
+	// Read one byte past the limit so an exact-size file still succeeds
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return 0, fmt.Errorf("%w of %d bytes", ErrFileTooLarge, l.limit)
+	}
+	return n, err
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimPrefix(item, "."), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,12 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"filemanager-api/internal/metrics"
 	"filemanager-api/internal/models"
 	"filemanager-api/internal/utils"
 	"filemanager-api/pkg/progresswriter"
@@ -8,7 +14,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -18,15 +27,31 @@ type UploadService struct {
 	basePath      string
 	progressStore *models.ProgressStore
 	chunkStore    *ChunkStore
+	rules         UploadRules
 	owner         string
 	uid           int
 	gid           int
+	remote        *FileManagerService // SSH host uploads are written to, nil for the local base path
+	// preserveOwner leaves files with the ownership the filesystem gives them instead of
+	// handing them to owner
+	preserveOwner bool
 }
 
 // ChunkStore stores pending chunked uploads
 type ChunkStore struct {
 	mu     sync.RWMutex
 	chunks map[string]*ChunkUpload
+
+	statsMu sync.RWMutex
+	stats   ChunkStoreStats
+}
+
+// ChunkStoreStats summarizes the chunked upload sessions held in a ChunkStore
+type ChunkStoreStats struct {
+	ActiveSessions   int       `json:"active_sessions"`
+	TempBytes        int64     `json:"temp_bytes"`
+	OldestAgeSeconds int64     `json:"oldest_age_seconds"`
+	CollectedAt      time.Time `json:"collected_at"`
 }
 
 // ChunkUpload represents a pending chunked upload
@@ -39,19 +64,173 @@ type ChunkUpload struct {
 	TotalChunks int
 	Chunks      map[int]bool
 	TempDir     string
+	Host        string // SSH host the file is assembled on, empty for the local base path
+	Overwrite   OverwritePolicy
+	CreatedAt   time.Time
+	UpdatedAt   time.Time // last chunk received, guarded by the store lock
+}
+
+// NewChunkStore creates a new chunk store shared across upload requests
+func NewChunkStore() *ChunkStore {
+	return &ChunkStore{
+		chunks: make(map[string]*ChunkUpload),
+	}
+}
+
+// Collect walks the active sessions and sums the size of their staged chunk files.
+// Only the session list is copied under the lock; disk access happens outside it.
+func (cs *ChunkStore) Collect() ChunkStoreStats {
+	type session struct {
+		tempDir   string
+		createdAt time.Time
+	}
+
+	cs.mu.RLock()
+	sessions := make([]session, 0, len(cs.chunks))
+	for _, c := range cs.chunks {
+		sessions = append(sessions, session{tempDir: c.TempDir, createdAt: c.CreatedAt})
+	}
+	cs.mu.RUnlock()
+
+	now := time.Now()
+	stats := ChunkStoreStats{
+		ActiveSessions: len(sessions),
+		CollectedAt:    now.UTC(),
+	}
+
+	for _, sess := range sessions {
+		if age := int64(now.Sub(sess.createdAt).Seconds()); age > stats.OldestAgeSeconds {
+			stats.OldestAgeSeconds = age
+		}
+
+		entries, err := os.ReadDir(sess.tempDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && !info.IsDir() {
+				stats.TempBytes += info.Size()
+			}
+		}
+	}
+
+	cs.statsMu.Lock()
+	cs.stats = stats
+	cs.statsMu.Unlock()
+
+	return stats
+}
+
+// Stats returns the most recently collected statistics
+func (cs *ChunkStore) Stats() ChunkStoreStats {
+	cs.statsMu.RLock()
+	defer cs.statsMu.RUnlock()
+	return cs.stats
+}
+
+// StartStatsCollector collects the chunk statistics now and refreshes them every interval.
+// An interval of zero or less disables refreshing.
+func (cs *ChunkStore) StartStatsCollector(interval time.Duration) {
+	cs.Collect()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cs.Collect()
+		}
+	}()
+}
+
+// Sweep drops sessions that received no chunk for maxAge, marking their progress as failed,
+// then removes every chunk temp directory older than maxAge that belongs to no session.
+// The latter also covers sessions lost in a restart. It returns the number of directories removed.
+func (cs *ChunkStore) Sweep(maxAge time.Duration, progressStore *models.ProgressStore) int {
+	cutoff := time.Now().Add(-maxAge)
+
+	cs.mu.Lock()
+	var expired []string
+	for id, c := range cs.chunks {
+		if c.UpdatedAt.Before(cutoff) {
+			expired = append(expired, id)
+			delete(cs.chunks, id)
+		}
+	}
+	active := make(map[string]bool, len(cs.chunks))
+	for id := range cs.chunks {
+		active[id] = true
+	}
+	cs.mu.Unlock()
+
+	for _, id := range expired {
+		progressStore.Fail(id, fmt.Errorf("upload abandoned: no chunk received for %s", maxAge))
+	}
+
+	root := tempPath("filemanager-chunks")
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || active[entry.Name()] {
+			continue
+		}
+		// A directory's mtime changes whenever a chunk file is added
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			utils.Warnf("Failed to remove orphaned chunk directory %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// StartSweeper sweeps abandoned chunked uploads now and then every interval. A maxAge of
+// zero or less disables sweeping, an interval of zero or less sweeps only now.
+func (cs *ChunkStore) StartSweeper(interval, maxAge time.Duration, progressStore *models.ProgressStore) {
+	if maxAge <= 0 {
+		return
+	}
+	sweep := func() {
+		if removed := cs.Sweep(maxAge, progressStore); removed > 0 {
+			utils.Infof("Removed %d orphaned chunk upload directories", removed)
+		}
+	}
+	sweep()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweep()
+		}
+	}()
 }
 
 // NewUploadService creates a new upload service
-func NewUploadService(basePath string, owner string, progressStore *models.ProgressStore) *UploadService {
+func NewUploadService(basePath string, owner string, progressStore *models.ProgressStore, chunkStore *ChunkStore, rules UploadRules) *UploadService {
 	svc := &UploadService{
 		basePath:      basePath,
 		progressStore: progressStore,
-		chunkStore: &ChunkStore{
-			chunks: make(map[string]*ChunkUpload),
-		},
-		owner: owner,
-		uid:   -1,
-		gid:   -1,
+		chunkStore:    chunkStore,
+		rules:         rules,
+		owner:         owner,
+		uid:           -1,
+		gid:           -1,
 	}
 
 	if owner != "" {
@@ -60,58 +239,191 @@ func NewUploadService(basePath string, owner string, progressStore *models.Progr
 			svc.uid = uid
 			svc.gid = gid
 		} else {
-			fmt.Printf("[ERROR] Failed to resolve user %s: %v\n", owner, err)
+			utils.Errorf("Failed to resolve user %s: %v", owner, err)
 		}
 	}
 
 	return svc
 }
 
+// SetPreserveOwner makes the service leave uploaded files with the ownership the filesystem
+// gives them, instead of handing them to the usersite owner
+func (s *UploadService) SetPreserveOwner(preserve bool) {
+	s.preserveOwner = preserve
+	if s.remote != nil {
+		s.remote.SetPreserveOwner(preserve)
+	}
+}
+
 // setOwner sets the file owner to the service configured user
 func (s *UploadService) setOwner(path string) error {
-	if s.owner == "" {
+	if s.remote != nil {
+		return s.remote.setOwner(path)
+	}
+	if s.owner == "" || s.preserveOwner {
 		return nil
 	}
 	return utils.SudoChown(path, s.owner)
 }
 
-// Upload handles a single file upload with progress tracking
-func (s *UploadService) Upload(filename, destination string, reader io.Reader, size int64) (string, error) {
+// mkdirAllOwned creates dir and any missing parents, handing the newly created ones to the owner
+func (s *UploadService) mkdirAllOwned(dir string) error {
+	if s.remote != nil {
+		return s.remote.mkdirAllOwned(dir)
+	}
+
+	var created []string
+	for p := dir; p != filepath.Dir(p) && !utils.PathExists(p); p = filepath.Dir(p) {
+		created = append(created, p)
+	}
+
+	if err := os.MkdirAll(dir, utils.DirMode()); err != nil {
+		return err
+	}
+
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := s.setOwner(created[i]); err != nil {
+			utils.Errorf("Failed to set owner for %s: %v", created[i], err)
+		}
+	}
+	return nil
+}
+
+// OverwritePolicy controls what an upload does when the target file already exists
+type OverwritePolicy string
+
+const (
+	// OverwriteRename stores the upload under a unique name next to the existing file
+	OverwriteRename OverwritePolicy = "rename"
+	// OverwriteReplace atomically replaces the existing file
+	OverwriteReplace OverwritePolicy = "overwrite"
+	// OverwriteFail rejects the upload with ErrAlreadyExists
+	OverwriteFail OverwritePolicy = "fail"
+)
+
+var (
+	// ErrInvalidOverwritePolicy is returned for an unknown overwrite policy
+	ErrInvalidOverwritePolicy = errors.New("overwrite must be one of rename, overwrite, fail")
+	// ErrInvalidChunkIndex is returned for a chunk index outside the upload
+	ErrInvalidChunkIndex = errors.New("invalid chunk index")
+	// ErrMissingChunks is returned when a chunked upload is assembled with chunks missing
+	ErrMissingChunks = errors.New("missing chunks")
+	// ErrInvalidChunkSize is returned for a chunk size that is not positive, or a chunk
+	// whose length does not match the size of its index
+	ErrInvalidChunkSize = errors.New("invalid chunk size")
+	// ErrUploadSizeMismatch is returned when the chunks of an upload do not add up to its total_size
+	ErrUploadSizeMismatch = errors.New("assembled upload does not match the declared total_size")
+	// ErrUploadFinalized is returned for changes to a chunked upload that is assembled or was aborted
+	ErrUploadFinalized = errors.New("upload has already been finalized or aborted")
+)
+
+// ParseOverwritePolicy parses an overwrite form value, defaulting to rename
+func ParseOverwritePolicy(value string) (OverwritePolicy, error) {
+	switch policy := OverwritePolicy(strings.ToLower(value)); policy {
+	case "":
+		return OverwriteRename, nil
+	case OverwriteRename, OverwriteReplace, OverwriteFail:
+		return policy, nil
+	default:
+		return "", ErrInvalidOverwritePolicy
+	}
+}
+
+// resolveTarget applies the overwrite policy to path and returns where the upload should be stored
+func (s *UploadService) resolveTarget(path string, policy OverwritePolicy) (string, error) {
+	if !s.pathExists(path) {
+		return path, nil
+	}
+	switch policy {
+	case OverwriteReplace:
+		return path, nil
+	case OverwriteFail:
+		return "", ErrAlreadyExists
+	default:
+		if s.remote != nil {
+			return s.remote.uniqueName(path), nil
+		}
+		return utils.GenerateUniqueName(path), nil
+	}
+}
+
+// stagingPath returns a hidden file next to path that an upload is written to before being renamed into place
+func stagingPath(path, uploadID string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".upload-"+uploadID)
+}
+
+// Upload handles a single file upload with progress tracking. When ctx ends, or the upload
+// is cancelled through CancelOperation, the partial file is removed and the upload reported cancelled.
+// With dedup, content identical to an earlier deduplicated upload of the usersite is hard-linked
+// to that file instead of stored again, and the progress reports it in DuplicateOf.
+// With encryption at rest configured, the file is stored encrypted with the usersite key.
+// Uploads to an SSH host are streamed over SFTP and stored as sent, without dedup or encryption.
+func (s *UploadService) Upload(ctx context.Context, filename, destination string, reader io.Reader, size int64, policy OverwritePolicy, dedup bool) (string, error) {
 	destPath, err := utils.ValidatePath(s.basePath, destination)
 	if err != nil {
 		return "", err
 	}
+	// The dedup index and the encryption keys live on this server
+	dedup = dedup && s.remote == nil
 
-	// Ensure destination directory exists
-	// Note: We might want chown on created dirs too, but usually destination exists
-	if err := os.MkdirAll(destPath, 0755); err != nil {
+	// Reject disallowed files before anything is written
+	if err := utils.ValidateName(filename); err != nil {
+		return "", err
+	}
+	if err := s.rules.CheckName(filename); err != nil {
+		return "", err
+	}
+	reader, err = s.rules.Wrap(filename, reader)
+	if err != nil {
 		return "", err
 	}
 
-	fullPath := filepath.Join(destPath, filename)
+	// Ensure destination directory exists, including any folders of a directory upload
+	if err := s.mkdirAllOwned(destPath); err != nil {
+		return "", err
+	}
 
-	// Generate unique name if file exists
-	if utils.PathExists(fullPath) {
-		fullPath = utils.GenerateUniqueName(fullPath)
+	fullPath, err := s.resolveTarget(filepath.Join(destPath, filename), policy)
+	if err != nil {
+		return "", err
 	}
 
 	// Generate upload ID for progress tracking
 	uploadID := uuid.New().String()
 
+	// Replacements are staged so readers never see a half-written file, and
+	// deduplicated uploads so a duplicate never appears under its own name
+	writePath := fullPath
+	if policy == OverwriteReplace || dedup {
+		writePath = stagingPath(fullPath, uploadID)
+	}
+
 	// Initialize progress
 	s.progressStore.Set(uploadID, &models.Progress{
 		ID:            uploadID,
+		Operation:     models.OperationUpload,
 		Filename:      filepath.Base(fullPath),
 		Progress:      0,
 		UploadedBytes: 0,
 		TotalBytes:    size,
-		Status:        models.StatusUploading,
+		Status:        models.StatusPending,
 	})
 
+	ctx, release := TrackOperation(ctx, uploadID, s.owner)
+	defer release()
+
+	// Wait for a free operation slot; the request body is not read until then
+	if err := operationLimiter.Acquire(ctx); err != nil {
+		s.updateProgressError(uploadID, err)
+		return uploadID, err
+	}
+	defer operationLimiter.Release()
+	s.progressStore.SetStatus(uploadID, models.StatusUploading)
+
 	// Create destination file
-	file, err := os.Create(fullPath)
+	file, err := s.createFile(writePath)
 	if err != nil {
-		s.updateProgressError(uploadID, err.Error())
+		s.updateProgressError(uploadID, err)
 		return uploadID, err
 	}
 
@@ -123,23 +435,85 @@ func (s *UploadService) Upload(filename, destination string, reader io.Reader, s
 	// os.Create opens the file. We can fchown if we want, but os.Chown by path is fine.
 
 	defer file.Close()
+	if writePath != fullPath {
+		// No-op once the staged file has been renamed into place
+		defer s.removeFile(writePath)
+	}
+
+	// Encrypt on the way to disk when configured; progress still counts the uploaded bytes
+	var out io.Writer = file
+	var enc io.WriteCloser
+	if EncryptionEnabled() && s.remote == nil {
+		if enc, err = newEncryptWriter(file, s.owner); err != nil {
+			s.updateProgressError(uploadID, err)
+			return uploadID, err
+		}
+		out = enc
+	}
 
 	// Create progress writer
-	pw := progresswriter.NewProgressWriter(file, size, func(written, total int64) {
+	pw := progresswriter.NewProgressWriter(out, size, func(written, total int64) {
 		s.progressStore.Update(uploadID, written)
 	})
 
-	// Copy with buffer
-	buf := make([]byte, utils.DefaultBufferSize)
-	_, err = io.CopyBuffer(pw, reader, buf)
+	// Copy with buffer, hashing the content on the way when deduplicating
+	var dst io.Writer = pw
+	hasher := sha256.New()
+	if dedup {
+		dst = io.MultiWriter(pw, hasher)
+	}
+	buf := utils.NewBuffer()
+	written, err := io.CopyBuffer(dst, utils.ContextReader(ctx, reader), buf)
+	metrics.BytesTransferred.WithLabelValues("upload").Add(float64(written))
+	if err == nil && enc != nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		// SFTP writes may only fail once the file is closed
+		err = file.Close()
+	}
 	if err != nil {
-		s.updateProgressError(uploadID, err.Error())
+		file.Close()
+		s.removeFile(writePath)
+		s.updateProgressError(uploadID, err)
 		return uploadID, err
 	}
 
+	// The size passed in is what the client declared, or -1 when it is unknown
+	s.progressStore.Modify(uploadID, func(p *models.Progress) { p.TotalBytes = written })
+
+	var hash string
+	if dedup {
+		hash = hex.EncodeToString(hasher.Sum(nil))
+		stored := written
+		if enc != nil {
+			stored = EncryptedSize(written)
+		}
+		if existing, ok := s.findDuplicate(hash, stored); ok {
+			// Keep the uploaded copy if the link fails, e.g. across filesystems
+			if existing == fullPath || linkDuplicate(existing, fullPath, uploadID) == nil {
+				relPath, _ := utils.GetRelativePath(s.basePath, existing)
+				s.progressStore.Modify(uploadID, func(p *models.Progress) { p.DuplicateOf = relPath })
+				s.updateProgressCompleted(uploadID)
+				return uploadID, nil
+			}
+		}
+	}
+
+	if writePath != fullPath {
+		if err := s.renameFile(writePath, fullPath); err != nil {
+			s.updateProgressError(uploadID, err)
+			return uploadID, err
+		}
+	}
+
 	// Set owner
 	s.setOwner(fullPath)
 
+	if dedup {
+		s.rememberUpload(hash, fullPath)
+	}
+
 	// Mark as completed
 	s.updateProgressCompleted(uploadID)
 
@@ -147,17 +521,35 @@ func (s *UploadService) Upload(filename, destination string, reader io.Reader, s
 }
 
 // InitChunkedUpload initializes a chunked upload session
-func (s *UploadService) InitChunkedUpload(filename, destination string, totalSize int64, chunkSize int) (*ChunkUpload, error) {
+func (s *UploadService) InitChunkedUpload(filename, destination string, totalSize int64, chunkSize int, policy OverwritePolicy) (*ChunkUpload, error) {
 	destPath, err := utils.ValidatePath(s.basePath, destination)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := utils.ValidateName(filename); err != nil {
+		return nil, err
+	}
+	if err := s.rules.CheckName(filename); err != nil {
+		return nil, err
+	}
+	if err := s.rules.CheckSize(totalSize); err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 || totalSize <= 0 {
+		return nil, fmt.Errorf("%w: chunk_size and total_size must be positive", ErrInvalidChunkSize)
+	}
+
+	// Fail early rather than after every chunk has been sent
+	if _, err := s.resolveTarget(filepath.Join(destPath, filename), policy); err != nil {
+		return nil, err
+	}
+
 	uploadID := uuid.New().String()
 	totalChunks := int((totalSize + int64(chunkSize) - 1) / int64(chunkSize))
 
 	// Create temp directory for chunks
-	tempDir := filepath.Join(os.TempDir(), "filemanager-chunks", uploadID)
+	tempDir := tempPath("filemanager-chunks", uploadID)
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
 		return nil, err
 	}
@@ -171,6 +563,10 @@ func (s *UploadService) InitChunkedUpload(filename, destination string, totalSiz
 		TotalChunks: totalChunks,
 		Chunks:      make(map[int]bool),
 		TempDir:     tempDir,
+		Host:        s.host(),
+		Overwrite:   policy,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
 	}
 
 	s.chunkStore.mu.Lock()
@@ -180,6 +576,7 @@ func (s *UploadService) InitChunkedUpload(filename, destination string, totalSiz
 	// Initialize progress
 	s.progressStore.Set(uploadID, &models.Progress{
 		ID:            uploadID,
+		Operation:     models.OperationUpload,
 		Filename:      filename,
 		Progress:      0,
 		UploadedBytes: 0,
@@ -191,7 +588,7 @@ func (s *UploadService) InitChunkedUpload(filename, destination string, totalSiz
 }
 
 // UploadChunk uploads a single chunk
-func (s *UploadService) UploadChunk(uploadID string, chunkIndex int, data []byte) error {
+func (s *UploadService) UploadChunk(uploadID string, chunkIndex int, src io.Reader, size int64) error {
 	s.chunkStore.mu.RLock()
 	chunk, ok := s.chunkStore.chunks[uploadID]
 	s.chunkStore.mu.RUnlock()
@@ -199,23 +596,59 @@ func (s *UploadService) UploadChunk(uploadID string, chunkIndex int, data []byte
 	if !ok {
 		return ErrNotFound
 	}
+	if chunk.Host != s.host() {
+		return ErrRemoteMismatch
+	}
+
+	if chunkIndex < 0 || chunkIndex >= chunk.TotalChunks {
+		return fmt.Errorf("%w: %d (expected 0-%d)", ErrInvalidChunkIndex, chunkIndex, chunk.TotalChunks-1)
+	}
+	// Every chunk but the last is exactly ChunkSize long, so the chunks add up to TotalSize.
+	// The declared size is checked before anything is read.
+	if want := chunk.chunkLen(chunkIndex); size != want {
+		return fmt.Errorf("%w: chunk %d has %d bytes, expected %d", ErrInvalidChunkSize, chunkIndex, size, want)
+	}
+
+	// The first chunk carries the bytes the content type is sniffed from
+	if chunkIndex == 0 {
+		header := make([]byte, utils.SniffLength)
+		n, err := io.ReadFull(src, header)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		if err := s.rules.CheckContent(chunk.Filename, header[:n]); err != nil {
+			s.abortChunkedUpload(chunk, err)
+			return err
+		}
+		src = io.MultiReader(bytes.NewReader(header[:n]), src)
+	}
 
-	// Write chunk to temp file
-	chunkPath := filepath.Join(chunk.TempDir, string(rune('0'+chunkIndex)))
-	if err := os.WriteFile(chunkPath, data, 0644); err != nil {
+	// Stream the chunk to its temp file
+	path := chunk.chunkPath(chunkIndex)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
 		return err
 	}
+	written, err := utils.CopyBuffer(file, io.LimitReader(src, size), utils.NewBuffer())
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && written != size {
+		err = fmt.Errorf("%w: chunk %d has %d bytes, expected %d", ErrInvalidChunkSize, chunkIndex, written, size)
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	metrics.BytesTransferred.WithLabelValues("upload").Add(float64(written))
 
 	s.chunkStore.mu.Lock()
 	chunk.Chunks[chunkIndex] = true
+	chunk.UpdatedAt = time.Now()
 	uploadedChunks := len(chunk.Chunks)
+	uploadedBytes := chunk.receivedBytes()
 	s.chunkStore.mu.Unlock()
 
-	// Update progress
-	uploadedBytes := int64(uploadedChunks * chunk.ChunkSize)
-	if uploadedBytes > chunk.TotalSize {
-		uploadedBytes = chunk.TotalSize
-	}
 	s.progressStore.Update(uploadID, uploadedBytes)
 
 	// Check if all chunks are uploaded
@@ -226,6 +659,116 @@ func (s *UploadService) UploadChunk(uploadID string, chunkIndex int, data []byte
 	return nil
 }
 
+// SetChunkedDestination moves an in-flight chunked upload to another destination folder.
+// Chunks are staged until the last one arrives, so the destination can change until then;
+// the new one is checked against the overwrite policy like the destination of
+// InitChunkedUpload. It returns the new destination relative to the base path.
+// ErrUploadFinalized is returned once the upload is being assembled.
+func (s *UploadService) SetChunkedDestination(uploadID, destination string) (string, error) {
+	destPath, err := utils.ValidatePath(s.basePath, destination)
+	if err != nil {
+		return "", err
+	}
+
+	s.chunkStore.mu.RLock()
+	chunk, ok := s.chunkStore.chunks[uploadID]
+	s.chunkStore.mu.RUnlock()
+
+	if !ok {
+		if p, ok := s.progressStore.Get(uploadID); ok && p.Operation == models.OperationUpload {
+			return "", ErrUploadFinalized
+		}
+		return "", ErrNotFound
+	}
+	if chunk.Host != s.host() {
+		return "", ErrRemoteMismatch
+	}
+
+	if _, err := s.resolveTarget(filepath.Join(destPath, chunk.Filename), chunk.Overwrite); err != nil {
+		return "", err
+	}
+
+	// Finalizing takes the session out of the store under the same lock before reading the
+	// destination, so the change either lands before assembly or not at all
+	s.chunkStore.mu.Lock()
+	defer s.chunkStore.mu.Unlock()
+	if _, ok := s.chunkStore.chunks[uploadID]; !ok {
+		return "", ErrUploadFinalized
+	}
+	chunk.Destination = destPath
+
+	relPath, _ := utils.GetRelativePath(s.basePath, destPath)
+	return relPath, nil
+}
+
+// chunkLen returns the length chunk index must have: ChunkSize, or what remains of TotalSize for the last one
+func (c *ChunkUpload) chunkLen(index int) int64 {
+	if index == c.TotalChunks-1 {
+		return c.TotalSize - int64(index)*int64(c.ChunkSize)
+	}
+	return int64(c.ChunkSize)
+}
+
+// receivedBytes sums the lengths of the chunks received so far. Callers hold the store lock.
+func (c *ChunkUpload) receivedBytes() int64 {
+	var total int64
+	for index := range c.Chunks {
+		total += c.chunkLen(index)
+	}
+	return total
+}
+
+// chunkPath returns the temp file holding chunk index
+func (c *ChunkUpload) chunkPath(index int) string {
+	return filepath.Join(c.TempDir, strconv.Itoa(index))
+}
+
+// missingChunks returns the chunk indexes that were never received or whose temp file is gone
+func (c *ChunkUpload) missingChunks() []int {
+	var missing []int
+	for i := 0; i < c.TotalChunks; i++ {
+		if !c.Chunks[i] || !utils.PathExists(c.chunkPath(i)) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// stagedSize sums the sizes of the chunk files on disk
+func (c *ChunkUpload) stagedSize() (int64, error) {
+	var total int64
+	for i := 0; i < c.TotalChunks; i++ {
+		info, err := os.Stat(c.chunkPath(i))
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// appendChunk copies the chunk file at path to the end of dst
+func appendChunk(dst io.Writer, path string, buf []byte) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.CopyBuffer(dst, src, buf)
+	return err
+}
+
+// abortChunkedUpload drops a chunked upload session and its temp files
+func (s *UploadService) abortChunkedUpload(chunk *ChunkUpload, cause error) {
+	s.chunkStore.mu.Lock()
+	delete(s.chunkStore.chunks, chunk.ID)
+	s.chunkStore.mu.Unlock()
+
+	os.RemoveAll(chunk.TempDir)
+	s.updateProgressError(chunk.ID, cause)
+}
+
 // finalizeChunkedUpload assembles chunks into final file
 func (s *UploadService) finalizeChunkedUpload(uploadID string) error {
 	s.chunkStore.mu.Lock()
@@ -237,34 +780,92 @@ func (s *UploadService) finalizeChunkedUpload(uploadID string) error {
 	delete(s.chunkStore.chunks, uploadID)
 	s.chunkStore.mu.Unlock()
 
+	// Assembling rewrites the whole file, so it counts as a heavy operation
+	operationLimiter.Acquire(context.Background())
+	defer operationLimiter.Release()
+
+	// Every chunk must be on disk before anything is assembled
+	if missing := chunk.missingChunks(); len(missing) > 0 {
+		err := fmt.Errorf("%w: %v", ErrMissingChunks, missing)
+		os.RemoveAll(chunk.TempDir)
+		s.updateProgressError(uploadID, err)
+		return err
+	}
+
+	// Chunks are checked as they arrive; this catches chunk files changed on disk since
+	staged, err := chunk.stagedSize()
+	if err == nil && staged != chunk.TotalSize {
+		err = fmt.Errorf("%w: chunks hold %d bytes, %d were declared", ErrUploadSizeMismatch, staged, chunk.TotalSize)
+	}
+	if err != nil {
+		os.RemoveAll(chunk.TempDir)
+		s.updateProgressError(uploadID, err)
+		return err
+	}
+
 	// Create final file
-	finalPath := filepath.Join(chunk.Destination, chunk.Filename)
-	if utils.PathExists(finalPath) {
-		finalPath = utils.GenerateUniqueName(finalPath)
+	finalPath, err := s.resolveTarget(filepath.Join(chunk.Destination, chunk.Filename), chunk.Overwrite)
+	if err != nil {
+		os.RemoveAll(chunk.TempDir)
+		s.updateProgressError(uploadID, err)
+		return err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
-		s.updateProgressError(uploadID, err.Error())
+	if err := s.mkdirAllOwned(filepath.Dir(finalPath)); err != nil {
+		s.updateProgressError(uploadID, err)
 		return err
 	}
 
-	file, err := os.Create(finalPath)
+	writePath := finalPath
+	if chunk.Overwrite == OverwriteReplace {
+		writePath = stagingPath(finalPath, uploadID)
+	}
+
+	file, err := s.createFile(writePath)
 	if err != nil {
-		s.updateProgressError(uploadID, err.Error())
+		s.updateProgressError(uploadID, err)
 		return err
 	}
 	defer file.Close()
+	if writePath != finalPath {
+		// No-op once the staged file has been renamed into place
+		defer s.removeFile(writePath)
+	}
+
+	var out io.Writer = file
+	var enc io.WriteCloser
+	if EncryptionEnabled() && s.remote == nil {
+		if enc, err = newEncryptWriter(file, s.owner); err != nil {
+			s.updateProgressError(uploadID, err)
+			return err
+		}
+		out = enc
+	}
 
-	// Assemble chunks
+	// Assemble chunks, streaming each one instead of loading it into memory
+	buf := utils.NewBuffer()
 	for i := 0; i < chunk.TotalChunks; i++ {
-		chunkPath := filepath.Join(chunk.TempDir, string(rune('0'+i)))
-		chunkData, err := os.ReadFile(chunkPath)
-		if err != nil {
-			s.updateProgressError(uploadID, err.Error())
+		if err := appendChunk(out, chunk.chunkPath(i), buf); err != nil {
+			s.updateProgressError(uploadID, err)
+			return err
+		}
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			s.updateProgressError(uploadID, err)
 			return err
 		}
-		if _, err := file.Write(chunkData); err != nil {
-			s.updateProgressError(uploadID, err.Error())
+	}
+
+	// Make sure the assembled file is on disk before the chunks are deleted
+	if err := closeSynced(file); err != nil {
+		s.updateProgressError(uploadID, err)
+		return err
+	}
+
+	if writePath != finalPath {
+		if err := s.renameFile(writePath, finalPath); err != nil {
+			s.updateProgressError(uploadID, err)
 			return err
 		}
 	}
@@ -284,19 +885,10 @@ func (s *UploadService) GetProgress(uploadID string) (*models.Progress, bool) {
 	return s.progressStore.Get(uploadID)
 }
 
-func (s *UploadService) updateProgressError(uploadID, errorMsg string) {
-	if p, ok := s.progressStore.Get(uploadID); ok {
-		p.Status = models.StatusFailed
-		p.Error = errorMsg
-		s.progressStore.Set(uploadID, p)
-	}
+func (s *UploadService) updateProgressError(uploadID string, err error) {
+	s.progressStore.Fail(uploadID, err)
 }
 
 func (s *UploadService) updateProgressCompleted(uploadID string) {
-	if p, ok := s.progressStore.Get(uploadID); ok {
-		p.Status = models.StatusCompleted
-		p.Progress = 100
-		p.UploadedBytes = p.TotalBytes
-		s.progressStore.Set(uploadID, p)
-	}
+	s.progressStore.Complete(uploadID)
 }
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestUploadService returns a local upload service below a fresh base path that leaves
+// ownership alone
+func newTestUploadService(t *testing.T) (*UploadService, string) {
+	t.Helper()
+	base := t.TempDir()
+	svc := NewUploadService(base, "", models.NewProgressStore(), NewChunkStore(), UploadRules{})
+	svc.SetPreserveOwner(true)
+	return svc, base
+}
+
+func TestInitChunkedUploadRejectsTraversalFilename(t *testing.T) {
+	svc, base := newTestUploadService(t)
+
+	for _, filename := range []string{"../../../etc/x", `..\..\x`, "sub/x.txt", "..", "."} {
+		t.Run(filename, func(t *testing.T) {
+			_, err := svc.InitChunkedUpload(filename, "", 4, 4, OverwriteRename)
+			if !errors.Is(err, utils.ErrInvalidPath) {
+				t.Fatalf("InitChunkedUpload(%q) = %v, want ErrInvalidPath", filename, err)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("base path holds %d entries after rejected uploads, want none", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(filepath.Dir(base)), "x")); err == nil {
+		t.Fatal("a file was written outside the base path")
+	}
+}
+
+func TestStartStatsCollectorZeroInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		// A non-positive ticker interval panics; it must disable refreshing instead
+		NewChunkStore().StartStatsCollector(interval)
+	}
+}
+
+func TestSweepFailsAbandonedUploads(t *testing.T) {
+	defer func(root string) { tempRoot = root }(tempRoot)
+	tempRoot = t.TempDir()
+
+	chunks := NewChunkStore()
+	progress := models.NewProgressStore()
+	stale := time.Now().Add(-2 * time.Hour)
+	for id, updated := range map[string]time.Time{"stale": stale, "active": time.Now()} {
+		dir := tempPath("filemanager-chunks", id)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(dir, updated, updated)
+		chunks.chunks[id] = &ChunkUpload{ID: id, TempDir: dir, CreatedAt: updated, UpdatedAt: updated}
+		progress.Set(id, &models.Progress{ID: id, Operation: models.OperationUpload, Status: models.StatusProcessing})
+	}
+	orphan := tempPath("filemanager-chunks", "orphan")
+	if err := os.MkdirAll(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(orphan, stale, stale)
+
+	// Zero intervals sweep once instead of panicking in time.NewTicker
+	chunks.StartSweeper(0, 0, progress)
+	if p, _ := progress.Get("stale"); p.Status != models.StatusProcessing {
+		t.Fatalf("a zero max age swept an upload to %s", p.Status)
+	}
+	chunks.StartSweeper(0, time.Hour, progress)
+
+	if p, _ := progress.Get("stale"); p.Status != models.StatusFailed || p.Error == "" {
+		t.Fatalf("abandoned upload is %s %q, want failed with a reason", p.Status, p.Error)
+	}
+	if p, _ := progress.Get("active"); p.Status != models.StatusProcessing {
+		t.Fatalf("active upload is %s, want processing", p.Status)
+	}
+	if _, ok := chunks.chunks["stale"]; ok {
+		t.Fatal("abandoned session was kept")
+	}
+	for dir, kept := range map[string]bool{orphan: false, tempPath("filemanager-chunks", "active"): true} {
+		if _, err := os.Stat(dir); kept != (err == nil) {
+			t.Fatalf("%s exists = %v, want %v", dir, err == nil, kept)
+		}
+	}
+}
+
+func TestDedupEditsLeaveDuplicatesUnchanged(t *testing.T) {
+	if err := ConfigureTempDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureTempDir("")
+
+	tests := []struct {
+		name string
+		edit func(fm *FileManagerService) error
+		want string
+	}{
+		{"UpdateFile", func(fm *FileManagerService) error {
+			_, err := fm.UpdateFile("b.txt", "changed")
+			return err
+		}, "changed"},
+		{"WriteAt", func(fm *FileManagerService) error {
+			_, err := fm.WriteAt("b.txt", 0, []byte("HE"))
+			return err
+		}, "HEllo world"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, base := newTestUploadService(t)
+			for _, name := range []string{"a.txt", "b.txt"} {
+				if _, err := svc.Upload(context.Background(), name, "", strings.NewReader("hello world"), 11, OverwriteRename, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			a, _ := os.Stat(filepath.Join(base, "a.txt"))
+			b, _ := os.Stat(filepath.Join(base, "b.txt"))
+			if !os.SameFile(a, b) {
+				t.Fatal("the duplicate upload was not hard-linked")
+			}
+
+			fm := NewFileManagerService(base, "")
+			fm.SetPreserveOwner(true)
+			if err := tt.edit(fm); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{"a.txt": "hello world", "b.txt": tt.want} {
+				if got, _ := os.ReadFile(filepath.Join(base, name)); string(got) != want {
+					t.Fatalf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupIndexOutsideBasePath(t *testing.T) {
+	if err := ConfigureTempDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureTempDir("")
+	svc, base := newTestUploadService(t)
+
+	// An index left in the base path by an earlier version is picked up, then removed
+	legacy := filepath.Join(base, legacyDedupIndexName)
+	if err := os.WriteFile(legacy, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Upload(context.Background(), "a.txt", "", strings.NewReader("hello"), 5, OverwriteRename, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatal("the index in the base path was not removed")
+	}
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 1 || entries[0].Name() != "a.txt" {
+		t.Fatalf("base path holds %v, want only a.txt", entries)
+	}
+	index, err := loadDedupIndex(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("index holds %d entries, want the upload", len(index))
+	}
+	if _, err := os.Stat(dedupIndexPath(base)); err != nil {
+		t.Fatalf("no index below the temp root: %v", err)
+	}
+}
+
+// unreadable fails the test when it is read
+type unreadable struct{ t *testing.T }
+
+func (r unreadable) Read(p []byte) (int, error) {
+	r.t.Fatal("the chunk was read although its size is wrong")
+	return 0, io.EOF
+}
+
+func TestUploadChunkChecksSizeBeforeReading(t *testing.T) {
+	if err := ConfigureTempDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureTempDir("")
+	svc, base := newTestUploadService(t)
+
+	chunk, err := svc.InitChunkedUpload("data.bin", "", 6, 4, OverwriteRename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.UploadChunk(chunk.ID, 0, unreadable{t}, 1<<40); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Fatalf("UploadChunk of a huge chunk = %v, want ErrInvalidChunkSize", err)
+	}
+
+	// A part shorter than it claims leaves no chunk behind
+	if err := svc.UploadChunk(chunk.ID, 0, strings.NewReader("ab"), 4); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Fatalf("UploadChunk of a short part = %v, want ErrInvalidChunkSize", err)
+	}
+	if _, err := os.Stat(chunk.chunkPath(0)); !os.IsNotExist(err) {
+		t.Fatal("the short chunk was kept")
+	}
+
+	for i, data := range []string{"abcd", "ef"} {
+		if err := svc.UploadChunk(chunk.ID, i, strings.NewReader(data), int64(len(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(base, "data.bin")); string(got) != "abcdef" {
+		t.Fatalf("assembled file holds %q, want abcdef", got)
+	}
+}
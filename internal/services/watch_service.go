@@ -0,0 +1,93 @@
+package services
+
+import (
+	"errors"
+	"filemanager-api/internal/models"
+	"filemanager-api/internal/utils"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var ErrNotSupported = errors.New("operation not supported")
+
+// WatchEvent describes a change inside a watched directory
+type WatchEvent struct {
+	Op   string           `json:"op"`
+	Path string           `json:"path"`
+	Info *models.FileInfo `json:"info,omitempty"`
+}
+
+// Watch emits an event for every create/write/remove/rename/chmod inside a local directory
+// until done is closed or emit returns an error. Remote directories are not supported.
+func (s *FileManagerService) Watch(relativePath string, done <-chan struct{}, emit func(WatchEvent) error) error {
+	if s.isRemote {
+		return ErrNotSupported
+	}
+
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return err
+	}
+	if !utils.PathExists(fullPath) {
+		return ErrNotFound
+	}
+	if !utils.IsDir(fullPath) {
+		return ErrNotAFolder
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(fullPath); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			relPath, _ := utils.GetRelativePath(s.basePath, ev.Name)
+			event := WatchEvent{Op: watchOpName(ev.Op), Path: relPath}
+
+			// Removed and renamed-away entries no longer have info to report
+			if !ev.Has(fsnotify.Remove) && !ev.Has(fsnotify.Rename) {
+				if info, err := s.getInfoLocal(filepath.Clean(ev.Name)); err == nil {
+					event.Info = info
+				}
+			}
+
+			if err := emit(event); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+func watchOpName(op fsnotify.Op) string {
+	switch {
+	case op.Has(fsnotify.Create):
+		return "create"
+	case op.Has(fsnotify.Write):
+		return "modify"
+	case op.Has(fsnotify.Remove):
+		return "delete"
+	case op.Has(fsnotify.Rename):
+		return "rename"
+	default:
+		return "chmod"
+	}
+}
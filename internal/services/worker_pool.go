@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"filemanager-api/internal/utils"
+	"sync/atomic"
+)
+
+var ErrQueueFull = errors.New("too many operations queued, try again later")
+
+// Defaults for ConfigureOperations
+const (
+	DefaultMaxConcurrentOperations = 4
+	DefaultOperationQueueSize      = 64
+)
+
+// OperationLimiter is a counting semaphore bounding how many heavy operations
+// (compress, extract, upload) run at once. Excess callers wait for a free slot.
+type OperationLimiter struct {
+	slots   chan struct{}
+	waiting int64
+}
+
+// NewOperationLimiter creates a limiter allowing max concurrent operations
+func NewOperationLimiter(max int) *OperationLimiter {
+	if max < 1 {
+		max = 1
+	}
+	return &OperationLimiter{slots: make(chan struct{}, max)}
+}
+
+// Acquire blocks until a slot is free or ctx ends, returning ctx's error in the latter case
+func (l *OperationLimiter) Acquire(ctx context.Context) error {
+	atomic.AddInt64(&l.waiting, 1)
+	defer atomic.AddInt64(&l.waiting, -1)
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (l *OperationLimiter) Release() {
+	<-l.slots
+}
+
+// Running returns the number of operations holding a slot
+func (l *OperationLimiter) Running() int {
+	return len(l.slots)
+}
+
+// Waiting returns the number of callers blocked in Acquire
+func (l *OperationLimiter) Waiting() int {
+	return int(atomic.LoadInt64(&l.waiting))
+}
+
+// Capacity returns the maximum number of concurrent operations
+func (l *OperationLimiter) Capacity() int {
+	return cap(l.slots)
+}
+
+// WorkerPool runs background jobs on a fixed number of goroutines with a bounded queue,
+// so a burst of requests cannot start an unlimited number of heavy operations.
+// Every job holds a limiter slot while it runs.
+type WorkerPool struct {
+	jobs    chan func()
+	limiter *OperationLimiter
+}
+
+// NewWorkerPool starts workers goroutines consuming up to queueSize pending jobs
+func NewWorkerPool(workers, queueSize int, limiter *OperationLimiter) *WorkerPool {
+	p := &WorkerPool{jobs: make(chan func(), queueSize), limiter: limiter}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	for job := range p.jobs {
+		p.run(job)
+	}
+}
+
+// run executes a job inside a limiter slot, keeping the worker alive if it panics
+func (p *WorkerPool) run(job func()) {
+	// Jobs check their own context once they run, so waiting is not cancelled here
+	p.limiter.Acquire(context.Background())
+	defer p.limiter.Release()
+	defer func() {
+		if r := recover(); r != nil {
+			utils.Errorf("Background operation panicked: %v", r)
+		}
+	}()
+	job()
+}
+
+// Submit queues job without blocking and fails with ErrQueueFull when the queue is at capacity
+func (p *WorkerPool) Submit(job func()) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Queued returns the number of jobs waiting for a worker
+func (p *WorkerPool) Queued() int {
+	return len(p.jobs)
+}
+
+// QueueCapacity returns the maximum number of queued jobs
+func (p *WorkerPool) QueueCapacity() int {
+	return cap(p.jobs)
+}
+
+var (
+	// operationLimiter is shared by background jobs and uploads
+	operationLimiter = NewOperationLimiter(DefaultMaxConcurrentOperations)
+	// operationPool runs compress and extract jobs in the background
+	operationPool = NewWorkerPool(DefaultMaxConcurrentOperations, DefaultOperationQueueSize, operationLimiter)
+)
+
+// ConfigureOperations sets the concurrency limit for heavy operations and how many
+// background jobs may wait for it. It must be called at startup, before any operation runs.
+func ConfigureOperations(maxConcurrent, queueSize int) {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	close(operationPool.jobs) // Stops the idle default workers
+	operationLimiter = NewOperationLimiter(maxConcurrent)
+	operationPool = NewWorkerPool(maxConcurrent, queueSize, operationLimiter)
+}
+
+// OperationStats describes the load on the heavy operation limiter
+type OperationStats struct {
+	MaxConcurrent int `json:"max_concurrent"`
+	Running       int `json:"running"`
+	Queued        int `json:"queued"`         // background jobs and uploads waiting for a slot
+	QueueCapacity int `json:"queue_capacity"` // maximum queued background jobs
+}
+
+// GetOperationStats returns the current operation concurrency and queue depth
+func GetOperationStats() OperationStats {
+	return OperationStats{
+		MaxConcurrent: operationLimiter.Capacity(),
+		Running:       operationLimiter.Running(),
+		Queued:        operationPool.Queued() + operationLimiter.Waiting(),
+		QueueCapacity: operationPool.QueueCapacity(),
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAcquireStopsWaitingWhenContextEnds(t *testing.T) {
+	limiter := NewOperationLimiter(1)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- limiter.Acquire(ctx) }()
+	for limiter.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Acquire = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Acquire still waits after its context ended")
+	}
+	if limiter.Waiting() != 0 || limiter.Running() != 1 {
+		t.Fatalf("waiting = %d, running = %d after a cancelled wait, want 0 and 1", limiter.Waiting(), limiter.Running())
+	}
+
+	// The slot given up by Release goes to the next caller
+	limiter.Release()
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"filemanager-api/internal/utils"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// ErrXattrNotSupported is returned when the filesystem or the connection has no extended attributes
+	ErrXattrNotSupported = errors.New("extended attributes are not supported")
+	// ErrXattrNotFound is returned when removing an attribute that is not set
+	ErrXattrNotFound = errors.New("extended attribute not found")
+)
+
+// GetXattrs returns the extended attributes of a local file or folder by name.
+// Symlinks are not followed, so the attributes are those of the link itself.
+func (s *FileManagerService) GetXattrs(relativePath string) (map[string][]byte, error) {
+	fullPath, err := s.xattrPath(relativePath)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := listXattrs(fullPath)
+	if err != nil {
+		return nil, xattrError(err, "")
+	}
+
+	attrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		value, err := getXattr(fullPath, name)
+		if errors.Is(err, unix.ENODATA) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, xattrError(err, name)
+		}
+		attrs[name] = value
+	}
+	return attrs, nil
+}
+
+// SetXattrs sets attrs on a local file or folder and removes the attributes named in remove
+func (s *FileManagerService) SetXattrs(relativePath string, attrs map[string][]byte, remove []string) error {
+	fullPath, err := s.xattrPath(relativePath)
+	if err != nil {
+		return err
+	}
+
+	for name, value := range attrs {
+		if err := unix.Lsetxattr(fullPath, name, value, 0); err != nil {
+			return xattrError(err, name)
+		}
+	}
+	for _, name := range remove {
+		if err := unix.Lremovexattr(fullPath, name); err != nil {
+			return xattrError(err, name)
+		}
+	}
+	return nil
+}
+
+// xattrPath validates relativePath for an extended attribute call, which only local files support
+func (s *FileManagerService) xattrPath(relativePath string) (string, error) {
+	if s.isRemote {
+		return "", fmt.Errorf("%w over SSH", ErrXattrNotSupported)
+	}
+	fullPath, err := utils.ValidatePath(s.basePath, relativePath)
+	if err != nil {
+		return "", err
+	}
+	if !utils.PathExists(fullPath) {
+		return "", ErrNotFound
+	}
+	return fullPath, nil
+}
+
+// listXattrs returns the attribute names of path, growing the buffer if attributes are added meanwhile
+func listXattrs(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Llistxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// getXattr returns the value of attribute name of path
+func getXattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil || size == 0 {
+			return []byte{}, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// xattrError maps errno values of attribute calls to the service errors
+func xattrError(err error, name string) error {
+	switch {
+	case errors.Is(err, unix.ENOTSUP), errors.Is(err, unix.EOPNOTSUPP):
+		return fmt.Errorf("%w by this filesystem: %v", ErrXattrNotSupported, err)
+	case errors.Is(err, unix.ENODATA):
+		return fmt.Errorf("%w: %s", ErrXattrNotFound, name)
+	case errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EACCES):
+		return fmt.Errorf("%w: attribute %s: %v", ErrPermissionDenied, name, err)
+	}
+	if name != "" {
+		return fmt.Errorf("attribute %s: %w", name, err)
+	}
+	return err
+}
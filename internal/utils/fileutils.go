@@ -1,20 +1,116 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 const (
 	DefaultBufferSize = 64 * 1024 // 64KB buffer for file operations
+	MinBufferSize     = 4 * 1024
+	MaxBufferSize     = 16 * 1024 * 1024
 )
 
-// CopyFile copies a file from src to dst with buffered I/O
-func CopyFile(src, dst string, preserveMetadata bool) error {
+// bufferSize is the size of the buffers used to copy file data, configured at startup with SetBufferSize
+var bufferSize = DefaultBufferSize
+
+// SetBufferSize sets the buffer size used by copies, uploads, compression and extraction.
+// Sizes outside MinBufferSize..MaxBufferSize are rejected.
+func SetBufferSize(size int) error {
+	if size < MinBufferSize || size > MaxBufferSize {
+		return fmt.Errorf("buffer size %d is outside the allowed range %d-%d", size, MinBufferSize, MaxBufferSize)
+	}
+	bufferSize = size
+	return nil
+}
+
+// NewBuffer returns a buffer of the configured size for copying file data
+func NewBuffer() []byte {
+	return make([]byte, bufferSize)
+}
+
+// CopyBuffer copies src to dst through buf like io.CopyBuffer, but always uses buf.
+// io.CopyBuffer leaves buf unused when dst implements io.ReaderFrom or src io.WriterTo,
+// as *os.File does, and a file then falls back to a 32KB buffer of its own.
+func CopyBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, buf)
+}
+
+// Permissions of new folders and files, configured at startup with SetDefaultModes
+var (
+	dirMode  os.FileMode = 0755
+	fileMode os.FileMode = 0644
+)
+
+// SetDefaultModes sets the permissions of new folders and files from octal strings such as "0750"
+func SetDefaultModes(dir, file string) error {
+	d, err := parseMode(dir)
+	if err != nil {
+		return err
+	}
+	f, err := parseMode(file)
+	if err != nil {
+		return err
+	}
+	dirMode, fileMode = d, f
+	return nil
+}
+
+// parseMode parses octal permission bits
+func parseMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q, expected octal permissions such as 0750", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// DirMode returns the permissions given to new folders
+func DirMode() os.FileMode {
+	return dirMode
+}
+
+// FileMode returns the permissions given to new files
+func FileMode() os.FileMode {
+	return fileMode
+}
+
+// CreateFile creates or truncates path like os.Create, with the configured file permissions
+func CreateFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
+}
+
+// ContextReader returns a reader that fails with ctx.Err() once ctx is done,
+// so copies from it stop between two reads
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, reader: r}
+}
+
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// CopyFile copies a file from src to dst with buffered I/O.
+// When ctx ends first the partial dst is removed and ctx.Err() returned.
+func CopyFile(ctx context.Context, src, dst string, preserveMetadata bool) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
@@ -27,20 +123,27 @@ func CopyFile(src, dst string, preserveMetadata bool) error {
 	}
 
 	// Create destination directory if needed
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	dstFile, err := os.Create(dst)
+	dstFile, err := CreateFile(dst)
 	if err != nil {
 		return fmt.Errorf("failed to create destination file: %w", err)
 	}
 	defer dstFile.Close()
 
-	// Use buffered copy
-	buf := make([]byte, DefaultBufferSize)
-	if _, err := io.CopyBuffer(dstFile, srcFile, buf); err != nil {
-		return fmt.Errorf("failed to copy file: %w", err)
+	// Clone the data where the filesystem supports it, otherwise use buffered copy
+	if err := reflink(dstFile, srcFile); err != nil {
+		buf := NewBuffer()
+		if _, err := CopyBuffer(dstFile, ContextReader(ctx, srcFile), buf); err != nil {
+			if ctx.Err() != nil {
+				dstFile.Close()
+				os.Remove(dst)
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to copy file: %w", err)
+		}
 	}
 
 	// Preserve metadata if requested
@@ -56,8 +159,9 @@ func CopyFile(src, dst string, preserveMetadata bool) error {
 	return nil
 }
 
-// CopyFileWithProgress copies a file and reports progress
-func CopyFileWithProgress(src, dst string, progressFn func(written, total int64)) error {
+// CopyFileWithProgress copies a file and reports progress.
+// When ctx ends first the partial dst is removed and ctx.Err() returned.
+func CopyFileWithProgress(ctx context.Context, src, dst string, progressFn func(written, total int64)) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open source file: %w", err)
@@ -69,11 +173,11 @@ func CopyFileWithProgress(src, dst string, progressFn func(written, total int64)
 		return fmt.Errorf("failed to stat source file: %w", err)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	dstFile, err := os.Create(dst)
+	dstFile, err := CreateFile(dst)
 	if err != nil {
 		return fmt.Errorf("failed to create destination file: %w", err)
 	}
@@ -81,9 +185,14 @@ func CopyFileWithProgress(src, dst string, progressFn func(written, total int64)
 
 	totalSize := srcInfo.Size()
 	var written int64
-	buf := make([]byte, DefaultBufferSize)
+	buf := NewBuffer()
 
 	for {
+		if err := ctx.Err(); err != nil {
+			dstFile.Close()
+			os.Remove(dst)
+			return err
+		}
 		n, err := srcFile.Read(buf)
 		if n > 0 {
 			nw, werr := dstFile.Write(buf[:n])
@@ -106,8 +215,101 @@ func CopyFileWithProgress(src, dst string, progressFn func(written, total int64)
 	return nil
 }
 
-// CopyDir copies a directory recursively
-func CopyDir(src, dst string, preserveMetadata bool) error {
+// CopyDirWithProgress copies a directory recursively, preserving metadata,
+// and reports the cumulative number of bytes copied across all files
+func CopyDirWithProgress(ctx context.Context, src, dst string, progressFn func(copied int64)) (int64, error) {
+	var copied int64
+	err := copyDirWithProgress(ctx, src, dst, func(n int64) {
+		copied += n
+		if progressFn != nil {
+			progressFn(copied)
+		}
+	})
+	return copied, err
+}
+
+func copyDirWithProgress(ctx context.Context, src, dst string, add func(n int64)) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat source directory: %w", err)
+	}
+
+	if err := os.MkdirAll(dst, srcInfo.Mode()); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("failed to read source directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		if entry.IsDir() {
+			if err := copyDirWithProgress(ctx, srcPath, dstPath, add); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var last int64
+		if err := CopyFileWithProgress(ctx, srcPath, dstPath, func(written, total int64) {
+			add(written - last)
+			last = written
+		}); err != nil {
+			return err
+		}
+		if info, err := entry.Info(); err == nil {
+			os.Chmod(dstPath, info.Mode())
+			os.Chtimes(dstPath, info.ModTime(), info.ModTime())
+		}
+	}
+
+	return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+}
+
+// CopyDir copies a directory recursively, stopping with ctx.Err() once ctx ends
+func CopyDir(ctx context.Context, src, dst string, preserveMetadata bool) error {
+	return copyDirWith(src, dst, preserveMetadata, func(src, dst string) error {
+		return CopyFile(ctx, src, dst, preserveMetadata)
+	})
+}
+
+// LinkFile hardlinks dst to src, replacing a file already at dst, so both names share one
+// file and see each other's changes. Where a link is not possible, for example across
+// filesystems, the file is copied instead.
+func LinkFile(ctx context.Context, src, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// A symlink would be linked itself rather than its target, unlike a copy
+	if info, err := os.Lstat(src); err != nil || info.Mode()&os.ModeSymlink != 0 {
+		return CopyFile(ctx, src, dst, true)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), dirMode); err != nil {
+		return fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	if info, err := os.Lstat(dst); err == nil && !info.IsDir() {
+		os.Remove(dst)
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return CopyFile(ctx, src, dst, true)
+}
+
+// LinkDir recreates the folder src at dst with every file hardlinked as by LinkFile
+func LinkDir(ctx context.Context, src, dst string) error {
+	return copyDirWith(src, dst, true, func(src, dst string) error {
+		return LinkFile(ctx, src, dst)
+	})
+}
+
+// copyDirWith recreates the folder src at dst, placing each file with copyFile. With
+// preserveMetadata the folders keep the permissions and modification times of their sources.
+func copyDirWith(src, dst string, preserveMetadata bool, copyFile func(src, dst string) error) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return fmt.Errorf("failed to stat source directory: %w", err)
@@ -127,17 +329,24 @@ func CopyDir(src, dst string, preserveMetadata bool) error {
 		dstPath := filepath.Join(dst, entry.Name())
 
 		if entry.IsDir() {
-			if err := CopyDir(srcPath, dstPath, preserveMetadata); err != nil {
+			if err := copyDirWith(srcPath, dstPath, preserveMetadata, copyFile); err != nil {
 				return err
 			}
 		} else {
-			if err := CopyFile(srcPath, dstPath, preserveMetadata); err != nil {
+			if err := copyFile(srcPath, dstPath); err != nil {
 				return err
 			}
 		}
 	}
 
-	return nil
+	if !preserveMetadata {
+		return nil
+	}
+	// Set last, as copying the entries changes the folder's modification time
+	if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
 }
 
 // GetMimeType returns the MIME type for a file
@@ -150,6 +359,56 @@ func GetMimeType(path string) string {
 	return mimeType
 }
 
+// SniffLength is the number of leading bytes DetectMimeType inspects
+const SniffLength = 512
+
+// shebangTypes maps script interpreters to MIME types
+var shebangTypes = map[string]string{
+	"python":  "text/x-python",
+	"python3": "text/x-python",
+	"perl":    "text/x-perl",
+	"ruby":    "text/x-ruby",
+	"node":    "text/javascript",
+	"php":     "text/x-php",
+}
+
+// DetectMimeType determines the MIME type from the leading bytes of a file,
+// falling back to the extension when the content is not conclusive
+func DetectMimeType(name string, header []byte) string {
+	if len(header) > SniffLength {
+		header = header[:SniffLength]
+	}
+
+	if bytes.HasPrefix(header, []byte("#!")) {
+		line := string(header[2:])
+		if idx := strings.IndexByte(line, '\n'); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			interpreter := filepath.Base(fields[0])
+			if interpreter == "env" && len(fields) > 1 {
+				interpreter = fields[1]
+			}
+			if mimeType, ok := shebangTypes[interpreter]; ok {
+				return mimeType
+			}
+		}
+		return "text/x-shellscript"
+	}
+
+	byExtension := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	sniffed := http.DetectContentType(header)
+
+	// Generic sniff results are less specific than a known extension (e.g. .json, .css)
+	if sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain") {
+		if byExtension != "" {
+			return byExtension
+		}
+	}
+	return sniffed
+}
+
 // FormatFileSize formats bytes to human readable format
 func FormatFileSize(bytes int64) string {
 	const unit = 1024
@@ -164,14 +423,16 @@ func FormatFileSize(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-// GetDirectorySize calculates total size of a directory
-func GetDirectorySize(path string) (int64, error) {
+// GetDirectorySize calculates total size of the regular files in a directory. Symlinks are
+// left out or counted as their targets according to policy; other special files add nothing.
+// The walk stops with ctx.Err() once ctx ends.
+func GetDirectorySize(ctx context.Context, path string, policy SymlinkPolicy) (int64, error) {
 	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
+	err := Walk(path, policy, func(_ string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if !info.IsDir() {
+		if info.Mode().IsRegular() {
 			size += info.Size()
 		}
 		return nil
@@ -179,6 +440,28 @@ func GetDirectorySize(path string) (int64, error) {
 	return size, err
 }
 
+// GetFilesystemStats returns total, free (available to unprivileged users) and used bytes
+// of the filesystem containing path
+func GetFilesystemStats(path string) (total, free, used int64, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	bsize := int64(stat.Bsize)
+	total = int64(stat.Blocks) * bsize
+	free = int64(stat.Bavail) * bsize
+	used = (int64(stat.Blocks) - int64(stat.Bfree)) * bsize
+	return total, free, used, nil
+}
+
+// UsedPercent calculates usage the way df does: used / (used + available)
+func UsedPercent(used, free int64) float64 {
+	if used+free <= 0 {
+		return 0
+	}
+	return math.Round(float64(used)*10000/float64(used+free)) / 100
+}
+
 // FormatPermissions formats os.FileMode to string like "rwxr-xr-x"
 func FormatPermissions(mode os.FileMode) string {
 	var result strings.Builder
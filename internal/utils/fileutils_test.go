@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDetectMimeType(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00"
+	tests := []struct {
+		name     string
+		filename string
+		header   string
+		want     string
+	}{
+		{"png renamed to dat", "photo.dat", png, "image/png"},
+		{"png without extension", "photo", png, "image/png"},
+		{"png named txt", "photo.txt", png, "image/png"},
+		{"pdf renamed", "report.bin", "%PDF-1.7\n", "application/pdf"},
+		{"sh shebang", "deploy", "#!/bin/sh\necho hi\n", "text/x-shellscript"},
+		{"bash shebang with extension", "run.txt", "#!/usr/bin/env bash\nset -e\n", "text/x-shellscript"},
+		{"python through env", "manage", "#!/usr/bin/env python3\nimport os\n", "text/x-python"},
+		{"python path", "tool", "#!/usr/bin/python\n", "text/x-python"},
+		{"node", "cli", "#! /usr/bin/env node\n", "text/javascript"},
+		{"shebang only", "x", "#!", "text/x-shellscript"},
+		{"json text keeps extension", "data.json", `{"a": 1}`, "application/json"},
+		{"binary keeps known extension", "a.wasm", "\x00\x01\x02\x03", "application/wasm"},
+		{"unknown binary", "blob", "\x00\x01\x02\x03", "application/octet-stream"},
+		{"plain text", "notes", "hello", "text/plain; charset=utf-8"},
+		{"empty", "empty", "", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectMimeType(tt.filename, []byte(tt.header)); got != tt.want {
+				t.Fatalf("DetectMimeType(%q, %q) = %q, want %q", tt.filename, tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// symlinkLoops creates a/file.txt of 4 bytes next to links that lead back into the tree:
+// a self-referential link, a link to the parent and a pair pointing at each other
+func symlinkLoops(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a/file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"self":   "self",
+		"a/up":   "..",
+		"a/root": root,
+		"ping":   "pong",
+		"pong":   "ping",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestGetDirectorySizeSymlinkLoops(t *testing.T) {
+	root := symlinkLoops(t)
+	for _, policy := range []SymlinkPolicy{SymlinksSkip, SymlinksFollow} {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			size, err := GetDirectorySize(ctx, root, policy)
+			if err != nil {
+				t.Fatalf("GetDirectorySize = %v", err)
+			}
+			if size != 4 {
+				t.Fatalf("size = %d, want 4: each file counted once", size)
+			}
+		})
+	}
+}
+
+// readerFromWriter records the calls a copy makes to it
+type readerFromWriter struct {
+	bytes.Buffer
+	readFrom bool
+	writes   []int
+}
+
+func (w *readerFromWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, len(p))
+	return w.Buffer.Write(p)
+}
+
+func (w *readerFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.readFrom = true
+	return w.Buffer.ReadFrom(r)
+}
+
+func TestCopyBufferUsesBuffer(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	dst := &readerFromWriter{}
+	// bytes.Reader implements io.WriterTo and dst io.ReaderFrom, either of which io.CopyBuffer would use
+	n, err := CopyBuffer(dst, bytes.NewReader(data), make([]byte, 4096))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("CopyBuffer = %d, %v, want %d bytes", n, err, len(data))
+	}
+	if dst.readFrom {
+		t.Fatal("CopyBuffer went through ReadFrom instead of the buffer")
+	}
+	for _, size := range dst.writes {
+		if size > 4096 {
+			t.Fatalf("wrote %d bytes at once, more than the 4096 byte buffer", size)
+		}
+	}
+	if len(dst.writes) != (len(data)+4095)/4096 {
+		t.Fatalf("%d writes for %d bytes through a 4096 byte buffer", len(dst.writes), len(data))
+	}
+	if !bytes.Equal(dst.Bytes(), data) {
+		t.Fatal("copied data differs")
+	}
+}
+
+// BenchmarkCopyFile copies a 32MB file through buffers of the default 64KB and of 1MB.
+// Filesystems with clones, such as btrfs or XFS, reflink the file and use no buffer.
+func BenchmarkCopyFile(b *testing.B) {
+	dir := b.TempDir()
+	src := filepath.Join(dir, "src.bin")
+	data := make([]byte, 32<<20)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	defer SetBufferSize(bufferSize)
+	for _, size := range []int{DefaultBufferSize, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			if err := SetBufferSize(size); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := CopyFile(context.Background(), src, filepath.Join(dir, "dst.bin"), false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
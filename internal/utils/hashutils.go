@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrUnsupportedHash = errors.New("unsupported hash algorithm")
+
+// hashCommands maps supported algorithms to the coreutils command producing the same digest
+var hashCommands = map[string]string{
+	"md5":    "md5sum",
+	"sha1":   "sha1sum",
+	"sha256": "sha256sum",
+}
+
+// NewHasher returns a hash.Hash for the algorithm name (md5, sha1, sha256)
+func NewHasher(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	}
+	return nil, ErrUnsupportedHash
+}
+
+// HashCommand returns the shell command computing the same digest as NewHasher
+func HashCommand(algo string) (string, error) {
+	cmd, ok := hashCommands[strings.ToLower(algo)]
+	if !ok {
+		return "", ErrUnsupportedHash
+	}
+	return cmd, nil
+}
+
+// HashReader streams r through the hasher and returns the hex digest
+func HashReader(r io.Reader, algo string) (string, error) {
+	h, err := NewHasher(algo)
+	if err != nil {
+		return "", err
+	}
+	buf := NewBuffer()
+	if _, err := CopyBuffer(h, r, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFile returns the hex digest of a local file
+func HashFile(path, algo string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return HashReader(file, algo)
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Log levels in increasing order of severity
+const (
+	LevelDebug int32 = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevel = LevelInfo
+
+// SetLogLevel sets the minimum level that is printed (debug, info, warn, error)
+func SetLogLevel(level string) {
+	value := LevelInfo
+	switch strings.ToLower(level) {
+	case "debug":
+		value = LevelDebug
+	case "warn", "warning":
+		value = LevelWarn
+	case "error":
+		value = LevelError
+	}
+	atomic.StoreInt32(&logLevel, value)
+}
+
+// Debugf logs details that are only useful when troubleshooting
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, "[DEBUG] ", format, args...)
+}
+
+// Infof logs normal operational messages
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, "[INFO] ", format, args...)
+}
+
+// Warnf logs unexpected but recoverable conditions
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, "[WARN] ", format, args...)
+}
+
+// Errorf logs failures
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, "[ERROR] ", format, args...)
+}
+
+func logf(level int32, prefix, format string, args ...interface{}) {
+	if level < atomic.LoadInt32(&logLevel) {
+		return
+	}
+	message := Redact(fmt.Sprintf(format, args...))
+	fmt.Print(prefix + strings.TrimSuffix(message, "\n") + "\n")
+}
+
+var (
+	privateKeyPattern = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?(-----END [A-Z ]*PRIVATE KEY-----|$)`)
+	secretPattern     = regexp.MustCompile(`(?i)((?:password|passwd|passphrase|secret|token|api[_-]?key|private[_-]?key)["']?\s*[:=]\s*["']?)[^\s"',&]+`)
+)
+
+// Redact masks private keys and credential values (password=..., "api_key": "...") in s
+func Redact(s string) string {
+	s = privateKeyPattern.ReplaceAllString(s, "[REDACTED PRIVATE KEY]")
+	return secretPattern.ReplaceAllString(s, "${1}[REDACTED]")
+}
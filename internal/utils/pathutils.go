@@ -14,10 +14,71 @@ var (
 	ErrInvalidPath     = errors.New("invalid path")
 )
 
+// Path shape limits, configured at startup with SetPathLimits (0 = unlimited)
+var (
+	maxPathDepth  = 64
+	maxNameLength = 255
+)
+
+// SetPathLimits sets the maximum number of path components and the maximum length of a single name
+func SetPathLimits(maxDepth, maxNameLen int) {
+	maxPathDepth = maxDepth
+	maxNameLength = maxNameLen
+}
+
+// ValidateName checks a single file or folder name: it must be a name rather than a path
+// (no separators, not "." or ".."), within the length limit and free of control characters
+func ValidateName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("%w: %q is not a valid name", ErrInvalidPath, name)
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: name %q contains a path separator", ErrInvalidPath, name)
+	}
+	if maxNameLength > 0 && len(name) > maxNameLength {
+		return fmt.Errorf("%w: name is %d bytes long, maximum is %d", ErrInvalidPath, len(name), maxNameLength)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("%w: name %q contains control character %U", ErrInvalidPath, name, r)
+		}
+	}
+	return nil
+}
+
+// validateComponents applies the depth and name limits to a sanitized relative path
+func validateComponents(cleanReq string) error {
+	components := strings.Split(cleanReq, string(filepath.Separator))
+	if maxPathDepth > 0 && len(components) > maxPathDepth {
+		return fmt.Errorf("%w: path has %d components, maximum is %d", ErrInvalidPath, len(components), maxPathDepth)
+	}
+	for _, name := range components {
+		if err := ValidateName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NormalizeSeparators converts Windows-style backslashes to forward slashes
+func NormalizeSeparators(path string) string {
+	return strings.ReplaceAll(path, "\\", "/")
+}
+
+// hasParentComponent reports whether a cleaned path still contains a ".." component
+func hasParentComponent(cleaned string) bool {
+	for _, name := range strings.Split(cleaned, "/") {
+		if name == ".." {
+			return true
+		}
+	}
+	return false
+}
+
 // SanitizePath cleans and validates a path
 func SanitizePath(path string) string {
-	// Clean the path
-	cleaned := filepath.Clean(path)
+	// Clean the path, treating backslashes as separators
+	cleaned := filepath.Clean(NormalizeSeparators(path))
 	
 	// Remove any leading slashes for relative paths
 	cleaned = strings.TrimPrefix(cleaned, "/")
@@ -35,6 +96,15 @@ func ValidatePath(basePath, requestedPath string) (string, error) {
 	if cleanReq == "" || cleanReq == "." {
 		return cleanBase, nil
 	}
+
+	// Cleaning only leaves ".." where it would climb above the base path
+	if hasParentComponent(cleanReq) {
+		return "", ErrPathTraversal
+	}
+
+	if err := validateComponents(cleanReq); err != nil {
+		return "", err
+	}
 	
 	// Join base path with requested path
 	fullPath := filepath.Join(cleanBase, cleanReq)
@@ -50,14 +120,40 @@ func ValidatePath(basePath, requestedPath string) (string, error) {
 		return "", fmt.Errorf("%w: %v", ErrInvalidPath, err)
 	}
 	
-	// Check for path traversal - ensure the path is under base path
-	if !strings.HasPrefix(absPath, absBase) {
+	// Check for path traversal - ensure the path is under base path,
+	// on a separator boundary so /base2 does not pass for /base
+	basePrefix := absBase
+	if !strings.HasSuffix(basePrefix, string(filepath.Separator)) {
+		basePrefix += string(filepath.Separator)
+	}
+	if absPath != absBase && !strings.HasPrefix(absPath, basePrefix) {
 		return "", ErrPathTraversal
 	}
 	
 	return absPath, nil
 }
 
+// HasGlob reports whether a path contains glob metacharacters (*, ? or [)
+func HasGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// GlobRoot returns the folders leading a glob pattern up to its first component with glob
+// metacharacters, e.g. logs for logs/*/*.log, or "." when the pattern starts with one
+func GlobRoot(pattern string) string {
+	var root []string
+	for _, name := range strings.Split(SanitizePath(pattern), "/") {
+		if HasGlob(name) {
+			break
+		}
+		root = append(root, name)
+	}
+	if len(root) == 0 {
+		return "."
+	}
+	return strings.Join(root, "/")
+}
+
 // GetRelativePath returns the path relative to the base path
 func GetRelativePath(basePath, fullPath string) (string, error) {
 	absBase, err := filepath.Abs(basePath)
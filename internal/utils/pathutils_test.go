@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		valid bool
+	}{
+		{"plain", "report.pdf", true},
+		{"spaces and unicode", "Laporan Akhir é.txt", true},
+		{"dotfile", ".env", true},
+		{"empty", "", false},
+		{"dot", ".", false},
+		{"dot dot", "..", false},
+		{"slash", "a/b", false},
+		{"traversal", "../../../etc/x", false},
+		{"backslash traversal", `..\..\x`, false},
+		{"newline", "a\nb", false},
+		{"null byte", "a\x00b", false},
+		{"delete character", "a\x7fb", false},
+		{"too long", strings.Repeat("a", 256), false},
+		{"longest allowed", strings.Repeat("a", 255), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateName(tt.input)
+			if tt.valid && err != nil {
+				t.Fatalf("ValidateName(%q) = %v, want nil", tt.input, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidPath) {
+				t.Fatalf("ValidateName(%q) = %v, want ErrInvalidPath", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	base := t.TempDir()
+	tests := []struct {
+		name    string
+		input   string
+		want    string // relative to base; empty when an error is expected
+		wantErr error
+	}{
+		{"empty is base", "", ".", nil},
+		{"nested", "a/b/c.txt", "a/b/c.txt", nil},
+		{"leading slash", "/a/b", "a/b", nil},
+		{"inner dot dot", "a/../b", "b", nil},
+		{"parent", "../x", "", ErrPathTraversal},
+		{"encoded-looking parent after decode", "../../etc/passwd", "", ErrPathTraversal},
+		{"backslashes", `..\..\etc\passwd`, "", ErrPathTraversal},
+		{"mixed separators", `a/..\..\x`, "", ErrPathTraversal},
+		{"1000 deep", strings.Repeat("d/", 1000) + "f", "", ErrInvalidPath},
+		{"newline in name", "a/b\nc", "", ErrInvalidPath},
+		{"long component", "a/" + strings.Repeat("x", 300), "", ErrInvalidPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidatePath(base, tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ValidatePath(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidatePath(%q) = %v", tt.input, err)
+			}
+			if want := filepath.Join(base, tt.want); got != want {
+				t.Fatalf("ValidatePath(%q) = %q, want %q", tt.input, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoReflink = errors.New("reflinks are only supported on Linux")
+
+// reflink is only available on Linux; elsewhere files are always copied byte by byte
+func reflink(dst, src *os.File) error {
+	return errNoReflink
+}
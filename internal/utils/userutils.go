@@ -2,20 +2,76 @@ package utils
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"os/user"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Resolved users are cached so the 'id' fallback is not spawned on every request.
+// Entries expire so NSS changes (new users, changed IDs) are picked up eventually;
+// failures expire sooner so a freshly created user becomes usable quickly.
+const (
+	userCacheTTL         = 5 * time.Minute
+	userCacheNegativeTTL = 30 * time.Second
+)
+
+type userCacheEntry struct {
+	uid     int
+	gid     int
+	err     error
+	expires time.Time
+}
+
+var (
+	userCacheMu sync.RWMutex
+	userCache   = map[string]userCacheEntry{}
 )
 
 // ResolveUser resolves a username to UID and GID
 // It first attempts to use the os/user CGO lookup.
 // If that fails, it falls back to executing the 'id' command.
+// Results are cached for a few minutes.
 func ResolveUser(username string) (int, int, error) {
 	if username == "" {
 		return -1, -1, fmt.Errorf("empty username")
 	}
 
+	now := time.Now()
+	userCacheMu.RLock()
+	entry, ok := userCache[username]
+	userCacheMu.RUnlock()
+	if ok && now.Before(entry.expires) {
+		return entry.uid, entry.gid, entry.err
+	}
+
+	uid, gid, err := lookupUser(username)
+
+	ttl := userCacheTTL
+	if err != nil {
+		ttl = userCacheNegativeTTL
+	}
+	userCacheMu.Lock()
+	// Drop expired entries while holding the lock so the map cannot grow without bound
+	for name, e := range userCache {
+		if now.After(e.expires) {
+			delete(userCache, name)
+		}
+	}
+	userCache[username] = userCacheEntry{uid: uid, gid: gid, err: err, expires: now.Add(ttl)}
+	userCacheMu.Unlock()
+
+	return uid, gid, err
+}
+
+// lookupUser performs an uncached ResolveUser lookup
+func lookupUser(username string) (int, int, error) {
+
 	// Strategy 1: os/user Lookup
 	u, err := user.Lookup(username)
 	if err == nil {
@@ -49,6 +105,47 @@ func ResolveUser(username string) (int, int, error) {
 	return -1, -1, fmt.Errorf("failed to resolve user %s: %v", username, err)
 }
 
+// accountNamePattern accepts the names usersites may have (see the auth middleware), as
+// files are owned by the account named like their usersite
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,63}$`)
+
+// numericPattern matches names chown would take as a numeric ID
+var numericPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// IsValidAccountName reports whether name is a plausible user or group name.
+// Names are passed to chown and remote shells, so anything else is refused up front,
+// as are all-digit names, which chown falls back to reading as a UID or GID.
+func IsValidAccountName(name string) bool {
+	return accountNamePattern.MatchString(name) && !numericPattern.MatchString(name)
+}
+
+// ResolveGroup resolves a group name to its GID, falling back to
+// 'getent group' like ResolveUser falls back to 'id'.
+func ResolveGroup(name string) (int, error) {
+	if name == "" {
+		return -1, fmt.Errorf("empty group name")
+	}
+
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		if gid, err := strconv.Atoi(g.Gid); err == nil {
+			return gid, nil
+		}
+	}
+
+	// getent prints name:password:gid:members
+	if out, errCmd := exec.Command("getent", "group", name).Output(); errCmd == nil {
+		fields := strings.Split(strings.TrimSpace(string(out)), ":")
+		if len(fields) >= 3 {
+			if gid, err := strconv.Atoi(fields[2]); err == nil {
+				return gid, nil
+			}
+		}
+	}
+
+	return -1, fmt.Errorf("failed to resolve group %s: %v", name, err)
+}
+
 // SudoChown changes ownership of a file/folder using chown command.
 // Uses format: chown user:user path
 // This works when the application runs as root.
@@ -57,12 +154,7 @@ func SudoChown(path, owner string) error {
 		return nil
 	}
 	// Format: chown owner:owner path
-	cmd := exec.Command("chown", owner+":"+owner, path)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("chown failed for %s: %v, output: %s", path, err, string(output))
-	}
-	return nil
+	return SudoChownTo(path, owner, owner, false)
 }
 
 // SudoChownRecursive changes ownership of a directory recursively using chown -R command.
@@ -72,10 +164,99 @@ func SudoChownRecursive(path, owner string) error {
 		return nil
 	}
 	// Format: chown -R owner:owner path
-	cmd := exec.Command("chown", "-R", owner+":"+owner, path)
-	output, err := cmd.CombinedOutput()
+	return SudoChownTo(path, owner, owner, true)
+}
+
+// chownBatchSize bounds the number of paths passed to a single chown process
+const chownBatchSize = 256
+
+// SudoChownPaths changes ownership of many paths to owner:owner, passing them to
+// chown in batches instead of spawning one process per path.
+func SudoChownPaths(paths []string, owner string, recursive bool) error {
+	if owner == "" || len(paths) == 0 {
+		return nil
+	}
+	for start := 0; start < len(paths); start += chownBatchSize {
+		end := start + chownBatchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		// Symlinks are changed themselves rather than the files they point to
+		args := []string{"-h", owner + ":" + owner, "--"}
+		if recursive {
+			args = append([]string{"-R"}, args...)
+		}
+		args = append(args, paths[start:end]...)
+		output, err := exec.Command("chown", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("chown failed for %d paths: %v, output: %s", end-start, err, string(output))
+		}
+	}
+	return nil
+}
+
+// SudoChownTo changes ownership of path to owner:group, descending into
+// directories when recursive is set. A symlink is changed itself, not the file it points to.
+func SudoChownTo(path, owner, group string, recursive bool) error {
+	args := []string{"-h", owner + ":" + group, "--", path}
+	name := "chown"
+	if recursive {
+		args = append([]string{"-R"}, args...)
+		name = "chown -R"
+	}
+	output, err := exec.Command("chown", args...).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("chown -R failed for %s: %v, output: %s", path, err, string(output))
+		return fmt.Errorf("%s failed for %s: %v, output: %s", name, path, err, string(output))
 	}
 	return nil
 }
+
+// OwnerNames resolves numeric owner and group IDs to names, remembering each
+// lookup so a directory listing does not repeat it for every entry.
+// IDs without a matching user or group are reported as the number itself.
+type OwnerNames struct {
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+// NewOwnerNames creates an empty owner name cache
+func NewOwnerNames() *OwnerNames {
+	return &OwnerNames{users: map[uint32]string{}, groups: map[uint32]string{}}
+}
+
+// Lookup returns the owner and group names of a local file
+func (o *OwnerNames) Lookup(info os.FileInfo) (string, string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	return o.User(stat.Uid), o.Group(stat.Gid)
+}
+
+// User returns the name of uid
+func (o *OwnerNames) User(uid uint32) string {
+	if name, ok := o.users[uid]; ok {
+		return name
+	}
+	id := strconv.FormatUint(uint64(uid), 10)
+	name := id
+	if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	o.users[uid] = name
+	return name
+}
+
+// Group returns the name of gid
+func (o *OwnerNames) Group(gid uint32) string {
+	if name, ok := o.groups[gid]; ok {
+		return name
+	}
+	id := strconv.FormatUint(uint64(gid), 10)
+	name := id
+	if g, err := user.LookupGroupId(id); err == nil {
+		name = g.Name
+	}
+	o.groups[gid] = name
+	return name
+}
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestIsValidAccountName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"u1", true},
+		{"www-data", true},
+		{"_apt", true},
+		{"", false},
+		{"Admin", true},
+		{"1user", true},
+		{"john.doe", true},
+		{"1000", false},
+		{"-rf", false},
+		{".hidden", false},
+		{"a'b", false},
+		{"a;id", false},
+		{"a b", false},
+		{"$(id)", false},
+		{"root:root", false},
+		{"a\nb", false},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		if got := IsValidAccountName(tt.name); got != tt.valid {
+			t.Errorf("IsValidAccountName(%q) = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+// BenchmarkResolveUser compares a cached lookup with the lookup it saves, for an existing
+// account and for one that does not exist, whose lookup falls back to running id
+func BenchmarkResolveUser(b *testing.B) {
+	current, err := user.Current()
+	if err != nil {
+		b.Skip(err)
+	}
+	for _, username := range []string{current.Username, "no-such-user"} {
+		b.Run(username+"/uncached", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lookupUser(username)
+			}
+		})
+		b.Run(username+"/cached", func(b *testing.B) {
+			ResolveUser(username)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ResolveUser(username)
+			}
+		})
+		b.Run(username+"/cached parallel", func(b *testing.B) {
+			ResolveUser(username)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					ResolveUser(username)
+				}
+			})
+		})
+	}
+}
+
+// chownBenchOwner returns the current user when chown to user:user works for it, as
+// SudoChownPaths hands paths to a group named like the owner
+func chownBenchOwner(b *testing.B, path string) string {
+	current, err := user.Current()
+	if err != nil {
+		b.Skip(err)
+	}
+	if err := SudoChownTo(path, current.Username, current.Username, false); err != nil {
+		b.Skipf("chown to %s:%s is not possible here: %v", current.Username, current.Username, err)
+	}
+	return current.Username
+}
+
+// BenchmarkChownPaths hands the files of an extraction to their owner with one chown per
+// file and with batched calls
+func BenchmarkChownPaths(b *testing.B) {
+	dir := b.TempDir()
+	paths := make([]string, 1000)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("file%04d.txt", i))
+		if err := os.WriteFile(paths[i], nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	owner := chownBenchOwner(b, dir)
+
+	b.Run("one call per path", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				if err := SudoChownTo(path, owner, owner, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := SudoChownPaths(paths, owner, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSudoChownLeavesSymlinkTargets(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing ownership to another user needs root")
+	}
+	uid, _, err := ResolveUser("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+	group := "nogroup"
+	if _, err := ResolveGroup(group); err != nil {
+		group = "nobody"
+	}
+
+	chowns := map[string]func(path string) error{
+		"SudoChownTo": func(path string) error { return SudoChownTo(path, "nobody", group, false) },
+	}
+	// SudoChownPaths hands paths to a group named like the owner
+	if group == "nobody" {
+		chowns["SudoChownPaths"] = func(path string) error { return SudoChownPaths([]string{path}, "nobody", false) }
+	}
+	for name, chown := range chowns {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "target")
+			if err := os.WriteFile(target, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			link := filepath.Join(dir, "link")
+			if err := os.Symlink(target, link); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := chown(link); err != nil {
+				t.Fatal(err)
+			}
+			if info, err := os.Lstat(link); err != nil || int(info.Sys().(*syscall.Stat_t).Uid) != uid {
+				t.Fatalf("the symlink itself is not owned by nobody (%v)", err)
+			}
+			if info, err := os.Stat(target); err != nil || info.Sys().(*syscall.Stat_t).Uid != 0 {
+				t.Fatalf("chown of the symlink changed the owner of its target (%v)", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// SymlinkPolicy controls how directory walks treat symbolic links below the walked folder
+type SymlinkPolicy string
+
+const (
+	// SymlinksSkip leaves symlinks out: they are not followed and add nothing to sizes
+	SymlinksSkip SymlinkPolicy = "skip"
+	// SymlinksFollow walks symlinks as their targets. A linked folder that was already
+	// walked is not walked again, so links to a parent folder cannot loop.
+	SymlinksFollow SymlinkPolicy = "follow"
+)
+
+// ErrInvalidSymlinkPolicy is returned for an unknown symlinks value
+var ErrInvalidSymlinkPolicy = errors.New("symlinks must be skip or follow")
+
+// ParseSymlinkPolicy parses a symlinks value, defaulting to skip
+func ParseSymlinkPolicy(value string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(strings.ToLower(value)); policy {
+	case "":
+		return SymlinksSkip, nil
+	case SymlinksSkip, SymlinksFollow:
+		return policy, nil
+	default:
+		return "", ErrInvalidSymlinkPolicy
+	}
+}
+
+// WalkFunc is called by Walk for each file and folder. When symlinks are followed, info
+// describes the target while path is the link's own path.
+type WalkFunc func(path string, info os.FileInfo) error
+
+// Walk calls fn for root and every file and folder below it in lexical order, like
+// filepath.Walk but with symlinks treated according to policy. root itself is followed
+// when it is a symlink, as it was named explicitly. Returning filepath.SkipDir from fn
+// for a folder skips its contents.
+func Walk(root string, policy SymlinkPolicy, fn WalkFunc) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	return walk(root, info, policy, make(map[fileID]bool), fn)
+}
+
+// fileID identifies a folder across the different paths that lead to it
+type fileID struct {
+	dev, ino uint64
+}
+
+func walk(path string, info os.FileInfo, policy SymlinkPolicy, visited map[fileID]bool, fn WalkFunc) error {
+	if info.IsDir() {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			id := fileID{uint64(stat.Dev), uint64(stat.Ino)}
+			if visited[id] {
+				return nil
+			}
+			visited[id] = true
+		}
+	}
+
+	if err := fn(path, info); err != nil {
+		if err == filepath.SkipDir && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		entryInfo, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue // removed during the walk
+		}
+		if err != nil {
+			return err
+		}
+		if entryInfo.Mode()&os.ModeSymlink != 0 {
+			if policy != SymlinksFollow {
+				continue
+			}
+			if entryInfo, err = os.Stat(entryPath); err != nil {
+				continue // broken link
+			}
+		}
+		if err := walk(entryPath, entryInfo, policy, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}